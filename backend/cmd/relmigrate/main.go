@@ -0,0 +1,83 @@
+// Command relmigrate copies the trust graph from the local trust_tuples table into SpiceDB.
+//
+// It reads the tuples of each org (or of -org) and touches them into SpiceDB in batches, validating them
+// against the org schema first, so it can be rerun after a partial run. The SpiceDB schema must be written
+// beforehand (zed schema write). Build with -tags spicedb; -dry-run only validates and needs no SpiceDB.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/rel"
+)
+
+func main() {
+	org := flag.String("org", "", "migrate only this org (default: every org owning tuples)")
+	batch := flag.Int("batch", rel.DefaultImportBatch, "tuples per SpiceDB write")
+	dryRun := flag.Bool("dry-run", false, "validate the tuples without writing them")
+	endpoint := flag.String("endpoint", os.Getenv("AURA_SPICEDB_ENDPOINT"), "SpiceDB endpoint")
+	token := flag.String("token", os.Getenv("AURA_SPICEDB_TOKEN"), "SpiceDB preshared key")
+	flag.Parse()
+
+	database.Connect()
+	ctx := context.Background()
+
+	var dst rel.GraphClient
+	if !*dryRun {
+		gc, err := rel.NewSpiceDBFromEnv(*endpoint, *token)
+		if err != nil {
+			log.Fatalf("SpiceDB: %v", err)
+		}
+		dst = gc
+	}
+	orgs := []string{*org}
+	if *org == "" {
+		var err error
+		if orgs, err = rel.TupleOrgs(ctx); err != nil {
+			log.Fatalf("Listing orgs: %v", err)
+		}
+	}
+	failed := false
+	for _, o := range orgs {
+		res, err := migrateOrg(rel.WithOrg(ctx, o), dst, rel.ImportOptions{BatchSize: *batch, DryRun: *dryRun})
+		for _, e := range res.Invalid {
+			log.Printf("org %s: tuple %d: %s", o, e.Line, e.Error)
+		}
+		if err != nil {
+			log.Printf("org %s: %v (%d of %d tuples migrated)", o, err, res.Imported, res.Read)
+			failed = true
+			continue
+		}
+		if *dryRun {
+			log.Printf("org %s: %d tuples read, %d invalid", o, res.Read, len(res.Invalid))
+			failed = failed || len(res.Invalid) > 0
+			continue
+		}
+		log.Printf("org %s: %d tuples migrated in %d batches (revision %s)", o, res.Imported, res.Batches, res.Revision)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// migrateOrg streams the org's tuples through the NDJSON codec into ImportTuples, so the tool validates
+// and batches exactly like the import endpoint; "lines" of the report are tuple positions
+func migrateOrg(ctx context.Context, dst rel.GraphClient, opts rel.ImportOptions) (rel.ImportResult, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		enc, _ := rel.NewTupleEncoder(pw, rel.FormatNDJSON)
+		err := rel.ExportTuples(ctx, rel.TupleFilter{}, enc.Encode)
+		if err == nil {
+			err = enc.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+	dec, _ := rel.NewTupleDecoder(pr, rel.FormatNDJSON)
+	return rel.ImportTuples(ctx, dst, dec, opts)
+}
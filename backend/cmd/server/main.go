@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -61,6 +62,10 @@ func main() {
 			cancel()
 		}()
 	}
+	// Policy replay workers (AURA_REPLAY_WORKERS, default 1)
+	go api.StartReplayWorker(context.Background())
+	// Pack upgrade notifications for orgs with older pack versions installed
+	go api.StartPackUpgradeNotifier(context.Background())
 	// Security headers
 	router.Use(api.CSPMiddleware())
 	router.Use(api.HSTSMiddlewareFromEnv())
@@ -247,6 +252,12 @@ func main() {
 				negTTL = d
 			}
 		}
+		maxEntries := rel.DefaultCacheEntries
+		if v := os.Getenv("AURA_REL_CACHE_MAX_ENTRIES"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				maxEntries = n
+			}
+		}
 		backend := os.Getenv("AURA_REL_BACKEND")
 		var inner rel.GraphClient
 		if backend == "spicedb" {
@@ -265,7 +276,7 @@ func main() {
 			inner = rel.NewLocalGraph()
 			log.Println("Trust Graph: using local SQL backend")
 		}
-		api.SetGraphClient(api.NewCachedGraph(inner, posTTL, negTTL))
+		api.SetGraphClient(api.NewCachedGraph(inner, posTTL, negTTL, maxEntries))
 	}
 
 	// Initialize Mesh Bus (NATS via env when built with nats tag; else LocalBus)
@@ -284,19 +295,35 @@ func main() {
 			log.Println("Mesh Bus: using local backend")
 		}
 		api.SetBus(b)
-		// Subscriptions: graph.invalidate => ClearGraphCache; policy.invalidate => DeleteCompiled
+		// Subscriptions: graph.invalidate => drop the written tuple sets (or everything) + verify cache + wake tuple watchers; policy.invalidate => DeleteCompiled + cached decisions
 		_, _ = b.Subscribe(mesh.TopicGraphInvalidate, func(ctx context.Context, e mesh.Event) {
-			api.ClearGraphCache()
+			var pl struct {
+				RevisionToken string   `json:"revision_token"`
+				Objects       []string `json:"objects"`
+			}
+			_ = json.Unmarshal(e.Payload, &pl)
+			if pl.Objects != nil {
+				api.InvalidateGraphObjects(pl.Objects)
+			} else {
+				api.ClearGraphCache()
+			}
+			api.ObserveGraphRevision(pl.RevisionToken)
+			api.ClearVerifyCache()
+			rel.NotifyChanges()
 		})
 		_, _ = b.Subscribe(mesh.TopicPolicyInvalidate, func(ctx context.Context, e mesh.Event) {
 			var pl struct {
 				PolicyID string `json:"policy_id"`
 			}
 			_ = json.Unmarshal(e.Payload, &pl)
-			if pl.PolicyID != "" {
-				if id, err := uuid.Parse(pl.PolicyID); err == nil {
-					policy.DeleteCompiled(id, 0)
-				}
+			api.ForgetPolicyShadows()
+			if pl.PolicyID == "" {
+				api.ClearVerifyCache()
+				return
+			}
+			if id, err := uuid.Parse(pl.PolicyID); err == nil {
+				policy.DeleteCompiled(id, 0)
+				api.InvalidateVerifyCache(id)
 			}
 		})
 	}
@@ -323,10 +350,16 @@ func main() {
 		coreRoutes.POST("/verify", api.HandleVerifyRequest)
 		// Agent CSR issue (MVP): protected by attestation or API key; returns client cert
 		coreRoutes.POST("/agents/:agentId/csr", api.AcceptAgentCSR)
-		// Trust Graph v1 endpoints (batch tuples, relation check, expand)
+		// Trust Graph v1 endpoints (batch tuples, relation check, expand, reverse lookups)
 		coreRoutes.POST("/tuples", api.UpsertTuplesV1)
+		coreRoutes.POST("/tuples/write", api.WriteRelationshipsV1)
+		coreRoutes.POST("/tuples/delete", api.DeleteTuplesV1)
+		coreRoutes.GET("/tuples/export", api.ExportTuplesV1)
+		coreRoutes.POST("/tuples/import", api.ImportTuplesV1)
 		coreRoutes.POST("/check", api.CheckRelationV1)
 		coreRoutes.GET("/trust/graph/expand", api.ExpandTrustGraphV1)
+		coreRoutes.GET("/trust/graph/lookup-resources", api.LookupResourcesV1)
+		coreRoutes.GET("/trust/graph/lookup-subjects", api.LookupSubjectsV1)
 	}
 
 	// Experimental v2 verification with policy/relationship prototype
@@ -340,6 +373,7 @@ func main() {
 	}
 	{
 		v2.POST("/verify", api.HandleVerifyV2)
+		v2.POST("/verify/batch", api.HandleVerifyBatch)
 		v2.GET("/decisions/search", api.GetRecentDecisionTraces)
 		v2.GET("/decisions/:traceId", api.GetDecisionTrace)
 		// Cognitive Firewall endpoints
@@ -347,6 +381,7 @@ func main() {
 		v2.POST("/policy/author/nl-compile", api.CompilePolicyFromNL)
 		v2.POST("/policy/tests/run", api.RunPolicyTests)
 		v2.POST("/policy/preview", api.PreviewPolicyAgainstTraces)
+		v2.POST("/policy/query", api.QueryPolicy)
 		// Attestation and certs
 		v2.POST("/attest", api.HandleAttest)
 		v2.POST("/certs/issue", api.IssueClientCert)
@@ -355,6 +390,7 @@ func main() {
 		v2.GET("/certs/crl.pem", api.GetCRL)
 		// Runtime approvals polling
 		v2.GET("/approvals/:traceId", api.GetApprovalStatus)
+		v2.POST("/approvals", api.CreateApprovalRequest)
 		v2.GET("/signals/risk", api.GetRiskSignals)
 		v2.POST("/signals/risk/alerts", api.RaiseRiskAlert)
 		v2.DELETE("/signals/risk/alerts", api.ClearRiskAlert)
@@ -363,6 +399,7 @@ func main() {
 		v2.GET("/policy/packs", api.ListPolicyPacks)
 		v2.GET("/policy/packs/:packId", api.GetPolicyPack)
 		v2.GET("/policies/:policyId/versions", api.ListPolicyVersionsV2)
+		v2.GET("/policies/:policyId/versions/:version/diff", api.DiffPolicyVersionsV2)
 		v2.GET("/audit/ledger", api.GetAuditLedger)
 		v2.GET("/audit/verify", api.VerifyAuditChain)
 		v2.POST("/audit/anchor", api.SetAuditAnchor)
@@ -498,6 +535,7 @@ func main() {
 		// Trust tuple admin utilities
 		admin.GET("/rel/tuples", api.AdminListTuples)
 		admin.DELETE("/rel/tuples", api.AdminDeleteTuples)
+		admin.GET("/rel/watch", api.AdminWatchTuples)
 	}
 
 	protectedRoutes := router.Group("/")
@@ -511,6 +549,8 @@ func main() {
 		protectedRoutes.PUT("/me/password", api.UpdatePassword)
 
 		protectedRoutes.GET("/organizations/mine", api.GetMyOrganizations)
+		// Approval votes: authorized by approver group membership rather than org role
+		protectedRoutes.POST("/approvals/:approvalId/decision", api.DecideApprovalRequest)
 		// All routes defined within this group will now require a valid JWT
 		orgRoutes := protectedRoutes.Group("/organizations/:orgId")
 		orgRoutes.Use(api.OrgMemberMiddleware())
@@ -538,6 +578,11 @@ func main() {
 					permRoutes.POST("", api.RequireOrgAdmin(), api.AddPermissionRule)
 					permRoutes.GET("", api.GetPermissionRules)
 					permRoutes.DELETE("/:ruleId", api.RequireOrgAdmin(), api.DeletePermissionRule)
+					// Migration of v1 rules onto the policy engine (convert, dual-run, switch)
+					permRoutes.POST("/convert", api.RequireOrgAdmin(), api.ConvertV1Permissions)
+					permRoutes.GET("/migration", api.RequireOrgAdmin(), api.GetV1Migration)
+					permRoutes.PUT("/migration", api.RequireOrgAdmin(), api.SetV1VerifyMode)
+					permRoutes.GET("/divergences", api.RequireOrgAdmin(), api.ListV1Divergences)
 				}
 			}
 
@@ -545,12 +590,56 @@ func main() {
 			polRoutes := orgRoutes.Group("/policies")
 			{
 				polRoutes.POST("", api.RequireOrgAdmin(), api.CreatePolicy)
+				// GitOps: sync a policy bundle (see cmd/policysync)
+				polRoutes.POST("sync", api.RequireOrgAdmin(), api.SyncPolicyBundle)
+				// Policy packs: install (or upgrade) a catalogue pack as a tested policy
+				polRoutes.POST("packs/:packId/install", api.RequireOrgAdmin(), api.InstallPolicyPack)
+				polRoutes.GET("packs/installs", api.RequireOrgAdmin(), api.ListPolicyPackInstalls)
 				polRoutes.POST(":policyId/versions", api.RequireOrgAdmin(), api.AddPolicyVersion)
 				polRoutes.POST(":policyId/versions/:version/approve", api.RequireOrgAdmin(), api.ApprovePolicyVersion)
 				polRoutes.POST(":policyId/assignments", api.RequireOrgAdmin(), api.AssignPolicy)
 				polRoutes.POST(":policyId/versions/:version/simulate", api.RequireOrgAdmin(), api.SimulatePolicyVersion)
 				polRoutes.POST(":policyId/versions/:version/activate", api.RequireOrgAdmin(), api.ActivatePolicyVersion)
 				polRoutes.GET(":policyId/versions", api.RequireOrgAdmin(), api.ListPolicyVersions)
+				polRoutes.GET(":policyId/versions/:version/diff", api.RequireOrgAdmin(), api.DiffPolicyVersions)
+				// Shadow evaluation of candidate versions on live verify traffic
+				polRoutes.POST(":policyId/versions/:version/shadow", api.RequireOrgAdmin(), api.StartPolicyShadow)
+				polRoutes.DELETE(":policyId/shadow", api.RequireOrgAdmin(), api.StopPolicyShadow)
+				polRoutes.GET(":policyId/shadows", api.RequireOrgAdmin(), api.ListPolicyShadows)
+				polRoutes.GET(":policyId/shadows/:shadowId", api.RequireOrgAdmin(), api.GetPolicyShadowReport)
+				polRoutes.GET(":policyId/shadows/:shadowId/divergences", api.RequireOrgAdmin(), api.ListPolicyShadowDivergences)
+				// Replay of candidate versions over stored decision traces
+				polRoutes.POST(":policyId/versions/:version/replays", api.RequireOrgAdmin(), api.StartPolicyReplay)
+				polRoutes.GET(":policyId/replays", api.RequireOrgAdmin(), api.ListPolicyReplays)
+				polRoutes.GET(":policyId/replays/:replayId", api.RequireOrgAdmin(), api.GetPolicyReplay)
+				polRoutes.POST(":policyId/replays/:replayId/cancel", api.RequireOrgAdmin(), api.CancelPolicyReplay)
+				polRoutes.GET(":policyId/replays/:replayId/report", api.RequireOrgAdmin(), api.DownloadPolicyReplayReport)
+			}
+
+			// Shared JSON Schema definitions referenced from policy schemas
+			schemaRoutes := orgRoutes.Group("/policy-schemas")
+			{
+				schemaRoutes.GET("", api.RequireOrgAdmin(), api.ListPolicySchemaDefs)
+				schemaRoutes.GET("/:name", api.RequireOrgAdmin(), api.GetPolicySchemaDef)
+				schemaRoutes.PUT("/:name", api.RequireOrgAdmin(), api.PutPolicySchemaDef)
+				schemaRoutes.DELETE("/:name", api.RequireOrgAdmin(), api.DeletePolicySchemaDef)
+			}
+
+			// Org data documents for Rego policies (data.<name>)
+			dataRoutes := orgRoutes.Group("/policy-data")
+			{
+				dataRoutes.GET("", api.RequireOrgAdmin(), api.ListRegoDataBundles)
+				dataRoutes.PUT("/:name", api.RequireOrgAdmin(), api.PutRegoDataBundle)
+				dataRoutes.DELETE("/:name", api.RequireOrgAdmin(), api.DeleteRegoDataBundle)
+			}
+
+			// Runtime approval requests and approver groups
+			orgRoutes.GET("/approvals", api.ListApprovalRequests)
+			apprGroups := orgRoutes.Group("/approval-groups")
+			{
+				apprGroups.GET("", api.ListApprovalGroups)
+				apprGroups.PUT("/:name", api.RequireOrgAdmin(), api.PutApprovalGroup)
+				apprGroups.DELETE("/:name", api.RequireOrgAdmin(), api.DeleteApprovalGroup)
 			}
 
 			// Relationship prototype endpoints
@@ -558,6 +647,10 @@ func main() {
 			{
 				relRoutes.POST("/tuples", api.RequireOrgAdmin(), api.UpsertTuples)
 				relRoutes.POST("/check", api.CheckRelation)
+				relRoutes.GET("/schema", api.GetRelSchema)
+				relRoutes.PUT("/schema", api.RequireOrgAdmin(), api.PutRelSchema)
+				relRoutes.GET("/schema/versions", api.ListRelSchemaVersions)
+				relRoutes.GET("/schema/versions/:version", api.GetRelSchemaVersion)
 			}
 
 			apiKeyRoutes := orgRoutes.Group("/apikeys")
@@ -0,0 +1,8 @@
+-- +goose Up
+-- Combining algorithm applied when several policy assignments (org/team/agent) match a request
+ALTER TABLE organizations
+  ADD COLUMN IF NOT EXISTS policy_combining_alg text
+  CHECK (policy_combining_alg IN ('deny-overrides','permit-overrides','first-applicable','only-one-applicable'));
+
+-- +goose Down
+ALTER TABLE organizations DROP COLUMN IF EXISTS policy_combining_alg;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Shared org-level JSON Schema definitions referenced from policy schemas as urn:aura:schema:<name>
+CREATE TABLE IF NOT EXISTS policy_schema_defs (
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  name text NOT NULL CHECK (name ~ '^[A-Za-z0-9_.-]+$'),
+  schema jsonb NOT NULL,
+  version int NOT NULL DEFAULT 1,
+  updated_by_user_id uuid NULL,
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (org_id, name)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS policy_schema_defs;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Per-org documents exposed to Rego policies as data.<name>
+CREATE TABLE IF NOT EXISTS rego_data_bundles (
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  name text NOT NULL CHECK (name ~ '^[A-Za-z_][A-Za-z0-9_]*$'),
+  data jsonb NOT NULL,
+  revision int NOT NULL DEFAULT 1,
+  updated_by_user_id uuid NULL,
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (org_id, name)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS rego_data_bundles;
@@ -0,0 +1,50 @@
+-- +goose Up
+-- Approver groups: members are user ids; min_approvals is the quorum a request routed to the group needs
+CREATE TABLE IF NOT EXISTS approval_groups (
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  name text NOT NULL CHECK (name ~ '^[A-Za-z0-9_.-]+$'),
+  members jsonb NOT NULL DEFAULT '[]'::jsonb,
+  min_approvals int NOT NULL DEFAULT 1 CHECK (min_approvals >= 1),
+  updated_by_user_id uuid NULL,
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (org_id, name)
+);
+
+-- Runtime approval requests created from require_approval decisions
+CREATE TABLE IF NOT EXISTS approval_requests (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  trace_id text NOT NULL,
+  agent_id uuid NULL,
+  policy_id uuid NULL,
+  policy_version int NULL,
+  context_hash text NOT NULL,
+  request_context jsonb NULL,
+  reason text NULL,
+  hints jsonb NOT NULL DEFAULT '[]'::jsonb,
+  groups jsonb NOT NULL DEFAULT '[]'::jsonb,
+  required int NOT NULL DEFAULT 1 CHECK (required >= 1),
+  status text NOT NULL DEFAULT 'pending', -- pending|approved|denied|expired|consumed
+  expires_at timestamptz NOT NULL,
+  decided_at timestamptz NULL,
+  consumed_at timestamptz NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_approval_requests_org_status ON approval_requests(org_id, status, created_at DESC);
+-- at most one open request per decision
+CREATE UNIQUE INDEX IF NOT EXISTS uq_approval_requests_pending_trace ON approval_requests(org_id, trace_id) WHERE status='pending';
+
+CREATE TABLE IF NOT EXISTS approval_votes (
+  approval_id uuid NOT NULL REFERENCES approval_requests(id) ON DELETE CASCADE,
+  user_id uuid NOT NULL,
+  vote text NOT NULL CHECK (vote IN ('approve','deny')),
+  group_name text NULL,
+  comment text NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (approval_id, user_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS approval_votes;
+DROP TABLE IF EXISTS approval_requests;
+DROP TABLE IF EXISTS approval_groups;
@@ -0,0 +1,35 @@
+-- +goose Up
+-- v1 permission rules converted to an AuraJSON policy version, per agent.
+-- mode: legacy (v1 engine only), dual (v1 serves, both run and divergences are recorded), v2 (converted policy serves)
+CREATE TABLE IF NOT EXISTS v1_permission_migrations (
+  agent_id uuid PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  policy_id uuid NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+  version int NOT NULL,
+  source_checksum text NOT NULL,
+  warnings jsonb NOT NULL DEFAULT '[]'::jsonb,
+  mode text NOT NULL DEFAULT 'dual' CHECK (mode IN ('legacy','dual','v2')),
+  converted_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- kind: decision (engines disagree), stale (rules changed since conversion), error (converted policy failed)
+CREATE TABLE IF NOT EXISTS v1_verify_divergences (
+  id bigserial PRIMARY KEY,
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  agent_id uuid NOT NULL,
+  policy_id uuid NOT NULL,
+  version int NOT NULL,
+  kind text NOT NULL CHECK (kind IN ('decision','stale','error')),
+  legacy_allow boolean NOT NULL,
+  legacy_reason text NULL,
+  v2_allow boolean NULL,
+  v2_reason text NULL,
+  request_context jsonb NULL,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_v1_verify_divergences_agent ON v1_verify_divergences(org_id, agent_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS v1_verify_divergences;
+DROP TABLE IF EXISTS v1_permission_migrations;
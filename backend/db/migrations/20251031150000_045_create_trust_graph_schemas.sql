@@ -0,0 +1,17 @@
+-- +goose Up
+-- Userset subjects (e.g. team:devs#member); '' means the subject object itself
+ALTER TABLE trust_tuples ADD COLUMN IF NOT EXISTS subject_relation text NOT NULL DEFAULT '';
+
+-- Versioned org namespace configurations for the trust graph; the highest version is in force
+CREATE TABLE IF NOT EXISTS trust_graph_schemas (
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  version int NOT NULL,
+  definition jsonb NOT NULL,
+  created_by_user_id uuid NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  PRIMARY KEY (org_id, version)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS trust_graph_schemas;
+ALTER TABLE trust_tuples DROP COLUMN IF EXISTS subject_relation;
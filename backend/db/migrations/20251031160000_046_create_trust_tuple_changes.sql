@@ -0,0 +1,39 @@
+-- +goose Up
+-- Tuples are identified by object, relation and subject (the caveat is an attribute); drop duplicates first
+DELETE FROM trust_tuples a USING trust_tuples b
+  WHERE a.id > b.id
+    AND a.object_type = b.object_type AND a.object_id = b.object_id AND a.relation = b.relation
+    AND a.subject_type = b.subject_type AND a.subject_id = b.subject_id AND a.subject_relation = b.subject_relation;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_trust_tuples ON trust_tuples(object_type, object_id, relation, subject_type, subject_id, subject_relation);
+
+-- Append-only changelog of tuple writes; every write transaction gets the next revision
+CREATE TABLE IF NOT EXISTS trust_tuple_changes (
+  id bigserial PRIMARY KEY,
+  revision bigint NOT NULL,
+  operation text NOT NULL CHECK (operation IN ('touch','create','delete')),
+  object_type text NOT NULL,
+  object_id text NOT NULL,
+  relation text NOT NULL,
+  subject_type text NOT NULL,
+  subject_id text NOT NULL,
+  subject_relation text NOT NULL DEFAULT '',
+  caveat_json jsonb,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_trust_tuple_changes_revision ON trust_tuple_changes(revision);
+
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION trust_tuple_changes_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'trust_tuple_changes is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+DROP TRIGGER IF EXISTS trg_trust_tuple_changes_append_only ON trust_tuple_changes;
+CREATE TRIGGER trg_trust_tuple_changes_append_only BEFORE UPDATE OR DELETE ON trust_tuple_changes
+  FOR EACH ROW EXECUTE FUNCTION trust_tuple_changes_append_only();
+
+-- +goose Down
+DROP TABLE IF EXISTS trust_tuple_changes;
+DROP FUNCTION IF EXISTS trust_tuple_changes_append_only();
+DROP INDEX IF EXISTS uq_trust_tuples;
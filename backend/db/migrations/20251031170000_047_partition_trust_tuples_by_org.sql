@@ -0,0 +1,48 @@
+-- +goose Up
+-- Tuples are partitioned by the org owning them; reads and writes only see their org's tuples
+ALTER TABLE trust_tuples ADD COLUMN IF NOT EXISTS org_id uuid NULL REFERENCES organizations(id) ON DELETE CASCADE;
+ALTER TABLE trust_tuple_changes ADD COLUMN IF NOT EXISTS org_id uuid NULL;
+
+-- Backfill ownership: org objects belong to that org, agent objects to the org that registered the agent
+UPDATE trust_tuples t SET org_id = o.id
+  FROM organizations o
+  WHERE t.org_id IS NULL AND t.object_type = 'org' AND o.id::text = t.object_id;
+UPDATE trust_tuples t SET org_id = a.organization_id
+  FROM agents a
+  WHERE t.org_id IS NULL AND t.object_type = 'agent' AND a.id::text = t.object_id;
+-- then objects of other namespaces (e.g. teams) by their subject's org
+UPDATE trust_tuples t SET org_id = o.id
+  FROM organizations o
+  WHERE t.org_id IS NULL AND t.subject_type = 'org' AND o.id::text = t.subject_id;
+UPDATE trust_tuples t SET org_id = a.organization_id
+  FROM agents a
+  WHERE t.org_id IS NULL AND t.subject_type = 'agent' AND a.id::text = t.subject_id;
+-- and finally by another tuple on the same object whose owner is known, when that owner is unambiguous
+UPDATE trust_tuples t SET org_id = k.org_id
+  FROM (SELECT object_type, object_id, MIN(org_id::text)::uuid AS org_id FROM trust_tuples
+        WHERE org_id IS NOT NULL GROUP BY object_type, object_id HAVING COUNT(DISTINCT org_id) = 1) k
+  WHERE t.org_id IS NULL AND t.object_type = k.object_type AND t.object_id = k.object_id;
+-- Tuples still without an owner are read by no org; find them with org_id IS NULL and rewrite or delete them
+
+-- Ownerless tuples are unique too: NULLS NOT DISTINCT keeps a write of a legacy tuple from adding a copy
+DROP INDEX IF EXISTS uq_trust_tuples;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_trust_tuples ON trust_tuples(org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation) NULLS NOT DISTINCT;
+DROP INDEX IF EXISTS idx_trust_obj_rel;
+DROP INDEX IF EXISTS idx_trust_subj_rel;
+CREATE INDEX IF NOT EXISTS idx_trust_subj_rel ON trust_tuples(org_id, subject_type, subject_id, relation);
+CREATE INDEX IF NOT EXISTS idx_trust_tuple_changes_org ON trust_tuple_changes(org_id, revision);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_trust_tuple_changes_org;
+DROP INDEX IF EXISTS idx_trust_subj_rel;
+CREATE INDEX IF NOT EXISTS idx_trust_subj_rel ON trust_tuples(subject_type, subject_id, relation);
+CREATE INDEX IF NOT EXISTS idx_trust_obj_rel ON trust_tuples(object_type, object_id, relation);
+DROP INDEX IF EXISTS uq_trust_tuples;
+-- the same tuple may be owned by several orgs; keep one copy
+DELETE FROM trust_tuples a USING trust_tuples b
+  WHERE a.id > b.id
+    AND a.object_type = b.object_type AND a.object_id = b.object_id AND a.relation = b.relation
+    AND a.subject_type = b.subject_type AND a.subject_id = b.subject_id AND a.subject_relation = b.subject_relation;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_trust_tuples ON trust_tuples(object_type, object_id, relation, subject_type, subject_id, subject_relation);
+ALTER TABLE trust_tuple_changes DROP COLUMN IF EXISTS org_id;
+ALTER TABLE trust_tuples DROP COLUMN IF EXISTS org_id;
@@ -0,0 +1,44 @@
+-- +goose Up
+-- A shadow evaluates a candidate policy version next to the version that serves /v2/verify, without
+-- affecting decisions. At most one shadow runs per policy; stopped shadows keep their report.
+CREATE TABLE IF NOT EXISTS policy_shadows (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  policy_id uuid NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+  version int NOT NULL,
+  percent int NOT NULL DEFAULT 100 CHECK (percent > 0 AND percent <= 100),
+  active boolean NOT NULL DEFAULT true,
+  evaluated bigint NOT NULL DEFAULT 0,
+  diverged bigint NOT NULL DEFAULT 0,
+  created_by_user_id uuid NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  stopped_at timestamptz NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_policy_shadows_active ON policy_shadows(policy_id) WHERE active = true;
+CREATE INDEX IF NOT EXISTS idx_policy_shadows_org ON policy_shadows(org_id) WHERE active = true;
+
+-- kind: decision (allow and deny swapped), approval (require_approval changed), error (candidate failed)
+-- *_rules are the ids of the rules of the shadowed policy that matched in each evaluation
+CREATE TABLE IF NOT EXISTS policy_shadow_divergences (
+  id bigserial PRIMARY KEY,
+  shadow_id uuid NOT NULL REFERENCES policy_shadows(id) ON DELETE CASCADE,
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  trace_id text NOT NULL,
+  agent_id uuid NULL,
+  active_version int NOT NULL,
+  kind text NOT NULL CHECK (kind IN ('decision','approval','error')),
+  active_allow boolean NOT NULL,
+  active_require_approval boolean NOT NULL,
+  active_reason text NULL,
+  active_rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  shadow_allow boolean NULL,
+  shadow_require_approval boolean NULL,
+  shadow_reason text NULL,
+  shadow_rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  created_at timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_policy_shadow_divergences_shadow ON policy_shadow_divergences(shadow_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS policy_shadow_divergences;
+DROP TABLE IF EXISTS policy_shadows;
@@ -0,0 +1,56 @@
+-- +goose Up
+-- A replay re-evaluates a candidate policy version over the stored decision traces of a time window.
+-- Jobs are claimed by workers (worker, heartbeat_at) and resume from their cursor (created_at, id of the
+-- last trace read) when a worker dies. status: queued|running|succeeded|failed|canceled
+CREATE TABLE IF NOT EXISTS policy_replays (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  policy_id uuid NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+  version int NOT NULL,
+  window_from timestamptz NOT NULL,
+  window_to timestamptz NOT NULL,
+  include_unassigned boolean NOT NULL DEFAULT false,
+  status text NOT NULL DEFAULT 'queued' CHECK (status IN ('queued','running','succeeded','failed','canceled')),
+  worker text NULL,
+  heartbeat_at timestamptz NULL,
+  cursor_at timestamptz NULL,
+  cursor_id bigint NOT NULL DEFAULT 0,
+  read bigint NOT NULL DEFAULT 0,
+  evaluated bigint NOT NULL DEFAULT 0,
+  skipped bigint NOT NULL DEFAULT 0,
+  flipped bigint NOT NULL DEFAULT 0,
+  error text NULL,
+  created_by_user_id uuid NULL,
+  created_at timestamptz NOT NULL DEFAULT now(),
+  started_at timestamptz NULL,
+  finished_at timestamptz NULL
+);
+CREATE INDEX IF NOT EXISTS idx_policy_replays_policy ON policy_replays(policy_id, version, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_policy_replays_pending ON policy_replays(created_at) WHERE status IN ('queued','running');
+
+-- one row per trace whose outcome the candidate changes; outcome: allow|deny|require_approval
+-- *_rules are the ids of the rules of the policy that matched originally and on replay; rules are those
+-- whose match changed, or the rules that matched when the same did (the report groups flips by them)
+CREATE TABLE IF NOT EXISTS policy_replay_flips (
+  id bigserial PRIMARY KEY,
+  replay_id uuid NOT NULL REFERENCES policy_replays(id) ON DELETE CASCADE,
+  trace_id text NOT NULL,
+  agent_id uuid NULL,
+  action text NOT NULL DEFAULT '',
+  original text NOT NULL,
+  replayed text NOT NULL,
+  original_rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  replayed_rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  rules jsonb NOT NULL DEFAULT '[]'::jsonb,
+  reason text NULL,
+  decided_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_policy_replay_flips_replay ON policy_replay_flips(replay_id, id);
+
+-- replays page through a window of an org's traces in (created_at, id) order
+CREATE INDEX IF NOT EXISTS idx_decision_traces_org_time_id ON decision_traces(org_id, created_at, id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_decision_traces_org_time_id;
+DROP TABLE IF EXISTS policy_replay_flips;
+DROP TABLE IF EXISTS policy_replays;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- The diff an approver reviewed: the version it was computed against and its change counts
+ALTER TABLE policy_version_approvals ADD COLUMN IF NOT EXISTS base_version int;
+ALTER TABLE policy_version_approvals ADD COLUMN IF NOT EXISTS diff_summary jsonb;
+
+-- +goose Down
+ALTER TABLE policy_version_approvals DROP COLUMN IF EXISTS diff_summary;
+ALTER TABLE policy_version_approvals DROP COLUMN IF EXISTS base_version;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- A policy pack installed into an org: the policy it created, and the pack version and parameters its
+-- latest pack version was rendered from. notified_version is the newest pack version the org was told
+-- about, so each upgrade is announced once.
+CREATE TABLE IF NOT EXISTS policy_pack_installs (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid(),
+  org_id uuid NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+  pack_id text NOT NULL,
+  pack_version int NOT NULL,
+  policy_id uuid NOT NULL REFERENCES policies(id) ON DELETE CASCADE,
+  version int NOT NULL,
+  params jsonb NOT NULL DEFAULT '{}'::jsonb,
+  notified_version int NOT NULL DEFAULT 0,
+  installed_by_user_id uuid NULL,
+  installed_at timestamptz NOT NULL DEFAULT now(),
+  updated_at timestamptz NOT NULL DEFAULT now(),
+  UNIQUE (org_id, pack_id)
+);
+CREATE INDEX IF NOT EXISTS idx_policy_pack_installs_pack ON policy_pack_installs(pack_id, pack_version);
+
+-- +goose Down
+DROP TABLE IF EXISTS policy_pack_installs;
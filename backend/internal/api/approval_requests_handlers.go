@@ -0,0 +1,365 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/approval"
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var approvalGroupName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+type createApprovalRequest struct {
+	TraceID string `json:"trace_id" binding:"required"`
+}
+
+// POST /v2/approvals
+// Opens an approval request for a recorded require_approval decision of the caller's org. The request is
+// routed to the approver groups named by the policy (or the org's "default" group) and expires after
+// AURA_APPROVAL_TTL_SECONDS. An open request for the same trace is returned as is.
+func CreateApprovalRequest(c *gin.Context) {
+	orgID, err := uuid.Parse(c.GetString("orgID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "missing org"})
+		return
+	}
+	var req createApprovalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var row decisionTraceRow
+	err = database.DB.GetContext(c.Request.Context(), &row, `SELECT org_id, trace_id, policy_id, policy_version, agent_id, allow, reason, trace FROM decision_traces WHERE org_id=$1 AND trace_id=$2 ORDER BY created_at DESC LIMIT 1`, orgID, req.TraceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "decision trace not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var tr policy.Trace
+	_ = json.Unmarshal(row.Trace, &tr)
+	if row.Allow || !tr.RequireApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "decision does not require approval"})
+		return
+	}
+	r, created, err := openApproval(c.Request.Context(), orgID, row, tr)
+	if errors.Is(err, approval.ErrNoApprovers) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !created {
+		c.JSON(http.StatusOK, r)
+		return
+	}
+	c.JSON(http.StatusCreated, r)
+}
+
+// openApproval routes and stores an approval request for a decision trace
+func openApproval(ctx context.Context, orgID uuid.UUID, row decisionTraceRow, tr policy.Trace) (approval.Request, bool, error) {
+	groups, required, err := approval.Route(ctx, orgID, tr.Approvers)
+	if err != nil {
+		return approval.Request{}, false, err
+	}
+	hints := tr.Hints
+	if hints == nil {
+		hints = []string{}
+	}
+	gb, _ := json.Marshal(groups)
+	hb, _ := json.Marshal(hints)
+	pid, ver := row.PolicyID, row.PolicyVersion
+	reason := row.Reason
+	r, created, err := approval.Create(ctx, approval.Request{
+		OrgID:          orgID,
+		TraceID:        row.TraceID,
+		AgentID:        row.AgentID,
+		PolicyID:       &pid,
+		PolicyVersion:  &ver,
+		ContextHash:    approval.ContextHash(tr.InputContext),
+		RequestContext: tr.InputContext,
+		Reason:         &reason,
+		Hints:          hb,
+		Groups:         gb,
+		Required:       required,
+		ExpiresAt:      time.Now().Add(approval.TTL()),
+	})
+	if err == nil && created {
+		_ = audit.Append(ctx, orgID, "approval_requested", map[string]any{"approval_id": r.ID, "trace_id": r.TraceID, "groups": groups, "required": required, "expires_at": r.ExpiresAt}, nil, row.AgentID)
+	}
+	return r, created, err
+}
+
+// expireApprovals marks the org's overdue requests expired and audits each one
+func expireApprovals(ctx context.Context, orgID uuid.UUID) {
+	ids, err := approval.ExpireDue(ctx, orgID)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		_ = audit.Append(ctx, orgID, "approval_expired", map[string]any{"approval_id": id}, nil, nil)
+	}
+}
+
+// getApprovalRequest serves GET /v2/approvals/:id for approval request ids; false when id is not one
+func getApprovalRequest(c *gin.Context, id string) bool {
+	aid, err := uuid.Parse(id)
+	if err != nil {
+		return false
+	}
+	orgID, err := uuid.Parse(c.GetString("orgID"))
+	if err != nil {
+		return false
+	}
+	expireApprovals(c.Request.Context(), orgID)
+	r, err := approval.Get(c.Request.Context(), orgID, aid)
+	if err != nil {
+		return false
+	}
+	c.JSON(http.StatusOK, r)
+	return true
+}
+
+type approvalDecisionRequest struct {
+	Decision string `json:"decision" binding:"required"` // approve|deny
+	Comment  string `json:"comment,omitempty"`
+}
+
+// POST /approvals/:approvalId/decision
+// Records the signed-in user's vote. Only members of a group the request is routed to may vote; any deny
+// vetoes the request and it is approved once it has the required number of distinct approvals.
+func DecideApprovalRequest(c *gin.Context) {
+	ctx := c.Request.Context()
+	aid, err := uuid.Parse(c.Param("approvalId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad approval id"})
+		return
+	}
+	userID, err := uuid.Parse(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	var req approvalDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	vote := strings.ToLower(req.Decision)
+	if vote != approval.VoteApprove && vote != approval.VoteDeny {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "decision must be approve or deny"})
+		return
+	}
+	r, err := approval.Get(ctx, uuid.Nil, aid)
+	if errors.Is(err, approval.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	group, ok, err := approval.IsApprover(ctx, r, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": approval.ErrNotApprover.Error()})
+		return
+	}
+	expireApprovals(ctx, r.OrgID)
+	r, err = approval.CastVote(ctx, aid, userID, vote, group, req.Comment)
+	switch {
+	case errors.Is(err, approval.ErrNotPending), errors.Is(err, approval.ErrAlreadyVoted):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": r.Status})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_ = audit.Append(ctx, r.OrgID, "approval_vote", map[string]any{"approval_id": aid, "vote": vote, "group": group, "comment": req.Comment}, &userID, nil)
+	if r.Status != approval.StatusPending {
+		_ = audit.Append(ctx, r.OrgID, "approval_"+r.Status, map[string]any{"approval_id": aid, "trace_id": r.TraceID, "votes": len(r.Votes), "required": r.Required}, &userID, nil)
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// GET /organizations/:orgId/approvals?status=pending&limit=100
+func ListApprovalRequests(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	expireApprovals(c.Request.Context(), orgID)
+	items, err := approval.List(c.Request.Context(), orgID, c.Query("status"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// GET /organizations/:orgId/approval-groups
+func ListApprovalGroups(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	items, err := approval.ListGroups(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+type putApprovalGroupRequest struct {
+	Members      []uuid.UUID `json:"members"`
+	MinApprovals int         `json:"min_approvals"`
+}
+
+// PUT /organizations/:orgId/approval-groups/:name
+// Body: {"members":["<user id>",...],"min_approvals":2}. The "default" group receives requests whose
+// policy names no approvers.
+func PutApprovalGroup(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	name := c.Param("name")
+	if !approvalGroupName.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must match [A-Za-z0-9_.-]+"})
+		return
+	}
+	var req putApprovalGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// members are distinct users of the org: a repeated id would count towards a quorum it cannot reach,
+	// and approvers only vote through their group
+	seen := map[uuid.UUID]bool{}
+	members := make([]uuid.UUID, 0, len(req.Members))
+	for _, m := range req.Members {
+		if !seen[m] {
+			seen[m] = true
+			members = append(members, m)
+		}
+	}
+	req.Members = members
+	outsiders, err := approval.NonMembers(c.Request.Context(), orgID, req.Members)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(outsiders) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "members must belong to the organization", "not_members": outsiders})
+		return
+	}
+	if req.MinApprovals <= 0 {
+		req.MinApprovals = 1
+	}
+	if req.MinApprovals > len(req.Members) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_approvals exceeds the number of members"})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	g, err := approval.PutGroup(c.Request.Context(), orgID, name, req.Members, req.MinApprovals, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "approval_group_put", map[string]any{"name": name, "members": len(req.Members), "min_approvals": req.MinApprovals}, uid, nil)
+	c.JSON(http.StatusOK, g)
+}
+
+// DELETE /organizations/:orgId/approval-groups/:name
+func DeleteApprovalGroup(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	ok, err := approval.DeleteGroup(c.Request.Context(), orgID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "approval_group_deleted", map[string]any{"name": c.Param("name")}, uid, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// redeemApproval consumes an approved request bound to the same agent and input, turning a
+// require_approval decision into an allow. Otherwise the decision stays denied with the reason.
+func redeemApproval(ctx context.Context, orgID string, approvalID string, agentID *uuid.UUID, canonCtx json.RawMessage, dec policy.Decision) policy.Decision {
+	org := uuid.MustParse(orgID)
+	id, err := uuid.Parse(approvalID)
+	if err != nil {
+		dec.Reason = "Approval not usable: invalid approval_id"
+		return dec
+	}
+	expireApprovals(ctx, org)
+	r, ok, err := approval.Consume(ctx, org, id, agentID, approval.ContextHash(canonCtx))
+	if !ok {
+		status := r.Status
+		switch {
+		case errors.Is(err, approval.ErrNotFound):
+			status = "not_found"
+		case err != nil:
+			status = "unavailable"
+		case status == approval.StatusApproved:
+			status = "request does not match the approved one"
+		}
+		dec.Reason = "Approval not usable: " + status
+		_ = audit.Append(ctx, org, "approval_redeem_failed", map[string]any{"approval_id": id, "trace_id": dec.TraceID, "status": status}, nil, agentID)
+		return dec
+	}
+	dec.Allow = true
+	dec.RequireApproval = false
+	dec.Hints = nil
+	dec.Reason = "Approved (approval " + id.String() + ")"
+	h := sha256.Sum256([]byte(dec.TraceID + "|" + id.String()))
+	dec.TraceID = hex.EncodeToString(h[:8])
+	if dec.Trace != nil {
+		tr := *dec.Trace
+		tr.RequireApproval = false
+		tr.ApprovalID = id.String()
+		dec.Trace = &tr
+	}
+	_ = audit.Append(ctx, org, "approval_consumed", map[string]any{"approval_id": id, "trace_id": dec.TraceID, "original_trace_id": r.TraceID}, nil, agentID)
+	return dec
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// jsonArg matches a JSON argument equal to want
+type jsonArg string
+
+func (a jsonArg) Match(v driver.Value) bool {
+	var got, want any
+	b, ok := v.([]byte)
+	if !ok {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		b = []byte(s)
+	}
+	if json.Unmarshal(b, &got) != nil || json.Unmarshal([]byte(a), &want) != nil {
+		return false
+	}
+	gb, _ := json.Marshal(got)
+	wb, _ := json.Marshal(want)
+	return string(gb) == string(wb)
+}
+
+func TestOpenApprovalStoresRuleHints(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	database.DB = sqlx.NewDb(db, "sqlmock")
+
+	org, pid := uuid.New(), uuid.New()
+	cp, err := evalRegistry[policy.EngineAuraJSON].Compile(json.RawMessage(`{"rules":[
+		{"id":"big_wire","effect":"needs_approval","hint":"Wires over 1000 need treasury","approvers":["treasury"],"when":{"amount":{"gt":1000}}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.PutCompiled(pid, 1, cp)
+	defer policy.DeleteCompiled(pid, 1)
+	live := []policy.ApplicableAssignment{{Policy: database.Policy{ID: pid, OrgID: org, EngineType: policy.EngineAuraJSON}, Version: database.PolicyVersion{PolicyID: pid, Version: 1}}}
+	dec, _ := evaluateAssignments(context.Background(), policy.CombineDenyOverrides, live, json.RawMessage(`{"action":"wire","amount":5000}`))
+	if dec.Allow || !dec.RequireApproval {
+		t.Fatalf("expected require_approval, got %+v", dec)
+	}
+	// the trace goes through storage as verify persists it
+	b, _ := json.Marshal(dec.Trace)
+	var tr policy.Trace
+	if err := json.Unmarshal(b, &tr); err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM approval_groups WHERE org_id=$1`)).WithArgs(org).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id", "name", "members", "min_approvals", "updated_by_user_id", "updated_at"}).
+			AddRow(org, "treasury", []byte(`[]`), 1, nil, tr.At))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO approval_requests`)).
+		WithArgs(org, dec.TraceID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			jsonArg(`["Wires over 1000 need treasury"]`), jsonArg(`["treasury"]`), 1, sqlmock.AnyArg()).
+		WillReturnError(context.Canceled)
+
+	row := decisionTraceRow{OrgID: org.String(), TraceID: dec.TraceID, PolicyID: pid, PolicyVersion: 1, Reason: dec.Reason, Trace: b}
+	if _, _, err := openApproval(context.Background(), org, row, tr); err != context.Canceled {
+		t.Fatalf("expected the insert with the rule hint, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
+
+func TestPutApprovalGroupChecksMembers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	database.DB = sqlx.NewDb(db, "sqlmock")
+
+	org, u, outsider := uuid.New(), uuid.New(), uuid.New()
+	r := gin.New()
+	r.PUT("/organizations/:orgId/approval-groups/:name", PutApprovalGroup)
+	put := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/organizations/"+org.String()+"/approval-groups/finance", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	nonMembers := regexp.QuoteMeta(`FROM jsonb_array_elements_text($2::jsonb)`)
+
+	// a user of another org cannot approve this org's decisions
+	mock.ExpectQuery(nonMembers).WithArgs(org, jsonArg(`["`+u.String()+`","`+outsider.String()+`"]`)).
+		WillReturnRows(sqlmock.NewRows([]string{"u"}).AddRow(outsider))
+	if w := put(`{"members":["` + u.String() + `","` + outsider.String() + `"]}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), outsider.String()) {
+		t.Fatalf("expected the outsider rejected, got %d %s", w.Code, w.Body.String())
+	}
+	// a repeated member counts once towards the quorum
+	mock.ExpectQuery(nonMembers).WithArgs(org, jsonArg(`["`+u.String()+`"]`)).
+		WillReturnRows(sqlmock.NewRows([]string{"u"}))
+	if w := put(`{"members":["` + u.String() + `","` + u.String() + `"],"min_approvals":2}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "min_approvals") {
+		t.Fatalf("expected an unreachable quorum rejected, got %d %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
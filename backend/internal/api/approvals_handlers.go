@@ -90,12 +90,16 @@ func SlackApprovalWebhook(c *gin.Context) {
 }
 
 // GET /v2/approvals/:traceId — check runtime approval status (pending|approved|denied)
+// An approval request id returns the full request (status, votes, expiry) instead.
 func GetApprovalStatus(c *gin.Context) {
 	traceID := strings.TrimSpace(c.Param("traceId"))
 	if traceID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing traceId"})
 		return
 	}
+	if getApprovalRequest(c, traceID) {
+		return
+	}
 	var status string
 	err := database.DB.Get(&status, `SELECT status FROM runtime_approvals WHERE trace_id=$1`, traceID)
 	if err != nil || status == "" {
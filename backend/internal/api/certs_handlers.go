@@ -132,7 +132,7 @@ func evaluateAllowPolicy(c *gin.Context, orgID uuid.UUID, input map[string]any)
 	if e == nil {
 		return false, "unsupported engine", nil
 	}
-	cp, err := e.Compile(v.Body)
+	cp, err := compileForOrg(c.Request.Context(), e, orgID, v.Body)
 	if err != nil {
 		return false, err.Error(), nil
 	}
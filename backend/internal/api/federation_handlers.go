@@ -32,6 +32,8 @@ func CreateFederationContract(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// drop cached contract lookups (verify cache) across the mesh
+	PublishGraphInvalidate(c.Request.Context())
 	_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "federation_contract_created", gin.H{"id": id, "counterparty_org_id": req.CounterpartyOrgID, "scope": req.Scope}, nil, nil)
 	c.JSON(http.StatusCreated, gin.H{"id": id, "org_id": orgID, "counterparty_org_id": req.CounterpartyOrgID, "scope": req.Scope, "created_at": createdAt})
 }
@@ -100,14 +102,17 @@ func CreateFederationDelegation(c *gin.Context) {
 	if targetOrg == "" {
 		targetOrg = callerOrg
 	}
+	// the tuple is written in the caller's partition: delegating for another org, or to an agent of an org
+	// without an active federation contract, fails with rel.ErrCrossOrg
 	t := rel.Tuple{ObjectType: "org", ObjectID: targetOrg, Relation: relation, SubjectType: "agent", SubjectID: req.AgentID}
-	if err := relDB.Upsert(c.Request.Context(), []rel.Tuple{t}); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if err := relDB.Upsert(rel.WithOrg(c.Request.Context(), callerOrg), []rel.Tuple{t}); err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	relStore.Upsert([]rel.Tuple{t})
-	ClearGraphCache()
-	PublishGraphInvalidate(c.Request.Context())
+	relStore.Upsert(callerOrg, []rel.Tuple{t})
+	objects := []string{rel.ObjectKey(callerOrg, t)}
+	InvalidateGraphObjects(objects)
+	PublishGraphRevision(c.Request.Context(), "", objects)
 	_ = audit.Append(c.Request.Context(), uuid.MustParse(targetOrg), "federation_delegation_created", gin.H{"agent_id": req.AgentID, "from_org_id": req.CounterpartyOrgID, "relation": relation}, nil, nil)
 	c.Status(http.StatusNoContent)
 }
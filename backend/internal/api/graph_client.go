@@ -12,13 +12,28 @@ func SetGraphClient(gc rel.GraphClient) { graphClient = gc }
 func getGraph() rel.GraphClient         { return graphClient }
 
 // Helpers to build a cached client from an inner implementation
-func NewCachedGraph(inner rel.GraphClient, ttl, negTtl time.Duration) rel.GraphClient {
-	return rel.NewCachedGraph(inner, ttl, negTtl)
+func NewCachedGraph(inner rel.GraphClient, ttl, negTtl time.Duration, maxEntries int) rel.GraphClient {
+	return rel.NewCachedGraphSize(inner, ttl, negTtl, maxEntries)
 }
 
-// ClearGraphCache clears the underlying cache if the client is a CachedGraph wrapper
+// ClearGraphCache clears the underlying cache if the client is a CachedGraph wrapper, and the cached org schemas
 func ClearGraphCache() {
+	rel.ForgetSchemas()
 	if cg, ok := graphClient.(*rel.CachedGraph); ok {
 		cg.Clear()
 	}
 }
+
+// InvalidateGraphObjects drops the cached checks that read the given org/object#relation tuple sets
+func InvalidateGraphObjects(objects []string) {
+	if cg, ok := graphClient.(*rel.CachedGraph); ok {
+		cg.Invalidate(objects)
+	}
+}
+
+// ObserveGraphRevision lets the cache know a write reached the given revision token
+func ObserveGraphRevision(token string) {
+	if cg, ok := graphClient.(*rel.CachedGraph); ok && token != "" {
+		cg.Observe(token)
+	}
+}
@@ -6,6 +6,7 @@ import (
 
 	database "github.com/Armour007/aura-backend/internal"
 	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/rel"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -25,10 +26,12 @@ type InlineGuardRequest struct {
 }
 
 type InlineGuardResponse struct {
-	Status  string   `json:"status"` // allow|deny|needs_approval
-	Reason  string   `json:"reason,omitempty"`
-	Hints   []string `json:"hints,omitempty"`
-	TraceID string   `json:"trace_id,omitempty"`
+	Status      string              `json:"status"` // allow|deny|needs_approval
+	Reason      string              `json:"reason,omitempty"`
+	Hints       []string            `json:"hints,omitempty"`
+	TraceID     string              `json:"trace_id,omitempty"`
+	Obligations []policy.Obligation `json:"obligations,omitempty"`
+	Advice      []policy.Obligation `json:"advice,omitempty"`
 }
 
 // POST /v2/guard — inline policy guard for agent actions
@@ -40,43 +43,42 @@ func InlineGuard(c *gin.Context) {
 		return
 	}
 
-	// Decide which engine and body to use
-	var engine policy.Evaluator
-	var body json.RawMessage
+	agentStr := req.AgentID.String()
+	if req.AgentID == uuid.Nil {
+		agentStr = c.GetString("agentID")
+	}
+	input := withRequestFacts(req.RequestContext, orgID, agentStr, req.Action, req.Resource)
+
+	// Evaluate the inline policy when provided, else compose the org's applicable assignments like VerifyV2;
+	// rel clauses check the org's graph
+	var caveatCtx map[string]any
+	_ = json.Unmarshal(req.RequestContext, &caveatCtx)
+	ectx := policy.WithRelations(c.Request.Context(), policyRelations(orgID, caveatCtx, rel.Consistency{}))
+	var dec policy.Decision
 	if req.Policy != nil && len(req.Policy.Body) > 0 {
-		engine = evalRegistry[req.Policy.Engine]
-		body = req.Policy.Body
+		engine := evalRegistry[req.Policy.Engine]
 		if engine == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 			return
 		}
+		oid, _ := uuid.Parse(orgID)
+		cp, err := compileForOrg(c.Request.Context(), engine, oid, req.Policy.Body)
+		if err != nil {
+			c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: err.Error()})
+			return
+		}
+		dec, err = policy.EvaluateWithContext(ectx, engine, cp, input)
+		if err != nil {
+			c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: err.Error()})
+			return
+		}
 	} else {
-		// Load active assignment and current version similar to VerifyV2
-		assigns, err := policy.GetActiveAssignmentsForOrg(c.Request.Context(), uuid.MustParse(orgID))
+		assigns, err := policy.GetApplicableAssignments(c.Request.Context(), uuid.MustParse(orgID), agentStr)
 		if err != nil || len(assigns) == 0 {
 			c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: "No active policy assignment"})
 			return
 		}
-		p := assigns[0].Policy
-		v := assigns[0].Version
-		engine = evalRegistry[p.EngineType]
-		if engine == nil {
-			c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: "Unsupported engine"})
-			return
-		}
-		body = v.Body
-	}
-
-	// Compile and evaluate
-	cp, err := engine.Compile(body)
-	if err != nil {
-		c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: err.Error()})
-		return
-	}
-	dec, err := engine.Evaluate(cp, req.RequestContext)
-	if err != nil {
-		c.JSON(http.StatusOK, InlineGuardResponse{Status: "deny", Reason: err.Error()})
-		return
+		dec, _ = evaluateAssignments(ectx, policy.GetCombiningAlg(c.Request.Context(), uuid.MustParse(orgID)), assigns, input)
 	}
 
 	// Persist a lightweight decision trace for analytics (optional)
@@ -102,5 +104,5 @@ func InlineGuard(c *gin.Context) {
 	} else if dec.RequireApproval {
 		status = "needs_approval"
 	}
-	c.JSON(http.StatusOK, InlineGuardResponse{Status: status, Reason: dec.Reason, Hints: dec.Hints, TraceID: dec.TraceID})
+	c.JSON(http.StatusOK, InlineGuardResponse{Status: status, Reason: dec.Reason, Hints: dec.Hints, TraceID: dec.TraceID, Obligations: dec.Obligations, Advice: dec.Advice})
 }
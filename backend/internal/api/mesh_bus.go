@@ -17,6 +17,26 @@ func PublishGraphInvalidate(ctx context.Context) {
 		_ = bus.Publish(ctx, mesh.Event{Topic: mesh.TopicGraphInvalidate})
 	}
 }
+
+// graphInvalidation is the payload of graph.invalidate events announcing tuple writes
+type graphInvalidation struct {
+	RevisionToken string `json:"revision_token,omitempty"`
+	// Objects lists the org/object#relation tuple sets written (see rel.ObjectKey); null invalidates every cached check
+	Objects []string `json:"objects"`
+}
+
+// PublishGraphRevision announces a tuple write with its revision token and the tuple sets it touched
+// (nil when unknown), so replicas drop the affected cached checks and can tell which revision their
+// remaining entries reflect
+func PublishGraphRevision(ctx context.Context, token string, objects []string) {
+	if bus == nil {
+		return
+	}
+	payload, _ := json.Marshal(graphInvalidation{RevisionToken: token, Objects: objects})
+	_ = bus.Publish(ctx, mesh.Event{Topic: mesh.TopicGraphInvalidate, Payload: payload})
+}
+
+// PublishPolicyInvalidate announces a policy change; an empty policyID invalidates every policy-derived cache
 func PublishPolicyInvalidate(ctx context.Context, policyID string) {
 	if bus == nil {
 		return
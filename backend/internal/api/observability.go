@@ -88,10 +88,20 @@ var (
 	verifyQuickRejectTotal = prometheus.NewCounter(
 		prometheus.CounterOpts{Namespace: "aura", Name: "verify_quick_reject_total", Help: "Total verify requests rejected immediately due to backpressure"},
 	)
+	// v1 dual-run divergences between the legacy engine and converted policies
+	v1DivergenceTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Namespace: "aura", Name: "v1_verify_divergence_total", Help: "v1 verify divergences between legacy rules and converted policies by kind"},
+		[]string{"kind", "org"},
+	)
+	// shadow evaluations of candidate policy versions on live verify decisions
+	shadowEvaluationTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{Namespace: "aura", Name: "policy_shadow_evaluation_total", Help: "Shadow evaluations of candidate policy versions by outcome (agree|decision|approval|error|dropped)"},
+		[]string{"outcome", "org"},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(reqDuration, reqTotal, decisionTotal, externalDuration, externalTotal, breakerOpen, dlqInsertTotal, dlqDepth, queuePending, decisionReasonTotal, apiKeyUsageTotal, cacheHitTotal, cacheMissTotal, trustTokensTotal, verifyInflight, verifyQuickRejectTotal)
+	prometheus.MustRegister(reqDuration, reqTotal, decisionTotal, externalDuration, externalTotal, breakerOpen, dlqInsertTotal, dlqDepth, queuePending, decisionReasonTotal, apiKeyUsageTotal, cacheHitTotal, cacheMissTotal, trustTokensTotal, verifyInflight, verifyQuickRejectTotal, v1DivergenceTotal, shadowEvaluationTotal)
 }
 
 // MetricsMiddleware records basic HTTP metrics
@@ -187,6 +197,22 @@ func RecordAPIKeyUsage(keyPrefix, org string) {
 // RecordCacheHit increments the cache hit counter for a component/key
 func RecordCacheHit(component, key string) { cacheHitTotal.WithLabelValues(component, key).Inc() }
 
+// RecordV1Divergence counts a v1 dual-run divergence by kind (decision|stale|error)
+func RecordV1Divergence(kind, org string) {
+	if !includeOrgLabel {
+		org = ""
+	}
+	v1DivergenceTotal.WithLabelValues(kind, org).Inc()
+}
+
+// RecordShadowEvaluation counts a shadow evaluation by outcome (agree|decision|approval|error|dropped)
+func RecordShadowEvaluation(outcome, org string) {
+	if !includeOrgLabel {
+		org = ""
+	}
+	shadowEvaluationTotal.WithLabelValues(outcome, org).Inc()
+}
+
 // RecordCacheMiss increments the cache miss counter for a component/key
 func RecordCacheMiss(component, key string) { cacheMissTotal.WithLabelValues(component, key).Inc() }
 
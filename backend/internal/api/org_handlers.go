@@ -5,6 +5,7 @@ import (
 
 	database "github.com/Armour007/aura-backend/internal"
 	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
@@ -77,6 +78,8 @@ func UpdateOrganization(c *gin.Context) {
 // UpdateOrganizationSettings toggles org-level flags like api_keys_disabled. Admin only.
 type UpdateOrganizationSettingsRequest struct {
 	ApiKeysDisabled *bool `json:"api_keys_disabled"`
+	// PolicyCombiningAlg selects how multiple applicable policies are merged (deny-overrides|permit-overrides|first-applicable|only-one-applicable)
+	PolicyCombiningAlg *string `json:"policy_combining_alg"`
 }
 
 // PUT /organizations/:orgId/settings
@@ -87,20 +90,38 @@ func UpdateOrganizationSettings(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
 		return
 	}
-	if req.ApiKeysDisabled == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "api_keys_disabled required"})
+	if req.ApiKeysDisabled == nil && req.PolicyCombiningAlg == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_keys_disabled or policy_combining_alg required"})
 		return
 	}
-	if _, err := database.DB.Exec(`UPDATE organizations SET api_keys_disabled=$1, updated_at=NOW() WHERE id=$2`, *req.ApiKeysDisabled, orgID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update settings"})
+	if req.PolicyCombiningAlg != nil && !policy.ValidCombiningAlg(*req.PolicyCombiningAlg) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid policy_combining_alg"})
 		return
 	}
+	changes := map[string]any{}
+	if req.ApiKeysDisabled != nil {
+		if _, err := database.DB.Exec(`UPDATE organizations SET api_keys_disabled=$1, updated_at=NOW() WHERE id=$2`, *req.ApiKeysDisabled, orgID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update settings"})
+			return
+		}
+		changes["api_keys_disabled"] = *req.ApiKeysDisabled
+	}
+	if req.PolicyCombiningAlg != nil {
+		if _, err := database.DB.Exec(`UPDATE organizations SET policy_combining_alg=$1, updated_at=NOW() WHERE id=$2`, *req.PolicyCombiningAlg, orgID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update settings"})
+			return
+		}
+		changes["policy_combining_alg"] = *req.PolicyCombiningAlg
+		// cached verify decisions were combined with the previous algorithm
+		PublishPolicyInvalidate(c.Request.Context(), "")
+	}
 	// audit
 	var actor *uuid.UUID
 	if uid := c.GetString("userID"); uid != "" {
 		u := uuid.MustParse(uid)
 		actor = &u
 	}
-	_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "org_settings_update", map[string]any{"api_keys_disabled": *req.ApiKeysDisabled}, actor, nil)
-	c.JSON(http.StatusOK, gin.H{"id": orgID, "api_keys_disabled": *req.ApiKeysDisabled})
+	_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "org_settings_update", changes, actor, nil)
+	changes["id"] = orgID
+	c.JSON(http.StatusOK, changes)
 }
@@ -15,6 +15,10 @@ import (
 type activateReq struct {
 	// Optional simulation context that must allow=true to proceed
 	RequestContext json.RawMessage `json:"request_context"`
+	// Optional replay of the version that must have succeeded (required with AURA_POLICY_REQUIRE_REPLAY=1,
+	// which defaults to the version's latest), flipping at most MaxFlipRate of the decisions it evaluated
+	ReplayID    string   `json:"replay_id"`
+	MaxFlipRate *float64 `json:"max_flip_rate"`
 }
 
 // POST /organizations/:orgId/policies/:policyId/versions/:version/activate
@@ -50,12 +54,25 @@ func ActivatePolicyVersion(c *gin.Context) {
 		return
 	}
 
+	// Replay gate
+	replay, gated, err := activationReplay(c.Request.Context(), p.OrgID, pid, version, req.ReplayID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if gated {
+		if err := replayGate(replay, pid, version, maxFlipRate(req.MaxFlipRate), minReplayEvaluated()); err != nil {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	e := evalRegistry[p.EngineType]
 	if e == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	comp, err := e.Compile(v.Body)
+	comp, err := compileForOrg(c.Request.Context(), e, p.OrgID, v.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -79,12 +96,23 @@ func ActivatePolicyVersion(c *gin.Context) {
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
+	// A shadow of the version has served its purpose; its report is kept
+	if stopped, _ := polrepo.StopShadow(c.Request.Context(), p.OrgID, pid, version); stopped {
+		ForgetPolicyShadows()
+	}
 	// Warm cache after activation
 	polrepo.PutCompiled(pid, version, comp)
 	// Invalidate other compiled versions to avoid stale active lookup
 	polrepo.DeleteCompiled(pid, 0)
 	// publish policy invalidation across mesh
 	PublishPolicyInvalidate(c.Request.Context(), pid.String())
-	_ = audit.Append(c.Request.Context(), uuid.Nil, "policy_version_activate", map[string]any{"policy_id": pid, "version": version}, nil, nil)
+	_ = audit.Append(c.Request.Context(), uuid.Nil, "policy_version_activate", map[string]any{"policy_id": pid, "version": version, "replay_id": replayRef(replay)}, nil, nil)
 	c.Status(http.StatusNoContent)
 }
+
+func replayRef(r *polrepo.Replay) any {
+	if r == nil {
+		return nil
+	}
+	return r.ID
+}
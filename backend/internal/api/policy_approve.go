@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,7 +14,8 @@ import (
 	polrepo "github.com/Armour007/aura-backend/internal/policy"
 )
 
-// POST /organizations/:orgId/policies/:policyId/versions/:version/approve
+// POST /organizations/:orgId/policies/:policyId/versions/:version/approve?base=7
+// Records the approval with the diff it was given on; reviewers fetch that diff from DiffPolicyVersions.
 func ApprovePolicyVersion(c *gin.Context) {
 	// orgID reserved for future scoping checks
 	_ = c.Param("orgId")
@@ -49,7 +51,7 @@ func ApprovePolicyVersion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	if _, err := e.Compile(v.Body); err != nil {
+	if _, err := compileForOrg(c.Request.Context(), e, p.OrgID, v.Body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -60,7 +62,23 @@ func ApprovePolicyVersion(c *gin.Context) {
 			approvalsRequired = n
 		}
 	}
-	if err := polrepo.RecordApproval(c.Request.Context(), pid, version, approver); err != nil {
+	// attach the diff under review: against ?base= when given, else the active (or previous) version
+	base := defaultDiffBase(c.Request.Context(), pid, version)
+	if s := c.Query("base"); s != "" {
+		if base, err = strconv.Atoi(s); err != nil || base < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad base version"})
+			return
+		}
+	}
+	// a body the engine cannot diff is still approved, without a recorded review
+	var review *polrepo.ApprovalReview
+	if d, err := diffVersions(c.Request.Context(), p, base, version); err == nil {
+		review = &polrepo.ApprovalReview{BaseVersion: base, Summary: d.Summary()}
+	} else if errors.Is(err, errVersionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "base version not found"})
+		return
+	}
+	if err := polrepo.RecordApproval(c.Request.Context(), pid, version, approver, review); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -80,6 +98,10 @@ func ApprovePolicyVersion(c *gin.Context) {
 	// publish policy invalidation across mesh
 	PublishPolicyInvalidate(c.Request.Context(), pid.String())
 	// Audit ledger entry
-	_ = audit.Append(c.Request.Context(), uuid.Nil, "policy_version_approval", map[string]any{"policy_id": pid, "version": version, "approvals": count}, approver, nil)
+	entry := map[string]any{"policy_id": pid, "version": version, "approvals": count}
+	if review != nil {
+		entry["base_version"], entry["diff_summary"] = review.BaseVersion, review.Summary
+	}
+	_ = audit.Append(c.Request.Context(), uuid.Nil, "policy_version_approval", entry, approver, nil)
 	c.Status(http.StatusNoContent)
 }
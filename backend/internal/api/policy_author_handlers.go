@@ -3,11 +3,13 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"regexp"
 	"strings"
 
 	database "github.com/Armour007/aura-backend/internal"
 	"github.com/Armour007/aura-backend/internal/policy"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 type NLCompileRequest struct {
@@ -19,9 +21,58 @@ type NLCompileResponse struct {
 	Engine string          `json:"engine"`
 	Body   json.RawMessage `json:"body"`
 	Notes  []string        `json:"notes,omitempty"`
+	// NeedsReview marks a draft the heuristic could not fully translate; the body then grants nothing the
+	// text did not clearly ask for and has to be completed by hand
+	NeedsReview bool `json:"needs_review"`
+}
+
+var (
+	nlSentence = regexp.MustCompile(`[.;!?\n]+`)
+	nlNegation = regexp.MustCompile(`\b(not|no|never|without|don't|dont|doesn't|needn't|isn't)\b`)
+	nlApproval = regexp.MustCompile(`\b(approv\w*|requir\w*|review\w*|sign-?off|only if)\b`)
+	nlDeny     = regexp.MustCompile(`\b(deny|denied|forbid\w*|block\w*|prohibit\w*|reject\w*)\b`)
+	// nlNever are deny phrasings that carry their own negation ("never export PII", "must not delete")
+	nlNever = regexp.MustCompile(`^\s*(never|must not|cannot|can't|do not|don't)\b`)
+)
+
+// nlIntents classifies each sentence of a natural-language policy as a deny or an approval requirement.
+// A negated requirement ("do not require approval") is not read as one: like any sentence the heuristic
+// cannot classify, it is returned so the draft is flagged for review.
+func nlIntents(nl string) (deny, approval bool, unclassified []string) {
+	for _, s := range nlSentence.Split(strings.ToLower(nl), -1) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		negatedBefore := func(kw []int) bool {
+			neg := nlNegation.FindStringIndex(s)
+			return neg != nil && neg[0] < kw[0]
+		}
+		switch {
+		case nlApproval.MatchString(s):
+			if negatedBefore(nlApproval.FindStringIndex(s)) {
+				unclassified = append(unclassified, s)
+			} else {
+				approval = true
+			}
+		case nlDeny.MatchString(s):
+			if negatedBefore(nlDeny.FindStringIndex(s)) {
+				unclassified = append(unclassified, s)
+			} else {
+				deny = true
+			}
+		case nlNever.MatchString(s):
+			deny = true
+		default:
+			unclassified = append(unclassified, s)
+		}
+	}
+	return deny, approval, unclassified
 }
 
 // POST /v2/policy/author/nl-compile
+// The translation is a heuristic draft: it only emits deny and approval rules and denies by default, so
+// it never grants access. Anything it cannot classify is listed in notes and sets needs_review.
 func CompilePolicyFromNL(c *gin.Context) {
 	var req NLCompileRequest
 	if err := c.ShouldBindJSON(&req); err != nil || req.Engine == "" || strings.TrimSpace(req.NL) == "" {
@@ -29,49 +80,68 @@ func CompilePolicyFromNL(c *gin.Context) {
 		return
 	}
 	eng := strings.ToLower(req.Engine)
-	nl := strings.ToLower(req.NL)
+	deny, approval, unclassified := nlIntents(req.NL)
+	resp := NLCompileResponse{Engine: eng, Notes: []string{"heuristic translation; review before use"}}
+	for _, s := range unclassified {
+		resp.Notes = append(resp.Notes, "not translated: "+s)
+	}
+	resp.NeedsReview = len(unclassified) > 0 || (!deny && !approval)
+	if !deny && !approval {
+		resp.Notes = append(resp.Notes, "no rule could be derived; the draft denies every request")
+	}
 	var body any
-	notes := []string{"heuristic translation; review before use"}
-	// Very simple heuristics
 	if eng == policy.EngineAuraJSON {
+		// no rule matching is a deny
 		rules := []map[string]any{}
-		if strings.Contains(nl, "never") || strings.Contains(nl, "forbid") || strings.Contains(nl, "deny") {
+		if deny {
 			rules = append(rules, map[string]any{"id": "deny_1", "effect": "deny", "when": map[string]any{}})
 		}
-		if strings.Contains(nl, "only if") || strings.Contains(nl, "require") {
+		if approval {
 			// create a guard that requires approval by default; user can refine conditions
 			rules = append(rules, map[string]any{"id": "needs_approval_1", "effect": "require_approval", "hint": "Manual review due to policy"})
 		}
-		if len(rules) == 0 {
-			rules = append(rules, map[string]any{"id": "allow_all", "effect": "allow"})
-		}
 		body = map[string]any{"rules": rules, "precedence": map[string]any{"deny_overrides": true}}
 	} else if eng == policy.EngineRego || eng == "rego" || eng == "opa" {
-		// Generate a minimal Rego template. The user can refine input fields.
+		// Generate a decision-document template (package aura.guard is the default entrypoint).
+		// The user can refine input fields.
 		tmpl := `package aura.guard
 
 default allow = false
 
-# Set allow to true when conditions are met
+# Replace false with the conditions under which requests are allowed
 allow {
-  # TODO: add conditions based on request_context fields
+  false
+}
+
+# Messages here deny the request and become the reason
+deny[msg] {
+  false
+  msg := "describe why the request is denied"
 }
 
-# Optional: require approval (out-of-band hint)
-require_approval {
+# Messages here require human approval and are returned as hints
+require_approval[msg] {
   false
+  msg := "Manual review due to policy"
 }
 `
+		if deny {
+			tmpl = strings.Replace(tmpl, "deny[msg] {\n  false\n", "deny[msg] {\n  true\n", 1)
+		}
+		if approval {
+			tmpl = strings.Replace(tmpl, "require_approval[msg] {\n  false\n", "require_approval[msg] {\n  true\n", 1)
+		}
 		body = map[string]any{"module": tmpl}
 	} else {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	b, _ := json.Marshal(body)
-	c.JSON(http.StatusOK, NLCompileResponse{Engine: eng, Body: b, Notes: notes})
+	resp.Body, _ = json.Marshal(body)
+	c.JSON(http.StatusOK, resp)
 }
 
 type PolicyTestCase struct {
+	Name   string          `json:"name,omitempty"`
 	Input  json.RawMessage `json:"input"`
 	Expect string          `json:"expect"` // allow|deny|needs_approval
 }
@@ -84,6 +154,7 @@ type PolicyTestRequest struct {
 
 type PolicyTestResult struct {
 	Index  int      `json:"index"`
+	Name   string   `json:"name,omitempty"`
 	Status string   `json:"status"`
 	Reason string   `json:"reason,omitempty"`
 	Hints  []string `json:"hints,omitempty"`
@@ -102,13 +173,20 @@ func RunPolicyTests(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	cp, err := engine.Compile(req.Body)
+	oid, _ := uuid.Parse(c.GetString("orgID"))
+	cp, err := compileForOrg(c.Request.Context(), engine, oid, req.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	results := make([]PolicyTestResult, 0, len(req.Tests))
-	for i, tc := range req.Tests {
+	results := runPolicyTests(engine, cp, req.Tests)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// runPolicyTests evaluates each case against a compiled policy and compares the status with the expectation
+func runPolicyTests(engine policy.Evaluator, cp policy.CompiledPolicy, tests []PolicyTestCase) []PolicyTestResult {
+	results := make([]PolicyTestResult, 0, len(tests))
+	for i, tc := range tests {
 		dec, err := engine.Evaluate(cp, tc.Input)
 		status := "deny"
 		hints := dec.Hints
@@ -123,9 +201,9 @@ func RunPolicyTests(c *gin.Context) {
 			reason = err.Error()
 		}
 		pass := strings.EqualFold(status, tc.Expect)
-		results = append(results, PolicyTestResult{Index: i, Status: status, Reason: reason, Hints: hints, Pass: pass})
+		results = append(results, PolicyTestResult{Index: i, Name: tc.Name, Status: status, Reason: reason, Hints: hints, Pass: pass})
 	}
-	c.JSON(http.StatusOK, gin.H{"results": results})
+	return results
 }
 
 type PreviewRequest struct {
@@ -178,7 +256,8 @@ func PreviewPolicyAgainstTraces(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	cp, err := engine.Compile(body)
+	oid, _ := uuid.Parse(orgID)
+	cp, err := compileForOrg(c.Request.Context(), engine, oid, body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
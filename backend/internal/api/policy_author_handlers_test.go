@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+	opaeval "github.com/Armour007/aura-backend/internal/policy/opa"
+	"github.com/gin-gonic/gin"
+)
+
+func TestNLIntents(t *testing.T) {
+	for nl, want := range map[string]struct{ deny, approval, unclassified bool }{
+		"Payments over 100 require approval":         {approval: true},
+		"Do not require approval for reads":          {unclassified: true},
+		"Never export PII":                           {deny: true},
+		"Block deletes. Transfers need review":       {deny: true, approval: true},
+		"Don't block reads":                          {unclassified: true},
+		"Agents may read the wiki":                   {unclassified: true},
+		"Deny shell access; allow reads from the db": {deny: true, unclassified: true},
+	} {
+		deny, approval, unclassified := nlIntents(nl)
+		if deny != want.deny || approval != want.approval || (len(unclassified) > 0) != want.unclassified {
+			t.Errorf("%q: deny %v approval %v unclassified %v", nl, deny, approval, unclassified)
+		}
+	}
+}
+
+func TestCompilePolicyFromNLNeverAllows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engines := map[string]policy.Evaluator{policy.EngineAuraJSON: &policy.AuraJSONEvaluator{}, policy.EngineRego: opaeval.New()}
+	for _, nl := range []string{"agents may do anything", "do not require approval for reads", "transfers require approval"} {
+		for name, e := range engines {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			b, _ := json.Marshal(NLCompileRequest{Engine: name, NL: nl})
+			c.Request = httptest.NewRequest(http.MethodPost, "/v2/policy/author/nl-compile", strings.NewReader(string(b)))
+			c.Request.Header.Set("Content-Type", "application/json")
+			CompilePolicyFromNL(c)
+			var resp NLCompileResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || w.Code != http.StatusOK {
+				t.Fatalf("%s %q: %d %s", name, nl, w.Code, w.Body.String())
+			}
+			cp, err := e.Compile(resp.Body)
+			if err != nil {
+				t.Fatalf("%s %q: compile: %v", name, nl, err)
+			}
+			d, err := e.Evaluate(cp, json.RawMessage(`{"action":"read"}`))
+			if err != nil || d.Allow {
+				t.Errorf("%s %q: the draft must not allow: %+v %v", name, nl, d, err)
+			}
+			if strings.HasPrefix(nl, "transfers") != !resp.NeedsReview || strings.HasPrefix(nl, "transfers") != d.RequireApproval {
+				t.Errorf("%s %q: needs_review %v, decision %+v", name, nl, resp.NeedsReview, d)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// data document names must be valid Rego identifiers since they are mounted at data.<name>
+var regoDataName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// GET /organizations/:orgId/policy-data
+func ListRegoDataBundles(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	items, err := policy.ListRegoData(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// PUT /organizations/:orgId/policy-data/:name
+// Body: any JSON document; Rego policies of the org read it as data.<name>.
+func PutRegoDataBundle(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	name := c.Param("name")
+	if !regoDataName.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be a Rego identifier ([A-Za-z_][A-Za-z0-9_]*)"})
+		return
+	}
+	var doc any
+	if err := c.ShouldBindJSON(&doc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	raw, _ := json.Marshal(doc)
+	var uid *uuid.UUID
+	if s := c.GetString("userID"); s != "" {
+		if u, err := uuid.Parse(s); err == nil {
+			uid = &u
+		}
+	}
+	d, err := policy.PutRegoData(c.Request.Context(), orgID, name, raw, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	invalidateRegoPolicies(c.Request.Context(), orgID)
+	_ = audit.Append(c.Request.Context(), orgID, "policy_data_put", map[string]any{"name": name, "revision": d.Revision}, uid, nil)
+	c.JSON(http.StatusOK, d)
+}
+
+// DELETE /organizations/:orgId/policy-data/:name
+func DeleteRegoDataBundle(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	ok, err := policy.DeleteRegoData(c.Request.Context(), orgID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	invalidateRegoPolicies(c.Request.Context(), orgID)
+	_ = audit.Append(c.Request.Context(), orgID, "policy_data_deleted", map[string]any{"name": c.Param("name")}, nil, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// invalidateRegoPolicies drops compiled Rego policies of the org locally and across the mesh,
+// since their prepared queries embed the previous data documents
+func invalidateRegoPolicies(ctx context.Context, orgID uuid.UUID) {
+	var ids []uuid.UUID
+	_ = database.DB.SelectContext(ctx, &ids, `SELECT id FROM policies WHERE org_id=$1 AND engine_type IN ('rego','opa')`, orgID)
+	for _, id := range ids {
+		policy.DeleteCompiled(id, 0)
+		PublishPolicyInvalidate(ctx, id.String())
+	}
+}
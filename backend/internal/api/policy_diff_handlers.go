@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	errDiffUnsupported = errors.New("engine cannot diff versions")
+	errVersionNotFound = errors.New("policy version not found")
+)
+
+// defaultDiffBase is the version a reviewer compares against: the active one, or the previous one when
+// the version is active or nothing is
+func defaultDiffBase(ctx context.Context, pid uuid.UUID, version int) int {
+	if active, err := policy.ActiveVersion(ctx, pid); err == nil && active > 0 && active != version {
+		return active
+	}
+	return version - 1
+}
+
+// diffVersions diffs a version against base (0: an empty policy) with the policy's engine
+func diffVersions(ctx context.Context, p database.Policy, base, version int) (policy.VersionDiff, error) {
+	differ, ok := evalRegistry[p.EngineType].(policy.Differ)
+	if !ok {
+		return policy.VersionDiff{}, errDiffUnsupported
+	}
+	var before json.RawMessage
+	if base > 0 {
+		bv, err := policy.GetVersion(ctx, p.ID, base)
+		if err != nil {
+			return policy.VersionDiff{}, versionErr(err)
+		}
+		before = bv.Body
+	}
+	v, err := policy.GetVersion(ctx, p.ID, version)
+	if err != nil {
+		return policy.VersionDiff{}, versionErr(err)
+	}
+	d, err := differ.Diff(before, v.Body)
+	if err != nil {
+		return policy.VersionDiff{}, badBodyError{err}
+	}
+	d.From, d.To = base, version
+	return d, nil
+}
+
+func versionErr(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return errVersionNotFound
+	}
+	return err
+}
+
+// badBodyError is a stored body the engine cannot diff
+type badBodyError struct{ error }
+
+// diffStatus maps diffVersions errors to a status
+func diffStatus(err error) int {
+	var bad badBodyError
+	switch {
+	case errors.Is(err, errVersionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errDiffUnsupported):
+		return http.StatusBadRequest
+	case errors.As(err, &bad):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// writeVersionDiff answers the diff of the :version path parameter against ?base= (default: defaultDiffBase)
+func writeVersionDiff(c *gin.Context, p database.Policy) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad version"})
+		return
+	}
+	base := defaultDiffBase(c.Request.Context(), p.ID, version)
+	if s := c.Query("base"); s != "" {
+		if base, err = strconv.Atoi(s); err != nil || base < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad base version"})
+			return
+		}
+	}
+	d, err := diffVersions(c.Request.Context(), p, base, version)
+	if err != nil {
+		c.JSON(diffStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"policy_id": p.ID, "diff": d, "summary": d.Summary()})
+}
+
+// GET /organizations/:orgId/policies/:policyId/versions/:version/diff?base=7
+// Structural diff of the version against base (default: the active version, else the previous one; 0 diffs
+// against an empty policy), with the action/field combinations whose outcome changed.
+func DiffPolicyVersions(c *gin.Context) {
+	_, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	writeVersionDiff(c, p)
+}
+
+// DiffPolicyVersionsV2 is DiffPolicyVersions scoped to the caller's org from auth context.
+// GET /v2/policies/:policyId/versions/:version/diff?base=7
+func DiffPolicyVersionsV2(c *gin.Context) {
+	pid, err := uuid.Parse(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad policy id"})
+		return
+	}
+	p, err := policy.GetPolicy(c.Request.Context(), pid)
+	if err != nil || p.OrgID.String() != c.GetString("orgID") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+		return
+	}
+	writeVersionDiff(c, p)
+}
@@ -9,6 +9,7 @@ import (
 	db "github.com/Armour007/aura-backend/internal"
 	"github.com/Armour007/aura-backend/internal/audit"
 	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/policy/bundle"
 	opaeval "github.com/Armour007/aura-backend/internal/policy/opa"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -73,6 +74,24 @@ func AddPolicyVersion(c *gin.Context) {
 		return
 	}
 	b, _ := json.Marshal(req.Body)
+	// checksum the body as submitted, so a policy bundle holding the same source is seen as unchanged
+	checksum, _ := bundle.Checksum(b)
+	// compile up front so malformed rules and schemas are rejected here instead of failing at verify time
+	var pol db.Policy
+	var cp policy.CompiledPolicy
+	if err := db.DB.Get(&pol, `SELECT id, org_id, name, engine_type, created_by_user_id, created_at FROM policies WHERE id=$1`, pid); err == nil {
+		// snapshot shared org schema definitions into the version so it stays reproducible
+		if b, err = policy.BundleOrgSchemaDefs(c.Request.Context(), pol.OrgID, b); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if e := evalRegistry[pol.EngineType]; e != nil {
+			if cp, err = compileForOrg(c.Request.Context(), e, pol.OrgID, b); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
 	createdBy := c.GetString("userID")
 	var uid *uuid.UUID
 	if createdBy != "" {
@@ -84,18 +103,10 @@ func AddPolicyVersion(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if req.ChangeTicket != "" {
-		_, _ = db.DB.Exec(`UPDATE policy_versions SET change_ticket=$1 WHERE policy_id=$2 AND version=$3`, req.ChangeTicket, pid, pv.Version)
-	}
-	// optional: pre-compile into cache to speed up first request
-	// pick evaluator
-	var pol db.Policy
-	if err := db.DB.Get(&pol, `SELECT id, org_id, name, engine_type, created_by_user_id, created_at FROM policies WHERE id=$1`, pid); err == nil {
-		if e := evalRegistry[pol.EngineType]; e != nil {
-			if cp, err := e.Compile(b); err == nil {
-				policy.PutCompiled(pid, pv.Version, cp)
-			}
-		}
+	_ = policy.SetVersionSource(c.Request.Context(), pid, pv.Version, checksum, req.ChangeTicket)
+	// pre-compiled above; cache to speed up first request
+	if cp != nil {
+		policy.PutCompiled(pid, pv.Version, cp)
 	}
 	// Invalidate other compiled versions to avoid stale behavior after new version is introduced (still draft)
 	policy.DeleteCompiled(pid, 0)
@@ -1,81 +1,299 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"time"
 
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/policy/bundle"
+	"github.com/Armour007/aura-backend/internal/policy/packs"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
-type PolicyPack struct {
-	ID          string           `json:"id"`
-	Name        string           `json:"name"`
-	Industry    string           `json:"industry"`
-	Description string           `json:"description"`
-	Engine      string           `json:"engine"`
-	Actions     []string         `json:"actions"`
-	Body        map[string]any   `json:"body"`
-	Tests       []map[string]any `json:"tests"`
-}
-
-// Minimal built-in packs registry (can be extended to pull from a catalog)
-var policyPacks = []PolicyPack{
-	{
-		ID:          "soc2-base-aurajson",
-		Name:        "SOC2 Base Controls",
-		Industry:    "Any",
-		Description: "Baseline guardrails aligned with SOC2 CC5/CC6: admin approval for sensitive actions, deny on missing posture.",
-		Engine:      "aurajson",
-		Actions:     []string{"issue_cert", "delete_agent", "rotate_trust_key"},
-		Body: map[string]any{
-			"rules": []any{
-				map[string]any{"when": map[string]any{"action": "issue_cert", "device.posture_ok": true}, "allow": true, "reason": "device posture ok"},
-				map[string]any{"when": map[string]any{"action": "delete_agent"}, "require_approval": true, "reason": "sensitive deletion"},
-				map[string]any{"when": map[string]any{"action": "rotate_trust_key"}, "allow": true},
-			},
-			"default": map[string]any{"deny": true, "reason": "not permitted"},
-		},
-		Tests: []map[string]any{
-			{"name": "issue_cert posture ok", "input": map[string]any{"action": "issue_cert", "device": map[string]any{"posture_ok": true}}, "expect": map[string]any{"allow": true}},
-			{"name": "delete_agent requires approval", "input": map[string]any{"action": "delete_agent"}, "expect": map[string]any{"require_approval": true}},
-		},
-	},
-	{
-		ID:          "gdpr-data-export-guard",
-		Name:        "GDPR Data Export Guard",
-		Industry:    "Any",
-		Description: "Require explicit approval for PII export and restrict cross-region unless whitelisted.",
-		Engine:      "aurajson",
-		Actions:     []string{"export_pii"},
-		Body: map[string]any{
-			"rules": []any{
-				map[string]any{"when": map[string]any{"action": "export_pii", "region": []any{"EU", "EEA"}}, "allow": true},
-				map[string]any{"when": map[string]any{"action": "export_pii"}, "require_approval": true, "reason": "cross-region export"},
-			},
-			"default": map[string]any{"deny": true},
-		},
-		Tests: []map[string]any{
-			{"name": "eu export allowed", "input": map[string]any{"action": "export_pii", "region": "EU"}, "expect": map[string]any{"allow": true}},
-		},
-	},
-}
+// packUpgradePoll is how often installs are checked for newer pack versions
+const packUpgradePoll = 10 * time.Minute
 
 // GET /v2/policy/packs
 func ListPolicyPacks(c *gin.Context) {
-	items := make([]gin.H, 0, len(policyPacks))
-	for _, p := range policyPacks {
-		items = append(items, gin.H{"id": p.ID, "name": p.Name, "industry": p.Industry, "description": p.Description, "engine": p.Engine, "actions": p.Actions})
+	all := packs.All()
+	items := make([]gin.H, 0, len(all))
+	for _, p := range all {
+		items = append(items, gin.H{"id": p.ID, "version": p.Version, "name": p.Name, "industry": p.Industry, "description": p.Description, "engine": p.Engine, "actions": p.Actions, "params": p.Params})
 	}
 	c.JSON(http.StatusOK, gin.H{"items": items})
 }
 
 // GET /v2/policy/packs/:packId
 func GetPolicyPack(c *gin.Context) {
-	id := c.Param("packId")
-	for _, p := range policyPacks {
-		if p.ID == id {
-			c.JSON(http.StatusOK, p)
+	p, ok := packs.Get(c.Param("packId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+type installPolicyPackReq struct {
+	// Name of the policy to create (default: the pack's name); ignored on upgrade
+	Name   string         `json:"name"`
+	Params map[string]any `json:"params"`
+	// ScopeType/ScopeID assign the policy (default: the org); on upgrade only a new scope is assigned
+	ScopeType string `json:"scope_type"`
+	ScopeID   string `json:"scope_id"`
+	DryRun    bool   `json:"dry_run"`
+}
+
+type installPolicyPackResp struct {
+	PackID      string         `json:"pack_id"`
+	PackVersion int            `json:"pack_version"`
+	PolicyID    *uuid.UUID     `json:"policy_id,omitempty"`
+	Version     int            `json:"version,omitempty"`
+	Upgrade     bool           `json:"upgrade"`
+	Unchanged   bool           `json:"unchanged,omitempty"`
+	DryRun      bool           `json:"dry_run"`
+	Params      map[string]any `json:"params"`
+	// DroppedParams are stored params of the previous install the new pack version no longer declares
+	DroppedParams []string           `json:"dropped_params,omitempty"`
+	Checksum      string             `json:"checksum"`
+	Tests         []PolicyTestResult `json:"tests"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// POST /organizations/:orgId/policies/packs/:packId/install
+// Body (optional): {"name":"...","params":{"approval_threshold":5000},"scope_type":"org","scope_id":"...","dry_run":false}
+// Renders the pack with the params, compiles it for the org and runs the pack's tests; only when they all
+// pass does it create the policy, a draft version and the assignment. Installing a pack again upgrades it:
+// a new version of the same policy, rendered with the stored params overlaid by the given ones. Versions
+// still go through approval and activation.
+func InstallPolicyPack(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID format"})
+		return
+	}
+	pack, ok := packs.Get(c.Param("packId"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pack not found"})
+		return
+	}
+	var req installPolicyPackReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	prev, err := packs.GetInstall(ctx, orgID, pack.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	values := map[string]any{}
+	var dropped []string
+	if prev != nil && len(prev.Params) > 0 {
+		stored := map[string]any{}
+		if err := json.Unmarshal(prev.Params, &stored); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stored params: " + err.Error()})
 			return
 		}
+		// params the new pack version dropped or renamed are left behind; renamed ones take their default
+		// unless given again
+		values, dropped = pack.Carry(stored)
+	}
+	for k, v := range req.Params {
+		values[k] = v
+	}
+	r, err := pack.Render(values)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp := installPolicyPackResp{PackID: pack.ID, PackVersion: pack.Version, Upgrade: prev != nil, DryRun: req.DryRun, Params: r.Params, DroppedParams: dropped}
+	resp.Checksum, _ = bundle.Checksum(r.Body)
+
+	e := evalRegistry[pack.Engine]
+	if e == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine " + pack.Engine})
+		return
+	}
+	body, err := policy.BundleOrgSchemaDefs(ctx, orgID, r.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	cp, err := compileForOrg(ctx, e, orgID, body)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "pack does not compile: " + err.Error()})
+		return
+	}
+	resp.Tests = runPolicyTests(e, cp, bundleTestCases(r.Tests))
+	failed := 0
+	for _, t := range resp.Tests {
+		if !t.Pass {
+			failed++
+		}
+	}
+	if failed > 0 {
+		resp.Error = fmt.Sprintf("%d pack tests failed", failed)
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+	if prev != nil {
+		resp.PolicyID, resp.Version = &prev.PolicyID, prev.Version
+		if pv, err := policy.GetVersion(ctx, prev.PolicyID, prev.Version); err == nil && pv.Checksum != nil && *pv.Checksum == resp.Checksum {
+			resp.Unchanged = true
+		}
+	}
+	if req.DryRun {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	scopeType, scopeID := req.ScopeType, req.ScopeID
+	if scopeType == "" && prev == nil {
+		scopeType, scopeID = "org", orgID.String()
+	}
+	if scopeType != "" && scopeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope_id required"})
+		return
+	}
+	if err := installPack(ctx, orgID, uid, pack, prev, req.Name, r, body, cp, scopeType, scopeID, &resp); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, packs.ErrInstallChanged) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if prev == nil {
+		c.JSON(http.StatusCreated, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// installPack writes an install or upgrade in one transaction, filling in the policy id and created
+// version, then audits it and refreshes the compiled policy caches
+func installPack(ctx context.Context, orgID uuid.UUID, uid *uuid.UUID, pack packs.Pack, prev *packs.Install, name string, r packs.Rendered, body json.RawMessage, cp policy.CompiledPolicy, scopeType, scopeID string, resp *installPolicyPackResp) error {
+	changed := !resp.Unchanged
+	if name == "" {
+		name = pack.Name
+	}
+	params, _ := json.Marshal(r.Params)
+	applied, err := packs.ApplyInstall(ctx, packs.Apply{OrgID: orgID, Pack: pack, Prev: prev, Name: name, Body: body, Changed: changed,
+		Checksum: resp.Checksum, Params: params, ScopeType: scopeType, ScopeID: scopeID, By: uid})
+	if err != nil {
+		return err
+	}
+	pid := applied.Install.PolicyID
+	resp.PolicyID, resp.Version = &pid, applied.Install.Version
+	if applied.Assigned {
+		_ = audit.Append(ctx, orgID, "policy_assigned", map[string]any{"policy_id": pid, "scope_type": scopeType, "scope_id": scopeID, "pack_id": pack.ID}, uid, nil)
+	}
+	event, details := "policy_pack_installed", map[string]any{"pack_id": pack.ID, "pack_version": pack.Version, "policy_id": pid, "version": resp.Version, "params": r.Params}
+	if prev != nil {
+		event = "policy_pack_upgraded"
+		details["from_pack_version"] = prev.PackVersion
+	}
+	_ = audit.Append(ctx, orgID, event, details, uid, nil)
+	if changed || applied.Assigned {
+		policy.DeleteCompiled(pid, 0)
+		if changed {
+			policy.PutCompiled(pid, resp.Version, cp)
+		}
+		PublishPolicyInvalidate(ctx, pid.String())
+	}
+	return nil
+}
+
+type policyPackInstallItem struct {
+	packs.Install
+	LatestVersion    int    `json:"latest_version"`
+	UpgradeAvailable bool   `json:"upgrade_available"`
+	Changes          string `json:"changes,omitempty"`
+}
+
+// GET /organizations/:orgId/policies/packs/installs
+// The org's installed packs, with whether the catalogue has a newer version of each.
+func ListPolicyPackInstalls(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID format"})
+		return
+	}
+	list, err := packs.ListInstalls(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]policyPackInstallItem, 0, len(list))
+	for _, in := range list {
+		it := policyPackInstallItem{Install: in}
+		if p, ok := packs.Get(in.PackID); ok {
+			it.LatestVersion = p.Version
+			if it.UpgradeAvailable = p.Version > in.PackVersion; it.UpgradeAvailable {
+				it.Changes = p.Changes
+			}
+		}
+		items = append(items, it)
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// StartPackUpgradeNotifier tells orgs, by webhook (policy_pack.upgrade_available) and audit event, when the
+// catalogue has a newer version of a pack they installed; each upgrade is announced once, across processes
+func StartPackUpgradeNotifier(ctx context.Context) {
+	t := time.NewTicker(packUpgradePoll)
+	defer t.Stop()
+	for {
+		NotifyPolicyPackUpgrades(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// NotifyPolicyPackUpgrades announces the pending pack upgrades once
+func NotifyPolicyPackUpgrades(ctx context.Context) {
+	for _, p := range packs.All() {
+		stale, err := packs.StaleInstalls(ctx, p.ID, p.Version)
+		if err != nil {
+			log.Printf("pack upgrades %s: %v", p.ID, err)
+			return
+		}
+		for _, in := range stale {
+			if ok, err := packs.MarkNotified(ctx, in.ID, p.Version); err != nil || !ok {
+				if err != nil {
+					log.Printf("pack upgrades %s: %v", p.ID, err)
+				}
+				continue
+			}
+			data := map[string]any{
+				"organization_id":   in.OrgID.String(),
+				"pack_id":           p.ID,
+				"policy_id":         in.PolicyID.String(),
+				"installed_version": in.PackVersion,
+				"latest_version":    p.Version,
+				"changes":           p.Changes,
+				"timestamp":         time.Now().UTC().Format(time.RFC3339),
+			}
+			_ = audit.Append(ctx, in.OrgID, "policy_pack_upgrade_available", data, nil, nil)
+			if b, err := json.Marshal(map[string]any{"type": "policy_pack.upgrade_available", "data": data}); err == nil {
+				dispatchWebhooks(in.OrgID, "policy_pack.upgrade_available", b)
+			}
+		}
 	}
-	c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 }
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+)
+
+// PolicyQueryRequest asks which actions an agent may perform. Context holds the known input (principal,
+// environment, ...); each candidate action is injected as "action". Fields left out are unknown.
+type PolicyQueryRequest struct {
+	AgentID  uuid.UUID       `json:"agent_id"`
+	Actions  []string        `json:"actions,omitempty"`
+	Context  json.RawMessage `json:"context,omitempty"`
+	Unknowns []string        `json:"unknowns,omitempty"`
+}
+
+type PolicyQueryPolicy struct {
+	PolicyID      uuid.UUID `json:"policy_id"`
+	PolicyVersion int       `json:"policy_version"`
+	ScopeType     string    `json:"scope_type,omitempty"`
+	Engine        string    `json:"engine"`
+	Error         string    `json:"error,omitempty"`
+	policy.PartialResult
+}
+
+type PolicyQueryAction struct {
+	Action   string              `json:"action"`
+	Outcome  string              `json:"outcome"`
+	Policies []PolicyQueryPolicy `json:"policies"`
+}
+
+// POST /v2/policy/query
+// Partially evaluates every active policy applicable to the agent, once per candidate action, and returns
+// allow/deny/require_approval/not_applicable or "conditional" with the residual conditions per policy.
+// Only the policy layer is considered; graph delegation and federation checks still apply at verify time.
+func QueryPolicy(c *gin.Context) {
+	ctx, span := otel.Tracer("aura-backend").Start(c.Request.Context(), "policy.query")
+	defer span.End()
+
+	orgID, err := uuid.Parse(c.GetString("orgID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "org context required"})
+		return
+	}
+	var req PolicyQueryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	known := map[string]any{}
+	if len(req.Context) > 0 {
+		if err := json.Unmarshal(req.Context, &known); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "context must be a JSON object"})
+			return
+		}
+	}
+	agentStr := req.AgentID.String()
+	if req.AgentID == uuid.Nil {
+		agentStr = c.GetString("agentID")
+	}
+
+	assignments, err := policy.GetApplicableAssignments(ctx, orgID, agentStr)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	assignments = applyRollouts(ctx, orgID.String(), agentStr, assignments)
+	alg := policy.GetCombiningAlg(ctx, orgID)
+
+	actions := req.Actions
+	if len(actions) == 0 {
+		set := map[string]bool{}
+		for _, a := range assignments {
+			for _, act := range policy.PolicyActions(a.Version.Body) {
+				set[act] = true
+			}
+		}
+		for a := range set {
+			actions = append(actions, a)
+		}
+		sort.Strings(actions)
+	}
+	if len(actions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "actions required (none could be derived from the active policies)"})
+		return
+	}
+
+	knownJSON, _ := json.Marshal(known)
+	out := make([]PolicyQueryAction, 0, len(actions))
+	for _, action := range actions {
+		input := queryInput(knownJSON, orgID.String(), agentStr, action)
+		qa := PolicyQueryAction{Action: action, Policies: make([]PolicyQueryPolicy, 0, len(assignments))}
+		results := make([]policy.PartialResult, 0, len(assignments))
+		for _, a := range assignments {
+			qp := PolicyQueryPolicy{PolicyID: a.Version.PolicyID, PolicyVersion: a.Version.Version, ScopeType: a.ScopeType, Engine: a.Policy.EngineType}
+			qp.PartialResult, qp.Error = partialFor(ctx, a.Policy, a.Version, input, req.Unknowns)
+			results = append(results, qp.PartialResult)
+			qa.Policies = append(qa.Policies, qp)
+		}
+		qa.Outcome = policy.OutcomeNotApplicable
+		if len(results) > 0 {
+			qa.Outcome = policy.CombinePartial(alg, results)
+		}
+		out = append(out, qa)
+	}
+	c.JSON(http.StatusOK, gin.H{"agent_id": agentStr, "combining": alg, "actions": out})
+}
+
+// queryInput is the known input for one candidate action, with the request facts verify adds (principal
+// org_id and agent_id, action, and resource when the context names one), so a query answers for the input
+// verify would evaluate
+func queryInput(known json.RawMessage, orgID, agentID, action string) json.RawMessage {
+	var m map[string]any
+	_ = json.Unmarshal(known, &m)
+	resource, _ := m["resource"].(string)
+	return utils.CanonicalizeJSON(withRequestFacts(known, orgID, agentID, action, resource))
+}
+
+// partialFor partially evaluates one policy version; failures count as deny, as they do in verify
+func partialFor(ctx context.Context, p database.Policy, v database.PolicyVersion, input json.RawMessage, unknowns []string) (policy.PartialResult, string) {
+	deny := policy.PartialResult{Outcome: policy.OutcomeDeny}
+	e := evalRegistry[p.EngineType]
+	if e == nil {
+		return deny, "unsupported engine"
+	}
+	pe, ok := e.(policy.PartialEvaluator)
+	if !ok {
+		return deny, "engine does not support partial evaluation"
+	}
+	comp, err := compiledFor(ctx, e, p.OrgID, v)
+	if err != nil {
+		return deny, err.Error()
+	}
+	res, err := pe.Partial(comp, input, unknowns)
+	if err != nil {
+		return deny, err.Error()
+	}
+	return res, ""
+}
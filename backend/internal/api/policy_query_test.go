@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestQueryInputAddsRequestFacts(t *testing.T) {
+	in := queryInput(json.RawMessage(`{"principal":{"agent_id":"spoofed","limit":100},"action":"other","env":"prod"}`), "o1", "a1", "pay")
+	var m map[string]any
+	if err := json.Unmarshal(in, &m); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := m["principal"].(map[string]any)
+	if p["agent_id"] != "a1" || p["org_id"] != "o1" || p["limit"] != float64(100) || m["action"] != "pay" || m["env"] != "prod" {
+		t.Fatalf("unexpected query input %v", m)
+	}
+	if _, ok := m["resource"]; ok {
+		t.Fatalf("resource must stay unknown when the context leaves it out: %v", m)
+	}
+	m = nil
+	_ = json.Unmarshal(queryInput(json.RawMessage(`{"resource":"doc:d1"}`), "o1", "a1", "read"), &m)
+	if m["resource"] != "doc:d1" {
+		t.Fatalf("resource: %v", m)
+	}
+}
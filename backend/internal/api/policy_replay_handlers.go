@@ -0,0 +1,556 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/rel"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Replays are run by workers polling policy_replays (AURA_REPLAY_WORKERS per process, default 1), so a
+// replay survives restarts: a running replay whose worker stops heartbeating for replayStale is resumed by
+// another from its cursor.
+const (
+	replayPageSize = 500
+	replayStale    = 2 * time.Minute
+	replayPoll     = 10 * time.Second
+)
+
+var replayKick = make(chan struct{}, 1)
+
+// kickReplays wakes an idle worker of this process
+func kickReplays() {
+	select {
+	case replayKick <- struct{}{}:
+	default:
+	}
+}
+
+// StartReplayWorker runs the replay workers of this process until ctx is done
+func StartReplayWorker(ctx context.Context) {
+	n := 1
+	if v := os.Getenv("AURA_REPLAY_WORKERS"); v != "" {
+		if x, err := strconv.Atoi(v); err == nil && x >= 0 {
+			n = x
+		}
+	}
+	host, _ := os.Hostname()
+	for i := 0; i < n; i++ {
+		worker := fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i)
+		go func() {
+			t := time.NewTicker(replayPoll)
+			defer t.Stop()
+			for {
+				for ctx.Err() == nil {
+					r, err := policy.ClaimReplay(ctx, worker, replayStale)
+					if err != nil {
+						log.Printf("claiming replay: %v", err)
+					}
+					if r == nil {
+						break
+					}
+					runReplay(ctx, r)
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-replayKick:
+				case <-t.C:
+				}
+			}
+		}()
+	}
+}
+
+// runReplay evaluates the remaining pages of a claimed replay, saving progress after each
+func runReplay(ctx context.Context, r *policy.Replay) {
+	worker := *r.Worker
+	rp := &replayer{replay: r, versions: map[string]*policy.ApplicableAssignment{}}
+	fail := func(err error) {
+		msg := err.Error()
+		if e := policy.FinishReplay(context.WithoutCancel(ctx), r.ID, worker, policy.ReplayFailed, &msg); e != nil {
+			log.Printf("replay %s: %v", r.ID, e)
+		}
+	}
+	if err := rp.loadCandidate(ctx); err != nil {
+		fail(err)
+		return
+	}
+	for {
+		page, err := policy.ReplayPage(ctx, *r, replayPageSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			return
+		}
+		if len(page) == 0 {
+			if err := policy.FinishReplay(ctx, r.ID, worker, policy.ReplaySucceeded, nil); err != nil {
+				log.Printf("replay %s: %v", r.ID, err)
+				return
+			}
+			_ = audit.Append(ctx, r.OrgID, "policy_replay_finished", map[string]any{"replay_id": r.ID, "policy_id": r.PolicyID, "version": r.Version,
+				"evaluated": r.Evaluated, "flipped": r.Flipped, "skipped": r.Skipped}, nil, nil)
+			return
+		}
+		var flips []policy.ReplayFlip
+		for _, t := range page {
+			r.Read++
+			flip, ok := rp.replayTrace(ctx, t)
+			if !ok {
+				r.Skipped++
+				continue
+			}
+			r.Evaluated++
+			if flip != nil {
+				r.Flipped++
+				flips = append(flips, *flip)
+			}
+		}
+		last := page[len(page)-1]
+		r.CursorAt, r.CursorID = &last.CreatedAt, last.ID
+		owned, err := policy.SaveReplayProgress(ctx, *r, flips)
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(err)
+			}
+			return
+		}
+		if !owned {
+			// canceled, or taken over by another worker
+			return
+		}
+	}
+}
+
+// replayer evaluates the traces of one replay; the policy versions they name are loaded once
+type replayer struct {
+	replay    *policy.Replay
+	candidate policy.ApplicableAssignment
+	versions  map[string]*policy.ApplicableAssignment // nil when the version no longer exists
+	alg       string
+}
+
+func (rp *replayer) loadCandidate(ctx context.Context) error {
+	a, err := loadAssignment(ctx, rp.replay.PolicyID, rp.replay.Version)
+	if err != nil {
+		return err
+	}
+	e := evalRegistry[a.Policy.EngineType]
+	if e == nil {
+		return fmt.Errorf("unsupported engine %s", a.Policy.EngineType)
+	}
+	if _, err := compiledFor(ctx, e, a.Policy.OrgID, a.Version); err != nil {
+		return err
+	}
+	rp.candidate = *a
+	rp.alg = policy.GetCombiningAlg(ctx, rp.replay.OrgID)
+	return nil
+}
+
+func loadAssignment(ctx context.Context, policyID uuid.UUID, version int) (*policy.ApplicableAssignment, error) {
+	p, err := policy.GetPolicy(ctx, policyID)
+	if err != nil {
+		return nil, err
+	}
+	v, err := policy.GetVersion(ctx, policyID, version)
+	if err != nil {
+		return nil, err
+	}
+	return &policy.ApplicableAssignment{Policy: p, Version: v}, nil
+}
+
+// assignment returns a policy version a trace names, nil when it cannot be loaded
+func (rp *replayer) assignment(ctx context.Context, ref policy.ReplayRef) *policy.ApplicableAssignment {
+	key := ref.PolicyID.String() + "@" + strconv.Itoa(ref.Version)
+	a, ok := rp.versions[key]
+	if !ok {
+		a, _ = loadAssignment(ctx, ref.PolicyID, ref.Version)
+		if a != nil && a.Policy.OrgID != rp.replay.OrgID {
+			a = nil
+		}
+		rp.versions[key] = a
+	}
+	return a
+}
+
+// replayTrace evaluates a stored decision with the candidate standing in for its policy. False when the
+// trace cannot be replayed: no policy made it (e.g. a graph denial), it has no input, one of its policy
+// versions is gone, or the policy took no part in it and the replay does not include unassigned traces.
+func (rp *replayer) replayTrace(ctx context.Context, t policy.ReplayTrace) (*policy.ReplayFlip, bool) {
+	var tr policy.Trace
+	if err := json.Unmarshal(t.Trace, &tr); err != nil || len(tr.InputContext) == 0 || tr.Engine == "graph" {
+		return nil, false
+	}
+	refs := policy.ReplayRefs(&tr, t.PolicyID, t.PolicyVersion)
+	if len(refs) == 0 {
+		return nil, false
+	}
+	assignments := make([]policy.ApplicableAssignment, 0, len(refs)+1)
+	idx := -1
+	for _, ref := range refs {
+		if ref.PolicyID == rp.replay.PolicyID {
+			a := rp.candidate
+			a.ScopeType, a.ScopeID = ref.ScopeType, ref.ScopeID
+			assignments = append(assignments, a)
+			idx = len(assignments) - 1
+			continue
+		}
+		a := rp.assignment(ctx, ref)
+		if a == nil {
+			return nil, false
+		}
+		x := *a
+		x.ScopeType, x.ScopeID = ref.ScopeType, ref.ScopeID
+		assignments = append(assignments, x)
+	}
+	if idx < 0 {
+		if !rp.replay.IncludeUnassigned {
+			return nil, false
+		}
+		assignments = append(assignments, rp.candidate)
+	}
+	alg := tr.Combining
+	if alg == "" {
+		alg = rp.alg
+	}
+	// rel clauses are answered by the current graph
+	ectx := policy.WithRelations(ctx, policyRelations(rp.replay.OrgID.String(), nil, rel.Consistency{}))
+	dec, _ := evaluateAssignments(ectx, alg, assignments, tr.InputContext)
+	original, replayed := policy.OriginalOutcome(t.Allow, &tr), policy.ReplayOutcome(dec)
+	if original == replayed {
+		return nil, true
+	}
+	origRules := policy.MatchedRules(policy.Decision{Trace: &tr}, rp.replay.PolicyID)
+	if len(tr.Policies) == 0 && t.PolicyID != nil && *t.PolicyID == rp.replay.PolicyID {
+		for _, r := range tr.EvaluatedRules {
+			if r.Matched {
+				origRules = append(origRules, r.RuleID)
+			}
+		}
+	}
+	newRules := policy.MatchedRules(dec, rp.replay.PolicyID)
+	var in struct {
+		Action any `json:"action"`
+	}
+	_ = json.Unmarshal(tr.InputContext, &in)
+	action, _ := in.Action.(string)
+	return &policy.ReplayFlip{ReplayID: rp.replay.ID, TraceID: t.TraceID, AgentID: t.AgentID, Action: action, Original: original, Replayed: replayed,
+		OriginalRules: origRules, ReplayedRules: newRules, Rules: policy.FlipRules(origRules, newRules), Reason: &dec.Reason, DecidedAt: t.CreatedAt}, true
+}
+
+// replayGate checks a replay allows activating a policy version: it must be a succeeded replay of that
+// version that evaluated at least minEvaluated traces, and its flip rate must not exceed maxFlipRate when
+// one is set. A replay that evaluated nothing never passes: its flip rate of 0 says nothing.
+func replayGate(r *policy.Replay, policyID uuid.UUID, version int, maxFlipRate *float64, minEvaluated int64) error {
+	switch {
+	case r == nil:
+		return errors.New("a succeeded replay of the version is required")
+	case r.PolicyID != policyID || r.Version != version:
+		return fmt.Errorf("replay %s is not a replay of version %d", r.ID, version)
+	case r.Status != policy.ReplaySucceeded:
+		return fmt.Errorf("replay %s is %s", r.ID, r.Status)
+	case r.Evaluated == 0:
+		return fmt.Errorf("replay %s evaluated no decisions, so its flip rate proves nothing; replay a window with traffic", r.ID)
+	case r.Evaluated < minEvaluated:
+		return fmt.Errorf("replay %s evaluated %d decisions (at least %d required)", r.ID, r.Evaluated, minEvaluated)
+	case maxFlipRate != nil && r.FlipRate() > *maxFlipRate:
+		return fmt.Errorf("replay %s flips %.4f of the decisions it evaluated (at most %.4f allowed)", r.ID, r.FlipRate(), *maxFlipRate)
+	}
+	return nil
+}
+
+// activationReplay finds the replay gating an activation: the one named, or the version's latest when
+// AURA_POLICY_REQUIRE_REPLAY=1. Nil without error when no gate applies.
+func activationReplay(ctx context.Context, orgID, policyID uuid.UUID, version int, replayID string) (*policy.Replay, bool, error) {
+	if replayID != "" {
+		id, err := uuid.Parse(replayID)
+		if err != nil {
+			return nil, true, errors.New("bad replay id")
+		}
+		r, err := policy.GetReplay(ctx, orgID, id)
+		return r, true, err
+	}
+	if os.Getenv("AURA_POLICY_REQUIRE_REPLAY") != "1" {
+		return nil, false, nil
+	}
+	r, err := policy.LatestReplay(ctx, policyID, version)
+	return r, true, err
+}
+
+// minReplayEvaluated is the number of traces a gating replay must have evaluated:
+// AURA_POLICY_REPLAY_MIN_EVALUATED, default 1
+func minReplayEvaluated() int64 {
+	if v := os.Getenv("AURA_POLICY_REPLAY_MIN_EVALUATED"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+// maxFlipRate is the stricter of the activation request's limit and AURA_POLICY_REPLAY_MAX_FLIP_RATE (unset:
+// no limit); a request can tighten the operator's gate but not loosen it
+func maxFlipRate(req *float64) *float64 {
+	var limit *float64
+	if v := os.Getenv("AURA_POLICY_REPLAY_MAX_FLIP_RATE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			limit = &f
+		}
+	}
+	if req != nil && (limit == nil || *req < *limit) {
+		return req
+	}
+	return limit
+}
+
+type startReplayReq struct {
+	From time.Time `json:"from" binding:"required"`
+	// To defaults to now
+	To                time.Time `json:"to"`
+	IncludeUnassigned bool      `json:"include_unassigned"`
+}
+
+// POST /organizations/:orgId/policies/:policyId/versions/:version/replays
+// Body: {"from":"2025-10-01T00:00:00Z","to":"2025-11-01T00:00:00Z","include_unassigned":false}
+// Queues a replay of the version over the org's decision traces of [from, to); poll it with GET .../replays/:replayId.
+func StartPolicyReplay(c *gin.Context) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad version"})
+		return
+	}
+	var req startReplayReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.To.IsZero() {
+		req.To = time.Now().UTC()
+	}
+	if !req.From.Before(req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	v, err := policy.GetVersion(c.Request.Context(), p.ID, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy version not found"})
+		return
+	}
+	e := evalRegistry[p.EngineType]
+	if e == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
+		return
+	}
+	if _, err := compiledFor(c.Request.Context(), e, p.OrgID, v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	r, err := policy.CreateReplay(c.Request.Context(), policy.Replay{OrgID: orgID, PolicyID: p.ID, Version: version, From: req.From, To: req.To, IncludeUnassigned: req.IncludeUnassigned, CreatedBy: uid})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	kickReplays()
+	_ = audit.Append(c.Request.Context(), orgID, "policy_replay_started", map[string]any{"replay_id": r.ID, "policy_id": p.ID, "version": version, "from": req.From, "to": req.To}, uid, nil)
+	c.JSON(http.StatusAccepted, r)
+}
+
+// GET /organizations/:orgId/policies/:policyId/replays
+func ListPolicyReplays(c *gin.Context) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	items, err := policy.ListReplays(c.Request.Context(), orgID, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// replayParam loads :replayId of the policy
+func replayParam(c *gin.Context) (*policy.Replay, bool) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return nil, false
+	}
+	id, err := uuid.Parse(c.Param("replayId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad replay id"})
+		return nil, false
+	}
+	r, err := policy.GetReplay(c.Request.Context(), orgID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if r == nil || r.PolicyID != p.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "replay not found"})
+		return nil, false
+	}
+	return r, true
+}
+
+// replayGroups groups a replay's flips by agent, action and rule
+func replayGroups(ctx context.Context, r *policy.Replay, limit int) (map[string][]policy.ReplayGroup, error) {
+	out := map[string][]policy.ReplayGroup{}
+	for _, by := range []string{policy.ReplayByAgent, policy.ReplayByAction, policy.ReplayByRule} {
+		g, err := policy.ReplayGroups(ctx, r.ID, by, limit)
+		if err != nil {
+			return nil, err
+		}
+		out[by] = g
+	}
+	return out, nil
+}
+
+// GET /organizations/:orgId/policies/:policyId/replays/:replayId
+// Progress and, as flips are found, the 20 largest groups of flipped decisions by agent, action and rule.
+func GetPolicyReplay(c *gin.Context) {
+	r, ok := replayParam(c)
+	if !ok {
+		return
+	}
+	groups, err := replayGroups(c.Request.Context(), r, 20)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"replay": r, "flip_rate": r.FlipRate(), "groups": groups})
+}
+
+// POST /organizations/:orgId/policies/:policyId/replays/:replayId/cancel
+func CancelPolicyReplay(c *gin.Context) {
+	r, ok := replayParam(c)
+	if !ok {
+		return
+	}
+	canceled, err := policy.CancelReplay(c.Request.Context(), r.OrgID, r.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !canceled {
+		c.JSON(http.StatusConflict, gin.H{"error": "replay is " + r.Status})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+var replayCSVHeader = []string{"trace_id", "decided_at", "agent_id", "action", "original", "replayed", "rules", "original_rules", "replayed_rules", "reason"}
+
+// GET /organizations/:orgId/policies/:policyId/replays/:replayId/report?format=json|csv[&group=agent|action|rule]
+// Downloads the diff report. JSON holds the replay, every group and every flip; CSV has a row per flip,
+// or per group and transition with group=.
+func DownloadPolicyReplayReport(c *gin.Context) {
+	r, ok := replayParam(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+	ctx := c.Request.Context()
+	name := fmt.Sprintf("replay-%s", r.ID)
+	if by := c.Query("group"); by != "" {
+		groups, err := policy.ReplayGroups(ctx, r.ID, by, 100000)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if format == "json" {
+			c.Header("Content-Disposition", `attachment; filename="`+name+`-`+by+`.json"`)
+			c.JSON(http.StatusOK, gin.H{"replay": r, "group": by, "groups": groups})
+			return
+		}
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`-`+by+`.csv"`)
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{by, "transition", "count"})
+		for _, g := range groups {
+			for t, n := range g.Transitions {
+				_ = w.Write([]string{g.Key, t, strconv.Itoa(n)})
+			}
+		}
+		w.Flush()
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	var err error
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		c.Status(http.StatusOK)
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write(replayCSVHeader)
+		err = policy.StreamReplayFlips(ctx, r.ID, func(f policy.ReplayFlip) error {
+			agent, reason := "", ""
+			if f.AgentID != nil {
+				agent = f.AgentID.String()
+			}
+			if f.Reason != nil {
+				reason = *f.Reason
+			}
+			return w.Write([]string{f.TraceID, f.DecidedAt.UTC().Format(time.RFC3339Nano), agent, f.Action, f.Original, f.Replayed,
+				strings.Join(f.Rules, ";"), strings.Join(f.OriginalRules, ";"), strings.Join(f.ReplayedRules, ";"), reason})
+		})
+		w.Flush()
+	} else {
+		// the flips are streamed as the last member, so the report is never held in memory
+		groups, gerr := replayGroups(ctx, r, 1000)
+		if gerr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gerr.Error()})
+			return
+		}
+		head, _ := json.Marshal(gin.H{"replay": r, "flip_rate": r.FlipRate(), "groups": groups})
+		c.Header("Content-Type", "application/json")
+		c.Header("Content-Disposition", `attachment; filename="`+name+`.json"`)
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write(head[:len(head)-1])
+		_, _ = c.Writer.WriteString(`,"flips":[`)
+		n := 0
+		err = policy.StreamReplayFlips(ctx, r.ID, func(f policy.ReplayFlip) error {
+			b, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				_, _ = c.Writer.WriteString(",")
+			}
+			n++
+			_, err = c.Writer.Write(b)
+			return err
+		})
+		_, _ = c.Writer.WriteString("]}")
+	}
+	if err != nil {
+		// headers are sent; a truncated body is all the client can be told
+		_ = c.Error(err)
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/google/uuid"
+)
+
+func TestReplayTrace(t *testing.T) {
+	org, pid, other := uuid.New(), uuid.New(), uuid.New()
+	pol := database.Policy{ID: pid, OrgID: org, EngineType: policy.EngineAuraJSON}
+	otherPol := database.Policy{ID: other, OrgID: org, EngineType: policy.EngineAuraJSON}
+	for _, c := range []struct {
+		id   uuid.UUID
+		v    int
+		body string
+	}{
+		{pid, 1, `{"rules":[{"id":"allow_read","effect":"allow","when":{"action":{"eq":"read"}}}]}`},
+		{pid, 2, `{"rules":[{"id":"deny_read","effect":"deny","when":{"action":{"eq":"read"}}}]}`},
+		{other, 1, `{"rules":[{"id":"allow_all","effect":"allow","when":{}}]}`},
+	} {
+		cp, err := evalRegistry[policy.EngineAuraJSON].Compile(json.RawMessage(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.PutCompiled(c.id, c.v, cp)
+		defer policy.DeleteCompiled(c.id, c.v)
+	}
+	replay := &policy.Replay{ID: uuid.New(), OrgID: org, PolicyID: pid, Version: 2}
+	rp := &replayer{replay: replay, alg: policy.CombineDenyOverrides,
+		candidate: policy.ApplicableAssignment{Policy: pol, Version: database.PolicyVersion{PolicyID: pid, Version: 2}},
+		versions: map[string]*policy.ApplicableAssignment{
+			other.String() + "@1": {Policy: otherPol, Version: database.PolicyVersion{PolicyID: other, Version: 1}},
+		}}
+	stored := func(action string, policies ...uuid.UUID) policy.ReplayTrace {
+		live := make([]policy.ApplicableAssignment, 0, len(policies))
+		for _, id := range policies {
+			if id == pid {
+				live = append(live, policy.ApplicableAssignment{Policy: pol, Version: database.PolicyVersion{PolicyID: pid, Version: 1}})
+			} else {
+				live = append(live, *rp.versions[other.String()+"@1"])
+			}
+		}
+		dec, _ := evaluateAssignments(context.Background(), policy.CombineDenyOverrides, live, json.RawMessage(`{"action":"`+action+`"}`))
+		b, _ := json.Marshal(dec.Trace)
+		agent := uuid.New()
+		return policy.ReplayTrace{TraceID: dec.TraceID, AgentID: &agent, Allow: dec.Allow, Trace: b}
+	}
+
+	flip, ok := rp.replayTrace(context.Background(), stored("read", pid, other))
+	if !ok || flip == nil {
+		t.Fatalf("expected a flip, got %+v %v", flip, ok)
+	}
+	if flip.Original != policy.OutcomeAllow || flip.Replayed != policy.OutcomeDeny || flip.Action != "read" ||
+		!reflect.DeepEqual(flip.Rules, policy.RuleIDs{"allow_read", "deny_read"}) {
+		t.Errorf("flip %+v", flip)
+	}
+	if flip, ok := rp.replayTrace(context.Background(), stored("write", pid, other)); !ok || flip != nil {
+		t.Errorf("unchanged decision: %+v %v", flip, ok)
+	}
+
+	// a trace the policy took no part in is only replayed with include_unassigned
+	if _, ok := rp.replayTrace(context.Background(), stored("read", other)); ok {
+		t.Error("unassigned trace replayed")
+	}
+	replay.IncludeUnassigned = true
+	if flip, ok := rp.replayTrace(context.Background(), stored("read", other)); !ok || flip == nil || flip.Replayed != policy.OutcomeDeny {
+		t.Errorf("unassigned trace: %+v %v", flip, ok)
+	}
+	if _, ok := rp.replayTrace(context.Background(), policy.ReplayTrace{Trace: json.RawMessage(`{"engine":"graph"}`)}); ok {
+		t.Error("graph denial replayed")
+	}
+}
+
+func TestReplayGate(t *testing.T) {
+	pid := uuid.New()
+	ok := &policy.Replay{ID: uuid.New(), PolicyID: pid, Version: 3, Status: policy.ReplaySucceeded, Evaluated: 100, Flipped: 5}
+	limit, strict := 0.1, 0.01
+	empty := &policy.Replay{ID: uuid.New(), PolicyID: pid, Version: 3, Status: policy.ReplaySucceeded}
+	if err := replayGate(ok, pid, 3, nil, 1); err != nil {
+		t.Errorf("no limit: %v", err)
+	}
+	if err := replayGate(ok, pid, 3, &limit, 100); err != nil {
+		t.Errorf("within limit: %v", err)
+	}
+	for name, err := range map[string]error{
+		"missing":        replayGate(nil, pid, 3, nil, 1),
+		"other version":  replayGate(ok, pid, 2, nil, 1),
+		"running":        replayGate(&policy.Replay{PolicyID: pid, Version: 3, Status: policy.ReplayRunning}, pid, 3, nil, 1),
+		"too many":       replayGate(ok, pid, 3, &strict, 1),
+		"nothing":        replayGate(empty, pid, 3, &limit, 0),
+		"too few traces": replayGate(ok, pid, 3, &limit, 101),
+	} {
+		if err == nil {
+			t.Errorf("%s: expected the gate to refuse", name)
+		}
+	}
+}
+
+func TestMinReplayEvaluated(t *testing.T) {
+	for env, want := range map[string]int64{"": 1, "0": 1, "x": 1, "500": 500} {
+		t.Setenv("AURA_POLICY_REPLAY_MIN_EVALUATED", env)
+		if got := minReplayEvaluated(); got != want {
+			t.Errorf("%q: got %d, want %d", env, got, want)
+		}
+	}
+}
+
+func TestMaxFlipRate(t *testing.T) {
+	loose, strict := 1.0, 0.01
+	t.Setenv("AURA_POLICY_REPLAY_MAX_FLIP_RATE", "")
+	if got := maxFlipRate(&loose); got == nil || *got != loose {
+		t.Errorf("request limit without an operator limit: %v", got)
+	}
+	t.Setenv("AURA_POLICY_REPLAY_MAX_FLIP_RATE", "0.05")
+	if got := maxFlipRate(&loose); got == nil || *got != 0.05 {
+		t.Errorf("a request must not loosen the operator limit: %v", got)
+	}
+	if got := maxFlipRate(&strict); got == nil || *got != strict {
+		t.Errorf("a request may tighten the operator limit: %v", got)
+	}
+	if got := maxFlipRate(nil); got == nil || *got != 0.05 {
+		t.Errorf("operator limit: %v", got)
+	}
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var schemaDefName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// GET /organizations/:orgId/policy-schemas
+func ListPolicySchemaDefs(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	defs, err := policy.ListSchemaDefs(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": defs})
+}
+
+// GET /organizations/:orgId/policy-schemas/:name
+func GetPolicySchemaDef(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	d, err := policy.GetSchemaDef(c.Request.Context(), orgID, c.Param("name"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// PUT /organizations/:orgId/policy-schemas/:name
+// Body: a JSON Schema (draft 2020-12). Policies reference it as {"$ref": "urn:aura:schema:<name>"}.
+func PutPolicySchemaDef(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	name := c.Param("name")
+	if !schemaDefName.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must match [A-Za-z0-9_.-]+"})
+		return
+	}
+	var schema map[string]any
+	if err := c.ShouldBindJSON(&schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	raw, _ := json.Marshal(schema)
+	// the definition must compile on its own, including any shared definitions it references
+	wrapped, _ := json.Marshal(map[string]any{"schema": schema})
+	bundled, err := policy.BundleOrgSchemaDefs(c.Request.Context(), orgID, wrapped)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var b struct {
+		Schema any `json:"schema"`
+	}
+	_ = json.Unmarshal(bundled, &b)
+	if _, err := policy.CompileInputSchema(b.Schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid *uuid.UUID
+	if s := c.GetString("userID"); s != "" {
+		if u, err := uuid.Parse(s); err == nil {
+			uid = &u
+		}
+	}
+	d, err := policy.PutSchemaDef(c.Request.Context(), orgID, name, raw, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "policy_schema_put", map[string]any{"name": name, "version": d.Version}, uid, nil)
+	c.JSON(http.StatusOK, d)
+}
+
+// DELETE /organizations/:orgId/policy-schemas/:name
+func DeletePolicySchemaDef(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	ok, err := policy.DeleteSchemaDef(c.Request.Context(), orgID, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "policy_schema_deleted", map[string]any{"name": c.Param("name")}, nil, nil)
+	c.Status(http.StatusNoContent)
+}
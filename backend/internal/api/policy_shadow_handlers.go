@@ -0,0 +1,398 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Shadow evaluation runs a candidate policy version on live /v2/verify decisions after they are made,
+// in the background, so it can neither change nor slow them. Samples beyond shadowSlots concurrent
+// evaluations are dropped.
+var shadowSlots = make(chan struct{}, 64)
+
+// shadowTTL bounds how long a replica keeps running a stopped shadow when the mesh is not configured
+const shadowTTL = 5 * time.Second
+
+// activeShadow is a running shadow with its candidate version loaded
+type activeShadow struct {
+	policy.Shadow
+	version database.PolicyVersion
+}
+
+type shadowsEntry struct {
+	val     []activeShadow
+	expires time.Time
+}
+
+var shadowCache = struct {
+	sync.Mutex
+	m map[string]shadowsEntry
+}{m: map[string]shadowsEntry{}}
+
+// ForgetPolicyShadows drops the cached running shadows of every org
+func ForgetPolicyShadows() {
+	shadowCache.Lock()
+	shadowCache.m = map[string]shadowsEntry{}
+	shadowCache.Unlock()
+}
+
+// orgShadows returns the org's running shadows, cached for shadowTTL
+func orgShadows(ctx context.Context, orgID string) ([]activeShadow, error) {
+	shadowCache.Lock()
+	ent, ok := shadowCache.m[orgID]
+	shadowCache.Unlock()
+	if ok && time.Now().Before(ent.expires) {
+		return ent.val, nil
+	}
+	shadows, err := policy.ActiveShadows(ctx, uuid.MustParse(orgID))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]activeShadow, 0, len(shadows))
+	for _, s := range shadows {
+		v, err := policy.GetVersion(ctx, s.PolicyID, s.Version)
+		if err != nil {
+			continue
+		}
+		out = append(out, activeShadow{Shadow: s, version: v})
+	}
+	shadowCache.Lock()
+	shadowCache.m[orgID] = shadowsEntry{val: out, expires: time.Now().Add(shadowTTL)}
+	shadowCache.Unlock()
+	return out, nil
+}
+
+// shadowRun is the live decision a shadow is compared with, and what it was evaluated from
+type shadowRun struct {
+	orgID       uuid.UUID
+	agentID     *uuid.UUID
+	alg         func() string
+	assignments []policy.ApplicableAssignment
+	input       json.RawMessage
+	relations   policy.RelationChecker
+	live        policy.Decision
+}
+
+// shadowAssignments returns the assignments with the shadowed policy at the candidate version and the
+// index of that policy; false when the policy does not apply or already serves the candidate (a rollout)
+func shadowAssignments(assignments []policy.ApplicableAssignment, s activeShadow) ([]policy.ApplicableAssignment, int, bool) {
+	for i, a := range assignments {
+		if a.Policy.ID != s.PolicyID {
+			continue
+		}
+		if a.Version.Version == s.Version {
+			return nil, -1, false
+		}
+		out := append([]policy.ApplicableAssignment(nil), assignments...)
+		out[i].Version = s.version
+		return out, i, true
+	}
+	return nil, -1, false
+}
+
+// shadowDecision samples the live decision into the org's running shadows that apply to it
+func shadowDecision(ctx context.Context, run shadowRun) {
+	shadows, err := orgShadows(ctx, run.orgID.String())
+	if err != nil || len(shadows) == 0 {
+		return
+	}
+	for _, s := range shadows {
+		candidate, idx, ok := shadowAssignments(run.assignments, s)
+		if !ok || bucket(run.live.TraceID, s.ID.String()) >= s.Percent {
+			continue
+		}
+		alg := run.alg()
+		select {
+		case shadowSlots <- struct{}{}:
+		default:
+			RecordShadowEvaluation("dropped", run.orgID.String())
+			continue
+		}
+		go func(s activeShadow) {
+			defer func() { <-shadowSlots }()
+			runShadow(context.WithoutCancel(ctx), s, candidate, idx, alg, run)
+		}(s)
+	}
+}
+
+// runShadow evaluates the candidate and records a divergence when it disagrees with the live decision
+func runShadow(ctx context.Context, s activeShadow, candidate []policy.ApplicableAssignment, idx int, alg string, run shadowRun) {
+	live := run.live
+	div := policy.ShadowDivergence{ShadowID: s.ID, OrgID: run.orgID, TraceID: live.TraceID, AgentID: run.agentID,
+		ActiveVersion: run.assignments[idx].Version.Version, ActiveAllow: live.Allow, ActiveRequireApproval: live.RequireApproval,
+		ActiveReason: &live.Reason, ActiveRules: policy.MatchedRules(live, s.PolicyID)}
+	a := candidate[idx]
+	var compileErr error
+	if e := evalRegistry[a.Policy.EngineType]; e == nil {
+		compileErr = fmt.Errorf("unsupported engine %s", a.Policy.EngineType)
+	} else {
+		_, compileErr = compiledFor(ctx, e, a.Policy.OrgID, a.Version)
+	}
+	if compileErr != nil {
+		msg := compileErr.Error()
+		div.Kind, div.ShadowReason = policy.ShadowError, &msg
+	} else {
+		dec, _ := evaluateAssignments(policy.WithRelations(ctx, run.relations), alg, candidate, run.input)
+		div.Kind = policy.CompareShadow(live, dec)
+		div.ShadowAllow, div.ShadowRequireApproval, div.ShadowReason = &dec.Allow, &dec.RequireApproval, &dec.Reason
+		div.ShadowRules = policy.MatchedRules(dec, s.PolicyID)
+	}
+	tallyShadow(s.ID, div.Kind != "")
+	if div.Kind == "" {
+		RecordShadowEvaluation("agree", run.orgID.String())
+		return
+	}
+	RecordShadowEvaluation(div.Kind, run.orgID.String())
+	if err := policy.RecordShadowDivergence(ctx, div); err != nil {
+		log.Printf("recording shadow divergence of %s: %v", s.ID, err)
+	}
+}
+
+// shadowTally accumulates sampled and diverged counts in memory; they are added to policy_shadows every
+// shadowFlushEvery rather than with a row update per decision
+var shadowTally = struct {
+	sync.Mutex
+	once   sync.Once
+	counts map[uuid.UUID]*[2]int64
+}{counts: map[uuid.UUID]*[2]int64{}}
+
+const shadowFlushEvery = 5 * time.Second
+
+func tallyShadow(id uuid.UUID, diverged bool) {
+	shadowTally.once.Do(func() {
+		go func() {
+			for range time.Tick(shadowFlushEvery) {
+				flushShadowTally(context.Background())
+			}
+		}()
+	})
+	shadowTally.Lock()
+	c := shadowTally.counts[id]
+	if c == nil {
+		c = &[2]int64{}
+		shadowTally.counts[id] = c
+	}
+	c[0]++
+	if diverged {
+		c[1]++
+	}
+	shadowTally.Unlock()
+}
+
+func flushShadowTally(ctx context.Context) {
+	shadowTally.Lock()
+	counts := shadowTally.counts
+	shadowTally.counts = map[uuid.UUID]*[2]int64{}
+	shadowTally.Unlock()
+	for id, c := range counts {
+		if err := policy.AddShadowCounts(ctx, id, c[0], c[1]); err != nil {
+			log.Printf("updating shadow counts of %s: %v", id, err)
+		}
+	}
+}
+
+// orgPolicyParams parses :orgId and :policyId and checks the policy belongs to the org
+func orgPolicyParams(c *gin.Context) (uuid.UUID, database.Policy, bool) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID format"})
+		return uuid.Nil, database.Policy{}, false
+	}
+	pid, err := uuid.Parse(c.Param("policyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad policy id"})
+		return uuid.Nil, database.Policy{}, false
+	}
+	p, err := policy.GetPolicy(c.Request.Context(), pid)
+	if err != nil || p.OrgID != orgID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+		return uuid.Nil, database.Policy{}, false
+	}
+	return orgID, p, true
+}
+
+type startShadowReq struct {
+	// Percent of the live decisions of the policy to evaluate the candidate on (default 100)
+	Percent int `json:"percent"`
+}
+
+// POST /organizations/:orgId/policies/:policyId/versions/:version/shadow
+// Body (optional): {"percent":100}
+// Evaluates the version alongside the serving version of the policy on live /v2/verify decisions, replacing
+// the policy's running shadow. The active version cannot be shadowed.
+func StartPolicyShadow(c *gin.Context) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad version"})
+		return
+	}
+	req := startShadowReq{Percent: 100}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Percent <= 0 || req.Percent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "percent must be between 1 and 100"})
+		return
+	}
+	v, err := policy.GetVersion(c.Request.Context(), p.ID, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy version not found"})
+		return
+	}
+	if v.Status == "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "version is active"})
+		return
+	}
+	e := evalRegistry[p.EngineType]
+	if e == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
+		return
+	}
+	if _, err := compiledFor(c.Request.Context(), e, p.OrgID, v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	s, err := policy.StartShadow(c.Request.Context(), orgID, p.ID, version, req.Percent, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ForgetPolicyShadows()
+	PublishPolicyInvalidate(c.Request.Context(), p.ID.String())
+	_ = audit.Append(c.Request.Context(), orgID, "policy_shadow_started", map[string]any{"shadow_id": s.ID, "policy_id": p.ID, "version": version, "percent": req.Percent}, uid, nil)
+	c.JSON(http.StatusCreated, s)
+}
+
+// DELETE /organizations/:orgId/policies/:policyId/shadow
+// Stops the policy's running shadow; its report stays available.
+func StopPolicyShadow(c *gin.Context) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	found, err := policy.StopShadow(c.Request.Context(), orgID, p.ID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no running shadow"})
+		return
+	}
+	ForgetPolicyShadows()
+	PublishPolicyInvalidate(c.Request.Context(), p.ID.String())
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "policy_shadow_stopped", map[string]any{"policy_id": p.ID}, uid, nil)
+	c.Status(http.StatusNoContent)
+}
+
+// GET /organizations/:orgId/policies/:policyId/shadows
+func ListPolicyShadows(c *gin.Context) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return
+	}
+	items, err := policy.ListShadows(c.Request.Context(), orgID, p.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// shadowParam loads :shadowId of the policy
+func shadowParam(c *gin.Context) (*policy.Shadow, bool) {
+	orgID, p, ok := orgPolicyParams(c)
+	if !ok {
+		return nil, false
+	}
+	id, err := uuid.Parse(c.Param("shadowId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad shadow id"})
+		return nil, false
+	}
+	s, err := policy.GetShadow(c.Request.Context(), orgID, p.ID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if s == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "shadow not found"})
+		return nil, false
+	}
+	return s, true
+}
+
+// GET /organizations/:orgId/policies/:policyId/shadows/:shadowId
+// Reports how the candidate fared: sampled decisions, divergences by kind, and the rules that matched in
+// the divergent decisions on each side (rule_id "" when no rule of the policy matched).
+func GetPolicyShadowReport(c *gin.Context) {
+	s, ok := shadowParam(c)
+	if !ok {
+		return
+	}
+	kinds, err := policy.ShadowDivergenceKinds(c.Request.Context(), s.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rules, err := policy.ShadowRuleStats(c.Request.Context(), s.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	rate := 0.0
+	if s.Evaluated > 0 {
+		rate = float64(s.Diverged) / float64(s.Evaluated)
+	}
+	c.JSON(http.StatusOK, gin.H{"shadow": s, "divergence_rate": rate, "divergences": kinds, "rules": rules})
+}
+
+// GET /organizations/:orgId/policies/:policyId/shadows/:shadowId/divergences?kind=decision|approval|error&limit=100
+// Each divergence names the live trace id, so the full decision is available from the decision traces.
+func ListPolicyShadowDivergences(c *gin.Context) {
+	s, ok := shadowParam(c)
+	if !ok {
+		return
+	}
+	kind := c.Query("kind")
+	switch kind {
+	case "", policy.ShadowDecision, policy.ShadowApproval, policy.ShadowError:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be decision, approval or error"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	items, err := policy.ListShadowDivergences(c.Request.Context(), s.ID, kind, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/google/uuid"
+)
+
+func TestShadowAssignments(t *testing.T) {
+	org, pid, other := uuid.New(), uuid.New(), uuid.New()
+	live := []policy.ApplicableAssignment{
+		{Policy: database.Policy{ID: other, OrgID: org, EngineType: policy.EngineAuraJSON}, Version: database.PolicyVersion{PolicyID: other, Version: 1}},
+		{Policy: database.Policy{ID: pid, OrgID: org, EngineType: policy.EngineAuraJSON}, Version: database.PolicyVersion{PolicyID: pid, Version: 1}},
+	}
+	for v, body := range map[int]string{
+		1: `{"rules":[{"id":"allow_read","effect":"allow","when":{"action":{"eq":"read"}}}]}`,
+		2: `{"rules":[{"id":"approve_read","effect":"require_approval","when":{"action":{"eq":"read"}}}]}`,
+	} {
+		cp, err := evalRegistry[policy.EngineAuraJSON].Compile(json.RawMessage(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.PutCompiled(pid, v, cp)
+		defer policy.DeleteCompiled(pid, v)
+	}
+	cp, _ := evalRegistry[policy.EngineAuraJSON].Compile(json.RawMessage(`{"rules":[]}`))
+	policy.PutCompiled(other, 1, cp)
+	defer policy.DeleteCompiled(other, 1)
+
+	s := activeShadow{Shadow: policy.Shadow{PolicyID: pid, Version: 2}, version: database.PolicyVersion{PolicyID: pid, Version: 2}}
+	candidate, idx, ok := shadowAssignments(live, s)
+	if !ok || idx != 1 || candidate[1].Version.Version != 2 || live[1].Version.Version != 1 {
+		t.Fatalf("candidate %+v at %d (live must be left as is: %+v)", candidate, idx, live[1].Version)
+	}
+	input := json.RawMessage(`{"action":"read"}`)
+	liveDec, _ := evaluateAssignments(context.Background(), policy.CombineDenyOverrides, live, input)
+	shadowDec, _ := evaluateAssignments(context.Background(), policy.CombineDenyOverrides, candidate, input)
+	if kind := policy.CompareShadow(liveDec, shadowDec); kind != policy.ShadowDecision {
+		t.Errorf("allow against require_approval: got %q", kind)
+	}
+	if got := policy.MatchedRules(shadowDec, pid); len(got) != 1 || got[0] != "approve_read" {
+		t.Errorf("shadow rules %v", got)
+	}
+
+	// a version already serving (e.g. through a rollout) or a policy that does not apply is not shadowed
+	if _, _, ok := shadowAssignments(candidate, s); ok {
+		t.Error("the candidate already serves")
+	}
+	if _, _, ok := shadowAssignments(live[:1], s); ok {
+		t.Error("the shadowed policy does not apply")
+	}
+}
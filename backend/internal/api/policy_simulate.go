@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 
 	polrepo "github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/rel"
 )
 
 type simulateReq struct {
@@ -56,12 +57,16 @@ func SimulatePolicyVersion(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported engine"})
 		return
 	}
-	comp, err := e.Compile(v.Body)
+	comp, err := compileForOrg(c.Request.Context(), e, p.OrgID, v.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	dec, err := e.Evaluate(comp, req.RequestContext)
+	// rel clauses check the policy org's current graph
+	var caveatCtx map[string]any
+	_ = json.Unmarshal(req.RequestContext, &caveatCtx)
+	ectx := polrepo.WithRelations(c.Request.Context(), policyRelations(p.OrgID.String(), caveatCtx, rel.Consistency{}))
+	dec, err := polrepo.EvaluateWithContext(ectx, e, comp, req.RequestContext)
 	if err != nil {
 		c.JSON(http.StatusOK, simulateResp{Allow: false, Reason: err.Error()})
 		return
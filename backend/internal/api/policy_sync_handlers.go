@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/policy/bundle"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type syncPolicyBundleReq struct {
+	// Commit is the bundle's commit SHA, recorded as the change ticket of the versions the sync creates
+	Commit string        `json:"commit"`
+	DryRun bool          `json:"dry_run"`
+	Bundle bundle.Bundle `json:"bundle"`
+}
+
+type syncedPolicy struct {
+	bundle.Change
+	Tests []PolicyTestResult `json:"tests,omitempty"`
+}
+
+type syncPolicyBundleResp struct {
+	Commit    string         `json:"commit,omitempty"`
+	DryRun    bool           `json:"dry_run"`
+	Policies  []syncedPolicy `json:"policies"`
+	Unmanaged []string       `json:"unmanaged,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// POST /organizations/:orgId/policies/sync
+// Body: {"commit":"<sha>","dry_run":false,"bundle":{"policies":[{"name","engine","body","assignments","tests"}]}}
+// Diffs the bundle against the org's policies by checksum, compiles and tests every bundle policy, and only
+// when all tests pass creates the missing policies, a draft version of each changed one, and the missing
+// assignments, in one transaction; a sync racing another change to the org's policies answers 409. Versions
+// still go through approval and activation. Nothing is removed: org policies and assignments the bundle
+// does not name are reported as unmanaged.
+func SyncPolicyBundle(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID format"})
+		return
+	}
+	var req syncPolicyBundleReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	b := req.Bundle
+	if len(b.Policies) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bundle has no policies"})
+		return
+	}
+	if err := b.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	// checksums are the server's own, whatever the client sent
+	for i := range b.Policies {
+		b.Policies[i].Checksum, _ = bundle.Checksum(b.Policies[i].Body)
+	}
+	current, err := bundle.CurrentPolicies(ctx, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := bundle.Plan(b, current)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	// compile and test the whole bundle before writing anything
+	resp := syncPolicyBundleResp{Commit: req.Commit, DryRun: req.DryRun, Policies: make([]syncedPolicy, len(b.Policies)), Unmanaged: plan.Unmanaged}
+	bodies := make([]json.RawMessage, len(b.Policies))
+	compiled := make([]policy.CompiledPolicy, len(b.Policies))
+	failed := 0
+	for i, p := range b.Policies {
+		resp.Policies[i].Change = plan.Changes[i]
+		e := evalRegistry[p.Engine]
+		if e == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("policy %s: unsupported engine %s", p.Name, p.Engine)})
+			return
+		}
+		if bodies[i], err = policy.BundleOrgSchemaDefs(ctx, orgID, p.Body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("policy %s: %v", p.Name, err)})
+			return
+		}
+		if compiled[i], err = compileForOrg(ctx, e, orgID, bodies[i]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("policy %s: %v", p.Name, err)})
+			return
+		}
+		resp.Policies[i].Tests = runPolicyTests(e, compiled[i], bundleTestCases(p.Tests))
+		for _, r := range resp.Policies[i].Tests {
+			if !r.Pass {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		resp.Error = fmt.Sprintf("%d bundle tests failed", failed)
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+	if req.DryRun || !plan.Changed() {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	if err := bundle.Apply(ctx, orgID, b, &plan, bodies, req.Commit, uid); err != nil {
+		if errors.Is(err, bundle.ErrPlanChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	counts := map[string]int{}
+	for i := range b.Policies {
+		resp.Policies[i].Change = plan.Changes[i]
+		syncedBundleChange(ctx, orgID, uid, &plan.Changes[i], req.Commit, compiled[i])
+		counts[plan.Changes[i].Action]++
+	}
+	_ = audit.Append(ctx, orgID, "policy_bundle_synced", map[string]any{"commit": req.Commit, "created": counts[bundle.ActionCreate], "updated": counts[bundle.ActionUpdate], "unchanged": counts[bundle.ActionUnchanged]}, uid, nil)
+	c.JSON(http.StatusOK, resp)
+}
+
+// syncedBundleChange audits a committed change and refreshes the policy's cached compilation
+func syncedBundleChange(ctx context.Context, orgID uuid.UUID, uid *uuid.UUID, ch *bundle.Change, commit string, cp policy.CompiledPolicy) {
+	if ch.Action == bundle.ActionUnchanged && len(ch.AddAssignments) == 0 {
+		return
+	}
+	pid := *ch.PolicyID
+	if ch.Action != bundle.ActionUnchanged {
+		_ = audit.Append(ctx, orgID, "policy_version_synced", map[string]any{"policy_id": pid, "version": ch.Version, "checksum": ch.Checksum, "commit": commit}, uid, nil)
+	}
+	for _, a := range ch.AddAssignments {
+		_ = audit.Append(ctx, orgID, "policy_assigned", map[string]any{"policy_id": pid, "scope_type": a.ScopeType, "scope_id": a.ScopeID, "commit": commit}, uid, nil)
+	}
+	policy.DeleteCompiled(pid, 0)
+	if ch.Action != bundle.ActionUnchanged {
+		policy.PutCompiled(pid, ch.Version, cp)
+	}
+	PublishPolicyInvalidate(ctx, pid.String())
+}
+
+func bundleTestCases(tests []bundle.TestCase) []PolicyTestCase {
+	out := make([]PolicyTestCase, len(tests))
+	for i, tc := range tests {
+		out[i] = PolicyTestCase{Name: tc.Name, Input: tc.Input, Expect: tc.Expect}
+	}
+	return out
+}
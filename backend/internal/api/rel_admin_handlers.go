@@ -1,36 +1,52 @@
 package api
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
 
 	database "github.com/Armour007/aura-backend/internal"
 	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/rel"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-// GET /admin/rel/tuples?object_ns=&object_id=&relation=&subject_ns=&subject_id=&limit=100
+// relAdminOrgs returns the orgs whose tuples the caller administers (as org admin or owner)
+func relAdminOrgs(c *gin.Context) (map[string]bool, error) {
+	var ids []string
+	if err := database.DB.SelectContext(c.Request.Context(), &ids, `SELECT organization_id::text FROM organization_members WHERE user_id=$1 AND role IN ('admin','owner')`, c.GetString("userID")); err != nil {
+		return nil, err
+	}
+	out := map[string]bool{}
+	for _, id := range ids {
+		out[id] = true
+	}
+	return out, nil
+}
+
+// GET /admin/rel/tuples?org_id=&object_ns=&object_id=&relation=&subject_ns=&subject_id=&subject_relation=&limit=100
+// Lists the tuples of the orgs the caller administers, or of org_id alone.
 func AdminListTuples(c *gin.Context) {
-	q := `SELECT object_type, object_id, relation, subject_type, subject_id FROM trust_tuples`
-	where := []string{}
-	args := []any{}
+	q := `SELECT org_id::text, object_type, object_id, relation, subject_type, subject_id, subject_relation FROM trust_tuples`
+	where := []string{`org_id IN (SELECT organization_id FROM organization_members WHERE user_id=$1 AND role IN ('admin','owner'))`}
+	args := []any{c.GetString("userID")}
 	add := func(col, val string) {
 		if val != "" {
 			where = append(where, col+"=$"+itoa(len(args)+1))
 			args = append(args, val)
 		}
 	}
+	add("org_id::text", c.Query("org_id"))
 	add("object_type", c.Query("object_ns"))
 	add("object_id", c.Query("object_id"))
 	add("relation", c.Query("relation"))
 	add("subject_type", c.Query("subject_ns"))
 	add("subject_id", c.Query("subject_id"))
-	if len(where) > 0 {
-		q += " WHERE " + join(where, " AND ")
-	}
-	q += " ORDER BY object_type, object_id, relation LIMIT $" + itoa(len(args)+1)
+	add("subject_relation", c.Query("subject_relation"))
+	q += " WHERE " + join(where, " AND ")
+	q += " ORDER BY org_id, object_type, object_id, relation LIMIT $" + itoa(len(args)+1)
 	limit := 100
 	if v := c.Query("limit"); v != "" {
 		if n, err := atoi(v); err == nil {
@@ -44,11 +60,11 @@ func AdminListTuples(c *gin.Context) {
 		return
 	}
 	defer rows.Close()
-	type row struct{ ObjectType, ObjectID, Relation, SubjectType, SubjectID string }
+	type row struct{ OrgID, ObjectType, ObjectID, Relation, SubjectType, SubjectID, SubjectRelation string }
 	out := []row{}
 	for rows.Next() {
 		var r row
-		if err := rows.Scan(&r.ObjectType, &r.ObjectID, &r.Relation, &r.SubjectType, &r.SubjectID); err != nil {
+		if err := rows.Scan(&r.OrgID, &r.ObjectType, &r.ObjectID, &r.Relation, &r.SubjectType, &r.SubjectID, &r.SubjectRelation); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
@@ -57,48 +73,113 @@ func AdminListTuples(c *gin.Context) {
 	c.JSON(http.StatusOK, out)
 }
 
-// DELETE /admin/rel/tuples?confirm=true&object_ns=&object_id=&relation=&subject_ns=&subject_id=
+// DELETE /admin/rel/tuples?org_id=&confirm=true&object_ns=&object_id=&relation=&subject_ns=&subject_id=&subject_relation=
+// Deletes tuples of one org the caller administers.
 func AdminDeleteTuples(c *gin.Context) {
-	confirm := c.Query("confirm") == "true"
-	where := []string{}
-	args := []any{}
-	add := func(col, val string) {
-		if val != "" {
-			where = append(where, col+"=$"+itoa(len(args)+1))
-			args = append(args, val)
-		}
+	orgID, err := uuid.Parse(c.Query("org_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "org_id required"})
+		return
 	}
-	add("object_type", c.Query("object_ns"))
-	add("object_id", c.Query("object_id"))
-	add("relation", c.Query("relation"))
-	add("subject_type", c.Query("subject_ns"))
-	add("subject_id", c.Query("subject_id"))
-	if len(where) == 0 && !confirm {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm=true required to delete all"})
+	orgs, err := relAdminOrgs(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !orgs[orgID.String()] {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admin privileges required"})
 		return
 	}
-	q := "DELETE FROM trust_tuples"
-	if len(where) > 0 {
-		q += " WHERE " + join(where, " AND ")
+	filter := rel.TupleFilter{
+		ObjectType:      c.Query("object_ns"),
+		ObjectID:        c.Query("object_id"),
+		Relation:        c.Query("relation"),
+		SubjectType:     c.Query("subject_ns"),
+		SubjectID:       c.Query("subject_id"),
+		SubjectRelation: c.Query("subject_relation"),
 	}
-	if _, err := database.DB.Exec(q, args...); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if filter.Empty() && c.Query("confirm") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm=true required to delete all"})
 		return
 	}
-	// Invalidate caches and broadcast graph invalidation
-	ClearGraphCache()
-	PublishGraphInvalidate(c.Request.Context())
+	// deletes go through the graph client, so the configured backend loses the tuples, the changelog records
+	// them and the cached checks reading them are dropped
+	token, err := getGraph().Delete(rel.WithOrg(c.Request.Context(), orgID.String()), filter, nil)
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	relStore.Delete(orgID.String(), filter)
+	// replicas drop the affected checks; filters not naming one tuple set invalidate everything
+	PublishGraphRevision(c.Request.Context(), token, rel.FilterObjects(orgID.String(), filter))
 	// Audit deletion with filter context
-	_ = audit.Append(c.Request.Context(), uuid.Nil, "rel_delete", gin.H{
-		"object_ns":  c.Query("object_ns"),
-		"object_id":  c.Query("object_id"),
-		"relation":   c.Query("relation"),
-		"subject_ns": c.Query("subject_ns"),
-		"subject_id": c.Query("subject_id"),
+	_ = audit.Append(c.Request.Context(), orgID, "rel_delete", gin.H{
+		"object_ns":        c.Query("object_ns"),
+		"object_id":        c.Query("object_id"),
+		"relation":         c.Query("relation"),
+		"subject_ns":       c.Query("subject_ns"),
+		"subject_id":       c.Query("subject_id"),
+		"subject_relation": c.Query("subject_relation"),
 	}, nil, nil)
 	c.Status(http.StatusNoContent)
 }
 
+// GET /admin/rel/watch?after_revision=0&follow=true
+// Streams tuple changes after a revision as NDJSON, oldest first. With follow=true the stream stays open
+// and delivers new changes as they are written; otherwise it ends at the current head. Only changes to
+// the tuples of orgs the caller administers are streamed.
+func AdminWatchTuples(c *gin.Context) {
+	var after int64
+	if v := c.Query("after_revision"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "after_revision must be a non-negative integer"})
+			return
+		}
+		after = n
+	}
+	orgs, err := relAdminOrgs(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	enc := json.NewEncoder(c.Writer)
+	emit := func(ch rel.Change) error {
+		if !orgs[ch.OrgID] {
+			return nil
+		}
+		if err := enc.Encode(ch); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+	if c.Query("follow") == "true" {
+		_ = rel.Watch(ctx, after, emit)
+		return
+	}
+	for {
+		changes, err := rel.Changes(ctx, after, 500)
+		if err != nil {
+			_ = enc.Encode(gin.H{"error": err.Error()})
+			return
+		}
+		if len(changes) == 0 {
+			return
+		}
+		for _, ch := range changes {
+			if emit(ch) != nil {
+				return
+			}
+			after = ch.Revision
+		}
+	}
+}
+
 // tiny helpers to avoid extra imports
 func itoa(i int) string          { return fmt.Sprintf("%d", i) }
 func atoi(s string) (int, error) { return strconv.Atoi(s) }
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Armour007/aura-backend/internal/rel"
+	"github.com/gin-gonic/gin"
+)
+
+var bulkContentTypes = map[string]string{
+	rel.FormatNDJSON: "application/x-ndjson",
+	rel.FormatCSV:    "text/csv",
+	rel.FormatZed:    "text/plain; charset=utf-8",
+}
+
+// bulkFormat reads ?format=, ndjson by default
+func bulkFormat(c *gin.Context) (string, bool) {
+	format := c.DefaultQuery("format", rel.FormatNDJSON)
+	if _, ok := bulkContentTypes[format]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ndjson, csv or zed"})
+		return "", false
+	}
+	return format, true
+}
+
+// GET /v1/tuples/export?format=ndjson|csv|zed[&object_type=&relation=&subject_type=]
+// Streams the org's trust_tuples in insertion order; zed output can be fed to `zed relationship create`
+// or the import endpoint of another environment.
+func ExportTuplesV1(c *gin.Context) {
+	format, ok := bulkFormat(c)
+	if !ok {
+		return
+	}
+	filter := rel.TupleFilter{ObjectType: c.Query("object_type"), Relation: c.Query("relation"), SubjectType: c.Query("subject_type")}
+	c.Header("Content-Type", bulkContentTypes[format])
+	c.Header("Cache-Control", "no-cache")
+	c.Status(http.StatusOK)
+	enc, _ := rel.NewTupleEncoder(c.Writer, format)
+	n := 0
+	err := rel.ExportTuples(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), filter, func(t rel.Tuple) error {
+		if err := enc.Encode(t); err != nil {
+			return err
+		}
+		// flush a page at a time so large exports stream
+		if n++; n%500 == 0 {
+			if err := enc.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	_ = enc.Flush()
+	if err != nil {
+		// headers are sent; a truncated body is all the client can be told
+		_ = c.Error(err)
+	}
+}
+
+// POST /v1/tuples/import?format=ndjson|csv|zed[&dry_run=true][&batch_size=1000]
+// Touches the tuples of the request body into the graph, one transaction per batch. A dry run validates
+// every tuple against the org schema and tenancy rules and writes nothing. A real import stops at the
+// first invalid tuple; the report says which batches were committed before it.
+func ImportTuplesV1(c *gin.Context) {
+	format, ok := bulkFormat(c)
+	if !ok {
+		return
+	}
+	opts := rel.ImportOptions{DryRun: c.Query("dry_run") == "true" || c.Query("dry_run") == "1"}
+	if v := c.Query("batch_size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 10000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "batch_size must be between 1 and 10000"})
+			return
+		}
+		opts.BatchSize = n
+	}
+	dec, _ := rel.NewTupleDecoder(c.Request.Body, format)
+	ctx := rel.WithOrg(c.Request.Context(), c.GetString("orgID"))
+	res, err := rel.ImportTuples(ctx, getGraph(), dec, opts)
+	if res.Imported > 0 {
+		// the cached client dropped the checks of each batch; replicas drop everything
+		PublishGraphRevision(c.Request.Context(), res.Revision, nil)
+	}
+	switch {
+	case err != nil:
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error(), "result": res})
+	case opts.DryRun && len(res.Invalid) > 0:
+		c.JSON(http.StatusUnprocessableEntity, res)
+	default:
+		c.JSON(http.StatusOK, res)
+	}
+}
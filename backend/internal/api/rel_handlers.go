@@ -24,17 +24,17 @@ func UpsertTuples(c *gin.Context) {
 		return
 	}
 	// write-through DB and mirror to memory
-	if err := relDB.Upsert(c.Request.Context(), req.Tuples); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	orgID := c.Param("orgId")
+	if err := relDB.Upsert(rel.WithOrg(c.Request.Context(), orgID), req.Tuples); err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	relStore.Upsert(req.Tuples)
-	// invalidate cached checks
-	ClearGraphCache()
-	// publish graph invalidation across mesh
-	PublishGraphInvalidate(c.Request.Context())
+	relStore.Upsert(orgID, req.Tuples)
+	// invalidate the cached checks reading these tuples, here and across the mesh
+	objects := rel.UpdatedObjects(orgID, rel.Touches(req.Tuples))
+	InvalidateGraphObjects(objects)
+	PublishGraphRevision(c.Request.Context(), "", objects)
 	// audit
-	orgID := c.Param("orgId")
 	_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "rel_upsert", gin.H{"tuples": req.Tuples}, nil, nil)
 	c.Status(http.StatusNoContent)
 }
@@ -53,10 +53,11 @@ func CheckRelation(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if ok, err := relDB.Check(context.Background(), req.SubjectType, req.SubjectID, req.Relation, req.ObjectType, req.ObjectID); err == nil {
+	orgID := c.Param("orgId")
+	if ok, err := relDB.Check(rel.WithOrg(context.Background(), orgID), req.SubjectType, req.SubjectID, req.Relation, req.ObjectType, req.ObjectID); err == nil {
 		c.JSON(http.StatusOK, gin.H{"allowed": ok})
 		return
 	}
-	ok := relStore.Check(req.SubjectType, req.SubjectID, req.Relation, req.ObjectType, req.ObjectID)
+	ok := relStore.Check(orgID, req.SubjectType, req.SubjectID, req.Relation, req.ObjectType, req.ObjectID)
 	c.JSON(http.StatusOK, gin.H{"allowed": ok})
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/rel"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// GET /organizations/:orgId/rel/schema
+// Returns the trust graph schema in force; 404 while the org uses the default schema.
+func GetRelSchema(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	sv, err := rel.GetSchemaVersion(c.Request.Context(), orgID, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no schema defined; the default schema applies"})
+		return
+	}
+	c.JSON(http.StatusOK, sv)
+}
+
+// PUT /organizations/:orgId/rel/schema
+// Body: a namespace configuration ({"namespaces": {...}}), stored as the next version
+func PutRelSchema(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	var body json.RawMessage
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := rel.ParseSchema(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	sv, err := rel.SaveSchema(c.Request.Context(), orgID, body, uid)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ClearGraphCache()
+	PublishGraphInvalidate(c.Request.Context())
+	_ = audit.Append(c.Request.Context(), orgID, "rel_schema_put", map[string]any{"version": sv.Version}, uid, nil)
+	c.JSON(http.StatusOK, sv)
+}
+
+// GET /organizations/:orgId/rel/schema/versions
+func ListRelSchemaVersions(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	items, err := rel.ListSchemaVersions(c.Request.Context(), orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// GET /organizations/:orgId/rel/schema/versions/:version
+func GetRelSchemaVersion(c *gin.Context) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad org id"})
+		return
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad version"})
+		return
+	}
+	sv, err := rel.GetSchemaVersion(c.Request.Context(), orgID, version)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if sv == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+		return
+	}
+	c.JSON(http.StatusOK, sv)
+}
@@ -1,7 +1,11 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/Armour007/aura-backend/internal/rel"
 	"github.com/gin-gonic/gin"
@@ -22,67 +26,222 @@ func UpsertTuplesV1(c *gin.Context) {
 		c.Status(http.StatusNoContent)
 		return
 	}
-	if err := getGraph().UpsertBatch(c.Request.Context(), req.Tuples); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	token, err := getGraph().WriteRelationships(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), rel.Touches(req.Tuples), nil)
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	// the cached client drops the checks the write affects; replicas learn of it over the mesh
+	PublishGraphRevision(c.Request.Context(), token, rel.UpdatedObjects(c.GetString("orgID"), rel.Touches(req.Tuples)))
+	c.JSON(http.StatusOK, gin.H{"revision_token": token})
+}
+
+type writeRelationshipsReq struct {
+	Updates       []rel.RelationshipUpdate `json:"updates" binding:"required"`
+	Preconditions []rel.Precondition       `json:"preconditions"`
+}
+
+// POST /v1/tuples/write applies touch/create/delete updates atomically once the preconditions hold
+func WriteRelationshipsV1(c *gin.Context) {
+	var req writeRelationshipsReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	token, err := getGraph().WriteRelationships(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), req.Updates, req.Preconditions)
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	PublishGraphRevision(c.Request.Context(), token, rel.UpdatedObjects(c.GetString("orgID"), req.Updates))
+	c.JSON(http.StatusOK, gin.H{"revision_token": token})
+}
+
+type deleteTuplesReq struct {
+	Filter        rel.TupleFilter    `json:"filter"`
+	Preconditions []rel.Precondition `json:"preconditions"`
+}
+
+// POST /v1/tuples/delete removes the tuples matching a filter once the preconditions hold
+func DeleteTuplesV1(c *gin.Context) {
+	var req deleteTuplesReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Filter.ObjectType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "filter.object_type required"})
+		return
+	}
+	token, err := getGraph().Delete(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), req.Filter, req.Preconditions)
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	// invalidate cache on write
-	ClearGraphCache()
-	// publish graph invalidation across mesh
-	PublishGraphInvalidate(c.Request.Context())
-	c.Status(http.StatusNoContent)
+	PublishGraphRevision(c.Request.Context(), token, rel.FilterObjects(c.GetString("orgID"), req.Filter))
+	c.JSON(http.StatusOK, gin.H{"revision_token": token})
 }
 
 type checkReqV1 struct {
 	Subject  rel.RelationRef `json:"subject" binding:"required"`
 	Relation string          `json:"relation" binding:"required"`
 	Object   rel.RelationRef `json:"object" binding:"required"`
+	// Context is evaluated by caveats on the tuples the check passes through
+	Context     map[string]any  `json:"context,omitempty"`
+	Consistency rel.Consistency `json:"consistency,omitempty"`
 }
 type checkRespV1 struct {
-	Allowed bool   `json:"allowed"`
-	Source  string `json:"source"`
+	Allowed        bool               `json:"allowed"`
+	Permissionship rel.Permissionship `json:"permissionship"`
+	MissingContext []string           `json:"missing_context,omitempty"`
+	Source         string             `json:"source"`
+	Explanation    *rel.Explanation   `json:"explanation,omitempty"`
 }
 
-// POST /v1/check
+// POST /v1/check[?explain=1]
+// With explain=1 the answer carries the proof: the tuples of the granting path, or what a denial read.
 func CheckRelationV1(c *gin.Context) {
 	var req checkReqV1
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	allowed, source, err := getGraph().Check(c.Request.Context(), req.Subject, req.Relation, req.Object)
+	if err := req.Consistency.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx := rel.WithConsistency(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), req.Consistency)
+	explain := c.Query("explain") == "1" || c.Query("explain") == "true"
+	if explain {
+		ctx = rel.WithExplain(ctx)
+	}
+	res, err := getGraph().Check(ctx, req.Subject, req.Relation, req.Object, req.Context)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, checkRespV1{Allowed: allowed, Source: source})
+	resp := checkRespV1{Allowed: res.Allowed(), Permissionship: res.Permissionship, MissingContext: res.MissingContext, Source: res.Source}
+	if explain {
+		resp.Explanation = res.Explanation
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
-// GET /v1/trust/graph/expand?object=team:devs&relation=member
+// GET /v1/trust/graph/expand?object=team:devs&relation=member[&depth=3][&at_least_as_fresh=<token>|&fully_consistent=true]
+// Expands recursively, up to rel.MaxDepth levels unless depth is smaller.
 func ExpandTrustGraphV1(c *gin.Context) {
-	object := c.Query("object")
 	relation := c.Query("relation")
-	if object == "" || relation == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "object and relation required"})
+	object, ok := parseRelationRef(c.Query("object"))
+	if !ok || relation == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "object (ns:id) and relation required"})
 		return
 	}
-	// parse object in ns:id format
-	var ns, id string
-	for i := 0; i < len(object); i++ {
-		if object[i] == ':' {
-			ns = object[:i]
-			id = object[i+1:]
-			break
+	depth := rel.MaxDepth
+	if v := c.Query("depth"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a positive integer"})
+			return
 		}
+		depth = n
 	}
-	if ns == "" || id == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "object must be ns:id"})
+	ctx, ok := graphReadContext(c)
+	if !ok {
 		return
 	}
-	exp, err := getGraph().Expand(c.Request.Context(), relation, rel.RelationRef{Namespace: ns, ObjectID: id}, 1)
+	exp, err := getGraph().Expand(ctx, relation, object, depth)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, exp)
 }
+
+// GET /v1/trust/graph/lookup-resources?subject=agent:a1&relation=can_act_for&resource_type=org[&limit=100&cursor=]
+// Lists the resources of a type the subject has the relation on, e.g. the orgs an agent can act for.
+func LookupResourcesV1(c *gin.Context) {
+	subject, ok := parseRelationRef(c.Query("subject"))
+	relation, resourceType := c.Query("relation"), c.Query("resource_type")
+	if !ok || relation == "" || resourceType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "subject (ns:id), relation and resource_type required"})
+		return
+	}
+	ctx, ok := graphReadContext(c)
+	if !ok {
+		return
+	}
+	res, err := getGraph().LookupResources(ctx, subject, relation, resourceType, lookupPage(c))
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /v1/trust/graph/lookup-subjects?resource=org:o1&relation=can_act_for&subject_type=agent[&limit=100&cursor=]
+// Lists the subjects of a type that have the relation on the resource, e.g. who can act for an org.
+func LookupSubjectsV1(c *gin.Context) {
+	resource, ok := parseRelationRef(c.Query("resource"))
+	relation, subjectType := c.Query("relation"), c.Query("subject_type")
+	if !ok || relation == "" || subjectType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "resource (ns:id), relation and subject_type required"})
+		return
+	}
+	ctx, ok := graphReadContext(c)
+	if !ok {
+		return
+	}
+	res, err := getGraph().LookupSubjects(ctx, resource, relation, subjectType, lookupPage(c))
+	if err != nil {
+		c.JSON(graphErrStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// parseRelationRef parses an object in ns:id format
+func parseRelationRef(s string) (rel.RelationRef, bool) {
+	ns, id, ok := strings.Cut(s, ":")
+	if !ok || ns == "" || id == "" {
+		return rel.RelationRef{}, false
+	}
+	return rel.RelationRef{Namespace: ns, ObjectID: id}, true
+}
+
+// graphReadContext scopes a graph read to the caller's org with the at_least_as_fresh / fully_consistent
+// query parameters; it answers 400 itself when they are invalid
+func graphReadContext(c *gin.Context) (context.Context, bool) {
+	cons := rel.Consistency{AtLeastAsFresh: c.Query("at_least_as_fresh"), FullyConsistent: c.Query("fully_consistent") == "true"}
+	if err := cons.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return rel.WithConsistency(rel.WithOrg(c.Request.Context(), c.GetString("orgID")), cons), true
+}
+
+func lookupPage(c *gin.Context) rel.Page {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return rel.Page{Cursor: c.Query("cursor"), Limit: limit}
+}
+
+// graphErrStatus maps schema violations, bad caveat context and bad revision tokens to 400, tuples
+// crossing into another org to 403, failed write conditions to 409, graphs too deep or broad to answer
+// to 422, a revision the store has not reached yet to 503 and anything else to 500
+func graphErrStatus(err error) int {
+	if errors.Is(err, rel.ErrInvalidTuple) || errors.Is(err, rel.ErrUnknownRelation) || errors.Is(err, rel.ErrInvalidContext) || errors.Is(err, rel.ErrInvalidToken) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, rel.ErrCrossOrg) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, rel.ErrPreconditionFailed) || errors.Is(err, rel.ErrTupleExists) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, rel.ErrLookupTooBroad) || errors.Is(err, rel.ErrMaxDepth) {
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, rel.ErrRevisionUnavailable) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -72,6 +72,7 @@ func AddPermissionRule(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add permission rule: " + err.Error()})
 		return
 	}
+	reconvertV1Permissions(c.Request.Context(), orgId, agentId, ruleAuthor(c))
 
 	// Respond with the created permission details
 	response := PermissionResponse{
@@ -232,6 +233,8 @@ func DeletePermissionRule(c *gin.Context) {
 		return
 	}
 
+	reconvertV1Permissions(c.Request.Context(), orgId, agentId, ruleAuthor(c))
+
 	// Respond with success (No Content)
 	c.Status(http.StatusNoContent)
 
@@ -268,3 +271,11 @@ func DeletePermissionRule(c *gin.Context) {
 			VALUES (:organization_id, :agent_id, :timestamp, :event_type, :decision, :request_details, :client_ip_address, :request_id, :user_agent, :path, :status_code)`, event)
 	}()
 }
+
+// ruleAuthor returns the signed-in user for attribution of generated policy versions
+func ruleAuthor(c *gin.Context) *uuid.UUID {
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		return &u
+	}
+	return nil
+}
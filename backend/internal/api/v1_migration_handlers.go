@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/audit"
+	"github.com/Armour007/aura-backend/internal/engine"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// evaluateV1 decides a /v1/verify request. Agents whose rules were converted are served according to
+// their migration mode: dual runs both engines and records divergences while the legacy engine answers,
+// v2 answers from the converted policy. A conversion older than the current rules (stale) or a failing
+// converted policy falls back to the legacy engine.
+func evaluateV1(ctx context.Context, orgID, agentID uuid.UUID, reqCtx json.RawMessage) (bool, string) {
+	mig, err := engine.GetMigration(ctx, agentID)
+	if err != nil || mig == nil || mig.Mode == engine.ModeLegacy {
+		return engine.Evaluate(agentID, reqCtx)
+	}
+	rules, err := engine.LoadRules(ctx, agentID)
+	if err != nil {
+		log.Printf("Error fetching rules for agent %s: %v", agentID, err)
+		return false, "Internal error fetching rules"
+	}
+	div := engine.Divergence{OrgID: orgID, AgentID: agentID, PolicyID: mig.PolicyID, Version: mig.Version, RequestContext: reqCtx}
+	legacy := func() (bool, string) {
+		allow, reason := engine.EvaluateRules(agentID, rules, reqCtx, time.Now())
+		div.LegacyAllow, div.LegacyReason = allow, &reason
+		return allow, reason
+	}
+	if engine.RulesChecksum(rules) != mig.SourceChecksum {
+		allow, reason := legacy()
+		div.Kind = engine.DivergenceStale
+		recordV1Divergence(ctx, div)
+		return allow, reason
+	}
+	dec, err := evaluateConvertedV1(ctx, orgID, mig, reqCtx)
+	if err != nil {
+		allow, reason := legacy()
+		msg := err.Error()
+		div.Kind, div.V2Reason = engine.DivergenceError, &msg
+		recordV1Divergence(ctx, div)
+		return allow, reason
+	}
+	if mig.Mode == engine.ModeV2 {
+		return dec.Allow, dec.Reason
+	}
+	allow, reason := legacy()
+	if dec.Allow != allow {
+		div.Kind, div.V2Allow, div.V2Reason = engine.DivergenceDecision, &dec.Allow, &dec.Reason
+		recordV1Divergence(ctx, div)
+	}
+	return allow, reason
+}
+
+// evaluateConvertedV1 evaluates the policy version an agent's rules were converted into
+func evaluateConvertedV1(ctx context.Context, orgID uuid.UUID, mig *engine.Migration, reqCtx json.RawMessage) (policy.Decision, error) {
+	v, err := policy.GetVersion(ctx, mig.PolicyID, mig.Version)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+	e := evalRegistry[policy.EngineAuraJSON]
+	comp, err := compiledFor(ctx, e, orgID, v)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+	return e.Evaluate(comp, reqCtx)
+}
+
+func recordV1Divergence(ctx context.Context, d engine.Divergence) {
+	RecordV1Divergence(d.Kind, d.OrgID.String())
+	go func() { _ = engine.RecordDivergence(context.WithoutCancel(ctx), d) }()
+}
+
+// convertV1Permissions converts the agent's active rules into a new version of its migration policy
+// (created on first conversion) and records the migration. Unchanged rules are not converted again.
+func convertV1Permissions(ctx context.Context, orgID, agentID uuid.UUID, userID *uuid.UUID) (engine.Migration, error) {
+	rules, err := engine.LoadRules(ctx, agentID)
+	if err != nil {
+		return engine.Migration{}, err
+	}
+	body, warnings, err := engine.Convert(rules)
+	if err != nil {
+		return engine.Migration{}, err
+	}
+	checksum := engine.RulesChecksum(rules)
+	existing, err := engine.GetMigration(ctx, agentID)
+	if err != nil {
+		return engine.Migration{}, err
+	}
+	if existing != nil && existing.SourceChecksum == checksum {
+		return *existing, nil
+	}
+	var policyID uuid.UUID
+	if existing != nil {
+		policyID = existing.PolicyID
+	} else {
+		p, err := policy.CreatePolicy(ctx, orgID, "v1-permissions-"+agentID.String(), policy.EngineAuraJSON, userID)
+		if err != nil {
+			return engine.Migration{}, err
+		}
+		policyID = p.ID
+	}
+	if _, err := evalRegistry[policy.EngineAuraJSON].Compile(body); err != nil {
+		return engine.Migration{}, err
+	}
+	v, err := policy.AddVersion(ctx, policyID, body, userID)
+	if err != nil {
+		return engine.Migration{}, err
+	}
+	wb, _ := json.Marshal(warnings)
+	m, err := engine.SaveMigration(ctx, engine.Migration{AgentID: agentID, OrgID: orgID, PolicyID: policyID, Version: v.Version, SourceChecksum: checksum, Warnings: wb})
+	if err != nil {
+		return engine.Migration{}, err
+	}
+	_ = audit.Append(ctx, orgID, "v1_permissions_converted", map[string]any{"agent_id": agentID, "policy_id": policyID, "version": v.Version, "rules": len(rules), "warnings": warnings}, userID, nil)
+	return m, nil
+}
+
+// reconvertV1Permissions keeps an agent's converted policy in step after its v1 rules change
+func reconvertV1Permissions(ctx context.Context, orgID, agentID uuid.UUID, userID *uuid.UUID) {
+	if mig, err := engine.GetMigration(ctx, agentID); err != nil || mig == nil {
+		return
+	}
+	if _, err := convertV1Permissions(ctx, orgID, agentID, userID); err != nil {
+		log.Printf("v1 permissions reconversion failed for agent %s: %v", agentID, err)
+	}
+}
+
+// POST /organizations/:orgId/agents/:agentId/permissions/convert?dry_run=1
+// Converts the agent's v1 rules into an AuraJSON policy version and enables dual-run for /v1/verify.
+// dry_run returns the converted body and warnings without storing anything.
+func ConvertV1Permissions(c *gin.Context) {
+	orgID, agentID, ok := orgAgentParams(c)
+	if !ok {
+		return
+	}
+	if c.Query("dry_run") == "1" || c.Query("dry_run") == "true" {
+		rules, err := engine.LoadRules(c.Request.Context(), agentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		body, warnings, err := engine.Convert(rules)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "warnings": warnings})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"body": body, "warnings": warnings, "source_checksum": engine.RulesChecksum(rules)})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	m, err := convertV1Permissions(c.Request.Context(), orgID, agentID, uid)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, m)
+}
+
+// GET /organizations/:orgId/agents/:agentId/permissions/migration
+func GetV1Migration(c *gin.Context) {
+	_, agentID, ok := orgAgentParams(c)
+	if !ok {
+		return
+	}
+	m, err := engine.GetMigration(c.Request.Context(), agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if m == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rules have not been converted"})
+		return
+	}
+	var counts []struct {
+		Kind  string `db:"kind" json:"kind"`
+		Count int    `db:"count" json:"count"`
+	}
+	_ = database.DB.SelectContext(c.Request.Context(), &counts, `SELECT kind, COUNT(*) AS count FROM v1_verify_divergences WHERE agent_id=$1 AND policy_id=$2 AND version=$3 GROUP BY kind ORDER BY kind`, agentID, m.PolicyID, m.Version)
+	c.JSON(http.StatusOK, gin.H{"migration": m, "divergences": counts})
+}
+
+type setV1ModeRequest struct {
+	Mode string `json:"mode" binding:"required"`
+}
+
+// PUT /organizations/:orgId/agents/:agentId/permissions/migration
+// Body: {"mode":"legacy"|"dual"|"v2"}
+func SetV1VerifyMode(c *gin.Context) {
+	orgID, agentID, ok := orgAgentParams(c)
+	if !ok {
+		return
+	}
+	var req setV1ModeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	switch req.Mode {
+	case engine.ModeLegacy, engine.ModeDual, engine.ModeV2:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be legacy, dual or v2"})
+		return
+	}
+	found, err := engine.SetMode(c.Request.Context(), orgID, agentID, req.Mode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rules have not been converted"})
+		return
+	}
+	var uid *uuid.UUID
+	if u, err := uuid.Parse(c.GetString("userID")); err == nil {
+		uid = &u
+	}
+	_ = audit.Append(c.Request.Context(), orgID, "v1_verify_mode_changed", map[string]any{"agent_id": agentID, "mode": req.Mode}, uid, nil)
+	c.JSON(http.StatusOK, gin.H{"agent_id": agentID, "mode": req.Mode})
+}
+
+// GET /organizations/:orgId/agents/:agentId/permissions/divergences?limit=100
+func ListV1Divergences(c *gin.Context) {
+	orgID, agentID, ok := orgAgentParams(c)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	items, err := engine.ListDivergences(c.Request.Context(), orgID, agentID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+// orgAgentParams parses :orgId and :agentId and checks the agent belongs to the org
+func orgAgentParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	orgID, err := uuid.Parse(c.Param("orgId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	agentID, err := uuid.Parse(c.Param("agentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid agent ID format"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	if !ensureAgentInOrg(c, agentID, orgID) {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return orgID, agentID, true
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type VerifyBatchRequest struct {
+	Items []VerifyV2Request `json:"items" binding:"required"`
+}
+
+// VerifyBatchItem is one result, at the same index as its request item
+type VerifyBatchItem struct {
+	Index int `json:"index"`
+	VerifyV2Response
+	Error string `json:"error,omitempty"`
+}
+
+// POST /v2/verify/batch
+// Verifies many requests with one inflight slot. Assignment, rollout, federation contract and delegation
+// lookups are shared across items, items are evaluated in parallel (AURA_VERIFY_BATCH_WORKERS, default 8)
+// and traces are persisted with one bulk insert. At most AURA_VERIFY_BATCH_MAX items (default 200).
+func HandleVerifyBatch(c *gin.Context) {
+	if !acquireVerifySlot() {
+		IncVerifyQuickReject()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "verify overloaded"})
+		return
+	}
+	defer releaseVerifySlot()
+
+	ctx, span := otel.Tracer("aura-backend").Start(c.Request.Context(), "verify.batch")
+	defer span.End()
+
+	var req VerifyBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		span.SetStatus(codes.Error, "bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	max := envInt("AURA_VERIFY_BATCH_MAX", 200)
+	if len(req.Items) == 0 || len(req.Items) > max {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items must contain between 1 and " + strconv.Itoa(max) + " requests"})
+		return
+	}
+	for i, it := range req.Items {
+		if err := it.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "items[" + strconv.Itoa(i) + "]: " + err.Error()})
+			return
+		}
+	}
+	span.SetAttributes(attribute.Int("verify.batch_size", len(req.Items)))
+
+	vr := newVerifier(c)
+	items := make([]VerifyBatchItem, len(req.Items))
+	traces := make([]*decisionTraceRow, len(req.Items))
+	workers := envInt("AURA_VERIFY_BATCH_WORKERS", 8)
+	if workers <= 0 || workers > len(req.Items) {
+		workers = len(req.Items)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				res, err := vr.verify(ctx, req.Items[i])
+				items[i] = VerifyBatchItem{Index: i, VerifyV2Response: res.resp}
+				if err != nil {
+					items[i].Error = err.Error()
+					items[i].Reason = "Verification failed"
+				}
+				traces[i] = res.trace
+			}
+		}()
+	}
+	for i := range req.Items {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	rows := make([]decisionTraceRow, 0, len(traces))
+	for _, t := range traces {
+		if t != nil {
+			rows = append(rows, *t)
+		}
+	}
+	go persistDecisionTraces(context.WithoutCancel(ctx), vr.orgID, rows)
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
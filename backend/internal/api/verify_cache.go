@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+)
+
+// verifyCache is the opt-in /v2/verify cache (AURA_VERIFY_CACHE=1). It keeps the Postgres lookups done
+// before evaluation (applicable assignments after rollouts, federation contract scope) and combined
+// decisions keyed by org, agent, target, policy id@version set and canonical context.
+// Allows live for AURA_VERIFY_CACHE_TTL_MS (default 5000), denials and missing contracts for
+// AURA_VERIFY_CACHE_NEG_TTL_MS (default 1000, 0 disables negative caching). Each of the three holds at
+// most AURA_VERIFY_CACHE_MAX_ENTRIES entries (default 10000).
+type verifyCache struct {
+	decisions *policy.DecisionCache
+	ttl       time.Duration
+	negTTL    time.Duration
+
+	assignments *ttlMap[[]policy.ApplicableAssignment]
+	// contracts values are nil when there is no active contract
+	contracts *ttlMap[json.RawMessage]
+}
+
+// ttlMap is a bounded map of expiring values, evicted like policy.DecisionCache: expired entries first,
+// then an arbitrary one
+type ttlMap[V any] struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]ttlEntry[V]
+}
+
+type ttlEntry[V any] struct {
+	val     V
+	expires time.Time
+}
+
+func newTTLMap[V any](max int) *ttlMap[V] {
+	if max <= 0 {
+		max = 10000
+	}
+	return &ttlMap[V]{max: max, entries: map[string]ttlEntry[V]{}}
+}
+
+func (m *ttlMap[V]) get(key string) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ent, ok := m.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if !time.Now().Before(ent.expires) {
+		delete(m.entries, key)
+		var zero V
+		return zero, false
+	}
+	return ent.val, true
+}
+
+func (m *ttlMap[V]) put(key string, val V, ttl time.Duration) {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.entries[key]; !exists && len(m.entries) >= m.max {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		for k := range m.entries {
+			if len(m.entries) < m.max {
+				break
+			}
+			delete(m.entries, k)
+		}
+	}
+	m.entries[key] = ttlEntry[V]{val: val, expires: now.Add(ttl)}
+}
+
+func (m *ttlMap[V]) clear() {
+	m.mu.Lock()
+	m.entries = map[string]ttlEntry[V]{}
+	m.mu.Unlock()
+}
+
+func (m *ttlMap[V]) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+var (
+	vcOnce sync.Once
+	vc     *verifyCache
+)
+
+// getVerifyCache returns nil unless caching is enabled
+func getVerifyCache() *verifyCache {
+	vcOnce.Do(func() {
+		if os.Getenv("AURA_VERIFY_CACHE") != "1" {
+			return
+		}
+		ttl := envMillis("AURA_VERIFY_CACHE_TTL_MS", 5*time.Second)
+		negTTL := envMillis("AURA_VERIFY_CACHE_NEG_TTL_MS", time.Second)
+		max, _ := strconv.Atoi(os.Getenv("AURA_VERIFY_CACHE_MAX_ENTRIES"))
+		vc = &verifyCache{
+			decisions:   policy.NewDecisionCache(ttl, negTTL, max),
+			ttl:         ttl,
+			negTTL:      negTTL,
+			assignments: newTTLMap[[]policy.ApplicableAssignment](max),
+			contracts:   newTTLMap[json.RawMessage](max),
+		}
+	})
+	return vc
+}
+
+func envMillis(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return time.Duration(n) * time.Millisecond
+		}
+	}
+	return def
+}
+
+// InvalidateVerifyCache drops cached decisions that involved the policy; cached assignment lookups are
+// dropped entirely since an assignment change may add the policy for any agent
+func InvalidateVerifyCache(policyID uuid.UUID) {
+	c := getVerifyCache()
+	if c == nil {
+		return
+	}
+	c.decisions.InvalidatePolicy(policyID)
+	c.assignments.clear()
+}
+
+// ClearVerifyCache drops everything (graph or federation changes)
+func ClearVerifyCache() {
+	c := getVerifyCache()
+	if c == nil {
+		return
+	}
+	c.decisions.Clear()
+	c.assignments.clear()
+	c.contracts.clear()
+}
+
+// resolveAssignments returns the agent's applicable assignments with canary rollouts applied
+func resolveAssignments(ctx context.Context, orgID, agentStr string) ([]policy.ApplicableAssignment, error) {
+	c := getVerifyCache()
+	key := orgID + "|" + agentStr
+	if c != nil {
+		if val, ok := c.assignments.get(key); ok {
+			RecordCacheHit("verify", "assignments")
+			return val, nil
+		}
+		RecordCacheMiss("verify", "assignments")
+	}
+	assignCtx, assignSpan := otel.Tracer("aura-backend").Start(ctx, "db.get_active_assignments")
+	assignments, err := policy.GetApplicableAssignments(assignCtx, uuid.MustParse(orgID), agentStr)
+	assignSpan.End()
+	if err != nil {
+		return nil, err
+	}
+	if len(assignments) > 0 {
+		_, rollSpan := otel.Tracer("aura-backend").Start(ctx, "db.get_policy_rollout")
+		assignments = applyRollouts(ctx, orgID, agentStr, assignments)
+		rollSpan.End()
+	}
+	if c != nil {
+		ttl := c.ttl
+		if len(assignments) == 0 {
+			ttl = c.negTTL
+		}
+		if ttl > 0 {
+			c.assignments.put(key, assignments, ttl)
+		}
+	}
+	return assignments, nil
+}
+
+// federationScope returns the scope of the active contract from orgID to target; nil when there is none
+func federationScope(ctx context.Context, orgID, target string) (json.RawMessage, error) {
+	c := getVerifyCache()
+	key := orgID + "|" + target
+	if c != nil {
+		if scope, ok := c.contracts.get(key); ok {
+			RecordCacheHit("verify", "federation")
+			return scope, nil
+		}
+		RecordCacheMiss("verify", "federation")
+	}
+	var scope json.RawMessage
+	err := database.DB.GetContext(ctx, &scope, `SELECT scope FROM federation_contracts WHERE org_id=$1 AND counterparty_org_id=$2 AND active=true ORDER BY created_at DESC LIMIT 1`, orgID, target)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if c != nil {
+		ttl := c.ttl
+		if len(scope) == 0 {
+			ttl = c.negTTL
+		}
+		if ttl > 0 {
+			c.contracts.put(key, scope, ttl)
+		}
+	}
+	return scope, nil
+}
+
+// decisionCacheKey identifies a verify decision; the policy set pins the exact versions evaluated
+func decisionCacheKey(orgID, agentStr, targetOrgID string, assignments []policy.ApplicableAssignment, canonCtx json.RawMessage) (string, []uuid.UUID) {
+	refs := make([]string, 0, len(assignments))
+	ids := make([]uuid.UUID, 0, len(assignments))
+	for _, a := range assignments {
+		refs = append(refs, a.Version.PolicyID.String()+"@"+strconv.Itoa(a.Version.Version))
+		ids = append(ids, a.Version.PolicyID)
+	}
+	return policy.DecisionKey(orgID, agentStr, targetOrgID, strings.Join(refs, ","), string(canonCtx)), ids
+}
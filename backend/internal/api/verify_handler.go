@@ -19,7 +19,6 @@ import (
 	"go.opentelemetry.io/otel/codes"
 
 	database "github.com/Armour007/aura-backend/internal"
-	"github.com/Armour007/aura-backend/internal/engine"
 	"github.com/Armour007/aura-backend/internal/utils"
 )
 
@@ -79,7 +78,7 @@ func HandleVerifyRequest(c *gin.Context) {
 	var reason string
 	{ // span for evaluation
 		_, evspan := otel.Tracer("aura-backend").Start(ctx, "engine.evaluate")
-		allowed, reason = engine.Evaluate(req.AgentID, req.RequestContext)
+		allowed, reason = evaluateV1(ctx, orgID, req.AgentID, req.RequestContext)
 		evspan.SetAttributes(attribute.String("reason", reason))
 		evspan.End()
 	}
@@ -6,13 +6,16 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	database "github.com/Armour007/aura-backend/internal"
@@ -40,13 +43,32 @@ type VerifyV2Request struct {
 	RequestContext    json.RawMessage `json:"request_context"`
 	TargetOrgID       string          `json:"target_org_id,omitempty"`
 	IncludeTrustToken bool            `json:"include_trust_token,omitempty"`
+	// ApprovalID redeems an approved approval request for this decision (one-time, same agent and input)
+	ApprovalID string `json:"approval_id,omitempty"`
+	// Consistency of the relationship check, e.g. at least as fresh as the caller's last tuple write
+	Consistency rel.Consistency `json:"consistency,omitempty"`
+}
+
+// validate rejects requests naming an org that cannot exist, before any lookup is made (and cached) for it
+func (r VerifyV2Request) validate() error {
+	if r.TargetOrgID != "" {
+		if _, err := uuid.Parse(r.TargetOrgID); err != nil {
+			return errors.New("target_org_id must be a UUID")
+		}
+	}
+	return nil
 }
 
 type VerifyV2Response struct {
-	Allow   bool   `json:"allow"`
-	Reason  string `json:"reason,omitempty"`
-	TraceID string `json:"trace_id,omitempty"`
-	Token   string `json:"token,omitempty"`
+	Allow       bool                `json:"allow"`
+	Reason      string              `json:"reason,omitempty"`
+	TraceID     string              `json:"trace_id,omitempty"`
+	Token       string              `json:"token,omitempty"`
+	Obligations []policy.Obligation `json:"obligations,omitempty"`
+	Advice      []policy.Obligation `json:"advice,omitempty"`
+	// RequireApproval is set when the decision can be approved; open a request with POST /v2/approvals
+	RequireApproval bool     `json:"require_approval,omitempty"`
+	Hints           []string `json:"hints,omitempty"`
 }
 
 // Risk tracker singleton for prototype
@@ -80,6 +102,21 @@ func mergeSignals(input json.RawMessage, sig risk.Signals) json.RawMessage {
 	return b
 }
 
+// caveatContext is the context relationship caveats are evaluated with. The server's facts come first:
+// risk is the agent's runtime risk signals and "now" is set by the graph. Request context fields only add
+// to them, so a caller cannot satisfy a caveat on its own delegation by sending its own now or risk.
+func caveatContext(input json.RawMessage, sig risk.Signals) map[string]any {
+	out := map[string]any{"risk": map[string]any{"score": sig.Score, "flags": sig.Flags}}
+	var m map[string]any
+	_ = json.Unmarshal(input, &m)
+	for k, v := range m {
+		if _, ok := out[k]; !ok && k != "now" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
 func HandleVerifyV2(c *gin.Context) {
 	// Backpressure: simple inflight limiter
 	if !acquireVerifySlot() {
@@ -92,40 +129,90 @@ func HandleVerifyV2(c *gin.Context) {
 	ctx, span := otel.Tracer("aura-backend").Start(c.Request.Context(), "verify")
 	defer span.End()
 
-	orgID := c.GetString("orgID")
 	var req VerifyV2Request
 	if err := c.ShouldBindJSON(&req); err != nil {
 		span.SetStatus(codes.Error, "bad_request")
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Principal (prototype): from headers or fallback to provided agent
-	pr := attest.FromRequest(c.Request, orgID, req.AgentID.String())
-
-	// Policy selection: support multiple assignments with deterministic bucketing
-	assignCtx, assignSpan := otel.Tracer("aura-backend").Start(ctx, "db.get_active_assignments")
-	assignments, err := polrepo.GetActiveAssignmentsForOrg(assignCtx, uuid.MustParse(orgID))
-	assignSpan.End()
+	if err := req.validate(); err != nil {
+		span.SetStatus(codes.Error, "bad_request")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	vr := newVerifier(c)
+	res, err := vr.verify(ctx, req)
 	if err != nil {
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "db_error_assignments")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if len(assignments) == 0 {
-		c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "No active policy assignment"})
-		return
+	if res.trace != nil {
+		go persistDecisionTraces(context.WithoutCancel(ctx), vr.orgID, []decisionTraceRow{*res.trace})
+	}
+	c.JSON(http.StatusOK, res.resp)
+}
+
+// verifier evaluates verify requests for one caller. Lookups that only depend on the org, agent or
+// target org are memoized, so a batch resolves each of them once however many items share it.
+type verifier struct {
+	orgID    string
+	agentID  string // authenticated agent, used when a request omits agent_id
+	authKind string
+	httpReq  *http.Request
+
+	assignments memo[[]polrepo.ApplicableAssignment]
+	contracts   memo[json.RawMessage]
+	delegations memo[rel.CheckResult]
+	alg         memo[string]
+}
+
+func newVerifier(c *gin.Context) *verifier {
+	return &verifier{orgID: c.GetString("orgID"), agentID: c.GetString("agentID"), authKind: c.GetString("authKind"), httpReq: c.Request}
+}
+
+// verifyResult is the response for one request plus the trace row to persist (nil when no policy ran)
+type verifyResult struct {
+	resp  VerifyV2Response
+	trace *decisionTraceRow
+}
+
+type decisionTraceRow struct {
+	OrgID         string          `db:"org_id"`
+	TraceID       string          `db:"trace_id"`
+	PolicyID      uuid.UUID       `db:"policy_id"`
+	PolicyVersion int             `db:"policy_version"`
+	AgentID       *uuid.UUID      `db:"agent_id"`
+	Allow         bool            `db:"allow"`
+	Reason        string          `db:"reason"`
+	Trace         json.RawMessage `db:"trace"`
+}
+
+// verify runs the federation, delegation and policy checks for one request. The error is only set when
+// the applicable assignments cannot be loaded; every other failure is a deny.
+func (vr *verifier) verify(ctx context.Context, req VerifyV2Request) (verifyResult, error) {
+	orgID := vr.orgID
+	auditCtx := context.WithoutCancel(ctx)
+
+	// Principal (prototype): from headers or fallback to provided agent
+	pr := attest.FromRequest(vr.httpReq, orgID, req.AgentID.String())
+
+	// Policy selection: every active assignment applicable to the agent (org, team and agent scope)
+	agentStr := req.AgentID.String()
+	if agentStr == uuid.Nil.String() {
+		agentStr = vr.agentID
 	}
-	// Choose which policy to evaluate using a stable bucket on (org, agent, action, resource)
-	agentStrForSel := req.AgentID.String()
-	if agentStrForSel == uuid.Nil.String() {
-		agentStrForSel = c.GetString("agentID")
+	// Canary rollouts are applied per policy, bucketing the agent deterministically into active rollouts
+	assignments, err := vr.assignments.do(agentStr, func() ([]polrepo.ApplicableAssignment, error) {
+		return resolveAssignments(ctx, orgID, agentStr)
+	})
+	if err != nil {
+		return verifyResult{}, err
+	}
+	if len(assignments) == 0 {
+		return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "No active policy assignment"}}, nil
 	}
-	selKey := orgID + "|" + agentStrForSel + "|" + req.Action + "|" + req.Resource
-	idx := bucket(selKey, "") % len(assignments)
-	p := &assignments[idx].Policy
-	v := &assignments[idx].Version
 
 	// Federation boundary, contract scope enforcement, and relationship checks
 	// If TargetOrgID is set and differs from caller org, require an active federation contract and enforce scope
@@ -134,85 +221,81 @@ func HandleVerifyV2(c *gin.Context) {
 		AllowedResources []string `json:"allowed_resources"`
 	}
 	if req.TargetOrgID != "" && req.TargetOrgID != orgID {
-		var scope json.RawMessage
-		if err := database.DB.Get(&scope, `SELECT scope FROM federation_contracts WHERE org_id=$1 AND counterparty_org_id=$2 AND active=true ORDER BY created_at DESC LIMIT 1`, orgID, req.TargetOrgID); err != nil || len(scope) == 0 {
-			_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "federation_boundary_crossing", gin.H{"to_org_id": req.TargetOrgID, "allowed": false, "reason": "no_contract"}, nil, nil)
-			c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "No federation contract"})
-			return
+		scope, err := vr.contracts.do(req.TargetOrgID, func() (json.RawMessage, error) {
+			return federationScope(ctx, orgID, req.TargetOrgID)
+		})
+		if err != nil || len(scope) == 0 {
+			_ = audit.Append(auditCtx, uuid.MustParse(orgID), "federation_boundary_crossing", gin.H{"to_org_id": req.TargetOrgID, "allowed": false, "reason": "no_contract"}, nil, nil)
+			return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "No federation contract"}}, nil
 		}
 		_ = json.Unmarshal(scope, &fedScope)
 		// Enforce action scope if provided
 		if req.Action != "" && len(fedScope.AllowedActions) > 0 && !matchesAllowed(req.Action, fedScope.AllowedActions) {
-			_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "federation_scope_denied", gin.H{"to_org_id": req.TargetOrgID, "reason": "action_not_allowed", "action": req.Action}, nil, nil)
-			c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "Federation: action not allowed by contract"})
-			return
+			_ = audit.Append(auditCtx, uuid.MustParse(orgID), "federation_scope_denied", gin.H{"to_org_id": req.TargetOrgID, "reason": "action_not_allowed", "action": req.Action}, nil, nil)
+			return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "Federation: action not allowed by contract"}}, nil
 		}
 		// Enforce resource scope if provided
 		if req.Resource != "" && len(fedScope.AllowedResources) > 0 && !matchesAllowed(req.Resource, fedScope.AllowedResources) {
-			_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "federation_scope_denied", gin.H{"to_org_id": req.TargetOrgID, "reason": "resource_not_allowed", "resource": req.Resource}, nil, nil)
-			c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "Federation: resource not allowed by contract"})
-			return
+			_ = audit.Append(auditCtx, uuid.MustParse(orgID), "federation_scope_denied", gin.H{"to_org_id": req.TargetOrgID, "reason": "resource_not_allowed", "resource": req.Resource}, nil, nil)
+			return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "Federation: resource not allowed by contract"}}, nil
 		}
-		_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "federation_boundary_crossing", gin.H{"to_org_id": req.TargetOrgID, "allowed": true}, nil, nil)
+		_ = audit.Append(auditCtx, uuid.MustParse(orgID), "federation_boundary_crossing", gin.H{"to_org_id": req.TargetOrgID, "allowed": true}, nil, nil)
 		// Zero trust cross-org: require attestation auth if enabled
 		if os.Getenv("AURA_ZERO_TRUST_CROSS_ORG") == "1" {
-			if kind := c.GetString("authKind"); kind != "attest" {
-				c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "Cross-org requires attestation auth"})
-				return
+			if vr.authKind != "attest" {
+				return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "Cross-org requires attestation auth"}}, nil
 			}
 		}
 	}
 
-	// Relationship check via Graph: if provided resource, example gate: agent can_act_for org (or target org when set)
+	// Record risk hit and compute runtime signals
+	signals := getRiskTracker().Get(orgID, agentStr, time.Now())
+	// caveats on delegation tuples and rel clauses (e.g. an expiry window or a risk bound) are evaluated
+	// with the server's facts, which the request context can add to but not override
+	caveatCtx := caveatContext(req.RequestContext, signals)
+
+	// Relationship check via Graph: if provided resource, example gate: agent can_act_for org (or target org when set).
+	// The check is explained so the trace records the path that granted it, or what a denial read.
+	var graphTrace *policy.GraphTrace
 	if req.Resource != "" {
 		relOrg := orgID
 		if req.TargetOrgID != "" {
 			relOrg = req.TargetOrgID
 		}
-		gctx, gspan := otel.Tracer("aura-backend").Start(ctx, "graph.check")
-		allowed, _, err := getGraph().Check(gctx,
-			rel.RelationRef{Namespace: "agent", ObjectID: pr.AgentID},
-			"can_act_for",
-			rel.RelationRef{Namespace: "org", ObjectID: relOrg},
-		)
-		if err != nil || !allowed {
+		cb, _ := json.Marshal(caveatCtx)
+		ctxSum := sha256.Sum256(utils.CanonicalizeJSON(cb))
+		if err := req.Consistency.Validate(); err != nil {
+			return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "Invalid consistency: " + err.Error()}}, nil
+		}
+		cons := req.Consistency
+		key := pr.AgentID + "|" + relOrg + "|" + base64.RawURLEncoding.EncodeToString(ctxSum[:8]) + "|" + cons.AtLeastAsFresh + "|" + strconv.FormatBool(cons.FullyConsistent)
+		res, err := vr.delegations.do(key, func() (rel.CheckResult, error) {
+			gctx, gspan := otel.Tracer("aura-backend").Start(ctx, "graph.check")
+			defer gspan.End()
+			res, err := getGraph().Check(rel.WithExplain(rel.WithConsistency(rel.WithOrg(gctx, relOrg), cons)),
+				rel.RelationRef{Namespace: "agent", ObjectID: pr.AgentID},
+				"can_act_for",
+				rel.RelationRef{Namespace: "org", ObjectID: relOrg},
+				caveatCtx,
+			)
 			if err != nil {
 				gspan.RecordError(err)
 			}
-			gspan.End()
-			c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "No delegation to act for org"})
-			return
+			gspan.SetAttributes(attribute.String("graph.permissionship", string(res.Permissionship)))
+			return res, err
+		})
+		if errors.Is(err, rel.ErrRevisionUnavailable) || errors.Is(err, rel.ErrInvalidToken) {
+			return verifyResult{resp: VerifyV2Response{Allow: false, Reason: "Relationship check: " + err.Error()}}, nil
 		}
-		gspan.End()
-	}
-
-	// Canary rollout selection: if a rollout is active for this policy, bucket the agent deterministically
-	var rollout struct {
-		Version int `db:"version"`
-		Percent int `db:"percent"`
-	}
-	_, rollSpan := otel.Tracer("aura-backend").Start(ctx, "db.get_policy_rollout")
-	if err := database.DB.Get(&rollout, `SELECT version, percent FROM policy_rollouts WHERE org_id=$1 AND policy_id=$2 AND active=true ORDER BY created_at DESC LIMIT 1`, orgID, p.ID); err == nil && rollout.Percent > 0 {
-		// Determine agent string for bucketing
-		agentStr := req.AgentID.String()
-		if agentStr == uuid.Nil.String() {
-			agentStr = c.GetString("agentID")
+		graphTrace = delegationTrace(pr.AgentID, relOrg, res, err)
+		if err == nil && res.Permissionship == rel.PermissionConditional {
+			return graphDenial(orgID, req.AgentID, pr, "Delegation requires request context: "+strings.Join(res.MissingContext, ", "), graphTrace), nil
 		}
-		if bucket(orgID, agentStr, p.ID.String()) < rollout.Percent {
-			// switch to rollout version
-			if rv, err := polrepo.GetVersion(c.Request.Context(), p.ID, rollout.Version); err == nil {
-				v = &rv
-			}
+		if err != nil || !res.Allowed() {
+			return graphDenial(orgID, req.AgentID, pr, "No delegation to act for org", graphTrace), nil
 		}
 	}
-	rollSpan.End()
 
-	// Record risk hit and compute runtime signals
-	agentStr := req.AgentID.String()
-	if agentStr == uuid.Nil.String() {
-		agentStr = c.GetString("agentID")
-	}
-	signals := getRiskTracker().Get(orgID, agentStr, time.Now())
 	mergedCtx := mergeSignals(req.RequestContext, signals)
 	// Inject federation scope and counterparty for policy evaluation when applicable
 	if req.TargetOrgID != "" && req.TargetOrgID != orgID {
@@ -234,51 +317,76 @@ func HandleVerifyV2(c *gin.Context) {
 		}
 	}
 
-	// compile policy version body and evaluate
-	e := evalRegistry[p.EngineType]
-	if e == nil {
-		c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: "Unsupported engine"})
-		return
-	}
-	var comp policy.CompiledPolicy
-	if cpc, ok := policy.GetCompiled(v.PolicyID, v.Version); ok {
-		comp = cpc
-	} else {
-		_, compSpan := otel.Tracer("aura-backend").Start(ctx, "policy.compile")
-		cp, err := e.Compile(v.Body)
-		compSpan.End()
-		if err != nil {
-			span.RecordError(err)
-			span.SetStatus(codes.Error, "compile_error")
-			c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: err.Error()})
-			return
-		}
-		policy.PutCompiled(v.PolicyID, v.Version, cp)
-		comp = cp
-	}
+	// The verified principal and the requested action and resource are part of the policy input, so rules
+	// and their rel clauses can refer to principal.agent_id, action and resource
+	mergedCtx = withRequestFacts(mergedCtx, orgID, agentStr, req.Action, req.Resource)
+
 	// Canonicalize context for stable token hashing; use canonicalized for eval too to keep parity
 	canonCtx := utils.CanonicalizeJSON(mergedCtx)
-	_, evalSpan := otel.Tracer("aura-backend").Start(ctx, "policy.evaluate")
-	dec, err := e.Evaluate(comp, canonCtx)
-	evalSpan.End()
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "eval_error")
-		c.JSON(http.StatusOK, VerifyV2Response{Allow: false, Reason: err.Error()})
-		return
+	var dec policy.Decision
+	v := &assignments[0].Version
+	vc := getVerifyCache()
+	var cacheKey string
+	var cachePolicies []uuid.UUID
+	cached := false
+	if vc != nil {
+		cacheKey, cachePolicies = decisionCacheKey(orgID, agentStr, req.TargetOrgID, assignments, canonCtx)
+		if hit, ok := vc.decisions.Get(cacheKey); ok {
+			RecordCacheHit("verify", "decision")
+			dec = hit.Decision
+			if dec.Trace != nil {
+				tr := *dec.Trace
+				tr.Cached = true
+				dec.Trace = &tr
+			}
+			v = &database.PolicyVersion{PolicyID: hit.PolicyID, Version: hit.PolicyVersion}
+			cached = true
+		} else {
+			RecordCacheMiss("verify", "decision")
+		}
+	}
+	alg := func() string {
+		alg, _ := vr.alg.do("", func() (string, error) {
+			return polrepo.GetCombiningAlg(ctx, uuid.MustParse(orgID)), nil
+		})
+		return alg
+	}
+	// rel clauses check the caller's graph, with the same caveat context as the delegation
+	relations := policyRelations(orgID, caveatCtx, req.Consistency)
+	if !cached {
+		_, evalSpan := otel.Tracer("aura-backend").Start(ctx, "policy.evaluate")
+		var decisive int
+		ectx := policy.WithRelations(ctx, relations)
+		dec, decisive = evaluateAssignments(ectx, alg(), assignments, canonCtx)
+		evalSpan.End()
+		if decisive >= 0 {
+			v = &assignments[decisive].Version
+		}
+		// decisions that read the graph are not cached, as tuple writes do not invalidate them
+		if vc != nil && policy.RelationsChecked(ectx) == 0 {
+			vc.decisions.Put(cacheKey, policy.CachedDecision{Decision: dec, PolicyID: v.PolicyID, PolicyVersion: v.Version}, cachePolicies)
+		}
 	}
 
-	// Enrich trace with policy and principal context
+	// Enrich trace with principal context and the relationship check (policy ids are recorded by the combiner);
+	// the trace is copied as the decision cache shares it
 	if dec.Trace != nil {
-		dec.Trace.PolicyID = v.PolicyID
-		dec.Trace.PolicyVersion = v.Version
-		dec.Trace.Principal = &policy.PrincipalTrace{
-			OrgID:           pr.OrgID,
-			AgentID:         pr.AgentID,
-			SPIFFEID:        pr.SPIFFEID,
-			AuthnKind:       pr.AuthnKind,
-			CertFingerprint: pr.CertFingerprint,
-		}
+		tr := *dec.Trace
+		tr.Principal = principalTrace(pr)
+		tr.Graph = graphTrace
+		dec.Trace = &tr
+	}
+
+	// An approved approval request for this agent and input turns require_approval into a one-time allow
+	var agentRef *uuid.UUID
+	if req.AgentID != uuid.Nil {
+		agentID := req.AgentID
+		agentRef = &agentID
+	}
+	// Candidate versions in shadow are compared with the policies' decision, before any approval redemption
+	shadowDecision(ctx, shadowRun{orgID: uuid.MustParse(orgID), agentID: agentRef, alg: alg, assignments: assignments, input: canonCtx, relations: relations, live: dec})
+	if req.ApprovalID != "" && !dec.Allow && dec.RequireApproval {
+		dec = redeemApproval(ctx, orgID, req.ApprovalID, agentRef, canonCtx, dec)
 	}
 
 	RecordDecision(map[bool]string{true: "ALLOWED", false: "DENIED"}[dec.Allow], orgID)
@@ -305,36 +413,241 @@ func HandleVerifyV2(c *gin.Context) {
 			}
 		}
 	}()
-	// persist decision trace for retrieval
-	go func() {
-		var traceJSON json.RawMessage
-		if dec.Trace != nil {
-			if b, err := json.Marshal(dec.Trace); err == nil {
-				traceJSON = b
-			}
-		}
-		var agentPtr *uuid.UUID
-		if req.AgentID != uuid.Nil {
-			agentPtr = &req.AgentID
-		}
-		_, _ = database.DB.Exec(`INSERT INTO decision_traces (org_id, trace_id, policy_id, policy_version, agent_id, allow, reason, trace) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
-			orgID, dec.TraceID, v.PolicyID, v.Version, agentPtr, dec.Allow, dec.Reason, traceJSON)
-		// Append audit event referencing this trace for compliance replay
-		_ = audit.Append(c.Request.Context(), uuid.MustParse(orgID), "decision_trace_recorded", map[string]any{"trace_id": dec.TraceID, "policy_id": v.PolicyID, "version": v.Version, "allow": dec.Allow, "reason": dec.Reason}, nil, agentPtr)
-	}()
 
-	// minimal event side-effect placeholder
-	_ = time.Now()
+	// decision trace row, persisted by the caller
+	row := &decisionTraceRow{OrgID: orgID, TraceID: dec.TraceID, PolicyID: v.PolicyID, PolicyVersion: v.Version, Allow: dec.Allow, Reason: dec.Reason}
+	if dec.Trace != nil {
+		if b, err := json.Marshal(dec.Trace); err == nil {
+			row.Trace = b
+		}
+	}
+	row.AgentID = agentRef
 
-	// Optional trust token
-	resp := VerifyV2Response{Allow: dec.Allow, Reason: dec.Reason, TraceID: dec.TraceID}
-	if req.IncludeTrustToken {
-		token := buildTrustToken(ctx, orgID, pr.AgentID, v.PolicyID.String(), v.Version, dec.Allow, dec.Reason, canonCtx, dec.TraceID)
+	// Optional trust token; a redeemed approval always gets one
+	resp := VerifyV2Response{Allow: dec.Allow, Reason: dec.Reason, TraceID: dec.TraceID, Obligations: dec.Obligations, Advice: dec.Advice, RequireApproval: !dec.Allow && dec.RequireApproval, Hints: dec.Hints}
+	if req.IncludeTrustToken || (dec.Trace != nil && dec.Trace.ApprovalID != "") {
+		token := buildTrustToken(ctx, orgID, pr.AgentID, v.PolicyID.String(), v.Version, dec.Allow, dec.Reason, canonCtx, dec.TraceID, dec.Obligations)
 		if token != "" {
 			resp.Token = token
 		}
 	}
-	c.JSON(http.StatusOK, resp)
+	return verifyResult{resp: resp, trace: row}, nil
+}
+
+func principalTrace(pr attest.Principal) *policy.PrincipalTrace {
+	return &policy.PrincipalTrace{
+		OrgID:           pr.OrgID,
+		AgentID:         pr.AgentID,
+		SPIFFEID:        pr.SPIFFEID,
+		AuthnKind:       pr.AuthnKind,
+		CertFingerprint: pr.CertFingerprint,
+	}
+}
+
+// withRequestFacts sets principal.agent_id and principal.org_id (keeping other principal fields the caller
+// sent), and action and resource when the request names them, in the policy input
+func withRequestFacts(input json.RawMessage, orgID, agentID, action, resource string) json.RawMessage {
+	m := map[string]any{}
+	if len(input) > 0 {
+		if err := json.Unmarshal(input, &m); err != nil {
+			m = map[string]any{"_": string(input)}
+		}
+	}
+	principal, _ := m["principal"].(map[string]any)
+	if principal == nil {
+		principal = map[string]any{}
+	}
+	principal["org_id"] = orgID
+	if agentID != "" && agentID != uuid.Nil.String() {
+		principal["agent_id"] = agentID
+	}
+	m["principal"] = principal
+	if action != "" {
+		m["action"] = action
+	}
+	if resource != "" {
+		m["resource"] = resource
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return input
+	}
+	return b
+}
+
+// policyRelations answers the rel checks of policies evaluated for org. Subjects and objects are type:id;
+// checks are explained so the rule trace carries their proof.
+func policyRelations(org string, caveatCtx map[string]any, cons rel.Consistency) policy.RelationChecker {
+	return func(ctx context.Context, subject, relation, object string) (policy.GraphTrace, error) {
+		s, ok := parseRelationRef(subject)
+		o, ok2 := parseRelationRef(object)
+		if !ok || !ok2 {
+			return policy.GraphTrace{}, fmt.Errorf("%w: subject and object must be type:id", rel.ErrInvalidTuple)
+		}
+		gctx, gspan := otel.Tracer("aura-backend").Start(ctx, "graph.check")
+		defer gspan.End()
+		res, err := getGraph().Check(rel.WithExplain(rel.WithConsistency(rel.WithOrg(gctx, org), cons)), s, relation, o, caveatCtx)
+		if err != nil {
+			gspan.RecordError(err)
+		}
+		gspan.SetAttributes(attribute.String("graph.permissionship", string(res.Permissionship)))
+		return *graphTrace(subject, relation, object, res, err), err
+	}
+}
+
+// delegationTrace records the can_act_for check of agent on org with its explanation
+func delegationTrace(agentID, org string, res rel.CheckResult, err error) *policy.GraphTrace {
+	return graphTrace("agent:"+agentID, "can_act_for", "org:"+org, res, err)
+}
+
+// graphTrace records a relationship check with its explanation
+func graphTrace(subject, relation, object string, res rel.CheckResult, err error) *policy.GraphTrace {
+	gt := &policy.GraphTrace{Subject: subject, Relation: relation, Object: object, Permissionship: string(res.Permissionship), MissingContext: res.MissingContext, Source: res.Source}
+	if err != nil {
+		gt.Error = err.Error()
+	}
+	if res.Explanation != nil {
+		gt.Explanation, _ = json.Marshal(res.Explanation)
+	}
+	return gt
+}
+
+// graphDenial denies a request whose delegation check failed. No policy ran, so the trace only records the
+// principal and the check; identical denials share a trace id.
+func graphDenial(orgID string, agentID uuid.UUID, pr attest.Principal, reason string, gt *policy.GraphTrace) verifyResult {
+	tr := &policy.Trace{EvaluatedRules: []policy.RuleTrace{}, At: time.Now().UTC(), Engine: "graph", Principal: principalTrace(pr), Graph: gt}
+	b, _ := json.Marshal(gt)
+	h := sha256.Sum256(append([]byte(orgID+"|"+reason+"|"), b...))
+	row := &decisionTraceRow{OrgID: orgID, TraceID: hex.EncodeToString(h[:8]), Reason: reason}
+	if agentID != uuid.Nil {
+		row.AgentID = &agentID
+	}
+	row.Trace, _ = json.Marshal(tr)
+	return verifyResult{resp: VerifyV2Response{Allow: false, Reason: reason, TraceID: row.TraceID}, trace: row}
+}
+
+// persistDecisionTraces stores decision traces in one statement (identical decisions share a trace id and
+// are stored once) and appends an audit event per trace referencing it for compliance replay
+func persistDecisionTraces(ctx context.Context, orgID string, rows []decisionTraceRow) {
+	if len(rows) == 0 {
+		return
+	}
+	_, _ = database.DB.NamedExecContext(ctx, `INSERT INTO decision_traces (org_id, trace_id, policy_id, policy_version, agent_id, allow, reason, trace)
+		VALUES (:org_id, :trace_id, :policy_id, :policy_version, :agent_id, :allow, :reason, :trace) ON CONFLICT (trace_id) DO NOTHING`, rows)
+	for _, r := range rows {
+		_ = audit.Append(ctx, uuid.MustParse(orgID), "decision_trace_recorded", map[string]any{"trace_id": r.TraceID, "policy_id": r.PolicyID, "version": r.PolicyVersion, "allow": r.Allow, "reason": r.Reason}, nil, r.AgentID)
+	}
+}
+
+// memo computes a value once per key; concurrent callers for the same key wait for the first
+type memo[T any] struct {
+	mu    sync.Mutex
+	cells map[string]*memoCell[T]
+}
+
+type memoCell[T any] struct {
+	once sync.Once
+	val  T
+	err  error
+}
+
+func (m *memo[T]) do(key string, f func() (T, error)) (T, error) {
+	m.mu.Lock()
+	if m.cells == nil {
+		m.cells = map[string]*memoCell[T]{}
+	}
+	cell, ok := m.cells[key]
+	if !ok {
+		cell = &memoCell[T]{}
+		m.cells[key] = cell
+	}
+	m.mu.Unlock()
+	cell.once.Do(func() { cell.val, cell.err = f() })
+	return cell.val, cell.err
+}
+
+// applyRollouts swaps in the canary version for each policy with an active rollout the agent is bucketed into
+func applyRollouts(ctx context.Context, orgID, agentStr string, assignments []polrepo.ApplicableAssignment) []polrepo.ApplicableAssignment {
+	var rollouts []struct {
+		PolicyID uuid.UUID `db:"policy_id"`
+		Version  int       `db:"version"`
+		Percent  int       `db:"percent"`
+	}
+	if err := database.DB.SelectContext(ctx, &rollouts, `SELECT DISTINCT ON (policy_id) policy_id, version, percent FROM policy_rollouts WHERE org_id=$1 AND active=true ORDER BY policy_id, created_at DESC`, orgID); err != nil || len(rollouts) == 0 {
+		return assignments
+	}
+	for _, r := range rollouts {
+		if r.Percent <= 0 || bucket(orgID, agentStr, r.PolicyID.String()) >= r.Percent {
+			continue
+		}
+		for i := range assignments {
+			if assignments[i].Policy.ID != r.PolicyID {
+				continue
+			}
+			// switch to rollout version
+			if rv, err := polrepo.GetVersion(ctx, r.PolicyID, r.Version); err == nil {
+				assignments[i].Version = rv
+			}
+		}
+	}
+	return assignments
+}
+
+// evaluateAssignments compiles (via the shared cache) and evaluates every applicable policy version with
+// the trust graph of ctx (see policy.WithRelations), then merges the results with the combining algorithm. Policies that fail to compile or evaluate count as deny.
+// Returns the combined decision and the index of the decisive assignment (-1 when none was decisive).
+func evaluateAssignments(ctx context.Context, alg string, assignments []polrepo.ApplicableAssignment, input json.RawMessage) (policy.Decision, int) {
+	results := make([]policy.PolicyResult, 0, len(assignments))
+	for _, a := range assignments {
+		res := policy.PolicyResult{PolicyID: a.Version.PolicyID, Version: a.Version.Version, ScopeType: a.ScopeType, ScopeID: a.ScopeID}
+		e := evalRegistry[a.Policy.EngineType]
+		if e == nil {
+			res.Decision = policy.Decision{Allow: false, Reason: "Unsupported engine"}
+			results = append(results, res)
+			continue
+		}
+		comp, err := compiledFor(ctx, e, a.Policy.OrgID, a.Version)
+		if err != nil {
+			res.Decision = policy.Decision{Allow: false, Reason: err.Error()}
+			results = append(results, res)
+			continue
+		}
+		dec, err := policy.EvaluateWithContext(ctx, e, comp, input)
+		if err != nil {
+			dec = policy.Decision{Allow: false, Reason: err.Error()}
+		}
+		res.Decision = dec
+		results = append(results, res)
+	}
+	return policy.Combine(alg, results)
+}
+
+// compiledFor returns the cached compiled form of a policy version, compiling and caching it on a miss
+func compiledFor(ctx context.Context, e policy.Evaluator, orgID uuid.UUID, v database.PolicyVersion) (policy.CompiledPolicy, error) {
+	if comp, ok := policy.GetCompiled(v.PolicyID, v.Version); ok {
+		return comp, nil
+	}
+	compCtx, compSpan := otel.Tracer("aura-backend").Start(ctx, "policy.compile")
+	cp, err := compileForOrg(compCtx, e, orgID, v.Body)
+	compSpan.End()
+	if err != nil {
+		return nil, err
+	}
+	policy.PutCompiled(v.PolicyID, v.Version, cp)
+	return cp, nil
+}
+
+// compileForOrg compiles a policy body, loading the org's Rego data documents for engines that accept them
+func compileForOrg(ctx context.Context, e policy.Evaluator, orgID uuid.UUID, body json.RawMessage) (policy.CompiledPolicy, error) {
+	dc, ok := e.(policy.DataCompiler)
+	if !ok || orgID == uuid.Nil {
+		return e.Compile(body)
+	}
+	data, err := polrepo.GetRegoDataDocument(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return dc.CompileWithData(body, data)
 }
 
 // bucket returns a deterministic 0-99 value for canary/selection
@@ -352,8 +665,9 @@ func bucket(a, b string, rest ...string) int {
 	return v % 100
 }
 
-// buildTrustToken signs a short-lived JWT with decision details and a hash of the evaluated context
-func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, version int, allow bool, reason string, reqCtx json.RawMessage, traceID string) string {
+// buildTrustToken signs a short-lived JWT with decision details and a hash of the evaluated context.
+// Obligations, when present, are embedded so downstream enforcers can trust them.
+func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, version int, allow bool, reason string, reqCtx json.RawMessage, traceID string, obligations []policy.Obligation) string {
 	// Observability
 	tr := otel.Tracer("aura")
 	ctx, span := tr.Start(ctx, "trust_token.build")
@@ -368,6 +682,13 @@ func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, versi
 		}
 	}
 	exp := time.Now().Add(time.Duration(ttl) * time.Second).Unix()
+	// optional obligations claim appended to the payload
+	obClaim := ""
+	if len(obligations) > 0 {
+		if b, err := json.Marshal(obligations); err == nil {
+			obClaim = `,"obligations":` + string(b)
+		}
+	}
 	// Prefer org-scoped KMS/local key if configured, else env Ed25519, else fallback to HS256. Include JTI for replay prevention.
 	// Attempt to load active trust key for org
 	var tk struct {
@@ -383,7 +704,7 @@ func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, versi
 	if err := database.DB.Get(&tk, `SELECT alg, COALESCE(kid,''), provider, key_ref, key_version, COALESCE(provider_config,'{}'::jsonb), ed25519_private_key_base64, COALESCE(jwk_pub,'{}'::jsonb) FROM trust_keys WHERE org_id=$1 AND active=true ORDER BY created_at DESC LIMIT 1`, orgID); err == nil && tk.Alg != "" {
 		jti := uuid.New().String()
 		header := fmt.Sprintf(`{"alg":"%s","typ":"JWT","kid":"%s"}`, tk.Alg, tk.Kid)
-		payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti)
+		payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"%s}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti, obClaim)
 		hb := base64.RawURLEncoding.EncodeToString([]byte(header))
 		pb := base64.RawURLEncoding.EncodeToString([]byte(payload))
 		unsigned := hb + "." + pb
@@ -424,7 +745,7 @@ func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, versi
 	if priv, pub, kid := loadEd25519KeyFromEnv(); priv != nil && pub != nil {
 		jti := uuid.New().String()
 		header := fmt.Sprintf(`{"alg":"EdDSA","typ":"JWT","kid":"%s"}`, kid)
-		payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti)
+		payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"%s}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti, obClaim)
 		hb := base64.RawURLEncoding.EncodeToString([]byte(header))
 		pb := base64.RawURLEncoding.EncodeToString([]byte(payload))
 		unsigned := hb + "." + pb
@@ -451,7 +772,7 @@ func buildTrustToken(ctx context.Context, orgID, agentID, policyID string, versi
 	}
 	jti := uuid.New().String()
 	header := `{"alg":"HS256","typ":"JWT"}`
-	payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti)
+	payload := fmt.Sprintf(`{"org_id":"%s","agent_id":"%s","policy_id":"%s","policy_version":%d,"allow":%t,"reason":%q,"context_hash":"%s","trace_id":"%s","exp":%d,"jti":"%s"%s}`, orgID, agentID, policyID, version, allow, reason, ctxHash, traceID, exp, jti, obClaim)
 	hb := base64.RawURLEncoding.EncodeToString([]byte(header))
 	pb := base64.RawURLEncoding.EncodeToString([]byte(payload))
 	unsigned := hb + "." + pb
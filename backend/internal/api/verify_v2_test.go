@@ -1,6 +1,23 @@
 package api
 
-import "testing"
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Armour007/aura-backend/internal/attest"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/rel"
+	"github.com/Armour007/aura-backend/internal/risk"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
 
 func TestBucketDeterministic(t *testing.T) {
 	a1 := bucket("org1", "agent1", "p1")
@@ -30,3 +47,130 @@ func TestMatchesAllowed(t *testing.T) {
 		}
 	}
 }
+
+func TestMemoSharesConcurrentLookups(t *testing.T) {
+	var m memo[int]
+	var calls int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "agent-a"
+			if i%2 == 1 {
+				key = "agent-b"
+			}
+			v, _ := m.do(key, func() (int, error) {
+				atomic.AddInt32(&calls, 1)
+				return len(key), nil
+			})
+			if v != 7 {
+				t.Errorf("unexpected memo value %d", v)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if calls != 2 {
+		t.Fatalf("expected one lookup per key, got %d", calls)
+	}
+}
+
+func TestVerifyBatchRejectsBadSizes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	t.Setenv("AURA_VERIFY_BATCH_MAX", "2")
+	for _, body := range []string{`{"items":[]}`, `{"items":[{},{},{}]}`, `{}`} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v2/verify/batch", strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		HandleVerifyBatch(c)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestVerifyRejectsBadTargetOrg(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for path, body := range map[string]string{
+		"/v2/verify":       `{"target_org_id":"x-1"}`,
+		"/v2/verify/batch": `{"items":[{},{"target_org_id":"x-1"}]}`,
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if path == "/v2/verify" {
+			HandleVerifyV2(c)
+		} else {
+			HandleVerifyBatch(c)
+		}
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "target_org_id") {
+			t.Fatalf("%s: expected 400, got %d %s", path, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestTTLMapBound(t *testing.T) {
+	m := newTTLMap[int](3)
+	m.put("expired", 0, -time.Second)
+	for i := 0; i < 100; i++ {
+		m.put(strconv.Itoa(i), i, time.Minute)
+	}
+	if n := m.len(); n > 3 {
+		t.Fatalf("map holds %d entries, want at most 3", n)
+	}
+	if v, ok := m.get("99"); !ok || v != 99 {
+		t.Fatalf("the latest entry must be kept, got %v %v", v, ok)
+	}
+	m.put("short", 1, -time.Second)
+	if _, ok := m.get("short"); ok || m.len() > 3 {
+		t.Fatalf("expired entries are not served")
+	}
+}
+
+func TestGraphDenialTrace(t *testing.T) {
+	res := rel.CheckResult{Permissionship: rel.PermissionDenied, Source: "local", Explanation: &rel.Explanation{Visited: []string{"org:o1#can_act_for"}}}
+	gt := delegationTrace("a1", "o1", res, nil)
+	if gt.Subject != "agent:a1" || gt.Object != "org:o1" || !strings.Contains(string(gt.Explanation), "org:o1#can_act_for") {
+		t.Fatalf("unexpected graph trace %+v", gt)
+	}
+	orgID := uuid.NewString()
+	a := graphDenial(orgID, uuid.Nil, attest.Principal{OrgID: orgID}, "No delegation to act for org", gt)
+	b := graphDenial(orgID, uuid.Nil, attest.Principal{OrgID: orgID}, "No delegation to act for org", gt)
+	if a.trace == nil || a.resp.Allow || a.resp.TraceID == "" || a.resp.TraceID != b.resp.TraceID {
+		t.Fatalf("expected a shared trace for identical denials, got %+v / %+v", a, b)
+	}
+	var tr policy.Trace
+	if err := json.Unmarshal(a.trace.Trace, &tr); err != nil || tr.Graph == nil || tr.Graph.Permissionship != "denied" {
+		t.Fatalf("trace must record the check: %s %v", a.trace.Trace, err)
+	}
+}
+
+func TestWithRequestFacts(t *testing.T) {
+	in := json.RawMessage(`{"principal":{"agent_id":"spoofed","limit":100},"amount":5}`)
+	var m map[string]any
+	if err := json.Unmarshal(withRequestFacts(in, "o1", "a1", "write", "doc:d1"), &m); err != nil {
+		t.Fatal(err)
+	}
+	p, _ := m["principal"].(map[string]any)
+	if p["agent_id"] != "a1" || p["org_id"] != "o1" || p["limit"] != float64(100) || m["action"] != "write" || m["resource"] != "doc:d1" || m["amount"] != float64(5) {
+		t.Fatalf("unexpected policy input %v", m)
+	}
+	m = nil
+	if err := json.Unmarshal(withRequestFacts(nil, "o1", uuid.Nil.String(), "", ""), &m); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m["resource"]; ok || m["principal"].(map[string]any)["agent_id"] != nil {
+		t.Fatalf("unset facts must stay out of the input: %v", m)
+	}
+}
+
+func TestCaveatContext(t *testing.T) {
+	in := json.RawMessage(`{"now":"2000-01-01T00:00:00Z","risk":{"score":0},"env":"prod"}`)
+	m := caveatContext(in, risk.Signals{Score: 70, Flags: []string{"burst"}})
+	r, _ := m["risk"].(map[string]any)
+	if _, ok := m["now"]; ok || r["score"] != 70 || m["env"] != "prod" {
+		t.Fatalf("request context must not override server facts: %v", m)
+	}
+}
@@ -0,0 +1,342 @@
+// Package approval implements runtime approval requests for require_approval decisions: routing to
+// approver groups, N-of-M quorum, expiry and one-time consumption by a re-verification.
+package approval
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/utils"
+	"github.com/google/uuid"
+)
+
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusDenied   = "denied"
+	StatusExpired  = "expired"
+	StatusConsumed = "consumed"
+
+	VoteApprove = "approve"
+	VoteDeny    = "deny"
+
+	// DefaultGroup receives requests whose policy names no approvers
+	DefaultGroup = "default"
+)
+
+var (
+	ErrNotFound     = errors.New("approval request not found")
+	ErrNotPending   = errors.New("approval request is no longer pending")
+	ErrNotApprover  = errors.New("not an approver for this request")
+	ErrAlreadyVoted = errors.New("already voted on this request")
+	ErrNoApprovers  = errors.New("no approvers configured for this request")
+)
+
+// Group is a named set of approvers (user ids) with the quorum a routed request needs
+type Group struct {
+	OrgID        uuid.UUID       `db:"org_id" json:"org_id"`
+	Name         string          `db:"name" json:"name"`
+	Members      json.RawMessage `db:"members" json:"members"`
+	MinApprovals int             `db:"min_approvals" json:"min_approvals"`
+	UpdatedBy    *uuid.UUID      `db:"updated_by_user_id" json:"updated_by_user_id,omitempty"`
+	UpdatedAt    time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+// MemberIDs decodes Members
+func (g Group) MemberIDs() []uuid.UUID {
+	var ids []uuid.UUID
+	_ = json.Unmarshal(g.Members, &ids)
+	return ids
+}
+
+// Request is an approval request for one decision (trace)
+type Request struct {
+	ID             uuid.UUID       `db:"id" json:"id"`
+	OrgID          uuid.UUID       `db:"org_id" json:"org_id"`
+	TraceID        string          `db:"trace_id" json:"trace_id"`
+	AgentID        *uuid.UUID      `db:"agent_id" json:"agent_id,omitempty"`
+	PolicyID       *uuid.UUID      `db:"policy_id" json:"policy_id,omitempty"`
+	PolicyVersion  *int            `db:"policy_version" json:"policy_version,omitempty"`
+	ContextHash    string          `db:"context_hash" json:"context_hash"`
+	RequestContext json.RawMessage `db:"request_context" json:"request_context,omitempty"`
+	Reason         *string         `db:"reason" json:"reason,omitempty"`
+	Hints          json.RawMessage `db:"hints" json:"hints"`
+	Groups         json.RawMessage `db:"groups" json:"groups"`
+	Required       int             `db:"required" json:"required"`
+	Status         string          `db:"status" json:"status"`
+	ExpiresAt      time.Time       `db:"expires_at" json:"expires_at"`
+	DecidedAt      *time.Time      `db:"decided_at" json:"decided_at,omitempty"`
+	ConsumedAt     *time.Time      `db:"consumed_at" json:"consumed_at,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+	Votes          []Vote          `db:"-" json:"votes,omitempty"`
+}
+
+// GroupNames decodes Groups
+func (r Request) GroupNames() []string {
+	var names []string
+	_ = json.Unmarshal(r.Groups, &names)
+	return names
+}
+
+type Vote struct {
+	ApprovalID uuid.UUID `db:"approval_id" json:"-"`
+	UserID     uuid.UUID `db:"user_id" json:"user_id"`
+	Vote       string    `db:"vote" json:"vote"`
+	Group      *string   `db:"group_name" json:"group,omitempty"`
+	Comment    *string   `db:"comment" json:"comment,omitempty"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+const requestCols = `id, org_id, trace_id, agent_id, policy_id, policy_version, context_hash, request_context, reason, hints, groups, required, status, expires_at, decided_at, consumed_at, created_at`
+
+// Tally derives a pending request's status from its votes: any deny vetoes, required approvals approve
+func Tally(required int, votes []Vote) string {
+	approvals := 0
+	for _, v := range votes {
+		if v.Vote == VoteDeny {
+			return StatusDenied
+		}
+		if v.Vote == VoteApprove {
+			approvals++
+		}
+	}
+	if approvals >= required {
+		return StatusApproved
+	}
+	return StatusPending
+}
+
+// ContextHash identifies the evaluated input an approval is bound to. Runtime risk signals are left out
+// since they change between the original call and the re-verification.
+func ContextHash(input json.RawMessage) string {
+	var m map[string]any
+	if err := json.Unmarshal(input, &m); err == nil && m != nil {
+		delete(m, "risk")
+		input, _ = json.Marshal(m)
+	}
+	sum := sha256.Sum256(utils.CanonicalizeJSON(input))
+	return hex.EncodeToString(sum[:])
+}
+
+// TTL is how long a request stays open and, once approved, usable (AURA_APPROVAL_TTL_SECONDS, default 1h)
+func TTL() time.Duration {
+	if v := os.Getenv("AURA_APPROVAL_TTL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return time.Hour
+}
+
+// PutGroup creates or replaces an approver group
+func PutGroup(ctx context.Context, orgID uuid.UUID, name string, members []uuid.UUID, minApprovals int, updatedBy *uuid.UUID) (Group, error) {
+	if members == nil {
+		members = []uuid.UUID{}
+	}
+	mb, _ := json.Marshal(members)
+	var g Group
+	err := database.DB.QueryRowxContext(ctx, `INSERT INTO approval_groups (org_id,name,members,min_approvals,updated_by_user_id) VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (org_id,name) DO UPDATE SET members=EXCLUDED.members, min_approvals=EXCLUDED.min_approvals, updated_by_user_id=EXCLUDED.updated_by_user_id, updated_at=now()
+		RETURNING org_id, name, members, min_approvals, updated_by_user_id, updated_at`, orgID, name, mb, minApprovals, updatedBy).StructScan(&g)
+	return g, err
+}
+
+// NonMembers returns the ids among users that are not members of the org
+func NonMembers(ctx context.Context, orgID uuid.UUID, users []uuid.UUID) ([]uuid.UUID, error) {
+	out := []uuid.UUID{}
+	if len(users) == 0 {
+		return out, nil
+	}
+	ub, _ := json.Marshal(users)
+	err := database.DB.SelectContext(ctx, &out, `SELECT u::uuid FROM jsonb_array_elements_text($2::jsonb) AS u
+		WHERE NOT EXISTS (SELECT 1 FROM organization_members m WHERE m.organization_id=$1 AND m.user_id=u::uuid)`, orgID, ub)
+	return out, err
+}
+
+func ListGroups(ctx context.Context, orgID uuid.UUID) ([]Group, error) {
+	out := []Group{}
+	err := database.DB.SelectContext(ctx, &out, `SELECT org_id, name, members, min_approvals, updated_by_user_id, updated_at FROM approval_groups WHERE org_id=$1 ORDER BY name`, orgID)
+	return out, err
+}
+
+func DeleteGroup(ctx context.Context, orgID uuid.UUID, name string) (bool, error) {
+	res, err := database.DB.ExecContext(ctx, `DELETE FROM approval_groups WHERE org_id=$1 AND name=$2`, orgID, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Route resolves the groups a request goes to and its quorum: the named groups (unknown names are an
+// error), else the org's default group. The quorum is the largest min_approvals among them.
+func Route(ctx context.Context, orgID uuid.UUID, names []string) ([]string, int, error) {
+	groups, err := ListGroups(ctx, orgID)
+	if err != nil {
+		return nil, 0, err
+	}
+	byName := map[string]Group{}
+	for _, g := range groups {
+		byName[g.Name] = g
+	}
+	if len(names) == 0 {
+		names = []string{DefaultGroup}
+	}
+	required := 1
+	for _, n := range names {
+		g, ok := byName[n]
+		if !ok {
+			return nil, 0, fmt.Errorf("%w: group %q is not defined", ErrNoApprovers, n)
+		}
+		if g.MinApprovals > required {
+			required = g.MinApprovals
+		}
+	}
+	return names, required, nil
+}
+
+// Create opens a request; an existing pending request for the same trace is returned instead
+func Create(ctx context.Context, r Request) (Request, bool, error) {
+	var out Request
+	err := database.DB.QueryRowxContext(ctx, `INSERT INTO approval_requests (org_id, trace_id, agent_id, policy_id, policy_version, context_hash, request_context, reason, hints, groups, required, expires_at)
+		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+		ON CONFLICT (org_id, trace_id) WHERE status='pending' DO NOTHING
+		RETURNING `+requestCols,
+		r.OrgID, r.TraceID, r.AgentID, r.PolicyID, r.PolicyVersion, r.ContextHash, r.RequestContext, r.Reason, r.Hints, r.Groups, r.Required, r.ExpiresAt).StructScan(&out)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = database.DB.GetContext(ctx, &out, `SELECT `+requestCols+` FROM approval_requests WHERE org_id=$1 AND trace_id=$2 AND status='pending'`, r.OrgID, r.TraceID)
+		return out, false, err
+	}
+	return out, err == nil, err
+}
+
+// Get loads a request with its votes. orgID may be uuid.Nil when the caller authorizes by other means.
+func Get(ctx context.Context, orgID, id uuid.UUID) (Request, error) {
+	var r Request
+	err := database.DB.GetContext(ctx, &r, `SELECT `+requestCols+` FROM approval_requests WHERE id=$1 AND ($2::uuid IS NULL OR org_id=$2)`, id, nullableID(orgID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	if err != nil {
+		return r, err
+	}
+	r.Votes = []Vote{}
+	err = database.DB.SelectContext(ctx, &r.Votes, `SELECT approval_id, user_id, vote, group_name, comment, created_at FROM approval_votes WHERE approval_id=$1 ORDER BY created_at`, id)
+	return r, err
+}
+
+// List returns the org's requests, newest first, optionally filtered by status
+func List(ctx context.Context, orgID uuid.UUID, status string, limit int) ([]Request, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	out := []Request{}
+	err := database.DB.SelectContext(ctx, &out, `SELECT `+requestCols+` FROM approval_requests WHERE org_id=$1 AND ($2='' OR status=$2) ORDER BY created_at DESC LIMIT $3`, orgID, status, limit)
+	return out, err
+}
+
+// ExpireDue marks the org's overdue pending or unused approved requests expired and returns their ids
+func ExpireDue(ctx context.Context, orgID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := database.DB.SelectContext(ctx, &ids, `UPDATE approval_requests SET status='expired', decided_at=COALESCE(decided_at, now())
+		WHERE org_id=$1 AND status IN ('pending','approved') AND expires_at <= now() RETURNING id`, orgID)
+	return ids, err
+}
+
+// CastVote records an approver's vote and applies the quorum; it returns the updated request.
+// The caller must have checked that the user may approve (IsApprover).
+func CastVote(ctx context.Context, id, userID uuid.UUID, vote, group, comment string) (Request, error) {
+	tx, err := database.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return Request{}, err
+	}
+	defer tx.Rollback()
+	var r Request
+	if err := tx.GetContext(ctx, &r, `SELECT `+requestCols+` FROM approval_requests WHERE id=$1 FOR UPDATE`, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return r, ErrNotFound
+		}
+		return r, err
+	}
+	if r.Status != StatusPending || !time.Now().Before(r.ExpiresAt) {
+		return r, ErrNotPending
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO approval_votes (approval_id, user_id, vote, group_name, comment) VALUES ($1,$2,$3,NULLIF($4,''),NULLIF($5,'')) ON CONFLICT DO NOTHING`, id, userID, vote, group, comment)
+	if err != nil {
+		return r, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return r, ErrAlreadyVoted
+	}
+	r.Votes = []Vote{}
+	if err := tx.SelectContext(ctx, &r.Votes, `SELECT approval_id, user_id, vote, group_name, comment, created_at FROM approval_votes WHERE approval_id=$1 ORDER BY created_at`, id); err != nil {
+		return r, err
+	}
+	if st := Tally(r.Required, r.Votes); st != StatusPending {
+		now := time.Now()
+		if _, err := tx.ExecContext(ctx, `UPDATE approval_requests SET status=$2, decided_at=$3 WHERE id=$1`, id, st, now); err != nil {
+			return r, err
+		}
+		r.Status, r.DecidedAt = st, &now
+	}
+	return r, tx.Commit()
+}
+
+// IsApprover reports the first routed group the user belongs to; users no longer in the org approve nothing
+func IsApprover(ctx context.Context, r Request, userID uuid.UUID) (string, bool, error) {
+	if out, err := NonMembers(ctx, r.OrgID, []uuid.UUID{userID}); err != nil || len(out) > 0 {
+		return "", false, err
+	}
+	groups, err := ListGroups(ctx, r.OrgID)
+	if err != nil {
+		return "", false, err
+	}
+	routed := map[string]bool{}
+	for _, n := range r.GroupNames() {
+		routed[n] = true
+	}
+	for _, g := range groups {
+		if !routed[g.Name] {
+			continue
+		}
+		for _, m := range g.MemberIDs() {
+			if m == userID {
+				return g.Name, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// Consume turns an approved, unexpired request into a one-time grant for the same agent and input.
+// On failure the returned request (when found) tells why.
+func Consume(ctx context.Context, orgID, id uuid.UUID, agentID *uuid.UUID, contextHash string) (Request, bool, error) {
+	var r Request
+	err := database.DB.GetContext(ctx, &r, `UPDATE approval_requests SET status='consumed', consumed_at=now()
+		WHERE id=$1 AND org_id=$2 AND status='approved' AND expires_at > now() AND context_hash=$3
+		AND (agent_id IS NULL OR agent_id=$4) RETURNING `+requestCols, id, orgID, contextHash, agentID)
+	if err == nil {
+		return r, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return r, false, err
+	}
+	r, err = Get(ctx, orgID, id)
+	return r, false, err
+}
+
+func nullableID(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
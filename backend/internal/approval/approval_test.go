@@ -0,0 +1,38 @@
+package approval
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestTally(t *testing.T) {
+	approve := Vote{Vote: VoteApprove}
+	deny := Vote{Vote: VoteDeny}
+	cases := []struct {
+		required int
+		votes    []Vote
+		want     string
+	}{
+		{1, nil, StatusPending},
+		{1, []Vote{approve}, StatusApproved},
+		{2, []Vote{approve}, StatusPending},
+		{2, []Vote{approve, approve}, StatusApproved},
+		{2, []Vote{approve, deny, approve}, StatusDenied},
+	}
+	for i, c := range cases {
+		if got := Tally(c.required, c.votes); got != c.want {
+			t.Fatalf("case %d: got %s want %s", i, got, c.want)
+		}
+	}
+}
+
+func TestContextHashIgnoresRiskAndKeyOrder(t *testing.T) {
+	a := ContextHash(json.RawMessage(`{"action":"wire","amount":10,"risk":{"score":1}}`))
+	b := ContextHash(json.RawMessage(`{"amount":10,"action":"wire","risk":{"score":80,"flags":["rate_spike"]}}`))
+	if a != b {
+		t.Fatalf("hash should not depend on risk signals or key order")
+	}
+	if a == ContextHash(json.RawMessage(`{"action":"wire","amount":11}`)) {
+		t.Fatalf("hash should change with the input")
+	}
+}
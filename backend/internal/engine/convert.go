@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// v1Ops are the context operators Evaluate understands; AuraJSON field operators share their semantics
+var v1Ops = map[string]bool{"eq": true, "neq": true, "gt": true, "gte": true, "lt": true, "lte": true, "contains": true}
+
+// numericOps are the v1 operators that compare numbers, with their expression operator
+var numericOps = map[string]string{"gt": ">", "gte": ">=", "lt": "<", "lte": "<="}
+
+// exprPath matches field paths that can be written as an expression identifier
+var exprPath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// exprLiterals are identifiers the expression language reads as literals or operators
+var exprLiterals = map[string]bool{"true": true, "false": true, "null": true, "in": true}
+
+// auraKeywords are `when` keys AuraJSON reads as combinators rather than fields
+var auraKeywords = map[string]bool{"and": true, "or": true, "not": true, "expr": true, "rel": true}
+
+var weekdays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// Convert translates an agent's v1 permission rules (in evaluation order) into an AuraJSON policy body
+// with the same decisions: rules keep their order under first_match precedence, context operators map
+// onto field operators and time windows onto now() calendar expressions. v1 quirks are preserved and
+// reported as warnings: OR clauses are ignored, keys next to AND are ignored, invalid time zones fall
+// back to UTC, and rules that can never decide are dropped.
+func Convert(rules []database.Permission) (json.RawMessage, []string, error) {
+	warnings := []string{}
+	out := []any{}
+	for i, p := range rules {
+		id := p.ID.String()
+		if p.ID == uuid.Nil {
+			id = fmt.Sprintf("%d", i)
+		}
+		warn := func(format string, args ...any) {
+			warnings = append(warnings, "rule "+id+": "+fmt.Sprintf(format, args...))
+		}
+		var rm map[string]any
+		if err := json.Unmarshal(p.Rule, &rm); err != nil {
+			warn("invalid JSON, skipped")
+			continue
+		}
+		action, aok := rm["action"].(string)
+		effectRaw, eok := rm["effect"].(string)
+		if !aok || !eok {
+			warn("missing action or effect, skipped")
+			continue
+		}
+		effect := strings.ToLower(effectRaw)
+		if effect != "allow" && effect != "deny" {
+			warn("effect %q never decides, skipped", effectRaw)
+			continue
+		}
+		clauses := []any{map[string]any{"action": map[string]any{"eq": action}}}
+		if ctx, ok := rm["context"].(map[string]any); ok && len(ctx) > 0 {
+			when, ok, err := convertContext(ctx, warn)
+			if err != nil {
+				return nil, warnings, fmt.Errorf("rule %s: %w", id, err)
+			}
+			if !ok {
+				warn("context can never match, skipped")
+				continue
+			}
+			if when != nil {
+				clauses = append(clauses, when)
+			}
+		}
+		if tw, ok := rm["time_window"].(map[string]any); ok {
+			expr, ok := convertTimeWindow(tw, warn)
+			if !ok {
+				warn("time window can never match, skipped")
+				continue
+			}
+			if expr != "" {
+				clauses = append(clauses, expr)
+			}
+		}
+		out = append(out, map[string]any{"id": "perm_" + id, "effect": effect, "when": map[string]any{"and": clauses}})
+	}
+	body, err := json.Marshal(map[string]any{"precedence": map[string]any{"first_match": true}, "rules": out})
+	return body, warnings, err
+}
+
+// convertContext maps a v1 context clause onto a `when` clause; ok is false when v1 can never match it
+func convertContext(ctx map[string]any, warn func(string, ...any)) (any, bool, error) {
+	if andRaw, has := ctx["AND"]; has {
+		items, ok := andRaw.([]any)
+		if !ok {
+			return nil, false, nil
+		}
+		if len(ctx) > 1 {
+			warn("keys next to AND are ignored")
+		}
+		subs := []any{}
+		for _, it := range items {
+			m, ok := it.(map[string]any)
+			if !ok {
+				return nil, false, nil
+			}
+			sub, ok, err := convertContext(m, warn)
+			if err != nil || !ok {
+				return nil, ok, err
+			}
+			if sub != nil {
+				subs = append(subs, sub)
+			}
+		}
+		return map[string]any{"and": subs}, true, nil
+	}
+	fields := map[string]any{}
+	var guards []string
+	keys := make([]string, 0, len(ctx))
+	for k := range ctx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key == "OR" {
+			warn("OR clause is ignored")
+			continue
+		}
+		if auraKeywords[key] {
+			return nil, false, fmt.Errorf("context field %q cannot be expressed in AuraJSON", key)
+		}
+		if strings.Contains(key, ".") {
+			warn("field %q is read as a nested path by AuraJSON", key)
+		}
+		switch cond := ctx[key].(type) {
+		case map[string]any:
+			ops := map[string]any{}
+			for op, v := range cond {
+				if !v1Ops[strings.ToLower(op)] {
+					return nil, false, nil // unknown operators never match in v1
+				}
+				ops[strings.ToLower(op)] = v
+			}
+			guard, ok := numericGuard(key, ops, warn)
+			if !ok {
+				return nil, false, nil
+			}
+			if guard != "" {
+				guards = append(guards, guard)
+			}
+			fields[key] = ops
+		default:
+			fields[key] = map[string]any{"eq": cond}
+		}
+	}
+	if len(guards) > 0 {
+		fields["expr"] = strings.Join(guards, " && ")
+	}
+	if len(fields) == 0 {
+		return nil, true, nil
+	}
+	return fields, true, nil
+}
+
+// numericGuard repeats a field's gt/gte/lt/lte comparisons as an expression over number(), which only
+// accepts strings that are entirely a number, as v1 does ("50abc" is not 50 there). The field operators
+// stay in place so that values number() converts but v1 does not compare, such as booleans, never match.
+// ok is false when a bound is not a number, which v1 can never match.
+func numericGuard(key string, ops map[string]any, warn func(string, ...any)) (string, bool) {
+	names := make([]string, 0, len(ops))
+	for op := range ops {
+		if numericOps[op] != "" {
+			names = append(names, op)
+		}
+	}
+	if len(names) == 0 {
+		return "", true
+	}
+	sort.Strings(names)
+	expressible := exprPath.MatchString(key)
+	for _, seg := range strings.Split(key, ".") {
+		if exprLiterals[seg] {
+			expressible = false
+		}
+	}
+	var parts []string
+	for _, op := range names {
+		var bound float64
+		switch v := ops[op].(type) {
+		case float64:
+			bound = v
+		case string:
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return "", false
+			}
+			bound = f
+		default:
+			return "", false
+		}
+		if math.IsInf(bound, 0) || math.IsNaN(bound) {
+			expressible = false
+		}
+		parts = append(parts, fmt.Sprintf("number(%s) %s %s", key, numericOps[op], strconv.FormatFloat(bound, 'f', -1, 64)))
+	}
+	if !expressible {
+		warn("field %q cannot be compared in an expression, numeric strings are compared by their leading number", key)
+		return "", true
+	}
+	return fmt.Sprintf("string(%s) == trim(string(%s)) && %s", key, key, strings.Join(parts, " && ")), true
+}
+
+// convertTimeWindow renders a v1 time window as an expression over now(); "" means no constraint and
+// ok=false a window that never matches
+func convertTimeWindow(tw map[string]any, warn func(string, ...any)) (string, bool) {
+	tz := "UTC"
+	if s, ok := tw["tz"].(string); ok && s != "" {
+		if _, err := time.LoadLocation(s); err == nil {
+			tz = s
+		} else {
+			warn("unknown time zone %q, using UTC", s)
+		}
+	}
+	var parts []string
+	if days, ok := tw["days"].([]any); ok && len(days) > 0 {
+		seen := map[int]bool{}
+		var nums []string
+		for _, d := range days {
+			ds, ok := d.(string)
+			if !ok {
+				continue
+			}
+			if len(ds) < 3 {
+				warn("day %q is not recognized", ds)
+				continue
+			}
+			n, ok := weekdays[strings.ToLower(ds[:3])]
+			if !ok || seen[n] {
+				continue
+			}
+			seen[n] = true
+			nums = append(nums, fmt.Sprintf("%d", n))
+		}
+		if len(nums) == 0 {
+			return "", false
+		}
+		sort.Strings(nums)
+		parts = append(parts, fmt.Sprintf("now().getDayOfWeek(%q) in [%s]", tz, strings.Join(nums, ", ")))
+	}
+	start, sok := tw["start"].(string)
+	end, eok := tw["end"].(string)
+	if sok && eok {
+		s, sok := parseHHMM(start)
+		e, eok := parseHHMM(end)
+		if sok && eok {
+			minutes := fmt.Sprintf("now().getHours(%q) * 60 + now().getMinutes(%q)", tz, tz)
+			parts = append(parts, fmt.Sprintf("%s >= %d && %s <= %d", minutes, s, minutes, e))
+		}
+	}
+	return strings.Join(parts, " && "), true
+}
+
+// RulesChecksum identifies a rule set (ids and bodies in order) so a stale conversion can be detected
+func RulesChecksum(rules []database.Permission) string {
+	h := sha256.New()
+	for _, p := range rules {
+		h.Write([]byte(p.ID.String()))
+		h.Write([]byte{0})
+		h.Write(p.Rule)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/google/uuid"
+)
+
+func perms(rules ...string) []database.Permission {
+	out := make([]database.Permission, len(rules))
+	for i, r := range rules {
+		out[i] = database.Permission{ID: uuid.New(), Rule: json.RawMessage(r), IsActive: true}
+	}
+	return out
+}
+
+// TestConvert_Equivalence evaluates v1 rules and their AuraJSON conversion on the same inputs
+func TestConvert_Equivalence(t *testing.T) {
+	now := time.Now().UTC()
+	today := now.Weekday().String()[:3]
+	other := now.Add(24 * time.Hour).Weekday().String()[:3]
+	rules := perms(
+		`{"action":"deploy","effect":"deny","context":{"env":{"eq":"prod"},"OR":[{"env":"dev"}]}}`,
+		`{"action":"deploy","effect":"allow","context":{"AND":[{"branch":"main"},{"version":{"gte":2}}]}}`,
+		`{"action":"read","effect":"allow","context":{"path":{"contains":"/public/"}}}`,
+		`{"action":"read","effect":"allow","context":{"path":{"startswith":"/x"}}}`,
+		`{"action":"pay","effect":"allow","context":{"amount":{"lte":100}}}`,
+		`{"action":"backup","effect":"allow","time_window":{"days":["`+today+`"],"start":"00:00","end":"23:59","tz":"UTC"}}`,
+		`{"action":"restore","effect":"allow","time_window":{"days":["`+other+`"],"start":"00:00","end":"23:59"}}`,
+		`{"action":"audit","effect":"review"}`,
+		`{"effect":"allow"}`,
+		`{"action":"deploy","effect":"allow"}`,
+	)
+	body, warnings, err := Convert(rules)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if !strings.Contains(strings.Join(warnings, "\n"), "OR clause is ignored") {
+		t.Fatalf("expected OR warning, got %v", warnings)
+	}
+	e := &policy.AuraJSONEvaluator{}
+	cp, err := e.Compile(body)
+	if err != nil {
+		t.Fatalf("compile converted body: %v\n%s", err, body)
+	}
+	inputs := []string{
+		`{"action":"deploy","env":"prod","branch":"main","version":3}`,
+		`{"action":"deploy","env":"dev","branch":"main","version":3}`,
+		`{"action":"deploy","env":"dev","branch":"feature","version":1}`,
+		`{"action":"pay","amount":50}`,
+		`{"action":"pay","amount":"50"}`,
+		`{"action":"pay","amount":"50abc"}`,
+		`{"action":"pay","amount":" 50"}`,
+		`{"action":"pay","amount":true}`,
+		`{"action":"deploy"}`,
+		`{"action":"read","path":"/public/a"}`,
+		`{"action":"read","path":"/xyz"}`,
+		`{"action":"backup"}`,
+		`{"action":"restore"}`,
+		`{"action":"audit"}`,
+		`{"action":5}`,
+		`[1,2]`,
+	}
+	for _, in := range inputs {
+		want, _ := EvaluateRules(uuid.Nil, rules, json.RawMessage(in), now)
+		d, err := e.Evaluate(cp, json.RawMessage(in))
+		if err != nil {
+			t.Fatalf("%s: evaluate: %v", in, err)
+		}
+		if d.Allow != want {
+			t.Fatalf("%s: v1 allow=%v, converted allow=%v (%s)", in, want, d.Allow, d.Reason)
+		}
+	}
+}
+
+func TestConvert_RejectsAmbiguousFields(t *testing.T) {
+	for _, field := range []string{"not", "rel"} {
+		_, _, err := Convert(perms(`{"action":"x","effect":"allow","context":{"` + field + `":"y"}}`))
+		if err == nil {
+			t.Fatalf("expected error for a field named like the AuraJSON keyword %q", field)
+		}
+	}
+	a, b := perms(`{"action":"x","effect":"allow"}`), perms(`{"action":"x","effect":"allow"}`)
+	if RulesChecksum(a) == RulesChecksum(b) {
+		t.Fatalf("checksum should include rule ids")
+	}
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log" // For logging errors during evaluation
@@ -18,14 +19,24 @@ import (
 // Returns true if allowed, false otherwise, and a reason string.
 func Evaluate(agentID uuid.UUID, requestContext json.RawMessage) (bool, string) {
 	// 1. Fetch Rules from Database
-	var rules []database.Permission
-	query := `SELECT rule FROM permissions WHERE agent_id = $1 AND is_active = true ORDER BY created_at ASC` // Get only active rules
-	err := database.DB.Select(&rules, query, agentID)
+	rules, err := LoadRules(context.Background(), agentID)
 	if err != nil {
 		log.Printf("Error fetching rules for agent %s: %v", agentID, err)
 		return false, "Internal error fetching rules" // Deny if rules can't be fetched
 	}
+	return EvaluateRules(agentID, rules, requestContext, time.Now())
+}
+
+// LoadRules returns the agent's active permission rules in evaluation order
+func LoadRules(ctx context.Context, agentID uuid.UUID) ([]database.Permission, error) {
+	var rules []database.Permission
+	query := `SELECT id, agent_id, rule, is_active, created_at FROM permissions WHERE agent_id = $1 AND is_active = true ORDER BY created_at ASC` // Get only active rules
+	err := database.DB.SelectContext(ctx, &rules, query, agentID)
+	return rules, err
+}
 
+// EvaluateRules applies rules in order (first matching allow or deny wins); time windows use now
+func EvaluateRules(agentID uuid.UUID, rules []database.Permission, requestContext json.RawMessage, now time.Time) (bool, string) {
 	if len(rules) == 0 {
 		return false, "No active rules defined for this agent" // Deny if no rules exist
 	}
@@ -81,7 +92,7 @@ func Evaluate(agentID uuid.UUID, requestContext json.RawMessage) (bool, string)
 		// Evaluate time window if present
 		timeMatch := true
 		if timeWindow != nil {
-			timeMatch = checkTimeWindow(timeWindow)
+			timeMatch = checkTimeWindow(timeWindow, now)
 		}
 
 		// If action and context/time match...
@@ -231,7 +242,7 @@ func toFloat(v interface{}) (float64, bool) {
 
 // checkTimeWindow validates if current time lies within the configured window.
 // { "days": ["Mon","Tue","Wed","Thu","Fri"], "start": "09:00", "end": "18:00", "tz": "Asia/Kolkata" }
-func checkTimeWindow(tw map[string]interface{}) bool {
+func checkTimeWindow(tw map[string]interface{}, at time.Time) bool {
 	// Timezone
 	loc := time.UTC
 	if tzRaw, ok := tw["tz"].(string); ok && tzRaw != "" {
@@ -240,7 +251,7 @@ func checkTimeWindow(tw map[string]interface{}) bool {
 		}
 	}
 
-	now := time.Now().In(loc)
+	now := at.In(loc)
 	// Day match
 	dayOK := true
 	if daysRaw, ok := tw["days"].([]interface{}); ok && len(daysRaw) > 0 {
@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	database "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// Serving modes of /v1/verify for an agent with converted rules
+const (
+	ModeLegacy = "legacy"
+	ModeDual   = "dual"
+	ModeV2     = "v2"
+)
+
+// Divergence kinds recorded by dual-run
+const (
+	DivergenceDecision = "decision"
+	DivergenceStale    = "stale"
+	DivergenceError    = "error"
+)
+
+// Migration links an agent's v1 rules to the AuraJSON policy version they were converted into
+type Migration struct {
+	AgentID        uuid.UUID       `db:"agent_id" json:"agent_id"`
+	OrgID          uuid.UUID       `db:"org_id" json:"org_id"`
+	PolicyID       uuid.UUID       `db:"policy_id" json:"policy_id"`
+	Version        int             `db:"version" json:"version"`
+	SourceChecksum string          `db:"source_checksum" json:"source_checksum"`
+	Warnings       json.RawMessage `db:"warnings" json:"warnings"`
+	Mode           string          `db:"mode" json:"mode"`
+	ConvertedAt    time.Time       `db:"converted_at" json:"converted_at"`
+	UpdatedAt      time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+type Divergence struct {
+	ID             int64           `db:"id" json:"id"`
+	OrgID          uuid.UUID       `db:"org_id" json:"org_id"`
+	AgentID        uuid.UUID       `db:"agent_id" json:"agent_id"`
+	PolicyID       uuid.UUID       `db:"policy_id" json:"policy_id"`
+	Version        int             `db:"version" json:"version"`
+	Kind           string          `db:"kind" json:"kind"`
+	LegacyAllow    bool            `db:"legacy_allow" json:"legacy_allow"`
+	LegacyReason   *string         `db:"legacy_reason" json:"legacy_reason,omitempty"`
+	V2Allow        *bool           `db:"v2_allow" json:"v2_allow,omitempty"`
+	V2Reason       *string         `db:"v2_reason" json:"v2_reason,omitempty"`
+	RequestContext json.RawMessage `db:"request_context" json:"request_context,omitempty"`
+	CreatedAt      time.Time       `db:"created_at" json:"created_at"`
+}
+
+const migrationCols = `agent_id, org_id, policy_id, version, source_checksum, warnings, mode, converted_at, updated_at`
+
+// GetMigration returns the agent's migration, or nil when its rules were never converted
+func GetMigration(ctx context.Context, agentID uuid.UUID) (*Migration, error) {
+	var m Migration
+	err := database.DB.GetContext(ctx, &m, `SELECT `+migrationCols+` FROM v1_permission_migrations WHERE agent_id=$1`, agentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// SaveMigration records a conversion; an existing migration keeps its mode
+func SaveMigration(ctx context.Context, m Migration) (Migration, error) {
+	if m.Mode == "" {
+		m.Mode = ModeDual
+	}
+	var out Migration
+	err := database.DB.QueryRowxContext(ctx, `INSERT INTO v1_permission_migrations (agent_id, org_id, policy_id, version, source_checksum, warnings, mode)
+		VALUES ($1,$2,$3,$4,$5,$6,$7)
+		ON CONFLICT (agent_id) DO UPDATE SET policy_id=EXCLUDED.policy_id, version=EXCLUDED.version, source_checksum=EXCLUDED.source_checksum,
+			warnings=EXCLUDED.warnings, converted_at=now(), updated_at=now()
+		RETURNING `+migrationCols, m.AgentID, m.OrgID, m.PolicyID, m.Version, m.SourceChecksum, m.Warnings, m.Mode).StructScan(&out)
+	return out, err
+}
+
+// SetMode switches how /v1/verify serves the agent; false when the agent has no migration
+func SetMode(ctx context.Context, orgID, agentID uuid.UUID, mode string) (bool, error) {
+	res, err := database.DB.ExecContext(ctx, `UPDATE v1_permission_migrations SET mode=$3, updated_at=now() WHERE org_id=$1 AND agent_id=$2`, orgID, agentID, mode)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func RecordDivergence(ctx context.Context, d Divergence) error {
+	_, err := database.DB.NamedExecContext(ctx, `INSERT INTO v1_verify_divergences (org_id, agent_id, policy_id, version, kind, legacy_allow, legacy_reason, v2_allow, v2_reason, request_context)
+		VALUES (:org_id, :agent_id, :policy_id, :version, :kind, :legacy_allow, :legacy_reason, :v2_allow, :v2_reason, :request_context)`, d)
+	return err
+}
+
+// ListDivergences returns the agent's most recent divergences
+func ListDivergences(ctx context.Context, orgID, agentID uuid.UUID, limit int) ([]Divergence, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	out := []Divergence{}
+	err := database.DB.SelectContext(ctx, &out, `SELECT id, org_id, agent_id, policy_id, version, kind, legacy_allow, legacy_reason, v2_allow, v2_reason, request_context, created_at
+		FROM v1_verify_divergences WHERE org_id=$1 AND agent_id=$2 ORDER BY created_at DESC LIMIT $3`, orgID, agentID, limit)
+	return out, err
+}
@@ -143,3 +143,21 @@ type DecisionTrace struct {
 	Trace         json.RawMessage `db:"trace"`
 	CreatedAt     time.Time       `db:"created_at"`
 }
+
+type PolicySchemaDef struct {
+	OrgID     uuid.UUID       `db:"org_id"`
+	Name      string          `db:"name"`
+	Schema    json.RawMessage `db:"schema"`
+	Version   int             `db:"version"`
+	UpdatedBy *uuid.UUID      `db:"updated_by_user_id"`
+	UpdatedAt time.Time       `db:"updated_at"`
+}
+
+type RegoDataBundle struct {
+	OrgID     uuid.UUID       `db:"org_id"`
+	Name      string          `db:"name"`
+	Data      json.RawMessage `db:"data"`
+	Revision  int             `db:"revision"`
+	UpdatedBy *uuid.UUID      `db:"updated_by_user_id"`
+	UpdatedAt time.Time       `db:"updated_at"`
+}
@@ -0,0 +1,206 @@
+// Package bundle reads policy bundles: a directory of AuraJSON and Rego files kept in git, described by a
+// manifest naming each policy with its engine, its assignments and the test cases it must pass.
+//
+// A loaded Bundle is self-contained (bodies inlined), so it is what the sync endpoint accepts; Plan diffs
+// it against an org's current policies by checksum.
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+)
+
+// ManifestFile is the manifest a bundle directory must contain
+const ManifestFile = "aura-policies.json"
+
+// Manifest lists the policies of a bundle; paths are relative to the bundle directory
+type Manifest struct {
+	Policies []PolicySpec `json:"policies"`
+}
+
+// PolicySpec describes one policy. For AuraJSON, File is the policy body. For Rego, a .rego File is the
+// main module, Modules are extra module files and Entrypoint is optional; any other File is taken as a
+// complete Rego policy body ({"module": ...}).
+type PolicySpec struct {
+	Name        string       `json:"name"`
+	Engine      string       `json:"engine"`
+	File        string       `json:"file"`
+	Modules     []string     `json:"modules,omitempty"`
+	Entrypoint  string       `json:"entrypoint,omitempty"`
+	Assignments []Assignment `json:"assignments,omitempty"`
+	Tests       []TestCase   `json:"tests,omitempty"`
+	TestsFile   string       `json:"tests_file,omitempty"`
+}
+
+type Assignment struct {
+	ScopeType string `json:"scope_type"`
+	ScopeID   string `json:"scope_id"`
+}
+
+// TestCase is an input and the status it must evaluate to (allow|deny|needs_approval)
+type TestCase struct {
+	Name   string          `json:"name,omitempty"`
+	Input  json.RawMessage `json:"input"`
+	Expect string          `json:"expect"`
+}
+
+// Bundle is a loaded bundle, bodies inlined
+type Bundle struct {
+	Policies []Policy `json:"policies"`
+}
+
+type Policy struct {
+	Name        string          `json:"name"`
+	Engine      string          `json:"engine"`
+	Body        json.RawMessage `json:"body"`
+	Checksum    string          `json:"checksum,omitempty"`
+	Assignments []Assignment    `json:"assignments,omitempty"`
+	Tests       []TestCase      `json:"tests,omitempty"`
+}
+
+// Load reads the bundle in dir
+func Load(dir string) (Bundle, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return Bundle{}, err
+	}
+	var m Manifest
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&m); err != nil {
+		return Bundle{}, fmt.Errorf("%s: %w", ManifestFile, err)
+	}
+	b := Bundle{Policies: make([]Policy, 0, len(m.Policies))}
+	for i, s := range m.Policies {
+		p, err := loadPolicy(dir, s)
+		if err != nil {
+			return Bundle{}, fmt.Errorf("%s: policy %d (%s): %w", ManifestFile, i, s.Name, err)
+		}
+		b.Policies = append(b.Policies, p)
+	}
+	if err := b.Validate(); err != nil {
+		return Bundle{}, err
+	}
+	return b, nil
+}
+
+func loadPolicy(dir string, s PolicySpec) (Policy, error) {
+	if s.File == "" {
+		return Policy{}, errors.New("file required")
+	}
+	src, err := readFile(dir, s.File)
+	if err != nil {
+		return Policy{}, err
+	}
+	p := Policy{Name: s.Name, Engine: s.Engine, Assignments: s.Assignments, Tests: s.Tests}
+	switch {
+	case isRego(s.Engine) && strings.HasSuffix(s.File, ".rego"):
+		body := map[string]any{"module": string(src)}
+		if len(s.Modules) > 0 {
+			mods := map[string]string{}
+			for _, f := range s.Modules {
+				m, err := readFile(dir, f)
+				if err != nil {
+					return Policy{}, err
+				}
+				mods[filepath.Base(f)] = string(m)
+			}
+			body["modules"] = mods
+		}
+		if s.Entrypoint != "" {
+			body["entrypoint"] = s.Entrypoint
+		}
+		p.Body, _ = json.Marshal(body)
+	case len(s.Modules) > 0 || s.Entrypoint != "":
+		return Policy{}, errors.New("modules and entrypoint apply to .rego files only")
+	default:
+		if !json.Valid(src) {
+			return Policy{}, fmt.Errorf("%s: invalid JSON", s.File)
+		}
+		p.Body = src
+	}
+	if s.TestsFile != "" {
+		raw, err := readFile(dir, s.TestsFile)
+		if err != nil {
+			return Policy{}, err
+		}
+		var tests []TestCase
+		if err := json.Unmarshal(raw, &tests); err != nil {
+			return Policy{}, fmt.Errorf("%s: %w", s.TestsFile, err)
+		}
+		p.Tests = append(p.Tests, tests...)
+	}
+	if p.Checksum, err = Checksum(p.Body); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// readFile reads a manifest path, which must stay inside the bundle
+func readFile(dir, name string) ([]byte, error) {
+	if filepath.IsAbs(name) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return nil, fmt.Errorf("%s: path outside the bundle", name)
+	}
+	return os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+}
+
+func isRego(engine string) bool { return engine == policy.EngineRego || engine == "opa" }
+
+// Validate checks what can be checked without compiling: names, engines, assignments and expectations
+func (b Bundle) Validate() error {
+	seen := map[string]bool{}
+	for i, p := range b.Policies {
+		switch {
+		case strings.TrimSpace(p.Name) == "":
+			return fmt.Errorf("policy %d: name required", i)
+		case seen[p.Name]:
+			return fmt.Errorf("policy %s: duplicate name", p.Name)
+		case p.Engine == "":
+			return fmt.Errorf("policy %s: engine required", p.Name)
+		case len(p.Body) == 0 || !json.Valid(p.Body):
+			return fmt.Errorf("policy %s: body must be JSON", p.Name)
+		}
+		seen[p.Name] = true
+		for _, a := range p.Assignments {
+			if a.ScopeType == "" || a.ScopeID == "" {
+				return fmt.Errorf("policy %s: assignments need scope_type and scope_id", p.Name)
+			}
+		}
+		for j, tc := range p.Tests {
+			switch strings.ToLower(tc.Expect) {
+			case "allow", "deny", "needs_approval":
+			default:
+				return fmt.Errorf("policy %s: test %d: expect must be allow, deny or needs_approval", p.Name, j)
+			}
+			if len(tc.Input) == 0 {
+				return fmt.Errorf("policy %s: test %d: input required", p.Name, j)
+			}
+		}
+	}
+	return nil
+}
+
+// Checksum identifies a policy body regardless of formatting and key order
+func Checksum(body json.RawMessage) (string, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	canon, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(canon)
+	return "sha256:" + hex.EncodeToString(h[:]), nil
+}
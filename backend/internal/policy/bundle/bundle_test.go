@@ -0,0 +1,186 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+func writeBundle(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeBundle(t, map[string]string{
+		ManifestFile: `{"policies":[
+			{"name":"payments","engine":"aurajson","file":"payments.json","assignments":[{"scope_type":"org","scope_id":"o1"}],
+			 "tests":[{"name":"small","input":{"action":"pay"},"expect":"allow"}],"tests_file":"tests/payments.json"},
+			{"name":"deploys","engine":"rego","file":"rego/deploys.rego","modules":["rego/lib.rego"]}
+		]}`,
+		"payments.json":       `{"rules":[{"id":"r","effect":"allow","when":{"action":{"eq":"pay"}}}]}`,
+		"tests/payments.json": `[{"input":{"action":"refund"},"expect":"deny"}]`,
+		"rego/deploys.rego":   "package deploys\nallow := true\n",
+		"rego/lib.rego":       "package lib\n",
+	})
+	b, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Policies) != 2 {
+		t.Fatalf("expected 2 policies, got %d", len(b.Policies))
+	}
+	pay := b.Policies[0]
+	if len(pay.Tests) != 2 || pay.Tests[1].Expect != "deny" || !strings.HasPrefix(pay.Checksum, "sha256:") {
+		t.Errorf("payments: %+v", pay)
+	}
+	var rego struct {
+		Module  string            `json:"module"`
+		Modules map[string]string `json:"modules"`
+	}
+	if err := json.Unmarshal(b.Policies[1].Body, &rego); err != nil || !strings.HasPrefix(rego.Module, "package deploys") || rego.Modules["lib.rego"] == "" {
+		t.Errorf("deploys body: %s", b.Policies[1].Body)
+	}
+
+	for name, manifest := range map[string]string{
+		"escape":    `{"policies":[{"name":"p","engine":"aurajson","file":"../p.json"}]}`,
+		"duplicate": `{"policies":[{"name":"p","engine":"aurajson","file":"p.json"},{"name":"p","engine":"aurajson","file":"p.json"}]}`,
+		"expect":    `{"policies":[{"name":"p","engine":"aurajson","file":"p.json","tests":[{"input":{},"expect":"maybe"}]}]}`,
+		"modules":   `{"policies":[{"name":"p","engine":"aurajson","file":"p.json","modules":["x.rego"]}]}`,
+		"unknown":   `{"policies":[{"name":"p","engine":"aurajson","file":"p.json","assign":[]}]}`,
+	} {
+		dir := writeBundle(t, map[string]string{ManifestFile: manifest, "p.json": `{"rules":[]}`})
+		if _, err := Load(dir); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	a, _ := Checksum(json.RawMessage(`{"rules":[{"id":"r","effect":"allow"}],"version":1}`))
+	b, _ := Checksum(json.RawMessage("{\n  \"version\": 1,\n  \"rules\": [ {\"effect\":\"allow\", \"id\":\"r\"} ]\n}"))
+	c, _ := Checksum(json.RawMessage(`{"rules":[{"id":"r","effect":"deny"}],"version":1}`))
+	if a != b || a == c {
+		t.Errorf("checksums: %s %s %s", a, b, c)
+	}
+	if _, err := Checksum(json.RawMessage(`{`)); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	body := json.RawMessage(`{"rules":[]}`)
+	sum, _ := Checksum(body)
+	org := Assignment{ScopeType: "org", ScopeID: "o1"}
+	team := Assignment{ScopeType: "team", ScopeID: "t1"}
+	b := Bundle{Policies: []Policy{
+		{Name: "new", Engine: "aurajson", Body: body, Assignments: []Assignment{org, org}},
+		{Name: "same", Engine: "aurajson", Body: body, Assignments: []Assignment{org}},
+		{Name: "edited", Engine: "rego", Body: body},
+	}}
+	current := []Current{
+		{PolicyID: uuid.New(), Name: "same", Engine: "aurajson", Version: 3, Checksum: sum, Assignments: []Assignment{org, team}},
+		{PolicyID: uuid.New(), Name: "edited", Engine: "opa", Version: 1, Checksum: "sha256:old"},
+		{PolicyID: uuid.New(), Name: "manual", Engine: "aurajson", Version: 1},
+	}
+	plan, err := Plan(b, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]Change{}
+	for _, ch := range plan.Changes {
+		got[ch.Name] = ch
+	}
+	if ch := got["new"]; ch.Action != ActionCreate || ch.PolicyID != nil || len(ch.AddAssignments) != 1 {
+		t.Errorf("new: %+v", ch)
+	}
+	if ch := got["same"]; ch.Action != ActionUnchanged || ch.Version != 3 || len(ch.AddAssignments) != 0 || len(ch.UnmanagedAssignments) != 1 || ch.UnmanagedAssignments[0] != team {
+		t.Errorf("same: %+v", ch)
+	}
+	if ch := got["edited"]; ch.Action != ActionUpdate || ch.PreviousChecksum != "sha256:old" || *ch.PolicyID != current[1].PolicyID {
+		t.Errorf("edited: %+v", ch)
+	}
+	if len(plan.Unmanaged) != 1 || plan.Unmanaged[0] != "manual" || !plan.Changed() {
+		t.Errorf("plan: %+v", plan)
+	}
+
+	// an engine switch and an ambiguous name are refused
+	if _, err := Plan(Bundle{Policies: []Policy{{Name: "same", Engine: "rego", Body: body}}}, current); err == nil {
+		t.Error("expected an engine mismatch error")
+	}
+	if _, err := Plan(Bundle{Policies: []Policy{{Name: "same", Engine: "aurajson", Body: body}}}, append(current, current[0])); err == nil {
+		t.Error("expected an ambiguous name error")
+	}
+	unchanged, _ := Plan(Bundle{Policies: b.Policies[1:2]}, current[:1])
+	if unchanged.Changed() {
+		t.Errorf("expected no changes, got %+v", unchanged)
+	}
+}
+
+func TestApply(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("error creating sqlmock: %v", err)
+	}
+	defer db.Close()
+	databasepkg.DB = sqlx.NewDb(db, "sqlmock")
+
+	org, pid := uuid.New(), uuid.New()
+	body := json.RawMessage(`{"rules":[]}`)
+	a := Assignment{ScopeType: "org", ScopeID: org.String()}
+	b := Bundle{Policies: []Policy{{Name: "new", Engine: "aurajson", Body: body, Assignments: []Assignment{a}}}}
+	expectCurrent := func(policies *sqlmock.Rows) {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock`)).WithArgs("policy_bundle:" + org.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(regexp.QuoteMeta(`FROM policies p`)).WithArgs(org).WillReturnRows(policies)
+		mock.ExpectQuery(regexp.QuoteMeta(`FROM policy_assignments pa`)).WithArgs(org).
+			WillReturnRows(sqlmock.NewRows([]string{"policy_id", "scope_type", "scope_id"}))
+	}
+	columns := []string{"id", "name", "engine_type", "version", "checksum", "body"}
+
+	// another sync created the policy after this one was planned: nothing is written
+	plan, _ := Plan(b, nil)
+	expectCurrent(sqlmock.NewRows(columns).AddRow(pid, "new", "aurajson", 0, nil, nil))
+	mock.ExpectRollback()
+	if err := Apply(context.Background(), org, b, &plan, []json.RawMessage{body}, "abc", nil); !errors.Is(err, ErrPlanChanged) {
+		t.Fatalf("expected ErrPlanChanged, got %v", err)
+	}
+
+	// the policy, its version and its assignment are written together
+	expectCurrent(sqlmock.NewRows(columns))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO policies`)).WithArgs(org, "new", "aurajson", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(pid))
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO policy_versions`)).WithArgs(pid, body, nil, plan.Changes[0].Checksum, "abc").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO policy_assignments`)).WithArgs(pid, a.ScopeType, a.ScopeID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := Apply(context.Background(), org, b, &plan, []json.RawMessage{body}, "abc", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ch := plan.Changes[0]; ch.PolicyID == nil || *ch.PolicyID != pid || ch.Version != 1 {
+		t.Errorf("expected the created policy and version filled in, got %+v", ch)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("unmet sqlmock expectations: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package bundle
+
+import (
+	"fmt"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/google/uuid"
+)
+
+// Plan actions
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+)
+
+// Current is an org policy as a sync sees it: its latest version (0 when none) and that version's checksum
+type Current struct {
+	PolicyID    uuid.UUID
+	Name        string
+	Engine      string
+	Version     int
+	Checksum    string
+	Assignments []Assignment
+}
+
+// Change is what a sync does to one bundle policy. Version is the version a sync created, or the latest
+// one when the policy is unchanged. Assignments are only ever added; ones the bundle does not name are
+// reported as unmanaged.
+type Change struct {
+	Name                 string       `json:"name"`
+	Action               string       `json:"action"`
+	PolicyID             *uuid.UUID   `json:"policy_id,omitempty"`
+	Version              int          `json:"version,omitempty"`
+	Checksum             string       `json:"checksum"`
+	PreviousChecksum     string       `json:"previous_checksum,omitempty"`
+	AddAssignments       []Assignment `json:"assignments_added,omitempty"`
+	UnmanagedAssignments []Assignment `json:"unmanaged_assignments,omitempty"`
+}
+
+// SyncPlan lists a change per bundle policy, in bundle order, and the org policies the bundle leaves alone
+type SyncPlan struct {
+	Changes   []Change `json:"changes"`
+	Unmanaged []string `json:"unmanaged,omitempty"`
+}
+
+// Plan diffs a bundle against an org's current policies, matched by name. A policy cannot change engine,
+// and names the bundle uses must be unique in the org.
+func Plan(b Bundle, current []Current) (SyncPlan, error) {
+	byName := map[string]Current{}
+	dup := map[string]bool{}
+	for _, c := range current {
+		if _, ok := byName[c.Name]; ok {
+			dup[c.Name] = true
+		}
+		byName[c.Name] = c
+	}
+	plan := SyncPlan{Changes: make([]Change, 0, len(b.Policies))}
+	named := map[string]bool{}
+	for _, p := range b.Policies {
+		named[p.Name] = true
+		sum := p.Checksum
+		if sum == "" {
+			var err error
+			if sum, err = Checksum(p.Body); err != nil {
+				return SyncPlan{}, fmt.Errorf("policy %s: %w", p.Name, err)
+			}
+		}
+		ch := Change{Name: p.Name, Checksum: sum}
+		cur, ok := byName[p.Name]
+		switch {
+		case dup[p.Name]:
+			return SyncPlan{}, fmt.Errorf("policy %s: several org policies have this name", p.Name)
+		case !ok:
+			ch.Action = ActionCreate
+			ch.AddAssignments, _ = diffAssignments(p.Assignments, nil)
+		case engineOf(cur.Engine) != engineOf(p.Engine):
+			return SyncPlan{}, fmt.Errorf("policy %s: engine is %s, the bundle says %s", p.Name, cur.Engine, p.Engine)
+		default:
+			id := cur.PolicyID
+			ch.PolicyID = &id
+			ch.PreviousChecksum = cur.Checksum
+			ch.Action = ActionUpdate
+			if cur.Version > 0 && cur.Checksum == sum {
+				ch.Action = ActionUnchanged
+				ch.Version = cur.Version
+			}
+			ch.AddAssignments, ch.UnmanagedAssignments = diffAssignments(p.Assignments, cur.Assignments)
+		}
+		plan.Changes = append(plan.Changes, ch)
+	}
+	for _, c := range current {
+		if !named[c.Name] {
+			plan.Unmanaged = append(plan.Unmanaged, c.Name)
+		}
+	}
+	return plan, nil
+}
+
+// Changed reports whether applying the plan writes anything
+func (p SyncPlan) Changed() bool {
+	for _, c := range p.Changes {
+		if c.Action != ActionUnchanged || len(c.AddAssignments) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// diffAssignments returns the wanted assignments missing from have, and those of have not wanted
+func diffAssignments(want, have []Assignment) (add, extra []Assignment) {
+	in := func(list []Assignment, a Assignment) bool {
+		for _, x := range list {
+			if x == a {
+				return true
+			}
+		}
+		return false
+	}
+	for _, a := range want {
+		if !in(have, a) && !in(add, a) {
+			add = append(add, a)
+		}
+	}
+	for _, a := range have {
+		if !in(want, a) {
+			extra = append(extra, a)
+		}
+	}
+	return add, extra
+}
+
+// engineOf folds the "opa" alias into rego
+func engineOf(e string) string {
+	if isRego(e) {
+		return policy.EngineRego
+	}
+	return e
+}
@@ -0,0 +1,118 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrPlanChanged reports that the org's policies changed between planning a sync and applying it
+var ErrPlanChanged = errors.New("org policies changed since the bundle was planned; sync again")
+
+// CurrentPolicies loads the org's policies with their latest version and assignments. Versions written
+// before checksums were recorded are checksummed from their stored body.
+func CurrentPolicies(ctx context.Context, orgID uuid.UUID) ([]Current, error) {
+	return currentPolicies(ctx, databasepkg.DB, orgID)
+}
+
+func currentPolicies(ctx context.Context, q sqlx.QueryerContext, orgID uuid.UUID) ([]Current, error) {
+	rows := []struct {
+		PolicyID uuid.UUID `db:"id"`
+		Name     string    `db:"name"`
+		Engine   string    `db:"engine_type"`
+		Version  int       `db:"version"`
+		Checksum *string   `db:"checksum"`
+		Body     []byte    `db:"body"` // NULL for a policy without versions
+
+	}{}
+	if err := sqlx.SelectContext(ctx, q, &rows, `
+		SELECT p.id, p.name, p.engine_type, COALESCE(v.version,0) AS version, v.checksum, v.body
+		FROM policies p
+		LEFT JOIN LATERAL (SELECT version, checksum, body FROM policy_versions WHERE policy_id=p.id ORDER BY version DESC LIMIT 1) v ON true
+		WHERE p.org_id=$1 ORDER BY p.created_at`, orgID); err != nil {
+		return nil, err
+	}
+	asg := []struct {
+		PolicyID  uuid.UUID `db:"policy_id"`
+		ScopeType string    `db:"scope_type"`
+		ScopeID   string    `db:"scope_id"`
+	}{}
+	if err := sqlx.SelectContext(ctx, q, &asg, `SELECT pa.policy_id, pa.scope_type, pa.scope_id FROM policy_assignments pa
+		JOIN policies p ON p.id=pa.policy_id WHERE p.org_id=$1 ORDER BY pa.created_at`, orgID); err != nil {
+		return nil, err
+	}
+	byPolicy := map[uuid.UUID][]Assignment{}
+	for _, a := range asg {
+		byPolicy[a.PolicyID] = append(byPolicy[a.PolicyID], Assignment{ScopeType: a.ScopeType, ScopeID: a.ScopeID})
+	}
+	out := make([]Current, 0, len(rows))
+	for _, r := range rows {
+		c := Current{PolicyID: r.PolicyID, Name: r.Name, Engine: r.Engine, Version: r.Version, Assignments: byPolicy[r.PolicyID]}
+		if r.Checksum != nil {
+			c.Checksum = *r.Checksum
+		} else if len(r.Body) > 0 {
+			c.Checksum, _ = Checksum(json.RawMessage(r.Body))
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// Apply writes a sync plan in one transaction: the policies it creates, a draft version of each changed
+// policy recording its checksum and commit as change ticket, and the assignments it adds. bodies are the
+// version bodies to store, by bundle index. The plan is made again under the transaction and the sync
+// refused with ErrPlanChanged when the org's policies moved meanwhile; a failure leaves nothing behind.
+// Created policy ids and versions are filled into plan.
+func Apply(ctx context.Context, orgID uuid.UUID, b Bundle, plan *SyncPlan, bodies []json.RawMessage, commit string, by *uuid.UUID) error {
+	tx, err := databasepkg.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	// syncs into an org are serialized
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "policy_bundle:"+orgID.String()); err != nil {
+		return err
+	}
+	current, err := currentPolicies(ctx, tx, orgID)
+	if err != nil {
+		return err
+	}
+	again, err := Plan(b, current)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPlanChanged, err)
+	}
+	if !reflect.DeepEqual(again, *plan) {
+		return ErrPlanChanged
+	}
+
+	for i := range plan.Changes {
+		ch := &plan.Changes[i]
+		if ch.Action == ActionCreate {
+			var id uuid.UUID
+			if err := tx.GetContext(ctx, &id, `INSERT INTO policies (org_id,name,engine_type,created_by_user_id) VALUES ($1,$2,$3,$4) RETURNING id`,
+				orgID, b.Policies[i].Name, b.Policies[i].Engine, by); err != nil {
+				return err
+			}
+			ch.PolicyID = &id
+		}
+		if ch.Action != ActionUnchanged {
+			if err := tx.GetContext(ctx, &ch.Version, `INSERT INTO policy_versions (policy_id,version,body,created_by_user_id,status,checksum,change_ticket)
+				SELECT $1, COALESCE(MAX(version),0)+1, $2, $3, 'draft', $4, NULLIF($5,'') FROM policy_versions WHERE policy_id=$1 RETURNING version`,
+				*ch.PolicyID, bodies[i], by, ch.Checksum, commit); err != nil {
+				return err
+			}
+		}
+		for _, a := range ch.AddAssignments {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO policy_assignments (policy_id, scope_type, scope_id) VALUES ($1,$2,$3)`, *ch.PolicyID, a.ScopeType, a.ScopeID); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,208 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Combining algorithms used when several policies apply to one request
+const (
+	CombineDenyOverrides     = "deny-overrides"
+	CombinePermitOverrides   = "permit-overrides"
+	CombineFirstApplicable   = "first-applicable"
+	CombineOnlyOneApplicable = "only-one-applicable"
+)
+
+// Outcomes of a single policy decision
+const (
+	OutcomeAllow           = "allow"
+	OutcomeDeny            = "deny"
+	OutcomeRequireApproval = "require_approval"
+	OutcomeNotApplicable   = "not_applicable"
+)
+
+// PolicyTrace records one policy that took part in a combined decision
+type PolicyTrace struct {
+	PolicyID       uuid.UUID   `json:"policy_id"`
+	PolicyVersion  int         `json:"policy_version"`
+	ScopeType      string      `json:"scope_type,omitempty"`
+	ScopeID        string      `json:"scope_id,omitempty"`
+	Engine         string      `json:"engine,omitempty"`
+	Outcome        string      `json:"outcome"`
+	Reason         string      `json:"reason,omitempty"`
+	Decisive       bool        `json:"decisive,omitempty"`
+	EvaluatedRules []RuleTrace `json:"evaluated_rules,omitempty"`
+}
+
+// PolicyResult is the decision of one assigned policy version prior to combining
+type PolicyResult struct {
+	PolicyID  uuid.UUID
+	Version   int
+	ScopeType string
+	ScopeID   string
+	Decision  Decision
+}
+
+// ValidCombiningAlg reports whether alg is a supported combining algorithm
+func ValidCombiningAlg(alg string) bool {
+	switch alg {
+	case CombineDenyOverrides, CombinePermitOverrides, CombineFirstApplicable, CombineOnlyOneApplicable:
+		return true
+	}
+	return false
+}
+
+// Outcome classifies a decision; AuraJSON decisions where no rule matched are not applicable
+func Outcome(d Decision) string {
+	if d.Allow {
+		return OutcomeAllow
+	}
+	if d.RequireApproval {
+		return OutcomeRequireApproval
+	}
+	if d.Trace != nil && d.Trace.Engine == EngineAuraJSON && len(d.Trace.Validations) == 0 {
+		for _, r := range d.Trace.EvaluatedRules {
+			if r.Matched {
+				return OutcomeDeny
+			}
+		}
+		return OutcomeNotApplicable
+	}
+	return OutcomeDeny
+}
+
+// Combine merges per-policy results (in evaluation order) with the given algorithm. The returned
+// decision's trace records every contributing policy; PolicyID/PolicyVersion point at the decisive one.
+// The second return value is the index of the decisive result, or -1 when none was decisive.
+func Combine(alg string, results []PolicyResult) (Decision, int) {
+	if !ValidCombiningAlg(alg) {
+		alg = CombineDenyOverrides
+	}
+	outcomes := make([]string, len(results))
+	first := map[string]int{}
+	applicable := 0
+	for i, r := range results {
+		outcomes[i] = Outcome(r.Decision)
+		if _, ok := first[outcomes[i]]; !ok {
+			first[outcomes[i]] = i
+		}
+		if outcomes[i] != OutcomeNotApplicable {
+			applicable++
+		}
+	}
+	pick := func(order ...string) (string, int) {
+		for _, o := range order {
+			if i, ok := first[o]; ok {
+				return o, i
+			}
+		}
+		return OutcomeNotApplicable, -1
+	}
+
+	var outcome string
+	idx := -1
+	reason := ""
+	switch alg {
+	case CombineDenyOverrides:
+		outcome, idx = pick(OutcomeDeny, OutcomeRequireApproval, OutcomeAllow)
+	case CombinePermitOverrides:
+		outcome, idx = pick(OutcomeAllow, OutcomeRequireApproval, OutcomeDeny)
+	case CombineFirstApplicable:
+		for i, o := range outcomes {
+			if o != OutcomeNotApplicable {
+				outcome, idx = o, i
+				break
+			}
+		}
+		if idx < 0 {
+			outcome = OutcomeNotApplicable
+		}
+	case CombineOnlyOneApplicable:
+		if applicable > 1 {
+			outcome = OutcomeDeny
+			reason = "Multiple applicable policies"
+		} else {
+			outcome, idx = pick(OutcomeDeny, OutcomeRequireApproval, OutcomeAllow)
+		}
+	}
+
+	d := Decision{}
+	switch outcome {
+	case OutcomeAllow:
+		d.Allow = true
+	case OutcomeRequireApproval:
+		d.RequireApproval = true
+	}
+	// ref is the result whose trace details are surfaced; a lone non-applicable policy keeps its own reason
+	ref := idx
+	if ref < 0 && len(results) == 1 {
+		ref = 0
+	} else if outcome == OutcomeNotApplicable {
+		reason = "No applicable policy"
+	}
+	if ref >= 0 {
+		d.Reason = results[ref].Decision.Reason
+	}
+	if reason != "" {
+		d.Reason = reason
+	}
+
+	tr := &Trace{EvaluatedRules: []RuleTrace{}, Combining: alg}
+	var obligations, advice []Obligation
+	ids := make([]string, 0, len(results))
+	for i, r := range results {
+		pt := PolicyTrace{PolicyID: r.PolicyID, PolicyVersion: r.Version, ScopeType: r.ScopeType, ScopeID: r.ScopeID, Outcome: outcomes[i], Reason: r.Decision.Reason, Decisive: i == idx}
+		if r.Decision.Trace != nil {
+			pt.Engine = r.Decision.Trace.Engine
+			pt.EvaluatedRules = r.Decision.Trace.EvaluatedRules
+			tr.DurationMS += r.Decision.Trace.DurationMS
+			tr.Validations = append(tr.Validations, r.Decision.Trace.Validations...)
+		}
+		tr.Policies = append(tr.Policies, pt)
+		ids = append(ids, r.Decision.TraceID)
+		// obligations, advice and hints come from every policy that agrees with the combined outcome; an
+		// agreeing allow flagged for approval keeps the flag, hints and approvers, as a lone policy's does
+		if outcomes[i] == outcome {
+			obligations = append(obligations, r.Decision.Obligations...)
+			advice = append(advice, r.Decision.Advice...)
+			if r.Decision.RequireApproval {
+				d.RequireApproval = true
+				d.Hints = append(d.Hints, r.Decision.Hints...)
+				d.Approvers = appendUnique(d.Approvers, r.Decision.Approvers...)
+			}
+		}
+	}
+	if ref >= 0 {
+		dt := results[ref].Decision.Trace
+		tr.PolicyID = results[ref].PolicyID
+		tr.PolicyVersion = results[ref].Version
+		if dt != nil {
+			tr.EvaluatedRules = dt.EvaluatedRules
+			tr.InputContext = dt.InputContext
+			tr.At = dt.At
+			tr.Engine = dt.Engine
+			tr.Explain = dt.Explain
+		}
+	} else if len(results) > 0 && results[0].Decision.Trace != nil {
+		tr.InputContext = results[0].Decision.Trace.InputContext
+		tr.At = results[0].Decision.Trace.At
+	}
+	d.Obligations = MergeObligations(obligations)
+	d.Advice = MergeObligations(advice)
+	tr.Obligations = d.Obligations
+	tr.Advice = d.Advice
+	tr.RequireApproval = d.RequireApproval
+	tr.Hints = d.Hints
+	tr.Approvers = d.Approvers
+	d.Trace = tr
+	if len(results) == 1 {
+		d.TraceID = results[0].Decision.TraceID
+	} else {
+		h := sha256.Sum256([]byte(alg + "|" + strings.Join(ids, "|")))
+		d.TraceID = hex.EncodeToString(h[:8])
+	}
+	return d, idx
+}
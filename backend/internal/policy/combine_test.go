@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func evalFor(t *testing.T, body, input string) Decision {
+	t.Helper()
+	e := &AuraJSONEvaluator{}
+	cp, err := e.Compile(json.RawMessage(body))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	d, err := e.Evaluate(cp, json.RawMessage(input))
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	return d
+}
+
+func TestCombine_Algorithms(t *testing.T) {
+	in := `{"action":"deploy","env":"prod"}`
+	baseline := evalFor(t, `{"rules":[{"id":"deny_prod","effect":"deny","when":{"env":{"eq":"prod"}}}]}`, in)
+	agent := evalFor(t, `{"rules":[{"id":"allow_deploy","effect":"allow","when":{"action":{"eq":"deploy"}},"obligations":[{"type":"log_to","value":"siem"}]}]}`, in)
+	unrelated := evalFor(t, `{"rules":[{"id":"allow_read","effect":"allow","when":{"action":{"eq":"read"}}}]}`, in)
+
+	orgPol, agentPol, otherPol := uuid.New(), uuid.New(), uuid.New()
+	results := []PolicyResult{
+		{PolicyID: agentPol, Version: 2, ScopeType: "agent", Decision: agent},
+		{PolicyID: otherPol, Version: 1, ScopeType: "team", Decision: unrelated},
+		{PolicyID: orgPol, Version: 7, ScopeType: "org", Decision: baseline},
+	}
+
+	cases := []struct {
+		alg       string
+		allow     bool
+		decisive  int
+		policyID  uuid.UUID
+		obligated bool
+	}{
+		{CombineDenyOverrides, false, 2, orgPol, false},
+		{CombinePermitOverrides, true, 0, agentPol, true},
+		{CombineFirstApplicable, true, 0, agentPol, true},
+		{CombineOnlyOneApplicable, false, -1, uuid.Nil, false},
+	}
+	for _, tc := range cases {
+		d, idx := Combine(tc.alg, results)
+		if d.Allow != tc.allow || idx != tc.decisive {
+			t.Fatalf("%s: got allow=%v idx=%d reason=%q", tc.alg, d.Allow, idx, d.Reason)
+		}
+		if d.Trace == nil || d.Trace.Combining != tc.alg || len(d.Trace.Policies) != 3 {
+			t.Fatalf("%s: trace missing contributing policies: %+v", tc.alg, d.Trace)
+		}
+		if d.Trace.PolicyID != tc.policyID {
+			t.Fatalf("%s: expected decisive policy %s, got %s", tc.alg, tc.policyID, d.Trace.PolicyID)
+		}
+		if (len(d.Obligations) > 0) != tc.obligated {
+			t.Fatalf("%s: unexpected obligations %+v", tc.alg, d.Obligations)
+		}
+	}
+	if d, _ := Combine(CombineOnlyOneApplicable, results); d.Reason != "Multiple applicable policies" {
+		t.Fatalf("expected only-one-applicable conflict reason, got %q", d.Reason)
+	}
+	if o := Outcome(unrelated); o != OutcomeNotApplicable {
+		t.Fatalf("expected not_applicable, got %s", o)
+	}
+}
+
+func TestCombine_NothingApplicable(t *testing.T) {
+	in := `{"action":"write"}`
+	a := evalFor(t, `{"rules":[{"id":"allow_read","effect":"allow","when":{"action":{"eq":"read"}}}]}`, in)
+	single, _ := Combine(CombineDenyOverrides, []PolicyResult{{PolicyID: uuid.New(), Version: 1, Decision: a}})
+	if single.Allow || single.Reason != a.Reason || single.TraceID != a.TraceID {
+		t.Fatalf("single policy should keep its own decision, got %+v", single)
+	}
+	multi, idx := Combine(CombineDenyOverrides, []PolicyResult{{Decision: a}, {Decision: a}})
+	if multi.Allow || idx != -1 || multi.Reason != "No applicable policy" {
+		t.Fatalf("expected not applicable deny, got %+v idx=%d", multi, idx)
+	}
+}
+
+func TestCombine_ApproversUnionOfAgreeingPolicies(t *testing.T) {
+	in := `{"action":"wire","amount":5000}`
+	a := evalFor(t, `{"rules":[{"id":"big","effect":"require_approval","approvers":["finance"],"when":{"amount":{"gt":1000}}}]}`, in)
+	b := evalFor(t, `{"rules":[{"id":"wire","effect":"require_approval","approvers":["finance","security"],"when":{"action":{"eq":"wire"}}}]}`, in)
+	if len(a.Approvers) != 1 || a.Approvers[0] != "finance" {
+		t.Fatalf("expected rule approvers on decision, got %v", a.Approvers)
+	}
+	d, _ := Combine(CombineDenyOverrides, []PolicyResult{{Decision: a}, {Decision: b}})
+	if !d.RequireApproval || len(d.Approvers) != 2 || d.Approvers[0] != "finance" || d.Approvers[1] != "security" {
+		t.Fatalf("expected require_approval routed to finance+security, got %+v", d)
+	}
+	if !d.Trace.RequireApproval || len(d.Trace.Approvers) != 2 {
+		t.Fatalf("trace should keep approval routing, got %+v", d.Trace)
+	}
+	if _, err := (&AuraJSONEvaluator{}).Compile(json.RawMessage(`{"rules":[{"id":"x","effect":"require_approval","approvers":"finance"}]}`)); err == nil {
+		t.Fatalf("expected compile error for non-array approvers")
+	}
+}
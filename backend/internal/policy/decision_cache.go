@@ -0,0 +1,132 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DecisionCache is a bounded TTL cache of combined decisions. Each entry remembers the policies it was
+// computed from so that invalidating one policy drops only the decisions depending on it.
+type DecisionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	negTTL  time.Duration
+	max     int
+	entries map[string]decisionEntry
+}
+
+// CachedDecision is a decision together with the decisive policy version it was attributed to
+type CachedDecision struct {
+	Decision      Decision
+	PolicyID      uuid.UUID
+	PolicyVersion int
+}
+
+type decisionEntry struct {
+	val      CachedDecision
+	policies []uuid.UUID
+	expires  time.Time
+}
+
+// NewDecisionCache creates a cache; allows live for ttl and denials for negTTL (0 disables negative
+// caching). max bounds the number of entries (<= 0 means 10000).
+func NewDecisionCache(ttl, negTTL time.Duration, max int) *DecisionCache {
+	if max <= 0 {
+		max = 10000
+	}
+	return &DecisionCache{ttl: ttl, negTTL: negTTL, max: max, entries: make(map[string]decisionEntry)}
+}
+
+// DecisionKey hashes the cache key parts (org, agent, policy id@version list, canonical context, ...)
+func DecisionKey(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *DecisionCache) Get(key string) (CachedDecision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ent, ok := c.entries[key]
+	if !ok {
+		return CachedDecision{}, false
+	}
+	if time.Now().After(ent.expires) {
+		delete(c.entries, key)
+		return CachedDecision{}, false
+	}
+	return ent.val, true
+}
+
+// Put stores a decision computed from the given policies. The stored trace is a copy, so callers may
+// keep enriching their own decision afterwards.
+func (c *DecisionCache) Put(key string, val CachedDecision, policies []uuid.UUID) {
+	ttl := c.ttl
+	if !val.Decision.Allow {
+		ttl = c.negTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	if val.Decision.Trace != nil {
+		tr := *val.Decision.Trace
+		val.Decision.Trace = &tr
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.max {
+		c.evictLocked(now)
+	}
+	c.entries[key] = decisionEntry{val: val, policies: policies, expires: now.Add(ttl)}
+}
+
+// evictLocked drops expired entries, or an arbitrary one when none has expired
+func (c *DecisionCache) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < c.max {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+// InvalidatePolicy drops every decision that involved the policy
+func (c *DecisionCache) InvalidatePolicy(pid uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		for _, p := range e.policies {
+			if p == pid {
+				delete(c.entries, k)
+				break
+			}
+		}
+	}
+}
+
+// Clear drops every entry
+func (c *DecisionCache) Clear() {
+	c.mu.Lock()
+	c.entries = make(map[string]decisionEntry)
+	c.mu.Unlock()
+}
+
+func (c *DecisionCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
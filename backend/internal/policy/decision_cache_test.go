@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDecisionCache_TTLAndNegative(t *testing.T) {
+	c := NewDecisionCache(100*time.Millisecond, 30*time.Millisecond, 0)
+	pid := uuid.New()
+	allowKey := DecisionKey("org", "agent", pid.String()+"@1", `{"a":1}`)
+	denyKey := DecisionKey("org", "agent", pid.String()+"@1", `{"a":2}`)
+	if allowKey == denyKey {
+		t.Fatalf("keys must differ by context")
+	}
+	c.Put(allowKey, CachedDecision{Decision: Decision{Allow: true, Trace: &Trace{}}, PolicyID: pid, PolicyVersion: 1}, []uuid.UUID{pid})
+	c.Put(denyKey, CachedDecision{Decision: Decision{Allow: false}, PolicyID: pid, PolicyVersion: 1}, []uuid.UUID{pid})
+	if got, ok := c.Get(allowKey); !ok || !got.Decision.Allow || got.PolicyVersion != 1 {
+		t.Fatalf("expected cached allow, got %+v ok=%v", got, ok)
+	}
+	if _, ok := c.Get(denyKey); !ok {
+		t.Fatalf("expected cached deny")
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := c.Get(denyKey); ok {
+		t.Fatalf("deny should expire after the negative TTL")
+	}
+	if _, ok := c.Get(allowKey); !ok {
+		t.Fatalf("allow should outlive the negative TTL")
+	}
+
+	noNeg := NewDecisionCache(time.Minute, 0, 0)
+	noNeg.Put(denyKey, CachedDecision{}, nil)
+	if noNeg.Len() != 0 {
+		t.Fatalf("negative caching disabled but deny was stored")
+	}
+}
+
+func TestDecisionCache_InvalidateAndBound(t *testing.T) {
+	c := NewDecisionCache(time.Minute, time.Minute, 2)
+	p1, p2 := uuid.New(), uuid.New()
+	tr := &Trace{Engine: EngineAuraJSON}
+	c.Put("a", CachedDecision{Decision: Decision{Allow: true, Trace: tr}}, []uuid.UUID{p1})
+	c.Put("b", CachedDecision{Decision: Decision{Allow: true}}, []uuid.UUID{p1, p2})
+	// stored traces are copies
+	tr.Engine = "changed"
+	if got, _ := c.Get("a"); got.Decision.Trace.Engine != EngineAuraJSON {
+		t.Fatalf("cached trace was mutated through the caller's pointer")
+	}
+	c.InvalidatePolicy(p2)
+	if _, ok := c.Get("b"); ok {
+		t.Fatalf("entry depending on p2 should be dropped")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("entry not depending on p2 should survive")
+	}
+	c.Put("c", CachedDecision{}, nil)
+	c.Put("d", CachedDecision{}, nil)
+	if c.Len() > 2 {
+		t.Fatalf("cache exceeded its bound: %d", c.Len())
+	}
+	c.Clear()
+	if c.Len() != 0 {
+		t.Fatalf("expected empty cache after Clear")
+	}
+}
@@ -0,0 +1,521 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Differ is implemented by engines that can explain what changed between two policy bodies
+type Differ interface {
+	Diff(before, after json.RawMessage) (VersionDiff, error)
+}
+
+// Rule change kinds
+const (
+	RuleAdded    = "added"
+	RuleRemoved  = "removed"
+	RuleModified = "modified"
+	RuleMoved    = "moved"
+)
+
+// Access change kinds
+const (
+	AccessGained     = "gained"
+	AccessLost       = "lost"
+	AccessChanged    = "changed"
+	AccessConditions = "conditions"
+)
+
+// RuleChange is a rule added, removed, modified or moved between two versions. Positions are 1-based;
+// Moved marks a modified rule whose position among the rules of both versions changed too.
+type RuleChange struct {
+	RuleID string   `json:"rule_id"`
+	Change string   `json:"change"`
+	From   int      `json:"from,omitempty"`
+	To     int      `json:"to,omitempty"`
+	Moved  bool     `json:"moved,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+	Before any      `json:"before,omitempty"`
+	After  any      `json:"after,omitempty"`
+}
+
+// PrecedenceChange holds the effective precedence settings of both versions
+type PrecedenceChange struct {
+	Before map[string]bool `json:"before"`
+	After  map[string]bool `json:"after"`
+}
+
+// AccessChange is a probe input whose outcome differs between two versions: the action, the other field
+// values it set (fields it left out are unknown) and both partial outcomes. Conditions marks a probe
+// that stays conditional in both versions, under different residual rules.
+type AccessChange struct {
+	Action string         `json:"action"`
+	Input  map[string]any `json:"input,omitempty"`
+	Before string         `json:"before"`
+	After  string         `json:"after"`
+	Change string         `json:"change"`
+}
+
+// VersionDiff explains what changed between two versions of a policy: the rules (structure) and the
+// action/field combinations whose outcome changed (access)
+type VersionDiff struct {
+	Engine     string            `json:"engine"`
+	From       int               `json:"from,omitempty"`
+	To         int               `json:"to,omitempty"`
+	Rules      []RuleChange      `json:"rules"`
+	Precedence *PrecedenceChange `json:"precedence,omitempty"`
+	// Other lists the other top-level keys that changed, such as schema or entrypoint
+	Other     []string       `json:"other,omitempty"`
+	Access    []AccessChange `json:"access"`
+	Probes    int            `json:"probes"`
+	Truncated bool           `json:"truncated,omitempty"`
+	Notes     []string       `json:"notes,omitempty"`
+}
+
+// Summary counts the changes of a diff, for audit entries and approvals
+func (d VersionDiff) Summary() map[string]int {
+	s := map[string]int{}
+	for _, r := range d.Rules {
+		s["rules_"+r.Change]++
+	}
+	for _, a := range d.Access {
+		s["access_"+a.Change]++
+	}
+	if d.Precedence != nil {
+		s["precedence_changed"] = 1
+	}
+	return s
+}
+
+// Diff compares two AuraJSON bodies: rules are matched by id (by position when ids are missing or
+// repeated), and access is probed with Partial over the actions and field values the rules compare against
+// An empty before is an empty policy, for diffing a first version.
+func (e *AuraJSONEvaluator) Diff(before, after json.RawMessage) (VersionDiff, error) {
+	if len(before) == 0 {
+		before = json.RawMessage(`{}`)
+	}
+	var b, a map[string]any
+	if err := json.Unmarshal(before, &b); err != nil {
+		return VersionDiff{}, fmt.Errorf("before: invalid policy body: %w", err)
+	}
+	if err := json.Unmarshal(after, &a); err != nil {
+		return VersionDiff{}, fmt.Errorf("after: invalid policy body: %w", err)
+	}
+	d := VersionDiff{Engine: EngineAuraJSON, Access: []AccessChange{}}
+	br, bkeys := ruleList(b)
+	ar, akeys := ruleList(a)
+	d.Rules = diffRules(br, bkeys, ar, akeys)
+
+	pb, pa := effectivePrecedence(b), effectivePrecedence(a)
+	if !reflect.DeepEqual(pb, pa) {
+		d.Precedence = &PrecedenceChange{Before: pb, After: pa}
+	}
+	for _, r := range d.Rules {
+		if r.Change == RuleMoved || r.Moved {
+			if pa["first_match"] || !pa["deny_overrides"] {
+				d.Notes = append(d.Notes, "rules moved and rule order decides outcomes under this precedence")
+			}
+			break
+		}
+	}
+	d.Other = diffKeys(b, a, "rules", "precedence")
+
+	cb, err := e.Compile(before)
+	if err != nil {
+		return VersionDiff{}, fmt.Errorf("before: %w", err)
+	}
+	ca, err := e.Compile(after)
+	if err != nil {
+		return VersionDiff{}, fmt.Errorf("after: %w", err)
+	}
+	space := probeSpace(br, ar)
+	d.Access, d.Probes, d.Truncated = accessDiff(e, cb, ca, space)
+	if space.opaque {
+		d.Notes = append(d.Notes, "expression and rel clauses are not probed; inputs they decide show as conditional")
+	}
+	return d, nil
+}
+
+// ruleList returns the rules of a body with their match keys: the id, or #<position> when the id is
+// missing or repeated
+func ruleList(body map[string]any) ([]map[string]any, []string) {
+	raw, _ := body["rules"].([]any)
+	rules := make([]map[string]any, 0, len(raw))
+	for _, r := range raw {
+		if rm, ok := r.(map[string]any); ok {
+			rules = append(rules, rm)
+		}
+	}
+	count := map[string]int{}
+	for _, r := range rules {
+		if id, ok := r["id"].(string); ok && id != "" {
+			count[id]++
+		}
+	}
+	keys := make([]string, len(rules))
+	for i, r := range rules {
+		id, _ := r["id"].(string)
+		if id == "" || count[id] > 1 {
+			id = fmt.Sprintf("#%d", i+1)
+		}
+		keys[i] = id
+	}
+	return rules, keys
+}
+
+// diffRules matches rules by key; common rules off the longest common subsequence of both orders moved
+func diffRules(br []map[string]any, bkeys []string, ar []map[string]any, akeys []string) []RuleChange {
+	bpos, apos := map[string]int{}, map[string]int{}
+	for i, k := range bkeys {
+		bpos[k] = i
+	}
+	for i, k := range akeys {
+		apos[k] = i
+	}
+	var bc, ac []string
+	for _, k := range bkeys {
+		if _, ok := apos[k]; ok {
+			bc = append(bc, k)
+		}
+	}
+	for _, k := range akeys {
+		if _, ok := bpos[k]; ok {
+			ac = append(ac, k)
+		}
+	}
+	stay := lcs(bc, ac)
+
+	out := []RuleChange{}
+	for i, k := range bkeys {
+		if _, ok := apos[k]; !ok {
+			out = append(out, RuleChange{RuleID: k, Change: RuleRemoved, From: i + 1, Before: br[i]})
+		}
+	}
+	for j, k := range akeys {
+		i, ok := bpos[k]
+		if !ok {
+			out = append(out, RuleChange{RuleID: k, Change: RuleAdded, To: j + 1, After: ar[j]})
+			continue
+		}
+		fields := diffKeys(br[i], ar[j])
+		moved := !stay[k]
+		switch {
+		case len(fields) > 0:
+			out = append(out, RuleChange{RuleID: k, Change: RuleModified, From: i + 1, To: j + 1, Moved: moved, Fields: fields, Before: br[i], After: ar[j]})
+		case moved:
+			out = append(out, RuleChange{RuleID: k, Change: RuleMoved, From: i + 1, To: j + 1})
+		}
+	}
+	return out
+}
+
+// lcs returns the keys on a longest common subsequence of a and b
+func lcs(a, b []string) map[string]bool {
+	n, m := len(a), len(b)
+	t := make([][]int, n+1)
+	for i := range t {
+		t[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				t[i][j] = t[i+1][j+1] + 1
+			} else {
+				t[i][j] = max(t[i+1][j], t[i][j+1])
+			}
+		}
+	}
+	out := map[string]bool{}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case a[i] == b[j]:
+			out[a[i]] = true
+			i++
+			j++
+		case t[i+1][j] >= t[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return out
+}
+
+// diffKeys returns the sorted keys whose values differ between two maps, skipping ignore
+func diffKeys(b, a map[string]any, ignore ...string) []string {
+	skip := map[string]bool{}
+	for _, k := range ignore {
+		skip[k] = true
+	}
+	var out []string
+	for k, v := range b {
+		if !skip[k] && !reflect.DeepEqual(v, a[k]) {
+			out = append(out, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok && !skip[k] {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// effectivePrecedence mirrors how Evaluate reads precedence, defaults included
+func effectivePrecedence(body map[string]any) map[string]bool {
+	p := map[string]bool{"deny_overrides": true, "first_match": false}
+	if m, ok := body["precedence"].(map[string]any); ok {
+		for k := range p {
+			if v, ok := m[k].(bool); ok {
+				p[k] = v
+			}
+		}
+	}
+	return p
+}
+
+// otherValue stands for any value a policy does not name
+const otherValue = "<other>"
+
+// Probe limits: probes per diff, field combinations per action, and reported access changes
+const (
+	maxProbes        = 2000
+	maxCombos        = 64
+	maxAccessChanges = 200
+)
+
+type space struct {
+	actions []string
+	fields  map[string][]any
+	// opaque is set when rules use expressions or rel clauses, which probes cannot set
+	opaque bool
+}
+
+// probeSpace collects the values the rules of both versions compare fields against: literals of eq, neq,
+// in and contains, numeric bounds and their neighbours, plus otherValue for fields compared to strings
+func probeSpace(ruleSets ...[]map[string]any) space {
+	s := space{fields: map[string][]any{}}
+	for _, rules := range ruleSets {
+		for _, r := range rules {
+			walkWhen(r["when"], func(path string, ops map[string]any) {
+				for op, rhs := range ops {
+					var vals []any
+					switch strings.ToLower(op) {
+					case "eq", "neq", "contains":
+						vals = []any{rhs}
+					case "in":
+						vals, _ = rhs.([]any)
+					case "gt", "gte", "lt", "lte":
+						if f, ok := toFloat(rhs); ok {
+							vals = []any{f - 1, f, f + 1}
+						}
+					}
+					for _, v := range vals {
+						s.fields[path] = appendValue(s.fields[path], v)
+					}
+				}
+			}, &s.opaque)
+		}
+	}
+	for path, vals := range s.fields {
+		for _, v := range vals {
+			if _, ok := v.(string); ok {
+				s.fields[path] = appendValue(vals, otherValue)
+				break
+			}
+		}
+	}
+	for _, v := range s.fields["action"] {
+		if a, ok := v.(string); ok {
+			s.actions = append(s.actions, a)
+		}
+	}
+	if len(s.actions) == 0 {
+		s.actions = []string{otherValue}
+	}
+	delete(s.fields, "action")
+	sort.Strings(s.actions)
+	return s
+}
+
+func appendValue(list []any, v any) []any {
+	for _, x := range list {
+		if reflect.DeepEqual(x, v) {
+			return list
+		}
+	}
+	return append(list, v)
+}
+
+// walkWhen calls fn for each {"field": {ops}} clause of a when tree (or a residual condition); indexed
+// paths are skipped and expression or rel clauses set opaque
+func walkWhen(when any, fn func(path string, ops map[string]any), opaque *bool) {
+	switch w := when.(type) {
+	case string:
+		*opaque = true
+	case []any:
+		for _, x := range w {
+			walkWhen(x, fn, opaque)
+		}
+	case map[string]any:
+		for k, v := range w {
+			switch k {
+			case "and", "or", "not":
+				walkWhen(v, fn, opaque)
+			case "expr", "rel":
+				*opaque = true
+			default:
+				if ops, ok := v.(map[string]any); ok && !strings.Contains(k, "[") {
+					fn(k, ops)
+				}
+			}
+		}
+	}
+}
+
+// accessDiff probes each action in both versions. An action whose outcome is settled in both is compared
+// as is; otherwise the fields left in residual conditions are set, all combinations of their values when
+// there are at most maxCombos, else one field at a time.
+func accessDiff(e PartialEvaluator, before, after CompiledPolicy, s space) ([]AccessChange, int, bool) {
+	out := []AccessChange{}
+	probes := 0
+	truncated := false
+	probe := func(action string, set map[string]any) (PartialResult, PartialResult, bool) {
+		if probes >= maxProbes {
+			truncated = true
+			return PartialResult{}, PartialResult{}, false
+		}
+		probes++
+		in := map[string]any{"action": action}
+		for path, v := range set {
+			setPath(in, path, v)
+		}
+		known, _ := json.Marshal(in)
+		rb, errB := e.Partial(before, known, nil)
+		ra, errA := e.Partial(after, known, nil)
+		return rb, ra, errB == nil && errA == nil
+	}
+	record := func(action string, set map[string]any, rb, ra PartialResult) {
+		ch := accessChange(rb, ra)
+		if ch == "" {
+			return
+		}
+		if len(out) >= maxAccessChanges {
+			truncated = true
+			return
+		}
+		out = append(out, AccessChange{Action: action, Input: set, Before: rb.Outcome, After: ra.Outcome, Change: ch})
+	}
+	for _, action := range s.actions {
+		rb, ra, ok := probe(action, nil)
+		if !ok {
+			continue
+		}
+		if rb.Outcome != OutcomeConditional && ra.Outcome != OutcomeConditional {
+			record(action, nil, rb, ra)
+			continue
+		}
+		fields := residualFields(s, rb, ra)
+		if len(fields) == 0 {
+			record(action, nil, rb, ra)
+			continue
+		}
+		for _, set := range combinations(fields, s.fields) {
+			if rb, ra, ok := probe(action, set); ok {
+				record(action, set, rb, ra)
+			}
+		}
+	}
+	return out, probes, truncated
+}
+
+// accessChange classifies two partial outcomes of the same input; "" when nothing changed
+func accessChange(rb, ra PartialResult) string {
+	switch {
+	case rb.Outcome == ra.Outcome && (rb.Outcome != OutcomeConditional || reflect.DeepEqual(rb.Rules, ra.Rules)):
+		return ""
+	case ra.Outcome == OutcomeAllow:
+		return AccessGained
+	case rb.Outcome == OutcomeAllow:
+		return AccessLost
+	case rb.Outcome == ra.Outcome:
+		return AccessConditions
+	}
+	return AccessChanged
+}
+
+// residualFields lists, sorted, the probed fields that residual conditions of either result depend on
+func residualFields(s space, results ...PartialResult) []string {
+	set := map[string]bool{}
+	var opaque bool
+	for _, r := range results {
+		for _, rr := range r.Rules {
+			walkWhen(rr.Condition, func(path string, _ map[string]any) {
+				if len(s.fields[path]) > 0 {
+					set[path] = true
+				}
+			}, &opaque)
+		}
+	}
+	out := make([]string, 0, len(set))
+	for f := range set {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// combinations returns every assignment of values to fields when there are at most maxCombos, else
+// each value of each field alone
+func combinations(fields []string, values map[string][]any) []map[string]any {
+	n := 1
+	for _, f := range fields {
+		if n *= len(values[f]); n > maxCombos {
+			break
+		}
+	}
+	if n > maxCombos {
+		var out []map[string]any
+		for _, f := range fields {
+			for _, v := range values[f] {
+				out = append(out, map[string]any{f: v})
+			}
+		}
+		return out
+	}
+	out := []map[string]any{{}}
+	for _, f := range fields {
+		next := make([]map[string]any, 0, len(out)*len(values[f]))
+		for _, set := range out {
+			for _, v := range values[f] {
+				m := make(map[string]any, len(set)+1)
+				for k, x := range set {
+					m[k] = x
+				}
+				m[f] = v
+				next = append(next, m)
+			}
+		}
+		out = next
+	}
+	return out
+}
+
+// setPath sets a dotted path in a nested input map
+func setPath(in map[string]any, path string, v any) {
+	parts := strings.Split(path, ".")
+	m := in
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := m[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			m[p] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = v
+}
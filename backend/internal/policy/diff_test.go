@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffRules(t *testing.T) {
+	before := `{"rules":[
+		{"id":"deny_prod","effect":"deny","when":{"action":{"eq":"deploy"},"env":{"eq":"prod"}}},
+		{"id":"allow_read","effect":"allow","when":{"action":{"eq":"read"}}},
+		{"id":"allow_deploy","effect":"allow","when":{"action":{"eq":"deploy"}}},
+		{"id":"old","effect":"allow","when":{"action":{"eq":"archive"}}}
+	]}`
+	after := `{"precedence":{"first_match":true},"schema":{"type":"object"},"rules":[
+		{"id":"allow_read","effect":"allow","when":{"action":{"in":["read","list"]}}},
+		{"id":"deny_prod","effect":"deny","when":{"action":{"eq":"deploy"},"env":{"eq":"prod"}}},
+		{"id":"allow_deploy","effect":"allow","when":{"action":{"eq":"deploy"}}},
+		{"id":"pay","effect":"needs_approval","when":{"action":{"eq":"pay"},"amount":{"gt":100}}}
+	]}`
+	d, err := (&AuraJSONEvaluator{}).Diff(json.RawMessage(before), json.RawMessage(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]RuleChange{}
+	for _, r := range d.Rules {
+		got[r.RuleID] = r
+	}
+	if r := got["old"]; r.Change != RuleRemoved || r.From != 4 {
+		t.Errorf("old: %+v", r)
+	}
+	if r := got["pay"]; r.Change != RuleAdded || r.To != 4 {
+		t.Errorf("pay: %+v", r)
+	}
+	if r := got["allow_read"]; r.Change != RuleModified || len(r.Fields) != 1 || r.Fields[0] != "when" {
+		t.Errorf("allow_read: %+v", r)
+	}
+	// allow_read and allow_deploy keep their order; deny_prod is the one that moved
+	if r := got["deny_prod"]; r.Change != RuleMoved || r.From != 1 || r.To != 2 {
+		t.Errorf("deny_prod: %+v", r)
+	}
+	if _, ok := got["allow_deploy"]; ok || len(d.Rules) != 4 {
+		t.Errorf("unexpected rules %+v", d.Rules)
+	}
+	if d.Precedence == nil || !d.Precedence.After["first_match"] || d.Precedence.Before["first_match"] {
+		t.Errorf("precedence: %+v", d.Precedence)
+	}
+	if len(d.Other) != 1 || d.Other[0] != "schema" || len(d.Notes) != 1 {
+		t.Errorf("other %v, notes %v", d.Other, d.Notes)
+	}
+	if s := d.Summary(); s["rules_added"] != 1 || s["rules_moved"] != 1 || s["precedence_changed"] != 1 {
+		t.Errorf("summary: %v", s)
+	}
+}
+
+func TestDiffAccess(t *testing.T) {
+	before := `{"rules":[
+		{"id":"read","effect":"allow","when":{"action":{"eq":"read"}}},
+		{"id":"write","effect":"allow","when":{"action":{"eq":"write"},"resource.type":{"eq":"doc"}}},
+		{"id":"pay","effect":"allow","when":{"action":{"eq":"pay"},"amount":{"lte":100}}}
+	]}`
+	after := `{"rules":[
+		{"id":"read","effect":"allow","when":{"action":{"eq":"read"}}},
+		{"id":"write","effect":"allow","when":{"action":{"eq":"write"},"resource.type":{"in":["doc","sheet"]}}},
+		{"id":"pay","effect":"allow","when":{"action":{"eq":"pay"},"amount":{"lte":50}}},
+		{"id":"pay_review","effect":"needs_approval","when":{"action":{"eq":"pay"},"amount":{"gt":50}}},
+		{"id":"delete","effect":"allow","when":{"action":{"eq":"delete"}}}
+	]}`
+	d, err := (&AuraJSONEvaluator{}).Diff(json.RawMessage(before), json.RawMessage(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type key struct{ action, field, change, before, after string }
+	got := map[key]bool{}
+	for _, a := range d.Access {
+		field := ""
+		for k, v := range a.Input {
+			b, _ := json.Marshal(v)
+			field = k + "=" + string(b)
+		}
+		got[key{a.Action, field, a.Change, a.Before, a.After}] = true
+	}
+	for _, want := range []key{
+		{"delete", "", AccessGained, OutcomeNotApplicable, OutcomeAllow},
+		{"write", `resource.type="sheet"`, AccessGained, OutcomeNotApplicable, OutcomeAllow},
+		{"pay", "amount=100", AccessLost, OutcomeAllow, OutcomeRequireApproval},
+		{"pay", "amount=101", AccessChanged, OutcomeNotApplicable, OutcomeRequireApproval},
+	} {
+		if !got[want] {
+			t.Errorf("missing %+v in %+v", want, d.Access)
+		}
+	}
+	for k := range got {
+		if k.action == "read" || (k.action == "write" && k.field != `resource.type="sheet"`) {
+			t.Errorf("unexpected change %+v", k)
+		}
+	}
+	if d.Probes == 0 || d.Truncated {
+		t.Errorf("probes %d truncated %v", d.Probes, d.Truncated)
+	}
+}
@@ -0,0 +1,1542 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expression language for AuraJSON `when` clauses (CEL-style subset).
+//
+//	request.amount <= principal.limit && resource.startsWith("prod/")
+//	lower(action) in ["read", "list"] || action.matches("^report\\.")
+//	timestamp(request.at) > now() - duration("24h")
+//	request.items.all(i, i.price < 100) && request.tags.exists(t, t.glob("pii:*"))
+//
+// Identifiers resolve against the evaluation input. Expressions are parsed and type-checked at
+// Compile time; fields read from the input are dynamically typed and checked at evaluation time.
+// A runtime type error or missing field makes the rule not match (and is recorded in the rule trace).
+
+// exprType is the static type of an expression node
+type exprType int
+
+const (
+	tDyn exprType = iota
+	tBool
+	tNumber
+	tString
+	tTime
+	tDuration
+	tList
+	tMap
+	tNull
+)
+
+func (t exprType) String() string {
+	return [...]string{"dyn", "bool", "number", "string", "timestamp", "duration", "list", "map", "null"}[t]
+}
+
+// Expr is a compiled, type-checked expression
+type Expr struct {
+	src  string
+	root exprNode
+}
+
+// CompileExpr parses and type-checks an expression; the result must be boolean (or dynamic).
+func CompileExpr(src string) (*Expr, error) {
+	p := &exprParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokEOF {
+		return nil, p.errorf(p.peek(), "unexpected %q", p.peek().text)
+	}
+	t, err := checkExpr(root, map[string]exprType{})
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	if t != tBool && t != tDyn {
+		return nil, fmt.Errorf("expression %q: must evaluate to bool, got %s", src, t)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// Eval evaluates the expression against input and returns its boolean result
+func (e *Expr) Eval(input map[string]any) (bool, error) {
+	v, err := evalNode(e.root, &exprEnv{input: input, now: time.Now()})
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q evaluated to %s, not bool", e.src, typeOfValue(v))
+	}
+	return b, nil
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Partial evaluates the expression with the fields present in input. Sub-expressions that depend on a
+// missing field accepted by unknown are kept as a residual expression (in source form); everything else
+// is folded. known reports whether the result could be decided without the unknown fields.
+func (e *Expr) Partial(input map[string]any, unknown func(path string) bool) (known, value bool, residual string, err error) {
+	v, res, err := partialNode(e.root, &exprEnv{input: input, now: time.Now()}, unknown)
+	if err != nil {
+		return false, false, "", err
+	}
+	if res != nil {
+		return false, false, formatNode(res), nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, false, "", fmt.Errorf("expression %q evaluated to %s, not bool", e.src, typeOfValue(v))
+	}
+	return true, b, "", nil
+}
+
+func partialNode(n exprNode, env *exprEnv, unknown func(string) bool) (any, exprNode, error) {
+	switch x := n.(type) {
+	case *binaryNode:
+		if x.op != "&&" && x.op != "||" {
+			break
+		}
+		lv, lr, err := partialNode(x.l, env, unknown)
+		if err != nil {
+			return nil, nil, err
+		}
+		if lr == nil {
+			lb, ok := lv.(bool)
+			if !ok {
+				return nil, nil, fmt.Errorf("operator %s expects bool, got %s", x.op, typeOfValue(lv))
+			}
+			if (x.op == "&&" && !lb) || (x.op == "||" && lb) {
+				return lb, nil, nil
+			}
+			return partialNode(x.r, env, unknown)
+		}
+		rv, rr, err := partialNode(x.r, env, unknown)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rr != nil {
+			return nil, &binaryNode{op: x.op, l: lr, r: rr}, nil
+		}
+		rb, ok := rv.(bool)
+		if !ok {
+			return nil, nil, fmt.Errorf("operator %s expects bool, got %s", x.op, typeOfValue(rv))
+		}
+		if (x.op == "&&" && !rb) || (x.op == "||" && rb) {
+			return rb, nil, nil
+		}
+		return nil, lr, nil
+	case *unaryNode:
+		if x.op != "!" {
+			break
+		}
+		v, r, err := partialNode(x.x, env, unknown)
+		if err != nil {
+			return nil, nil, err
+		}
+		if r != nil {
+			return nil, &unaryNode{op: "!", x: r}, nil
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, nil, fmt.Errorf("operator ! expects bool, got %s", typeOfValue(v))
+		}
+		return !b, nil, nil
+	case *callNode:
+		// has() on an unknown field cannot be decided yet
+		if x.fn == "has" {
+			if _, err := evalNode(x.args[0], env); err != nil {
+				if mf, ok := err.(*missingFieldError); ok && mf.path != "" && unknown(mf.path) {
+					return nil, x, nil
+				}
+				return false, nil, nil
+			}
+			return true, nil, nil
+		}
+	}
+	v, err := evalNode(n, env)
+	if err != nil {
+		if mf, ok := err.(*missingFieldError); ok && mf.path != "" && unknown(mf.path) {
+			return nil, foldKnown(n, env), nil
+		}
+		return nil, nil, err
+	}
+	return v, nil, nil
+}
+
+// foldKnown replaces operands of a residual that are computable from the known input with literals
+func foldKnown(n exprNode, env *exprEnv) exprNode {
+	fold := func(x exprNode) exprNode {
+		v, err := evalNode(x, env)
+		if err != nil {
+			return foldKnown(x, env)
+		}
+		switch t := typeOfValue(v); t {
+		case tBool, tNumber, tString, tNull:
+			return &litNode{v: v, t: t}
+		}
+		return x
+	}
+	switch x := n.(type) {
+	case *binaryNode:
+		return &binaryNode{op: x.op, l: fold(x.l), r: fold(x.r)}
+	case *unaryNode:
+		return &unaryNode{op: x.op, x: fold(x.x)}
+	case *callNode:
+		args := make([]exprNode, len(x.args))
+		for i, a := range x.args {
+			args[i] = fold(a)
+		}
+		return &callNode{fn: x.fn, args: args, re: x.re}
+	}
+	return n
+}
+
+// formatNode renders an AST back to expression source; nested binary operations are parenthesized
+func formatNode(n exprNode) string {
+	switch x := n.(type) {
+	case *litNode:
+		switch v := x.v.(type) {
+		case string:
+			return strconv.Quote(v)
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case nil:
+			return "null"
+		}
+		return fmt.Sprintf("%v", x.v)
+	case *identNode:
+		return x.name
+	case *selectNode:
+		return formatNode(x.x) + "." + x.field
+	case *indexNode:
+		return formatNode(x.x) + "[" + formatNode(x.idx) + "]"
+	case *listNode:
+		items := make([]string, len(x.items))
+		for i, it := range x.items {
+			items[i] = formatNode(it)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case *unaryNode:
+		return x.op + formatOperand(x.x)
+	case *binaryNode:
+		return formatOperand(x.l) + " " + x.op + " " + formatOperand(x.r)
+	case *callNode:
+		args := make([]string, len(x.args))
+		for i, a := range x.args {
+			args[i] = formatNode(a)
+		}
+		return x.fn + "(" + strings.Join(args, ", ") + ")"
+	case *macroNode:
+		return x.fn + "(" + formatNode(x.list) + ", " + x.v + ", " + formatNode(x.pred) + ")"
+	}
+	return "?"
+}
+
+func formatOperand(n exprNode) string {
+	if _, ok := n.(*binaryNode); ok {
+		return "(" + formatNode(n) + ")"
+	}
+	return formatNode(n)
+}
+
+// ---- AST ----
+
+type exprNode interface{}
+
+type litNode struct {
+	v any
+	t exprType
+}
+type identNode struct{ name string }
+type selectNode struct {
+	x     exprNode
+	field string
+}
+type indexNode struct{ x, idx exprNode }
+type listNode struct{ items []exprNode }
+type unaryNode struct {
+	op string
+	x  exprNode
+}
+type binaryNode struct {
+	op   string
+	l, r exprNode
+}
+type callNode struct {
+	fn   string
+	args []exprNode
+	re   *regexp.Regexp // precompiled pattern for matches/glob with a literal argument
+}
+type macroNode struct {
+	fn   string // exists|all
+	list exprNode
+	v    string
+	pred exprNode
+}
+
+// ---- lexer ----
+
+const (
+	tokEOF = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+type exprParser struct {
+	src  string
+	toks []exprToken
+	i    int
+}
+
+func (p *exprParser) errorf(t exprToken, format string, args ...any) error {
+	return fmt.Errorf("expression %q at %d: %s", p.src, t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) lex() error {
+	s := p.src
+	i := 0
+	for i < len(s) {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c):
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, exprToken{tokNumber, s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(s) && rune(s[j]) != c {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					switch s[j] {
+					case 'n':
+						sb.WriteByte('\n')
+					case 't':
+						sb.WriteByte('\t')
+					default:
+						sb.WriteByte(s[j])
+					}
+					j++
+					continue
+				}
+				sb.WriteByte(s[j])
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("expression %q at %d: unterminated string", p.src, i)
+			}
+			p.toks = append(p.toks, exprToken{tokString, sb.String(), i})
+			i = j + 1
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			p.toks = append(p.toks, exprToken{tokIdent, s[i:j], i})
+			i = j
+		default:
+			two := ""
+			if i+1 < len(s) {
+				two = s[i : i+2]
+			}
+			switch two {
+			case "&&", "||", "==", "!=", "<=", ">=":
+				p.toks = append(p.toks, exprToken{tokOp, two, i})
+				i += 2
+				continue
+			}
+			if strings.ContainsRune("()[],.!<>+-*/%", c) {
+				p.toks = append(p.toks, exprToken{tokOp, string(c), i})
+				i++
+				continue
+			}
+			return fmt.Errorf("expression %q at %d: unexpected character %q", p.src, i, c)
+		}
+	}
+	p.toks = append(p.toks, exprToken{tokEOF, "", len(s)})
+	return nil
+}
+
+func (p *exprParser) peek() exprToken { return p.toks[p.i] }
+func (p *exprParser) next() exprToken {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+func (p *exprParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.i++
+		return true
+	}
+	return false
+}
+func (p *exprParser) expect(op string) error {
+	if !p.accept(op) {
+		return p.errorf(p.peek(), "expected %q, got %q", op, p.peek().text)
+	}
+	return nil
+}
+
+// ---- parser (precedence climbing) ----
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	l, err := p.parseRel()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		r, err := p.parseRel()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *exprParser) parseRel() (exprNode, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	op := ""
+	if t.kind == tokOp {
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			op = t.text
+		}
+	} else if t.kind == tokIdent && t.text == "in" {
+		op = "in"
+	}
+	if op == "" {
+		return l, nil
+	}
+	p.next()
+	r, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	return &binaryNode{op: op, l: l, r: r}, nil
+}
+
+func (p *exprParser) parseAdd() (exprNode, error) {
+	l, err := p.parseMul()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseMul()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseMul() (exprNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return l, nil
+		}
+		p.next()
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &binaryNode{op: t.text, l: l, r: r}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "!", x: x}, nil
+	}
+	if p.accept("-") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: "-", x: x}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name := p.next()
+			if name.kind != tokIdent {
+				return nil, p.errorf(name, "expected field or method name")
+			}
+			if !p.accept("(") {
+				x = &selectNode{x: x, field: name.text}
+				continue
+			}
+			// receiver-style call: x.f(args) == f(x, args)
+			if name.text == "exists" || name.text == "all" || name.text == "any" {
+				v := p.next()
+				if v.kind != tokIdent {
+					return nil, p.errorf(v, "%s() expects a variable name", name.text)
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				pred, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				fn := name.text
+				if fn == "any" {
+					fn = "exists"
+				}
+				x = &macroNode{fn: fn, list: x, v: v.text, pred: pred}
+				continue
+			}
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			x = &callNode{fn: name.text, args: append([]exprNode{x}, args...)}
+		case p.accept("["):
+			idx, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = &indexNode{x: x, idx: idx}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// parseArgs parses a comma separated list up to and including ')'
+func (p *exprParser) parseArgs() ([]exprNode, error) {
+	var args []exprNode
+	if p.accept(")") {
+		return args, nil
+	}
+	for {
+		a, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.accept(")") {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad number %q", t.text)
+		}
+		return &litNode{v: f, t: tNumber}, nil
+	case tokString:
+		return &litNode{v: t.text, t: tString}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &litNode{v: true, t: tBool}, nil
+		case "false":
+			return &litNode{v: false, t: tBool}, nil
+		case "null":
+			return &litNode{v: nil, t: tNull}, nil
+		}
+		if p.accept("(") {
+			// function-style quantifiers: exists(list, x, pred) / all(list, x, pred)
+			if t.text == "exists" || t.text == "all" || t.text == "any" {
+				list, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				v := p.next()
+				if v.kind != tokIdent {
+					return nil, p.errorf(v, "%s() expects a variable name", t.text)
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				pred, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+				fn := t.text
+				if fn == "any" {
+					fn = "exists"
+				}
+				return &macroNode{fn: fn, list: list, v: v.text, pred: pred}, nil
+			}
+			args, err := p.parseArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &callNode{fn: t.text, args: args}, nil
+		}
+		return &identNode{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		case "[":
+			ln := &listNode{}
+			if p.accept("]") {
+				return ln, nil
+			}
+			for {
+				it, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				ln.items = append(ln.items, it)
+				if p.accept("]") {
+					return ln, nil
+				}
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of expression")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+// ---- type checker ----
+
+type exprFunc struct {
+	args []exprType
+	ret  exprType
+}
+
+var exprFuncs = map[string]exprFunc{
+	"lower":      {[]exprType{tString}, tString},
+	"upper":      {[]exprType{tString}, tString},
+	"trim":       {[]exprType{tString}, tString},
+	"startsWith": {[]exprType{tString, tString}, tBool},
+	"endsWith":   {[]exprType{tString, tString}, tBool},
+	"contains":   {[]exprType{tString, tString}, tBool},
+	"matches":    {[]exprType{tString, tString}, tBool},
+	"glob":       {[]exprType{tString, tString}, tBool},
+	"size":       {[]exprType{tDyn}, tNumber},
+	"timestamp":  {[]exprType{tString}, tTime},
+	"duration":   {[]exprType{tString}, tDuration},
+	"now":        {nil, tTime},
+	// calendar accessors in an IANA time zone: t.getDayOfWeek("UTC") (0 = Sunday), t.getHours(tz), t.getMinutes(tz)
+	"getDayOfWeek": {[]exprType{tTime, tString}, tNumber},
+	"getHours":     {[]exprType{tTime, tString}, tNumber},
+	"getMinutes":   {[]exprType{tTime, tString}, tNumber},
+	"string":       {[]exprType{tDyn}, tString},
+	"number":       {[]exprType{tDyn}, tNumber},
+	"has":          {[]exprType{tDyn}, tBool},
+}
+
+func assignable(have, want exprType) bool {
+	return want == tDyn || have == tDyn || have == want
+}
+
+func checkExpr(n exprNode, vars map[string]exprType) (exprType, error) {
+	switch x := n.(type) {
+	case *litNode:
+		return x.t, nil
+	case *identNode:
+		if t, ok := vars[x.name]; ok {
+			return t, nil
+		}
+		return tDyn, nil
+	case *selectNode:
+		t, err := checkExpr(x.x, vars)
+		if err != nil {
+			return 0, err
+		}
+		if t != tDyn && t != tMap {
+			return 0, fmt.Errorf("cannot select field %q from %s", x.field, t)
+		}
+		return tDyn, nil
+	case *indexNode:
+		t, err := checkExpr(x.x, vars)
+		if err != nil {
+			return 0, err
+		}
+		if t != tDyn && t != tList && t != tMap {
+			return 0, fmt.Errorf("cannot index %s", t)
+		}
+		if _, err := checkExpr(x.idx, vars); err != nil {
+			return 0, err
+		}
+		return tDyn, nil
+	case *listNode:
+		for _, it := range x.items {
+			if _, err := checkExpr(it, vars); err != nil {
+				return 0, err
+			}
+		}
+		return tList, nil
+	case *unaryNode:
+		t, err := checkExpr(x.x, vars)
+		if err != nil {
+			return 0, err
+		}
+		if x.op == "!" {
+			if !assignable(t, tBool) {
+				return 0, fmt.Errorf("operator ! expects bool, got %s", t)
+			}
+			return tBool, nil
+		}
+		if !assignable(t, tNumber) && t != tDuration {
+			return 0, fmt.Errorf("unary - expects number or duration, got %s", t)
+		}
+		return t, nil
+	case *binaryNode:
+		lt, err := checkExpr(x.l, vars)
+		if err != nil {
+			return 0, err
+		}
+		rt, err := checkExpr(x.r, vars)
+		if err != nil {
+			return 0, err
+		}
+		return checkBinary(x.op, lt, rt)
+	case *callNode:
+		f, ok := exprFuncs[x.fn]
+		if !ok {
+			return 0, fmt.Errorf("unknown function %s()", x.fn)
+		}
+		if x.fn == "has" {
+			if len(x.args) != 1 {
+				return 0, fmt.Errorf("has() expects 1 argument")
+			}
+			switch x.args[0].(type) {
+			case *selectNode, *indexNode, *identNode:
+				return tBool, nil
+			}
+			return 0, fmt.Errorf("has() expects a field path")
+		}
+		if len(x.args) != len(f.args) {
+			return 0, fmt.Errorf("%s() expects %d argument(s), got %d", x.fn, len(f.args), len(x.args))
+		}
+		for i, a := range x.args {
+			at, err := checkExpr(a, vars)
+			if err != nil {
+				return 0, err
+			}
+			if !assignable(at, f.args[i]) {
+				return 0, fmt.Errorf("%s() argument %d expects %s, got %s", x.fn, i+1, f.args[i], at)
+			}
+		}
+		// validate literal arguments at compile time
+		if lit, ok := lastArgLiteral(x); ok {
+			switch x.fn {
+			case "matches":
+				re, err := regexp.Compile(lit)
+				if err != nil {
+					return 0, fmt.Errorf("matches(): invalid pattern: %v", err)
+				}
+				x.re = re
+			case "glob":
+				x.re = globToRegexp(lit)
+			case "timestamp":
+				if _, err := parseTimestamp(lit); err != nil {
+					return 0, err
+				}
+			case "duration":
+				if _, err := parseDuration(lit); err != nil {
+					return 0, err
+				}
+			case "getDayOfWeek", "getHours", "getMinutes":
+				if _, err := time.LoadLocation(lit); err != nil {
+					return 0, fmt.Errorf("%s(): unknown time zone %q", x.fn, lit)
+				}
+			}
+		}
+		return f.ret, nil
+	case *macroNode:
+		lt, err := checkExpr(x.list, vars)
+		if err != nil {
+			return 0, err
+		}
+		if lt != tDyn && lt != tList {
+			return 0, fmt.Errorf("%s() expects a list, got %s", x.fn, lt)
+		}
+		inner := make(map[string]exprType, len(vars)+1)
+		for k, v := range vars {
+			inner[k] = v
+		}
+		inner[x.v] = tDyn
+		pt, err := checkExpr(x.pred, inner)
+		if err != nil {
+			return 0, err
+		}
+		if !assignable(pt, tBool) {
+			return 0, fmt.Errorf("%s() predicate must be bool, got %s", x.fn, pt)
+		}
+		return tBool, nil
+	}
+	return 0, fmt.Errorf("unsupported expression node")
+}
+
+func lastArgLiteral(c *callNode) (string, bool) {
+	if len(c.args) == 0 {
+		return "", false
+	}
+	if l, ok := c.args[len(c.args)-1].(*litNode); ok {
+		s, ok := l.v.(string)
+		return s, ok
+	}
+	return "", false
+}
+
+func checkBinary(op string, l, r exprType) (exprType, error) {
+	dyn := l == tDyn || r == tDyn
+	switch op {
+	case "&&", "||":
+		if !assignable(l, tBool) || !assignable(r, tBool) {
+			return 0, fmt.Errorf("operator %s expects bool operands, got %s and %s", op, l, r)
+		}
+		return tBool, nil
+	case "==", "!=":
+		if !dyn && l != r && l != tNull && r != tNull {
+			return 0, fmt.Errorf("cannot compare %s %s %s", l, op, r)
+		}
+		return tBool, nil
+	case "<", "<=", ">", ">=":
+		if dyn {
+			return tBool, nil
+		}
+		if l != r || (l != tNumber && l != tString && l != tTime && l != tDuration) {
+			return 0, fmt.Errorf("cannot order %s %s %s", l, op, r)
+		}
+		return tBool, nil
+	case "in":
+		if r != tDyn && r != tList && r != tMap {
+			return 0, fmt.Errorf("operator in expects list or map on the right, got %s", r)
+		}
+		return tBool, nil
+	case "+":
+		if dyn {
+			return tDyn, nil
+		}
+		switch {
+		case l == tNumber && r == tNumber:
+			return tNumber, nil
+		case l == tString && r == tString:
+			return tString, nil
+		case l == tList && r == tList:
+			return tList, nil
+		case l == tTime && r == tDuration, l == tDuration && r == tTime:
+			return tTime, nil
+		case l == tDuration && r == tDuration:
+			return tDuration, nil
+		}
+	case "-":
+		if dyn {
+			return tDyn, nil
+		}
+		switch {
+		case l == tNumber && r == tNumber:
+			return tNumber, nil
+		case l == tTime && r == tDuration:
+			return tTime, nil
+		case l == tTime && r == tTime, l == tDuration && r == tDuration:
+			return tDuration, nil
+		}
+	case "*", "/", "%":
+		if dyn || (l == tNumber && r == tNumber) {
+			return tNumber, nil
+		}
+	}
+	return 0, fmt.Errorf("operator %s not defined for %s and %s", op, l, r)
+}
+
+// ---- evaluator ----
+
+// missingFieldError reports an input field absent at evaluation time; partial evaluation keeps such
+// sub-expressions as residuals. path is empty when the field hangs off a bound quantifier variable.
+type missingFieldError struct{ path string }
+
+func (e *missingFieldError) Error() string { return fmt.Sprintf("no such field %q", e.path) }
+
+type exprEnv struct {
+	input map[string]any
+	vars  map[string]any
+	now   time.Time
+}
+
+func (env *exprEnv) with(name string, v any) *exprEnv {
+	vars := make(map[string]any, len(env.vars)+1)
+	for k, x := range env.vars {
+		vars[k] = x
+	}
+	vars[name] = v
+	return &exprEnv{input: env.input, vars: vars, now: env.now}
+}
+
+// path renders a field-access chain rooted at an input field ("request.items[0].sku"), or "" otherwise
+func (env *exprEnv) path(n exprNode) string {
+	switch x := n.(type) {
+	case *identNode:
+		if _, bound := env.vars[x.name]; bound {
+			return ""
+		}
+		return x.name
+	case *selectNode:
+		if p := env.path(x.x); p != "" {
+			return p + "." + x.field
+		}
+	case *indexNode:
+		if l, ok := x.idx.(*litNode); ok {
+			if p := env.path(x.x); p != "" {
+				if s, ok := l.v.(string); ok {
+					return p + "." + s
+				}
+				return fmt.Sprintf("%s[%v]", p, l.v)
+			}
+		}
+	}
+	return ""
+}
+
+func evalNode(n exprNode, env *exprEnv) (any, error) {
+	switch x := n.(type) {
+	case *litNode:
+		return x.v, nil
+	case *identNode:
+		if v, ok := env.vars[x.name]; ok {
+			return v, nil
+		}
+		v, ok := env.input[x.name]
+		if !ok {
+			return nil, &missingFieldError{path: x.name}
+		}
+		return normalizeValue(v), nil
+	case *selectNode:
+		base, err := evalNode(x.x, env)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := base.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("cannot select %q from %s", x.field, typeOfValue(base))
+		}
+		v, ok := m[x.field]
+		if !ok {
+			return nil, &missingFieldError{path: env.path(x)}
+		}
+		return normalizeValue(v), nil
+	case *indexNode:
+		base, err := evalNode(x.x, env)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := evalNode(x.idx, env)
+		if err != nil {
+			return nil, err
+		}
+		switch b := base.(type) {
+		case []any:
+			f, ok := idx.(float64)
+			if !ok || f != math.Trunc(f) || int(f) < 0 || int(f) >= len(b) {
+				return nil, fmt.Errorf("index %v out of range", idx)
+			}
+			return normalizeValue(b[int(f)]), nil
+		case map[string]any:
+			k, ok := idx.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key must be string")
+			}
+			v, ok := b[k]
+			if !ok {
+				return nil, &missingFieldError{path: env.path(x)}
+			}
+			return normalizeValue(v), nil
+		}
+		return nil, fmt.Errorf("cannot index %s", typeOfValue(base))
+	case *listNode:
+		out := make([]any, 0, len(x.items))
+		for _, it := range x.items {
+			v, err := evalNode(it, env)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, v)
+		}
+		return out, nil
+	case *unaryNode:
+		v, err := evalNode(x.x, env)
+		if err != nil {
+			return nil, err
+		}
+		if x.op == "!" {
+			b, ok := v.(bool)
+			if !ok {
+				return nil, fmt.Errorf("operator ! expects bool, got %s", typeOfValue(v))
+			}
+			return !b, nil
+		}
+		switch t := v.(type) {
+		case float64:
+			return -t, nil
+		case time.Duration:
+			return -t, nil
+		}
+		return nil, fmt.Errorf("unary - not defined for %s", typeOfValue(v))
+	case *binaryNode:
+		return evalBinary(x, env)
+	case *callNode:
+		return evalCall(x, env)
+	case *macroNode:
+		lv, err := evalNode(x.list, env)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := lv.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s() expects a list, got %s", x.fn, typeOfValue(lv))
+		}
+		for _, it := range list {
+			pv, err := evalNode(x.pred, env.with(x.v, normalizeValue(it)))
+			if err != nil {
+				return nil, err
+			}
+			b, ok := pv.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%s() predicate must be bool", x.fn)
+			}
+			if x.fn == "exists" && b {
+				return true, nil
+			}
+			if x.fn == "all" && !b {
+				return false, nil
+			}
+		}
+		return x.fn == "all", nil
+	}
+	return nil, fmt.Errorf("unsupported expression node")
+}
+
+func evalBinary(x *binaryNode, env *exprEnv) (any, error) {
+	// short-circuit logic; an error on the unevaluated side never flips the result
+	if x.op == "&&" || x.op == "||" {
+		l, err := evalNode(x.l, env)
+		if err != nil {
+			return nil, err
+		}
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects bool, got %s", x.op, typeOfValue(l))
+		}
+		if (x.op == "&&" && !lb) || (x.op == "||" && lb) {
+			return lb, nil
+		}
+		r, err := evalNode(x.r, env)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("operator %s expects bool, got %s", x.op, typeOfValue(r))
+		}
+		return rb, nil
+	}
+	l, err := evalNode(x.l, env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := evalNode(x.r, env)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "==":
+		return valuesEqual(l, r), nil
+	case "!=":
+		return !valuesEqual(l, r), nil
+	case "<", "<=", ">", ">=":
+		c, err := compareValues(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "in":
+		switch rv := r.(type) {
+		case []any:
+			for _, it := range rv {
+				if valuesEqual(l, normalizeValue(it)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			k, ok := l.(string)
+			if !ok {
+				return false, nil
+			}
+			_, has := rv[k]
+			return has, nil
+		}
+		return nil, fmt.Errorf("operator in expects list or map, got %s", typeOfValue(r))
+	}
+	return arith(x.op, l, r)
+}
+
+func arith(op string, l, r any) (any, error) {
+	switch a := l.(type) {
+	case float64:
+		if b, ok := r.(float64); ok {
+			switch op {
+			case "+":
+				return a + b, nil
+			case "-":
+				return a - b, nil
+			case "*":
+				return a * b, nil
+			case "/":
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				return a / b, nil
+			case "%":
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				return math.Mod(a, b), nil
+			}
+		}
+	case string:
+		if b, ok := r.(string); ok && op == "+" {
+			return a + b, nil
+		}
+	case []any:
+		if b, ok := r.([]any); ok && op == "+" {
+			return append(append([]any{}, a...), b...), nil
+		}
+	case time.Time:
+		switch b := r.(type) {
+		case time.Duration:
+			if op == "+" {
+				return a.Add(b), nil
+			}
+			if op == "-" {
+				return a.Add(-b), nil
+			}
+		case time.Time:
+			if op == "-" {
+				return a.Sub(b), nil
+			}
+		}
+	case time.Duration:
+		switch b := r.(type) {
+		case time.Duration:
+			if op == "+" {
+				return a + b, nil
+			}
+			if op == "-" {
+				return a - b, nil
+			}
+		case time.Time:
+			if op == "+" {
+				return b.Add(a), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("operator %s not defined for %s and %s", op, typeOfValue(l), typeOfValue(r))
+}
+
+func evalCall(x *callNode, env *exprEnv) (any, error) {
+	if x.fn == "has" {
+		_, err := evalNode(x.args[0], env)
+		return err == nil, nil
+	}
+	if x.fn == "now" {
+		return env.now, nil
+	}
+	args := make([]any, len(x.args))
+	for i, a := range x.args {
+		v, err := evalNode(a, env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	str := func(i int) (string, error) {
+		s, ok := args[i].(string)
+		if !ok {
+			return "", fmt.Errorf("%s() argument %d expects string, got %s", x.fn, i+1, typeOfValue(args[i]))
+		}
+		return s, nil
+	}
+	switch x.fn {
+	case "lower", "upper", "trim":
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		switch x.fn {
+		case "lower":
+			return strings.ToLower(s), nil
+		case "upper":
+			return strings.ToUpper(s), nil
+		}
+		return strings.TrimSpace(s), nil
+	case "startsWith", "endsWith", "contains", "matches", "glob":
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		p, err := str(1)
+		if err != nil {
+			return nil, err
+		}
+		switch x.fn {
+		case "startsWith":
+			return strings.HasPrefix(s, p), nil
+		case "endsWith":
+			return strings.HasSuffix(s, p), nil
+		case "contains":
+			return strings.Contains(s, p), nil
+		}
+		re := x.re
+		if re == nil {
+			if x.fn == "glob" {
+				re = globToRegexp(p)
+			} else if re, err = regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("matches(): invalid pattern: %v", err)
+			}
+		}
+		return re.MatchString(s), nil
+	case "size":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("size() not defined for %s", typeOfValue(args[0]))
+	case "timestamp":
+		if t, ok := args[0].(time.Time); ok {
+			return t, nil
+		}
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		return parseTimestamp(s)
+	case "duration":
+		if d, ok := args[0].(time.Duration); ok {
+			return d, nil
+		}
+		s, err := str(0)
+		if err != nil {
+			return nil, err
+		}
+		return parseDuration(s)
+	case "getDayOfWeek", "getHours", "getMinutes":
+		t, ok := args[0].(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("%s() expects a timestamp, got %s", x.fn, typeOfValue(args[0]))
+		}
+		tz, err := str(1)
+		if err != nil {
+			return nil, err
+		}
+		loc, err := time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): unknown time zone %q", x.fn, tz)
+		}
+		t = t.In(loc)
+		switch x.fn {
+		case "getDayOfWeek":
+			return float64(t.Weekday()), nil
+		case "getHours":
+			return float64(t.Hour()), nil
+		}
+		return float64(t.Minute()), nil
+	case "string":
+		switch v := args[0].(type) {
+		case string:
+			return v, nil
+		case time.Time:
+			return v.UTC().Format(time.RFC3339), nil
+		case time.Duration:
+			return v.String(), nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		}
+		return fmt.Sprintf("%v", args[0]), nil
+	case "number":
+		switch v := args[0].(type) {
+		case float64:
+			return v, nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("number(): %q is not numeric", v)
+			}
+			return f, nil
+		case time.Duration:
+			return v.Seconds(), nil
+		case bool:
+			if v {
+				return 1.0, nil
+			}
+			return 0.0, nil
+		}
+		return nil, fmt.Errorf("number() not defined for %s", typeOfValue(args[0]))
+	}
+	return nil, fmt.Errorf("unknown function %s()", x.fn)
+}
+
+// normalizeValue maps decoded JSON values onto the evaluator's value space (numbers as float64)
+func normalizeValue(v any) any {
+	switch t := v.(type) {
+	case int:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case float32:
+		return float64(t)
+	}
+	if f, ok := v.(interface{ Float64() (float64, error) }); ok {
+		if n, err := f.Float64(); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+func valuesEqual(a, b any) bool {
+	switch x := a.(type) {
+	case time.Time:
+		y, ok := b.(time.Time)
+		return ok && x.Equal(y)
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !valuesEqual(normalizeValue(x[i]), normalizeValue(y[i])) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		y, ok := b.(map[string]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !valuesEqual(normalizeValue(v), normalizeValue(w)) {
+				return false
+			}
+		}
+		return true
+	}
+	switch b.(type) {
+	case []any, map[string]any:
+		return false
+	}
+	return a == b
+}
+
+func compareValues(a, b any) (int, error) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return cmp3(x < y, x > y), nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return cmp3(x.Before(y), x.After(y)), nil
+		}
+	case time.Duration:
+		if y, ok := b.(time.Duration); ok {
+			return cmp3(x < y, x > y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s with %s", typeOfValue(a), typeOfValue(b))
+}
+
+func cmp3(less, greater bool) int {
+	if less {
+		return -1
+	}
+	if greater {
+		return 1
+	}
+	return 0
+}
+
+func typeOfValue(v any) exprType {
+	switch v.(type) {
+	case bool:
+		return tBool
+	case float64:
+		return tNumber
+	case string:
+		return tString
+	case time.Time:
+		return tTime
+	case time.Duration:
+		return tDuration
+	case []any:
+		return tList
+	case map[string]any:
+		return tMap
+	case nil:
+		return tNull
+	}
+	return tDyn
+}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp(): %q is not RFC3339 or YYYY-MM-DD", s)
+}
+
+// parseDuration accepts Go durations plus a day suffix ("7d", "1d12h")
+func parseDuration(s string) (time.Duration, error) {
+	var days time.Duration
+	if i := strings.Index(s, "d"); i > 0 {
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("duration(): invalid %q", s)
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		s = s[i+1:]
+		if s == "" {
+			return days, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("duration(): invalid %q", s)
+	}
+	return days + d, nil
+}
+
+// globToRegexp converts a shell-style glob (* and ?) into an anchored regexp
+func globToRegexp(g string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range g {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestExpr_Evaluate(t *testing.T) {
+	in := map[string]any{
+		"action":    "Deploy",
+		"resource":  "prod/db-1",
+		"request":   map[string]any{"amount": 250.0, "at": "2025-10-30T12:00:00Z", "tags": []any{"pii:email", "team:core"}},
+		"principal": map[string]any{"limit": 500.0, "roles": []any{"ops"}},
+		"items":     []any{map[string]any{"price": 10.0}, map[string]any{"price": 99.0}},
+	}
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`request.amount <= principal.limit`, true},
+		{`request.amount * 3 > principal.limit`, true},
+		{`lower(action) in ["deploy", "rollback"]`, true},
+		{`resource.startsWith("prod/") && !resource.endsWith("-2")`, true},
+		{`resource.matches("^prod/db-[0-9]+$")`, true},
+		{`resource.glob("staging/*")`, false},
+		{`request.tags.exists(t, t.glob("pii:*"))`, true},
+		{`items.all(i, i.price < 100)`, true},
+		{`all(items, i, i.price < 50)`, false},
+		{`size(principal.roles) == 1 && "ops" in principal.roles`, true},
+		{`timestamp(request.at) + duration("1d") > timestamp("2025-10-31T00:00:00Z")`, true},
+		{`timestamp("2025-10-31") - timestamp(request.at) <= duration("12h")`, true},
+		{`has(request.missing) || request.amount == 250`, true},
+		{`timestamp(request.at).getDayOfWeek("UTC") == 4 && timestamp(request.at).getHours("Asia/Kolkata") == 17`, true},
+		{`timestamp(request.at).getMinutes("Asia/Kolkata") == 30`, true},
+	}
+	for _, tc := range cases {
+		x, err := CompileExpr(tc.src)
+		if err != nil {
+			t.Fatalf("%s: compile: %v", tc.src, err)
+		}
+		got, err := x.Eval(in)
+		if err != nil {
+			t.Fatalf("%s: eval: %v", tc.src, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %v want %v", tc.src, got, tc.want)
+		}
+	}
+	// runtime type errors surface instead of silently matching
+	x, _ := CompileExpr(`request.amount > "100"`)
+	if _, err := x.Eval(in); err == nil {
+		t.Fatalf("expected runtime type error comparing number to string")
+	}
+}
+
+func TestExpr_CompileErrors(t *testing.T) {
+	bad := []string{
+		`request.amount <=`,
+		`"a" < 1`,
+		`lower(1)`,
+		`unknown(action)`,
+		`action.matches("([")`,
+		`duration("forever") > duration("1h")`,
+		`1 + 2`,
+		`startsWith(action)`,
+		`items.exists(i)`,
+		`now().getHours("Mars/Olympus") == 1`,
+	}
+	for _, src := range bad {
+		if _, err := CompileExpr(src); err == nil {
+			t.Fatalf("expected compile error for %s", src)
+		}
+	}
+}
+
+func TestAuraJSON_ExpressionRules(t *testing.T) {
+	body := `{"rules":[
+		{"id":"deny_over_limit","effect":"deny","when":"request.amount > principal.limit"},
+		{"id":"allow_prod","effect":"allow","when":{"and":[{"env":{"eq":"prod"}},{"expr":"action.startsWith('deploy')"}]}}
+	]}`
+	d := evalFor(t, body, `{"env":"prod","action":"deploy.app","request":{"amount":10},"principal":{"limit":100}}`)
+	if !d.Allow {
+		t.Fatalf("expected allow, got %+v", d)
+	}
+	d = evalFor(t, body, `{"env":"prod","action":"deploy.app","request":{"amount":1000},"principal":{"limit":100}}`)
+	if d.Allow || d.Reason != "Matched deny rule" {
+		t.Fatalf("expected deny over limit, got %+v", d)
+	}
+	// missing fields never match, even under not, and the error is kept on the rule trace
+	d = evalFor(t, `{"rules":[{"id":"r","effect":"allow","when":{"not":"request.amount > 5"}}]}`, `{}`)
+	if d.Allow || d.Trace.EvaluatedRules[0].Reason == "" {
+		t.Fatalf("expected non-match with reason, got %+v", d.Trace.EvaluatedRules)
+	}
+
+	e := &AuraJSONEvaluator{}
+	for _, b := range []string{
+		`{"rules":[{"id":"r","effect":"allow","when":"request.amount <"}]}`,
+		`{"rules":[{"id":"r","effect":"allow","when":{"expr":"lower(5) == 'x'"}}]}`,
+		`{"rules":[{"id":"r","effect":"allow","when":{"amount":{"gteq":5}}}]}`,
+		`{"rules":[{"id":"r","effect":"allow","when":{"or":{"a":{"eq":1}}}}]}`,
+	} {
+		if _, err := e.Compile(json.RawMessage(b)); err == nil {
+			t.Fatalf("expected compile error for %s", b)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -16,7 +17,14 @@ func (e *AuraJSONEvaluator) Name() string { return EngineAuraJSON }
 
 type compiledJSON struct {
 	Body   map[string]any
-	Schema map[string]any
+	Schema *InputSchema
+	// obligations/advice parsed per rule index at compile time
+	Obligations map[int][]Obligation
+	Advice      map[int][]Obligation
+	// approver groups named by require_approval rules, per rule index
+	Approvers map[int][]string
+	// expression strings found in `when` clauses, parsed and type-checked at compile time
+	Exprs map[string]*Expr
 }
 
 func (e *AuraJSONEvaluator) Compile(policyBody json.RawMessage) (CompiledPolicy, error) {
@@ -24,14 +32,63 @@ func (e *AuraJSONEvaluator) Compile(policyBody json.RawMessage) (CompiledPolicy,
 	if err := json.Unmarshal(policyBody, &m); err != nil {
 		return nil, fmt.Errorf("invalid policy body: %w", err)
 	}
-	var schema map[string]any
-	if s, ok := m["schema"].(map[string]any); ok {
+	var schema *InputSchema
+	if raw, ok := m["schema"]; ok && raw != nil {
+		s, err := CompileInputSchema(raw)
+		if err != nil {
+			return nil, err
+		}
 		schema = s
 	}
-	return &compiledJSON{Body: m, Schema: schema}, nil
+	cj := &compiledJSON{Body: m, Schema: schema, Obligations: map[int][]Obligation{}, Advice: map[int][]Obligation{}, Approvers: map[int][]string{}, Exprs: map[string]*Expr{}}
+	rules, _ := m["rules"].([]any)
+	for i, r := range rules {
+		rm, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		ruleID := fmt.Sprintf("%v", rm["id"])
+		if err := compileWhen(rm["when"], cj.Exprs); err != nil {
+			return nil, fmt.Errorf("rule %s: when: %w", ruleID, err)
+		}
+		obs, err := parseObligations(rm["obligations"], ruleID)
+		if err != nil {
+			return nil, err
+		}
+		adv, err := parseObligations(rm["advice"], ruleID)
+		if err != nil {
+			return nil, err
+		}
+		if raw, ok := rm["approvers"]; ok {
+			list, ok := raw.([]any)
+			if !ok {
+				return nil, fmt.Errorf("rule %s: approvers must be an array of group names", ruleID)
+			}
+			for _, a := range list {
+				name, ok := a.(string)
+				if !ok || name == "" {
+					return nil, fmt.Errorf("rule %s: approvers must be an array of group names", ruleID)
+				}
+				cj.Approvers[i] = append(cj.Approvers[i], name)
+			}
+		}
+		if len(obs) > 0 {
+			cj.Obligations[i] = obs
+		}
+		if len(adv) > 0 {
+			cj.Advice[i] = adv
+		}
+	}
+	return cj, nil
 }
 
 func (e *AuraJSONEvaluator) Evaluate(compiled CompiledPolicy, input json.RawMessage) (Decision, error) {
+	return e.EvaluateContext(context.Background(), compiled, input)
+}
+
+// EvaluateContext evaluates with the trust graph of ctx available to rel clauses (see WithRelations);
+// each rule trace records the checks its clauses made
+func (e *AuraJSONEvaluator) EvaluateContext(ctx context.Context, compiled CompiledPolicy, input json.RawMessage) (Decision, error) {
 	cj, ok := compiled.(*compiledJSON)
 	if !ok {
 		return Decision{}, fmt.Errorf("bad compiled policy type")
@@ -42,8 +99,8 @@ func (e *AuraJSONEvaluator) Evaluate(compiled CompiledPolicy, input json.RawMess
 
 	// optional schema validation
 	trace := &Trace{EvaluatedRules: []RuleTrace{}, At: time.Now(), Engine: e.Name(), InputContext: input}
-	if len(cj.Schema) > 0 {
-		if errs := validateAgainstSchema(input, cj.Schema); len(errs) > 0 {
+	if cj.Schema != nil {
+		if errs := cj.Schema.Validate(input); len(errs) > 0 {
 			trace.Validations = errs
 			d := Decision{Allow: false, Reason: "Schema validation failed", Trace: trace}
 			d.TraceID = hashDecision(input, cj.Body)
@@ -58,37 +115,56 @@ func (e *AuraJSONEvaluator) Evaluate(compiled CompiledPolicy, input json.RawMess
 		if v, ok := p["deny_overrides"].(bool); ok {
 			precedence["deny_overrides"] = v
 		}
+		// first_match: the first matching allow or deny rule decides, in rule order
+		if v, ok := p["first_match"].(bool); ok {
+			precedence["first_match"] = v
+		}
 	}
 
 	var allow bool
 	var requireApproval bool
 	reason := "No matching allow rule"
-	var hints []string
+	var hints, approvers []string
+	// obligations/advice are collected per effect; only those agreeing with the final outcome apply
+	obligations := map[string][]Obligation{}
+	advice := map[string][]Obligation{}
 
-	for _, r := range rules {
+	for i, r := range rules {
 		rm, ok := r.(map[string]any)
 		if !ok {
 			continue
 		}
 		effect := strings.ToLower(fmt.Sprintf("%v", rm["effect"]))
 		ruleID := fmt.Sprintf("%v", rm["id"])
-		when, _ := rm["when"].(map[string]any)
-		matched := evalExpr(in, when)
-		trace.EvaluatedRules = append(trace.EvaluatedRules, RuleTrace{RuleID: ruleID, Matched: matched, Effect: effect})
+		rctx, checked := RecordRelations(ctx)
+		matched, err := evalExpr(rctx, in, rm["when"], cj.Exprs)
+		rt := RuleTrace{RuleID: ruleID, Matched: matched, Effect: effect, Relations: checked()}
+		if err != nil {
+			rt.Reason = err.Error()
+		}
+		trace.EvaluatedRules = append(trace.EvaluatedRules, rt)
 		if matched {
+			if effect == "needs_approval" {
+				effect = "require_approval"
+			}
+			obligations[effect] = append(obligations[effect], cj.Obligations[i]...)
+			advice[effect] = append(advice[effect], cj.Advice[i]...)
 			if effect == "deny" {
 				allow = false
 				reason = "Matched deny rule"
-				if precedence["deny_overrides"] {
+				if precedence["deny_overrides"] || precedence["first_match"] {
 					break
 				}
 			}
 			if effect == "allow" {
 				allow = true
 				reason = "Matched allow rule"
+				if precedence["first_match"] {
+					break
+				}
 				// continue to see if a deny appears later and overrides when configured
 			}
-			if effect == "require_approval" || effect == "needs_approval" {
+			if effect == "require_approval" {
 				requireApproval = true
 				// Optional hint from rule
 				if h, ok := rm["hint"].(string); ok && h != "" {
@@ -96,6 +172,7 @@ func (e *AuraJSONEvaluator) Evaluate(compiled CompiledPolicy, input json.RawMess
 				} else {
 					hints = append(hints, "Human approval required")
 				}
+				approvers = appendUnique(approvers, cj.Approvers[i]...)
 				if !allow {
 					reason = "Requires human approval"
 				}
@@ -103,12 +180,44 @@ func (e *AuraJSONEvaluator) Evaluate(compiled CompiledPolicy, input json.RawMess
 		}
 	}
 
-	d := Decision{Allow: allow, Reason: reason, Trace: trace, RequireApproval: requireApproval, Hints: hints}
+	outcome := "deny"
+	if allow {
+		outcome = "allow"
+	} else if requireApproval {
+		outcome = "require_approval"
+	}
+	d := Decision{Allow: allow, Reason: reason, Trace: trace, RequireApproval: requireApproval, Hints: hints, Approvers: approvers}
+	// an allow that a matched approval rule also flags carries that rule's obligations and advice as well
+	if outcome == "allow" && requireApproval {
+		obligations[outcome] = append(obligations[outcome], obligations["require_approval"]...)
+		advice[outcome] = append(advice[outcome], advice["require_approval"]...)
+	}
+	d.Obligations = MergeObligations(obligations[outcome])
+	d.Advice = MergeObligations(advice[outcome])
+	trace.Obligations = d.Obligations
+	trace.Advice = d.Advice
 	d.TraceID = hashDecision(input, cj.Body)
 	d.Trace.DurationMS = time.Since(start).Milliseconds()
 	return d, nil
 }
 
+// appendUnique appends the values not already present in list
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, x := range list {
+			if x == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func hashDecision(input any, body any) string {
 	b1, _ := json.Marshal(input)
 	b2, _ := json.Marshal(body)
@@ -116,82 +225,187 @@ func hashDecision(input any, body any) string {
 	return hex.EncodeToString(h[:8])
 }
 
-// evalExpr supports {"and": [...]}, {"or": [...]}, {"not": expr}, operators on fields and array membership
-func evalExpr(input map[string]any, expr map[string]any) bool {
+// legacyOps are the operators accepted in field maps: {"field": {"op": value}}
+var legacyOps = map[string]bool{"eq": true, "neq": true, "gt": true, "gte": true, "lt": true, "lte": true, "in": true, "contains": true}
+
+// compileWhen walks a `when` tree, compiling expression strings into exprs and rejecting unknown operators
+func compileWhen(when any, exprs map[string]*Expr) error {
+	switch w := when.(type) {
+	case nil:
+		return nil
+	case string:
+		if _, ok := exprs[w]; ok {
+			return nil
+		}
+		x, err := CompileExpr(w)
+		if err != nil {
+			return err
+		}
+		exprs[w] = x
+		return nil
+	case map[string]any:
+		for k, v := range w {
+			switch k {
+			case "and", "or":
+				list, ok := v.([]any)
+				if !ok {
+					return fmt.Errorf("%s expects an array", k)
+				}
+				for _, e := range list {
+					if err := compileWhen(e, exprs); err != nil {
+						return err
+					}
+				}
+			case "not":
+				if v == nil {
+					return fmt.Errorf("not expects an expression")
+				}
+				if err := compileWhen(v, exprs); err != nil {
+					return err
+				}
+			case "expr":
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("expr expects a string")
+				}
+				if err := compileWhen(s, exprs); err != nil {
+					return err
+				}
+			case "rel":
+				if _, err := parseRelClause(v); err != nil {
+					return err
+				}
+			default:
+				ops, ok := v.(map[string]any)
+				if !ok {
+					continue
+				}
+				for op := range ops {
+					if !legacyOps[strings.ToLower(op)] {
+						return fmt.Errorf("field %q: unknown operator %q", k, op)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported when clause of type %T", when)
+}
+
+// evalExpr supports {"and": [...]}, {"or": [...]}, {"not": expr}, {"expr": "..."} or a bare expression
+// string, {"rel": {...}} trust graph checks, operators on fields and array membership. Expression errors
+// never match, including under not.
+func evalExpr(ctx context.Context, input map[string]any, when any, exprs map[string]*Expr) (bool, error) {
+	if when == nil {
+		return true, nil
+	}
+	if s, ok := when.(string); ok {
+		x := exprs[s]
+		if x == nil {
+			var err error
+			if x, err = CompileExpr(s); err != nil {
+				return false, err
+			}
+		}
+		return x.Eval(input)
+	}
+	expr, _ := when.(map[string]any)
 	if expr == nil {
-		return true
+		return true, nil
 	}
 	if v, ok := expr["and"].([]any); ok {
 		for _, e := range v {
-			if !evalExpr(input, asMap(e)) {
-				return false
+			ok, err := evalExpr(ctx, input, e, exprs)
+			if err != nil || !ok {
+				return false, err
 			}
 		}
-		return true
+		return true, nil
 	}
 	if v, ok := expr["or"].([]any); ok {
+		var firstErr error
 		for _, e := range v {
-			if evalExpr(input, asMap(e)) {
-				return true
+			ok, err := evalExpr(ctx, input, e, exprs)
+			if ok {
+				return true, nil
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
 			}
 		}
-		return false
+		return false, firstErr
 	}
-	if v, ok := expr["not"].(map[string]any); ok {
-		return !evalExpr(input, v)
+	if v, ok := expr["not"]; ok && v != nil {
+		ok, err := evalExpr(ctx, input, v, exprs)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	}
+	if s, ok := expr["expr"].(string); ok {
+		if matched, err := evalExpr(ctx, input, s, exprs); err != nil || !matched {
+			return false, err
+		}
+	}
+	if v, ok := expr["rel"]; ok {
+		c, err := parseRelClause(v)
+		if err != nil {
+			return false, err
+		}
+		if matched, err := c.eval(ctx, input); err != nil || !matched {
+			return false, err
+		}
 	}
 	// field ops: {"field": {"eq": 1, "in": [..]}}
 	for k, vv := range expr {
 		ops, ok := vv.(map[string]any)
-		if !ok {
+		if !ok || k == "rel" {
 			continue
 		}
 		val, has := pluck(input, k)
 		if !has {
-			return false
+			return false, nil
 		}
 		for op, rhs := range ops {
 			switch strings.ToLower(op) {
 			case "eq":
 				if fmt.Sprintf("%v", val) != fmt.Sprintf("%v", rhs) {
-					return false
+					return false, nil
 				}
 			case "neq":
 				if fmt.Sprintf("%v", val) == fmt.Sprintf("%v", rhs) {
-					return false
+					return false, nil
 				}
 			case "gt":
 				if !cmpNumber(val, rhs, ">") {
-					return false
+					return false, nil
 				}
 			case "gte":
 				if !cmpNumber(val, rhs, ">=") {
-					return false
+					return false, nil
 				}
 			case "lt":
 				if !cmpNumber(val, rhs, "<") {
-					return false
+					return false, nil
 				}
 			case "lte":
 				if !cmpNumber(val, rhs, "<=") {
-					return false
+					return false, nil
 				}
 			case "in":
 				if !inArray(val, rhs) {
-					return false
+					return false, nil
 				}
 			case "contains":
 				if !contains(val, rhs) {
-					return false
+					return false, nil
 				}
 			}
 		}
 	}
-	return true
+	return true, nil
 }
 
-func asMap(v any) map[string]any { m, _ := v.(map[string]any); return m }
-
 func pluck(m map[string]any, path string) (any, bool) {
 	parts := strings.Split(path, ".")
 	var cur any = m
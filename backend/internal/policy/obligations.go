@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Obligation is a machine-readable instruction attached to a decision. Obligations must be
+// enforced by the caller (tool wrapper/PEP); advice uses the same shape but is informational.
+//
+// Well-known types and how they combine when several matched rules contribute:
+//   - redact:     {"type":"redact","fields":["ssn","email"]}  -> union of fields
+//   - max_rows:   {"type":"max_rows","value":100}             -> smallest value wins
+//   - log_to:     {"type":"log_to","value":"siem"}            -> union of destinations
+//   - rate_limit: {"type":"rate_limit","value":"5/min"}       -> most restrictive rate wins
+//
+// Any other type is passed through and de-duplicated by value.
+type Obligation struct {
+	Type   string   `json:"type"`
+	Fields []string `json:"fields,omitempty"`
+	Value  any      `json:"value,omitempty"`
+	// Rules lists the rule IDs that contributed to this (merged) obligation
+	Rules []string `json:"rules,omitempty"`
+}
+
+const (
+	ObligationRedact    = "redact"
+	ObligationMaxRows   = "max_rows"
+	ObligationLogTo     = "log_to"
+	ObligationRateLimit = "rate_limit"
+)
+
+// ParseObligations validates an obligations/advice list produced outside AuraJSON rules (e.g. a Rego
+// decision document); source names the producer in error messages.
+func ParseObligations(raw any, source string) ([]Obligation, error) {
+	return parseObligations(raw, source)
+}
+
+// parseObligations reads a rule's "obligations"/"advice" list. Each entry must be an object with a
+// non-empty "type"; well-known types are shape-checked so malformed rules fail at compile time.
+func parseObligations(raw any, ruleID string) ([]Obligation, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("rule %s: obligations must be an array", ruleID)
+	}
+	out := make([]Obligation, 0, len(list))
+	for i, it := range list {
+		m, ok := it.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("rule %s: obligation %d must be an object", ruleID, i)
+		}
+		o := Obligation{Value: m["value"]}
+		o.Type, _ = m["type"].(string)
+		o.Type = strings.ToLower(strings.TrimSpace(o.Type))
+		if o.Type == "" {
+			return nil, fmt.Errorf("rule %s: obligation %d missing type", ruleID, i)
+		}
+		if fs, ok := m["fields"].([]any); ok {
+			for _, f := range fs {
+				s, ok := f.(string)
+				if !ok || s == "" {
+					return nil, fmt.Errorf("rule %s: obligation %d fields must be non-empty strings", ruleID, i)
+				}
+				o.Fields = append(o.Fields, s)
+			}
+		}
+		switch o.Type {
+		case ObligationRedact:
+			if len(o.Fields) == 0 {
+				return nil, fmt.Errorf("rule %s: redact obligation requires fields", ruleID)
+			}
+		case ObligationMaxRows:
+			if n, ok := toFloat(o.Value); !ok || n < 0 {
+				return nil, fmt.Errorf("rule %s: max_rows obligation requires a non-negative numeric value", ruleID)
+			}
+		case ObligationLogTo:
+			if len(stringList(o.Value)) == 0 {
+				return nil, fmt.Errorf("rule %s: log_to obligation requires a destination value", ruleID)
+			}
+		case ObligationRateLimit:
+			s, _ := o.Value.(string)
+			if _, err := parseRate(s); err != nil {
+				return nil, fmt.Errorf("rule %s: %w", ruleID, err)
+			}
+		}
+		if ruleID != "" {
+			o.Rules = []string{ruleID}
+		}
+		out = append(out, o)
+	}
+	return out, nil
+}
+
+// MergeObligations combines obligations contributed by several rules (or policies) into one entry
+// per type using the most restrictive interpretation. Output order is stable (sorted by type).
+func MergeObligations(in []Obligation) []Obligation {
+	if len(in) == 0 {
+		return nil
+	}
+	byType := map[string]*Obligation{}
+	// unknown types are kept per distinct value
+	custom := map[string]*Obligation{}
+	for _, o := range in {
+		switch o.Type {
+		case ObligationRedact, ObligationMaxRows, ObligationLogTo, ObligationRateLimit:
+			cur, ok := byType[o.Type]
+			if !ok {
+				cp := o
+				cp.Fields = append([]string(nil), o.Fields...)
+				cp.Rules = append([]string(nil), o.Rules...)
+				if o.Type == ObligationLogTo {
+					cp.Value = stringList(o.Value)
+				}
+				byType[o.Type] = &cp
+				continue
+			}
+			cur.Rules = append(cur.Rules, o.Rules...)
+			switch o.Type {
+			case ObligationRedact:
+				cur.Fields = append(cur.Fields, o.Fields...)
+			case ObligationMaxRows:
+				a, _ := toFloat(cur.Value)
+				if b, ok := toFloat(o.Value); ok && b < a {
+					cur.Value = o.Value
+				}
+			case ObligationLogTo:
+				cur.Value = append(cur.Value.([]string), stringList(o.Value)...)
+			case ObligationRateLimit:
+				a, _ := parseRate(fmt.Sprintf("%v", cur.Value))
+				if b, err := parseRate(fmt.Sprintf("%v", o.Value)); err == nil && b < a {
+					cur.Value = o.Value
+				}
+			}
+		default:
+			vb, _ := json.Marshal(struct {
+				V any      `json:"v"`
+				F []string `json:"f"`
+			}{o.Value, o.Fields})
+			k := o.Type + "|" + string(vb)
+			if cur, ok := custom[k]; ok {
+				cur.Rules = append(cur.Rules, o.Rules...)
+				continue
+			}
+			cp := o
+			cp.Rules = append([]string(nil), o.Rules...)
+			custom[k] = &cp
+		}
+	}
+	out := make([]Obligation, 0, len(byType)+len(custom))
+	for _, o := range byType {
+		o.Fields = uniqueSorted(o.Fields)
+		if o.Type == ObligationLogTo {
+			o.Value = uniqueSorted(o.Value.([]string))
+		}
+		o.Rules = uniqueSorted(o.Rules)
+		out = append(out, *o)
+	}
+	for _, o := range custom {
+		o.Rules = uniqueSorted(o.Rules)
+		out = append(out, *o)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Type != out[j].Type {
+			return out[i].Type < out[j].Type
+		}
+		vi, _ := json.Marshal(out[i].Value)
+		vj, _ := json.Marshal(out[j].Value)
+		return string(vi) < string(vj)
+	})
+	return out
+}
+
+// parseRate converts "N/unit" (unit: s|sec|second|m|min|minute|h|hour|d|day) into events per second.
+func parseRate(s string) (float64, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("rate_limit must look like N/unit (got %q)", s)
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("rate_limit count must be a non-negative number (got %q)", parts[0])
+	}
+	var per time.Duration
+	switch strings.ToLower(strings.TrimSpace(parts[1])) {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	case "d", "day":
+		per = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("rate_limit unit must be one of s|min|hour|day (got %q)", parts[1])
+	}
+	return n / per.Seconds(), nil
+}
+
+func stringList(v any) []string {
+	switch t := v.(type) {
+	case string:
+		if t == "" {
+			return nil
+		}
+		return []string{t}
+	case []string:
+		return append([]string(nil), t...)
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, x := range t {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func uniqueSorted(in []string) []string {
+	if len(in) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMergeObligations_MostRestrictive(t *testing.T) {
+	in := []Obligation{
+		{Type: ObligationRedact, Fields: []string{"ssn"}, Rules: []string{"r1"}},
+		{Type: ObligationRedact, Fields: []string{"email", "ssn"}, Rules: []string{"r2"}},
+		{Type: ObligationMaxRows, Value: float64(500), Rules: []string{"r1"}},
+		{Type: ObligationMaxRows, Value: float64(100), Rules: []string{"r2"}},
+		{Type: ObligationRateLimit, Value: "10/min", Rules: []string{"r1"}},
+		{Type: ObligationRateLimit, Value: "1/s", Rules: []string{"r2"}},
+		{Type: ObligationLogTo, Value: "siem", Rules: []string{"r1"}},
+		{Type: ObligationLogTo, Value: []any{"s3", "siem"}, Rules: []string{"r2"}},
+	}
+	out := MergeObligations(in)
+	if len(out) != 4 {
+		t.Fatalf("expected 4 merged obligations, got %d: %+v", len(out), out)
+	}
+	got := map[string]Obligation{}
+	for _, o := range out {
+		got[o.Type] = o
+	}
+	if r := got[ObligationRedact]; len(r.Fields) != 2 || r.Fields[0] != "email" || r.Fields[1] != "ssn" {
+		t.Fatalf("redact fields not unioned: %+v", r.Fields)
+	}
+	if v, _ := toFloat(got[ObligationMaxRows].Value); v != 100 {
+		t.Fatalf("expected max_rows 100, got %v", got[ObligationMaxRows].Value)
+	}
+	if got[ObligationRateLimit].Value != "10/min" {
+		t.Fatalf("expected slowest rate 10/min, got %v", got[ObligationRateLimit].Value)
+	}
+	if l, _ := got[ObligationLogTo].Value.([]string); len(l) != 2 {
+		t.Fatalf("expected two log destinations, got %v", got[ObligationLogTo].Value)
+	}
+	if rs := got[ObligationMaxRows].Rules; len(rs) != 2 {
+		t.Fatalf("expected contributing rules recorded, got %v", rs)
+	}
+}
+
+func TestAuraJSON_ObligationsFollowOutcome(t *testing.T) {
+	e := &AuraJSONEvaluator{}
+	body := json.RawMessage(`{"rules":[
+		{"id":"allow_q","effect":"allow","when":{"action":{"eq":"db.query"}},"obligations":[{"type":"max_rows","value":100}],"advice":[{"type":"log_to","value":"siem"}]},
+		{"id":"allow_pii","effect":"allow","when":{"pii":{"eq":true}},"obligations":[{"type":"redact","fields":["ssn"]},{"type":"max_rows","value":10}]},
+		{"id":"deny_prod","effect":"deny","when":{"env":{"eq":"prod"}},"obligations":[{"type":"log_to","value":"soc"}]}
+	]}`)
+	cp, err := e.Compile(body)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	dec, err := e.Evaluate(cp, json.RawMessage(`{"action":"db.query","pii":true}`))
+	if err != nil || !dec.Allow {
+		t.Fatalf("expected allow, got %+v err=%v", dec, err)
+	}
+	if len(dec.Obligations) != 2 || len(dec.Advice) != 1 {
+		t.Fatalf("unexpected obligations/advice: %+v / %+v", dec.Obligations, dec.Advice)
+	}
+	if dec.Trace == nil || len(dec.Trace.Obligations) != 2 {
+		t.Fatalf("obligations missing from trace")
+	}
+	dec, _ = e.Evaluate(cp, json.RawMessage(`{"action":"db.query","env":"prod"}`))
+	if dec.Allow || len(dec.Obligations) != 1 || dec.Obligations[0].Type != ObligationLogTo {
+		t.Fatalf("expected deny with deny-rule obligations only, got %+v", dec)
+	}
+}
+
+func TestAuraJSON_ApprovalRuleObligationsJoinAllow(t *testing.T) {
+	e := &AuraJSONEvaluator{}
+	cp, err := e.Compile(json.RawMessage(`{"rules":[
+		{"id":"allow_pay","effect":"allow","when":{"action":{"eq":"pay"}},"obligations":[{"type":"max_rows","value":100}]},
+		{"id":"big_pay","effect":"needs_approval","approvers":["finance"],"when":{"amount":{"gt":1000}},"obligations":[{"type":"log_to","value":"siem"}],"advice":[{"type":"log_to","value":"soc"}]}
+	]}`))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	dec, err := e.Evaluate(cp, json.RawMessage(`{"action":"pay","amount":5000}`))
+	if err != nil || !dec.Allow || !dec.RequireApproval {
+		t.Fatalf("expected allow flagged for approval, got %+v err=%v", dec, err)
+	}
+	if len(dec.Obligations) != 2 || len(dec.Advice) != 1 || len(dec.Approvers) != 1 {
+		t.Fatalf("expected the approval rule's obligations, advice and approvers kept, got %+v", dec)
+	}
+	d, _ := Combine(CombineDenyOverrides, []PolicyResult{{Decision: dec}})
+	if !d.Allow || !d.RequireApproval || len(d.Obligations) != 2 || len(d.Approvers) != 1 || d.Approvers[0] != "finance" {
+		t.Fatalf("combining must keep them, got %+v", d)
+	}
+}
+
+func TestAuraJSON_CompileRejectsMalformedObligations(t *testing.T) {
+	e := &AuraJSONEvaluator{}
+	bad := []string{
+		`{"rules":[{"id":"r","effect":"allow","obligations":{"type":"redact"}}]}`,
+		`{"rules":[{"id":"r","effect":"allow","obligations":[{"fields":["a"]}]}]}`,
+		`{"rules":[{"id":"r","effect":"allow","obligations":[{"type":"redact"}]}]}`,
+		`{"rules":[{"id":"r","effect":"allow","obligations":[{"type":"rate_limit","value":"5 per minute"}]}]}`,
+		`{"rules":[{"id":"r","effect":"allow","obligations":[{"type":"max_rows","value":"many"}]}]}`,
+	}
+	for _, b := range bad {
+		if _, err := e.Compile(json.RawMessage(b)); err == nil {
+			t.Fatalf("expected compile error for %s", b)
+		}
+	}
+}
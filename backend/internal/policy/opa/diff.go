@@ -0,0 +1,97 @@
+package opa
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Diff compares the rules of two Rego bodies by reference (data.<package>.<name>); the definitions of a
+// rule are compared as a set, so reordering them is no change. Access is not probed for Rego. An empty
+// before is an empty policy.
+func (e *Evaluator) Diff(before, after json.RawMessage) (policy.VersionDiff, error) {
+	b, bentry, err := regoRules(before)
+	if err != nil {
+		return policy.VersionDiff{}, fmt.Errorf("before: %w", err)
+	}
+	a, aentry, err := regoRules(after)
+	if err != nil {
+		return policy.VersionDiff{}, fmt.Errorf("after: %w", err)
+	}
+	d := policy.VersionDiff{Engine: policy.EngineRego, Rules: []policy.RuleChange{}, Access: []policy.AccessChange{},
+		Notes: []string{"access changes are probed for AuraJSON policies only"}}
+	refs := map[string]bool{}
+	for r := range b {
+		refs[r] = true
+	}
+	for r := range a {
+		refs[r] = true
+	}
+	names := make([]string, 0, len(refs))
+	for r := range refs {
+		names = append(names, r)
+	}
+	sort.Strings(names)
+	for _, r := range names {
+		bs, inB := b[r]
+		as, inA := a[r]
+		switch {
+		case !inA:
+			d.Rules = append(d.Rules, policy.RuleChange{RuleID: r, Change: policy.RuleRemoved, Before: bs})
+		case !inB:
+			d.Rules = append(d.Rules, policy.RuleChange{RuleID: r, Change: policy.RuleAdded, After: as})
+		case bs != as:
+			d.Rules = append(d.Rules, policy.RuleChange{RuleID: r, Change: policy.RuleModified, Before: bs, After: as})
+		}
+	}
+	if len(before) > 0 && bentry != aentry {
+		d.Other = append(d.Other, "entrypoint")
+	}
+	return d, nil
+}
+
+// regoRules parses the modules of a body into rule reference -> sorted definitions, and its entrypoint
+func regoRules(body json.RawMessage) (map[string]string, string, error) {
+	if len(body) == 0 {
+		return map[string]string{}, "", nil
+	}
+	var b regoBody
+	if err := json.Unmarshal(body, &b); err != nil {
+		return nil, "", err
+	}
+	if b.Module == "" {
+		return nil, "", ErrBadRegoModule
+	}
+	srcs := map[string]string{"policy.rego": b.Module}
+	for n, s := range b.Modules {
+		srcs[n] = s
+	}
+	defs := map[string][]string{}
+	entry := ""
+	for name, src := range srcs {
+		m, err := ast.ParseModule(name, src)
+		if err != nil {
+			return nil, "", err
+		}
+		if name == "policy.rego" {
+			entry = m.Package.Path.String()
+		}
+		for _, r := range m.Rules {
+			ref := m.Package.Path.String() + "." + r.Head.Ref().String()
+			defs[ref] = append(defs[ref], r.String())
+		}
+	}
+	if b.Entrypoint != "" {
+		entry = b.Entrypoint
+	}
+	out := make(map[string]string, len(defs))
+	for ref, list := range defs {
+		sort.Strings(list)
+		out[ref] = strings.Join(list, "\n")
+	}
+	return out, entry, nil
+}
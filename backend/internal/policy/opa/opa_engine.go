@@ -3,10 +3,19 @@ package opa
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage/inmem"
+	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/topdown/lineage"
+	"github.com/open-policy-agent/opa/types"
 )
 
 // Evaluator implements policy.Evaluator using OPA/Rego.
@@ -19,34 +28,167 @@ func OpaNew() policy.Evaluator { return &Evaluator{} }
 
 func (e *Evaluator) Name() string { return policy.EngineRego }
 
+// Explain modes, selected per policy with "explain" (default from AURA_OPA_EXPLAIN, else off)
+const (
+	ExplainOff   = "off"
+	ExplainNotes = "notes"
+	ExplainFails = "fails"
+	ExplainFull  = "full"
+)
+
 type compiled struct {
-	query rego.PreparedEvalQuery
+	query      rego.PreparedEvalQuery
+	modules    map[string]string
+	entrypoint string
+	// document is true when the entrypoint is a package (decision document) rather than a single rule
+	document bool
+	explain  string
+	data     map[string]any
+	schema   *policy.InputSchema
+}
+
+type regoBody struct {
+	Module     string            `json:"module"`
+	Modules    map[string]string `json:"modules,omitempty"`
+	Entrypoint string            `json:"entrypoint,omitempty"`
+	Explain    string            `json:"explain,omitempty"`
+	Schema     any               `json:"schema,omitempty"`
 }
 
-// Compile expects policyBody JSON with field "module" containing a Rego module string.
-// The module should define a package named 'aura' and a rule 'allow' boolean.
+// Compile expects policyBody JSON with field "module" containing a Rego module string, plus optional
+// "modules" (extra files by name), "entrypoint", "explain" and "schema" (JSON Schema 2020-12 for input).
+//
+// The entrypoint defaults to the package of "module". When it names a package, the package document is
+// read as a decision: allow, deny (bool or set of messages), require_approval (bool or set of hints),
+// approvers (approver group names), hints, reason, obligations and advice. When it names a single rule, its value is the allow boolean.
 func (e *Evaluator) Compile(policyBody json.RawMessage) (policy.CompiledPolicy, error) {
-	var m map[string]any
-	if err := json.Unmarshal(policyBody, &m); err != nil {
+	return e.CompileWithData(policyBody, nil)
+}
+
+// CompileWithData compiles the policy against an org's data documents, mounted under data.<name>
+func (e *Evaluator) CompileWithData(policyBody json.RawMessage, data map[string]any) (policy.CompiledPolicy, error) {
+	var b regoBody
+	if err := json.Unmarshal(policyBody, &b); err != nil {
 		return nil, err
 	}
-	mod, _ := m["module"].(string)
-	if mod == "" {
+	if b.Module == "" {
 		return nil, ErrBadRegoModule
 	}
-	r := rego.New(
-		rego.Module("policy.rego", mod),
-		rego.Query("data.aura.allow"),
-	)
-	pq, err := r.PrepareForEval(context.Background())
+	parsed, err := ast.ParseModule("policy.rego", b.Module)
+	if err != nil {
+		return nil, err
+	}
+	modules := map[string]string{"policy.rego": b.Module}
+	packages := map[string]bool{parsed.Package.Path.String(): true}
+	for name, src := range b.Modules {
+		if _, dup := modules[name]; dup {
+			return nil, &evalError{fmt.Sprintf("duplicate module name %q", name)}
+		}
+		m, err := ast.ParseModule(name, src)
+		if err != nil {
+			return nil, err
+		}
+		modules[name] = src
+		packages[m.Package.Path.String()] = true
+	}
+	entry := parsed.Package.Path.String()
+	if b.Entrypoint != "" {
+		if entry, err = normalizeEntrypoint(b.Entrypoint); err != nil {
+			return nil, err
+		}
+	}
+	explain := b.Explain
+	if explain == "" {
+		explain = os.Getenv("AURA_OPA_EXPLAIN")
+	}
+	switch explain {
+	case "", ExplainOff:
+		explain = ExplainOff
+	case ExplainNotes, ExplainFails, ExplainFull:
+	default:
+		return nil, &evalError{fmt.Sprintf("explain must be one of off, notes, fails, full (got %q)", explain)}
+	}
+
+	c := &compiled{modules: modules, entrypoint: entry, document: packages[entry], explain: explain, data: data}
+	pq, err := rego.New(append(c.options(), rego.Query(entry))...).PrepareForEval(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	return &compiled{query: pq}, nil
+	c.query = pq
+	if b.Schema != nil {
+		if c.schema, err = policy.CompileInputSchema(b.Schema); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// options returns the module and data options shared by evaluation and partial evaluation
+func (c *compiled) options() []func(*rego.Rego) {
+	names := make([]string, 0, len(c.modules))
+	for n := range c.modules {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	opts := make([]func(*rego.Rego), 0, len(names)+1)
+	for _, n := range names {
+		opts = append(opts, rego.Module(n, c.modules[n]))
+	}
+	if len(c.data) > 0 {
+		opts = append(opts, rego.Store(inmem.NewFromObject(c.data)))
+	}
+	return append(opts, rego.Function3(relBuiltin, relCheck))
+}
+
+// relBuiltin is aura.rel(subject, relation, object), a trust graph check such as
+// aura.rel(sprintf("agent:%s", [input.principal.agent_id]), "editor", input.resource). It is answered by
+// the checker of the evaluation context (policy.WithRelations); a failed check leaves the call undefined.
+// Partial evaluation keeps calls in the residual.
+var relBuiltin = &rego.Function{
+	Name:             "aura.rel",
+	Decl:             types.NewFunction(types.Args(types.S, types.S, types.S), types.B),
+	Memoize:          true,
+	Nondeterministic: true,
 }
 
-// Evaluate returns Allow true when the 'allow' rule evaluates truthy; Reason best-effort.
+func relCheck(bctx rego.BuiltinContext, subject, relation, object *ast.Term) (*ast.Term, error) {
+	var args [3]string
+	for i, t := range []*ast.Term{subject, relation, object} {
+		s, ok := t.Value.(ast.String)
+		if !ok {
+			return nil, fmt.Errorf("aura.rel: operand %d must be a string", i+1)
+		}
+		args[i] = string(s)
+	}
+	allowed, err := policy.CheckRelation(bctx.Context, args[0], args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return ast.BooleanTerm(allowed), nil
+}
+
+// normalizeEntrypoint accepts data.aura.guard, aura.guard or aura/guard
+func normalizeEntrypoint(s string) (string, error) {
+	s = strings.Trim(strings.ReplaceAll(strings.TrimSpace(s), "/", "."), ".")
+	if !strings.HasPrefix(s, "data.") && s != "data" {
+		s = "data." + s
+	}
+	ref, err := ast.ParseRef(s)
+	if err != nil {
+		return "", &evalError{fmt.Sprintf("invalid entrypoint %q: %v", s, err)}
+	}
+	return ref.String(), nil
+}
+
+// Evaluate evaluates the entrypoint and maps the result (decision document or allow boolean) to a Decision.
+// The trace records the decision keys as rules and, when enabled, OPA's explain output.
 func (e *Evaluator) Evaluate(comp policy.CompiledPolicy, input json.RawMessage) (policy.Decision, error) {
+	return e.EvaluateContext(context.Background(), comp, input)
+}
+
+// EvaluateContext evaluates with the trust graph of ctx available to aura.rel. Rules of a decision cannot
+// be told apart, so each rule trace lists every check the evaluation made.
+func (e *Evaluator) EvaluateContext(ctx context.Context, comp policy.CompiledPolicy, input json.RawMessage) (policy.Decision, error) {
 	c, ok := comp.(*compiled)
 	if !ok {
 		return policy.Decision{}, ErrBadCompiled
@@ -54,32 +196,268 @@ func (e *Evaluator) Evaluate(comp policy.CompiledPolicy, input json.RawMessage)
 	var in any
 	_ = json.Unmarshal(input, &in)
 	start := time.Now()
-	res, err := c.query.Eval(context.Background(), rego.EvalInput(in))
+	if c.schema != nil {
+		if errs := c.schema.Validate(input); len(errs) > 0 {
+			tr := &policy.Trace{InputContext: input, Validations: errs, DurationMS: time.Since(start).Milliseconds(), At: time.Now(), Engine: e.Name()}
+			return policy.Decision{Allow: false, Reason: "Schema validation failed", Trace: tr}, nil
+		}
+	}
+	opts := []rego.EvalOption{rego.EvalInput(in)}
+	var buf *topdown.BufferTracer
+	if c.explain != ExplainOff {
+		buf = topdown.NewBufferTracer()
+		opts = append(opts, rego.EvalQueryTracer(buf))
+	}
+	ctx, checked := policy.RecordRelations(ctx)
+	res, err := c.query.Eval(ctx, opts...)
+	if err != nil {
+		return policy.Decision{}, err
+	}
+	var value any
+	defined := len(res) > 0 && len(res[0].Expressions) > 0
+	if defined {
+		value = res[0].Expressions[0].Value
+	}
+	dec, err := decisionFrom(value, defined, c.document)
 	if err != nil {
 		return policy.Decision{}, err
 	}
-	allow := false
-	if len(res) > 0 && len(res[0].Expressions) > 0 {
-		if b, ok := res[0].Expressions[0].Value.(bool); ok {
-			allow = b
+	tr := dec.Trace
+	if rels := checked(); len(rels) > 0 {
+		for i := range tr.EvaluatedRules {
+			tr.EvaluatedRules[i].Relations = rels
+		}
+	}
+	tr.InputContext = input
+	tr.At = time.Now()
+	tr.Engine = e.Name()
+	if buf != nil {
+		tr.Explain = explainLines(*buf, c.explain)
+	}
+	tr.DurationMS = time.Since(start).Milliseconds()
+	tr.Obligations = dec.Obligations
+	tr.Advice = dec.Advice
+	return dec, nil
+}
+
+// decisionFrom maps an entrypoint value onto a Decision with per-key rule traces
+func decisionFrom(value any, defined, document bool) (policy.Decision, error) {
+	d := policy.Decision{Trace: &policy.Trace{EvaluatedRules: []policy.RuleTrace{}}}
+	if !defined {
+		d.Reason = "OPA deny (entrypoint undefined)"
+		return d, nil
+	}
+	doc, isDoc := value.(map[string]any)
+	if !document || !isDoc {
+		allow, _ := value.(bool)
+		d.Allow = allow
+		d.Reason = "OPA deny"
+		if allow {
+			d.Reason = "OPA allow"
+		}
+		d.Trace.EvaluatedRules = append(d.Trace.EvaluatedRules, policy.RuleTrace{RuleID: "allow", Matched: allow, Effect: "allow"})
+		return d, nil
+	}
+
+	allow, _ := doc["allow"].(bool)
+	denied, denyMsgs := flagOrMessages(doc["deny"])
+	approval, approvalMsgs := flagOrMessages(doc["require_approval"])
+	reason, _ := doc["reason"].(string)
+	d.Hints = stringsOf(doc["hints"])
+	var err error
+	if d.Obligations, err = policy.ParseObligations(doc["obligations"], "rego"); err != nil {
+		return policy.Decision{}, err
+	}
+	if d.Advice, err = policy.ParseObligations(doc["advice"], "rego"); err != nil {
+		return policy.Decision{}, err
+	}
+	d.Obligations = policy.MergeObligations(d.Obligations)
+	d.Advice = policy.MergeObligations(d.Advice)
+
+	if _, ok := doc["deny"]; ok {
+		d.Trace.EvaluatedRules = append(d.Trace.EvaluatedRules, policy.RuleTrace{RuleID: "deny", Matched: denied, Effect: "deny", Reason: strings.Join(denyMsgs, "; ")})
+	}
+	if _, ok := doc["require_approval"]; ok {
+		d.Trace.EvaluatedRules = append(d.Trace.EvaluatedRules, policy.RuleTrace{RuleID: "require_approval", Matched: approval, Effect: "require_approval", Reason: strings.Join(approvalMsgs, "; ")})
+	}
+	if _, ok := doc["allow"]; ok {
+		d.Trace.EvaluatedRules = append(d.Trace.EvaluatedRules, policy.RuleTrace{RuleID: "allow", Matched: allow, Effect: "allow"})
+	}
+
+	switch {
+	case denied:
+		d.Reason = "OPA deny"
+		if len(denyMsgs) > 0 {
+			d.Reason = strings.Join(denyMsgs, "; ")
+		}
+	case allow:
+		d.Allow = true
+		d.Reason = "OPA allow"
+	default:
+		d.Reason = "OPA deny"
+	}
+	if approval && !denied {
+		d.RequireApproval = true
+		if len(approvalMsgs) > 0 {
+			d.Hints = append(d.Hints, approvalMsgs...)
+		} else if len(d.Hints) == 0 {
+			d.Hints = append(d.Hints, "Human approval required")
+		}
+		if !allow {
+			d.Reason = "Requires human approval"
 		}
+		d.Approvers = stringsOf(doc["approvers"])
 	}
-	reason := "OPA allow"
-	if !allow {
-		reason = "OPA deny"
+	if reason != "" {
+		d.Reason = reason
+	}
+	return d, nil
+}
+
+// flagOrMessages reads a rule that is either a boolean or a set/array of messages (partial set rule)
+func flagOrMessages(v any) (bool, []string) {
+	switch t := v.(type) {
+	case bool:
+		return t, nil
+	case []any:
+		msgs := stringsOf(t)
+		sort.Strings(msgs)
+		return len(t) > 0, msgs
+	case string:
+		return t != "", []string{t}
+	}
+	return false, nil
+}
+
+func stringsOf(v any) []string {
+	l, _ := v.([]any)
+	out := make([]string, 0, len(l))
+	for _, x := range l {
+		if s, ok := x.(string); ok {
+			out = append(out, s)
+		} else if x != nil {
+			b, _ := json.Marshal(x)
+			out = append(out, string(b))
+		}
+	}
+	return out
+}
+
+// explainLines renders the buffered trace like `opa eval --explain=<mode>`
+func explainLines(buf topdown.BufferTracer, mode string) []string {
+	var events []*topdown.Event
+	switch mode {
+	case ExplainNotes:
+		events = lineage.Notes(buf)
+	case ExplainFails:
+		events = lineage.Fails(buf)
+	default:
+		events = lineage.Full(buf)
+	}
+	var sb strings.Builder
+	topdown.PrettyTraceWithLocation(&sb, events)
+	var out []string
+	for _, l := range strings.Split(sb.String(), "\n") {
+		if strings.TrimSpace(l) != "" {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Partial runs OPA partial evaluation of the decision with the given input paths unknown (default:
+// resource). Each residual query is one alternative condition under which the input is allowed: for a
+// decision document, allow holds and neither deny nor require_approval does, as Evaluate decides.
+func (e *Evaluator) Partial(comp policy.CompiledPolicy, known json.RawMessage, unknowns []string) (policy.PartialResult, error) {
+	c, ok := comp.(*compiled)
+	if !ok {
+		return policy.PartialResult{}, ErrBadCompiled
+	}
+	var in any
+	_ = json.Unmarshal(known, &in)
+	if len(unknowns) == 0 {
+		unknowns = []string{"resource"}
+	}
+	refs := make([]string, 0, len(unknowns))
+	for _, u := range unknowns {
+		refs = append(refs, "input."+u)
+	}
+	query := c.entrypoint + " == true"
+	if c.document {
+		query = c.entrypoint + ".allow == true"
+		for _, name := range []string{"deny", "require_approval"} {
+			term, err := c.flagTerm(name)
+			if err != nil {
+				return policy.PartialResult{}, err
+			}
+			if term != "" {
+				query += "; not " + term
+			}
+		}
+	}
+	opts := append(c.options(), rego.Query(query), rego.Input(in), rego.Unknowns(refs))
+	pq, err := rego.New(opts...).Partial(context.Background())
+	if err != nil {
+		return policy.PartialResult{}, err
+	}
+	res := policy.PartialResult{Outcome: policy.OutcomeDeny, Rules: []policy.ResidualRule{}}
+	for _, q := range pq.Queries {
+		if len(q) == 0 {
+			// unconditionally true
+			return policy.PartialResult{Outcome: policy.OutcomeAllow, Rules: []policy.ResidualRule{{RuleID: "allow", Effect: "allow"}}}, nil
+		}
+		res.Rules = append(res.Rules, policy.ResidualRule{RuleID: "allow", Effect: "allow", Condition: q.String()})
+	}
+	if len(res.Rules) > 0 {
+		res.Outcome = policy.OutcomeConditional
+		for _, m := range pq.Support {
+			res.Support = append(res.Support, m.String())
+		}
+	}
+	return res, nil
+}
+
+// flagTerm is the query term under which the decision document's deny or require_approval rule holds:
+// "count(<entry>.<name>) > 0" for a set of messages, "<entry>.<name> == true" for a boolean, "" when the package
+// does not define it. Rules with a literal non-boolean value cannot be partially evaluated faithfully.
+func (c *compiled) flagTerm(name string) (string, error) {
+	kind := ""
+	for file, src := range c.modules {
+		m, err := ast.ParseModule(file, src)
+		if err != nil {
+			return "", err
+		}
+		if m.Package.Path.String() != c.entrypoint {
+			continue
+		}
+		for _, r := range m.Rules {
+			if r.Head.Ref().String() != name {
+				continue
+			}
+			if r.Head.RuleKind() == ast.MultiValue {
+				kind = "set"
+				continue
+			}
+			switch r.Head.Value.Value.(type) {
+			case ast.String, *ast.Array, ast.Set, ast.Object:
+				return "", &evalError{fmt.Sprintf("partial evaluation needs %s to be a boolean or a set of messages", name)}
+			}
+			if kind == "" {
+				kind = "bool"
+			}
+		}
 	}
-	// Build minimal trace for explainability and UI diff
-	tr := &policy.Trace{
-		InputContext: input,
-		DurationMS:   time.Since(start).Milliseconds(),
-		At:           time.Now(),
-		Engine:       e.Name(),
+	switch kind {
+	case "set":
+		return "count(" + c.entrypoint + "." + name + ") > 0", nil
+	case "bool":
+		return c.entrypoint + "." + name + " == true", nil
 	}
-	return policy.Decision{Allow: allow, Reason: reason, Trace: tr}, nil
+	return "", nil
 }
 
 var (
-	ErrBadRegoModule = &evalError{"policy body must include a 'module' string with package aura and allow rule"}
+	ErrBadRegoModule = &evalError{"policy body must include a 'module' string"}
 	ErrBadCompiled   = &evalError{"invalid compiled policy type"}
 )
 
@@ -0,0 +1,252 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+)
+
+func regoBodyJSON(t *testing.T, fields map[string]any) json.RawMessage {
+	t.Helper()
+	b, err := json.Marshal(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func evalRego(t *testing.T, body json.RawMessage, data map[string]any, input string) policy.Decision {
+	t.Helper()
+	e := &Evaluator{}
+	cp, err := e.CompileWithData(body, data)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	d, err := e.Evaluate(cp, json.RawMessage(input))
+	if err != nil {
+		t.Fatalf("evaluate: %v", err)
+	}
+	return d
+}
+
+const guardModule = `package aura.guard
+
+import rego.v1
+
+default allow := false
+
+allow if input.action == "read"
+allow if input.action == "transfer"
+
+deny contains "amount over limit" if input.amount > data.limits.max_amount
+
+require_approval contains "Transfers need a second approver" if input.action == "transfer"
+
+hints contains "use the read-only replica" if input.action == "read"
+
+obligations contains {"type": "log_to", "value": "siem"} if allow
+`
+
+func TestRego_DecisionDocument(t *testing.T) {
+	body := regoBodyJSON(t, map[string]any{"module": guardModule})
+	data := map[string]any{"limits": map[string]any{"max_amount": 100}}
+
+	d := evalRego(t, body, data, `{"action":"read","amount":1}`)
+	if !d.Allow || d.RequireApproval || len(d.Hints) != 1 || len(d.Obligations) != 1 || d.Obligations[0].Type != "log_to" {
+		t.Fatalf("read: unexpected decision %+v", d)
+	}
+
+	d = evalRego(t, body, data, `{"action":"transfer","amount":1}`)
+	if !d.Allow || !d.RequireApproval || len(d.Hints) != 1 || d.Hints[0] != "Transfers need a second approver" {
+		t.Fatalf("transfer: unexpected decision %+v", d)
+	}
+
+	d = evalRego(t, body, data, `{"action":"transfer","amount":500}`)
+	if d.Allow || d.RequireApproval || d.Reason != "amount over limit" {
+		t.Fatalf("over limit: unexpected decision %+v", d)
+	}
+	rules := map[string]bool{}
+	for _, r := range d.Trace.EvaluatedRules {
+		rules[r.RuleID] = r.Matched
+	}
+	if !rules["deny"] || !rules["require_approval"] || !rules["allow"] {
+		t.Fatalf("expected deny/require_approval/allow rule traces, got %+v", d.Trace.EvaluatedRules)
+	}
+
+	// without the data bundle the deny rule is undefined
+	if d := evalRego(t, body, nil, `{"action":"transfer","amount":500}`); !d.Allow {
+		t.Fatalf("expected allow without data, got %+v", d)
+	}
+}
+
+func TestRego_Entrypoints(t *testing.T) {
+	mod := "package acme.authz\n\nimport rego.v1\n\nallow if input.user == \"alice\"\n\nis_admin if input.user == \"root\"\n"
+	for _, ep := range []string{"", "acme.authz", "data.acme.authz", "acme/authz"} {
+		d := evalRego(t, regoBodyJSON(t, map[string]any{"module": mod, "entrypoint": ep}), nil, `{"user":"alice"}`)
+		if !d.Allow {
+			t.Fatalf("entrypoint %q: expected allow, got %+v", ep, d)
+		}
+	}
+	body := regoBodyJSON(t, map[string]any{"module": mod, "entrypoint": "acme.authz.is_admin"})
+	if d := evalRego(t, body, nil, `{"user":"alice"}`); d.Allow {
+		t.Fatalf("is_admin rule entrypoint: expected deny, got %+v", d)
+	}
+	if d := evalRego(t, body, nil, `{"user":"root"}`); !d.Allow {
+		t.Fatalf("is_admin rule entrypoint: expected allow, got %+v", d)
+	}
+	// extra modules may hold the entrypoint package
+	lib := "package acme.lib\n\nimport rego.v1\n\nallow if data.acme.authz.allow\n"
+	body = regoBodyJSON(t, map[string]any{"module": mod, "modules": map[string]string{"lib.rego": lib}, "entrypoint": "acme.lib"})
+	if d := evalRego(t, body, nil, `{"user":"alice"}`); !d.Allow {
+		t.Fatalf("module entrypoint: expected allow, got %+v", d)
+	}
+	if d := evalRego(t, body, nil, `{"user":"bob"}`); d.Allow || d.Reason != "OPA deny" {
+		t.Fatalf("module entrypoint: expected deny, got %+v", d)
+	}
+}
+
+func TestRego_Explain(t *testing.T) {
+	mod := "package aura\n\nimport rego.v1\n\nallow if {\n\ttrace(\"checking level\")\n\tinput.level >= 3\n}\n"
+	d := evalRego(t, regoBodyJSON(t, map[string]any{"module": mod, "explain": "notes"}), nil, `{"level":5}`)
+	if !d.Allow || !strings.Contains(strings.Join(d.Trace.Explain, "\n"), "checking level") {
+		t.Fatalf("expected note in explain, got %q", d.Trace.Explain)
+	}
+	d = evalRego(t, regoBodyJSON(t, map[string]any{"module": mod, "explain": "fails"}), nil, `{"level":1}`)
+	if d.Allow || len(d.Trace.Explain) == 0 {
+		t.Fatalf("expected failure explain, got %+v", d.Trace)
+	}
+	if d := evalRego(t, regoBodyJSON(t, map[string]any{"module": mod}), nil, `{"level":5}`); len(d.Trace.Explain) != 0 {
+		t.Fatalf("explain should be off by default")
+	}
+	if _, err := (&Evaluator{}).Compile(regoBodyJSON(t, map[string]any{"module": mod, "explain": "verbose"})); err == nil {
+		t.Fatalf("expected invalid explain mode error")
+	}
+}
+
+func TestRego_PartialUsesEntrypointAndData(t *testing.T) {
+	mod := "package acme.authz\n\nimport rego.v1\n\nallow if {\n\tinput.action == data.cfg.action\n\tstartswith(input.resource, \"docs/\")\n}\n"
+	e := &Evaluator{}
+	cp, err := e.CompileWithData(regoBodyJSON(t, map[string]any{"module": mod}), map[string]any{"cfg": map[string]any{"action": "read"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := e.Partial(cp, json.RawMessage(`{"action":"read"}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Outcome != policy.OutcomeConditional || len(res.Rules) != 1 || !strings.Contains(res.Rules[0].Condition.(string), `"docs/"`) {
+		t.Fatalf("expected conditional residual, got %+v", res)
+	}
+	if res, _ := e.Partial(cp, json.RawMessage(`{"action":"write"}`), nil); res.Outcome != policy.OutcomeDeny {
+		t.Fatalf("expected deny, got %+v", res)
+	}
+}
+
+func TestRego_PartialHonoursDenyAndApproval(t *testing.T) {
+	e := &Evaluator{}
+	cp, err := e.CompileWithData(regoBodyJSON(t, map[string]any{"module": guardModule}), map[string]any{"limits": map[string]any{"max_amount": 100}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := e.Partial(cp, json.RawMessage(`{"action":"read","amount":1}`), nil); err != nil || res.Outcome != policy.OutcomeAllow {
+		t.Fatalf("read: %+v %v", res, err)
+	}
+	// verify denies over the limit and sends every transfer for approval
+	for _, in := range []string{`{"action":"read","amount":500}`, `{"action":"transfer","amount":1}`} {
+		if res, err := e.Partial(cp, json.RawMessage(in), nil); err != nil || res.Outcome != policy.OutcomeDeny {
+			t.Fatalf("%s: %+v %v", in, res, err)
+		}
+	}
+	res, err := e.Partial(cp, json.RawMessage(`{"action":"read"}`), []string{"amount"})
+	if err != nil || res.Outcome != policy.OutcomeConditional || !strings.Contains(res.Rules[0].Condition.(string)+strings.Join(res.Support, "\n"), "input.amount") {
+		t.Fatalf("unknown amount: %+v %v", res, err)
+	}
+	// a deny rule valued with a message string cannot be read as a condition
+	mod := "package p\n\nimport rego.v1\n\nallow := true\n\ndeny := \"no\" if input.x\n"
+	cp, err = e.Compile(regoBodyJSON(t, map[string]any{"module": mod}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Partial(cp, json.RawMessage(`{}`), nil); err == nil {
+		t.Fatal("expected an error for a string-valued deny")
+	}
+}
+
+func TestRego_RelBuiltin(t *testing.T) {
+	mod := "package aura.docs\n\nimport rego.v1\n\ndefault allow := false\n\nagent := sprintf(\"agent:%s\", [input.principal.agent_id])\n\nallow if {\n\tinput.action == \"write\"\n\taura.rel(agent, \"editor\", input.resource)\n}\n\nallow if {\n\tinput.action == \"read\"\n\taura.rel(agent, \"editor\", input.resource)\n\taura.rel(agent, \"editor\", input.resource)\n}\n"
+	e := &Evaluator{}
+	cp, err := e.Compile(regoBodyJSON(t, map[string]any{"module": mod}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	ctx := policy.WithRelations(context.Background(), func(ctx context.Context, subject, relation, object string) (policy.GraphTrace, error) {
+		calls++
+		if object == "doc:down" {
+			return policy.GraphTrace{}, errors.New("graph unavailable")
+		}
+		perm := "denied"
+		if subject == "agent:a1" && relation == "editor" && object == "doc:d1" {
+			perm = "allowed"
+		}
+		return policy.GraphTrace{Permissionship: perm}, nil
+	})
+	d, err := e.EvaluateContext(ctx, cp, json.RawMessage(`{"action":"read","resource":"doc:d1","principal":{"agent_id":"a1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allow || calls != 1 {
+		t.Fatalf("expected a memoized allow, got %+v after %d calls", d, calls)
+	}
+	if rs := d.Trace.EvaluatedRules[0].Relations; len(rs) != 1 || rs[0].Object != "doc:d1" || rs[0].Permissionship != "allowed" {
+		t.Fatalf("the rule trace must record the check: %+v", d.Trace.EvaluatedRules)
+	}
+	// failed checks and evaluations without a graph leave the call undefined
+	if d, err := e.EvaluateContext(ctx, cp, json.RawMessage(`{"action":"write","resource":"doc:down","principal":{"agent_id":"a1"}}`)); err != nil || d.Allow {
+		t.Fatalf("failed check: %+v %v", d, err)
+	}
+	if d := evalRego(t, regoBodyJSON(t, map[string]any{"module": mod}), nil, `{"action":"write","resource":"doc:d1","principal":{"agent_id":"a1"}}`); d.Allow {
+		t.Fatalf("without a graph: %+v", d)
+	}
+	// partial evaluation keeps the check in the residual
+	res, err := e.Partial(cp, json.RawMessage(`{"action":"write","principal":{"agent_id":"a1"}}`), nil)
+	if err != nil || res.Outcome != policy.OutcomeConditional || !strings.Contains(res.Rules[0].Condition.(string), "aura.rel") {
+		t.Fatalf("partial: %+v %v", res, err)
+	}
+}
+
+func TestRego_Diff(t *testing.T) {
+	next := strings.Replace(guardModule, `allow if input.action == "transfer"`, `allow if input.action == "list"`, 1)
+	next = strings.Replace(next, "hints contains \"use the read-only replica\" if input.action == \"read\"\n", "", 1)
+	next += "\nadvice contains {\"type\": \"notify\", \"value\": \"owner\"} if allow\n"
+	// reordering the definitions of a rule is no change
+	next = strings.Replace(next, "default allow := false\n", "", 1) + "\ndefault allow := false\n"
+	d, err := (&Evaluator{}).Diff(regoBodyJSON(t, map[string]any{"module": guardModule}), regoBodyJSON(t, map[string]any{"module": next, "entrypoint": "data.aura.guard.allow"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, r := range d.Rules {
+		got[r.RuleID] = r.Change
+	}
+	want := map[string]string{"data.aura.guard.allow": policy.RuleModified, "data.aura.guard.hints": policy.RuleRemoved, "data.aura.guard.advice": policy.RuleAdded}
+	if len(got) != len(want) {
+		t.Fatalf("rules: %+v", d.Rules)
+	}
+	for ref, ch := range want {
+		if got[ref] != ch {
+			t.Errorf("%s: want %s, got %s", ref, ch, got[ref])
+		}
+	}
+	if len(d.Other) != 1 || d.Other[0] != "entrypoint" {
+		t.Errorf("other: %v", d.Other)
+	}
+	first, err := (&Evaluator{}).Diff(nil, regoBodyJSON(t, map[string]any{"module": guardModule}))
+	if err != nil || len(first.Rules) != 5 || len(first.Other) != 0 {
+		t.Errorf("first version: %+v %v", first, err)
+	}
+}
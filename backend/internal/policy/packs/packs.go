@@ -0,0 +1,349 @@
+// Package packs holds the policy pack catalogue: versioned policy templates that install as ordinary
+// policies. A pack is a policy body with ${param} placeholders, its parameters and the tests the rendered
+// policy must pass.
+//
+// The built-in catalogue is embedded from catalog/*.json; AURA_POLICY_PACKS_DIR adds (or overrides, by id)
+// packs from a directory at startup.
+package packs
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Armour007/aura-backend/internal/policy/bundle"
+)
+
+//go:embed catalog/*.json
+var builtin embed.FS
+
+// Parameter types
+const (
+	ParamNumber     = "number"
+	ParamString     = "string"
+	ParamBoolean    = "boolean"
+	ParamStringList = "string_list"
+)
+
+// Param is a pack parameter; one without a default is required
+type Param struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Default     any      `json:"default,omitempty"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Pack is a catalogue entry. Version is bumped whenever the body or tests change; Changes says what the
+// current version changed, for upgrade notifications.
+type Pack struct {
+	ID          string            `json:"id"`
+	Version     int               `json:"version"`
+	Name        string            `json:"name"`
+	Industry    string            `json:"industry"`
+	Description string            `json:"description"`
+	Changes     string            `json:"changes,omitempty"`
+	Engine      string            `json:"engine"`
+	Actions     []string          `json:"actions"`
+	Params      []Param           `json:"params,omitempty"`
+	Body        json.RawMessage   `json:"body"`
+	Tests       []bundle.TestCase `json:"tests"`
+}
+
+// Rendered is a pack with its parameters applied
+type Rendered struct {
+	Body   json.RawMessage   `json:"body"`
+	Tests  []bundle.TestCase `json:"tests"`
+	Params map[string]any    `json:"params"`
+}
+
+var catalog = func() []Pack {
+	list, err := loadDir(builtin, "catalog")
+	if err != nil {
+		panic("policy packs: " + err.Error())
+	}
+	if dir := os.Getenv("AURA_POLICY_PACKS_DIR"); dir != "" {
+		extra, err := loadDir(os.DirFS(dir), ".")
+		if err != nil {
+			log.Printf("policy packs: %s: %v", dir, err)
+		}
+		list = merge(list, extra)
+	}
+	return list
+}()
+
+// All returns the catalogue, sorted by id
+func All() []Pack { return catalog }
+
+// Get returns a pack of the catalogue
+func Get(id string) (Pack, bool) {
+	for _, p := range catalog {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return Pack{}, false
+}
+
+func loadDir(fsys fs.FS, dir string) ([]Pack, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []Pack
+	for _, n := range names {
+		raw, err := fs.ReadFile(fsys, n)
+		if err != nil {
+			return nil, err
+		}
+		p, err := Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", n, err)
+		}
+		out = append(out, p)
+	}
+	return merge(nil, out), nil
+}
+
+// merge adds packs to list, replacing those with the same id, and sorts by id
+func merge(list, packs []Pack) []Pack {
+	byID := map[string]Pack{}
+	for _, p := range append(list, packs...) {
+		byID[p.ID] = p
+	}
+	out := make([]Pack, 0, len(byID))
+	for _, p := range byID {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Parse decodes and checks a pack definition; it does not compile the body (see Render)
+func Parse(raw []byte) (Pack, error) {
+	var p Pack
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return Pack{}, err
+	}
+	switch {
+	case p.ID == "":
+		return Pack{}, errors.New("id required")
+	case p.Version < 1:
+		return Pack{}, errors.New("version must be a positive integer")
+	case p.Engine == "":
+		return Pack{}, errors.New("engine required")
+	case len(p.Body) == 0:
+		return Pack{}, errors.New("body required")
+	case len(p.Tests) == 0:
+		return Pack{}, errors.New("a pack needs tests")
+	}
+	seen := map[string]bool{}
+	for _, prm := range p.Params {
+		if prm.Name == "" || seen[prm.Name] {
+			return Pack{}, fmt.Errorf("param %q: missing or duplicate name", prm.Name)
+		}
+		seen[prm.Name] = true
+		if prm.Default != nil {
+			if _, err := prm.check(prm.Default); err != nil {
+				return Pack{}, fmt.Errorf("param %s: default: %w", prm.Name, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// check converts a value to the parameter's type and checks its bounds
+func (prm Param) check(v any) (any, error) {
+	switch prm.Type {
+	case ParamNumber:
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errors.New("must be a number")
+		}
+		if (prm.Min != nil && f < *prm.Min) || (prm.Max != nil && f > *prm.Max) {
+			return nil, fmt.Errorf("%v is out of range", f)
+		}
+		return f, nil
+	case ParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return nil, errors.New("must be a string")
+	case ParamBoolean:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, errors.New("must be a boolean")
+	case ParamStringList:
+		list, ok := v.([]any)
+		if !ok {
+			return nil, errors.New("must be an array of strings")
+		}
+		for _, x := range list {
+			if _, ok := x.(string); !ok {
+				return nil, errors.New("must be an array of strings")
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", prm.Type)
+}
+
+// Resolve checks values against the pack's parameters and fills in defaults
+func (p Pack) Resolve(values map[string]any) (map[string]any, error) {
+	out := map[string]any{}
+	known := map[string]bool{}
+	for _, prm := range p.Params {
+		known[prm.Name] = true
+		v, ok := values[prm.Name]
+		if !ok || v == nil {
+			if prm.Default == nil {
+				return nil, fmt.Errorf("param %s is required", prm.Name)
+			}
+			v = prm.Default
+		}
+		cv, err := prm.check(v)
+		if err != nil {
+			return nil, fmt.Errorf("param %s %w", prm.Name, err)
+		}
+		out[prm.Name] = cv
+	}
+	for name := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown param %s", name)
+		}
+	}
+	return out, nil
+}
+
+// Carry returns the stored parameter values of an earlier install that this pack version still declares,
+// and the names of those it dropped or renamed, so an upgrade is not refused for parameters that are gone
+func (p Pack) Carry(stored map[string]any) (map[string]any, []string) {
+	declared := map[string]bool{}
+	for _, prm := range p.Params {
+		declared[prm.Name] = true
+	}
+	out := map[string]any{}
+	var dropped []string
+	for name, v := range stored {
+		if declared[name] {
+			out[name] = v
+		} else {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	return out, dropped
+}
+
+// Render applies parameter values (defaults filled in) to the body and tests
+func (p Pack) Render(values map[string]any) (Rendered, error) {
+	params, err := p.Resolve(values)
+	if err != nil {
+		return Rendered{}, err
+	}
+	var body any
+	if err := json.Unmarshal(p.Body, &body); err != nil {
+		return Rendered{}, fmt.Errorf("body: %w", err)
+	}
+	if body, err = substitute(body, params); err != nil {
+		return Rendered{}, fmt.Errorf("body: %w", err)
+	}
+	r := Rendered{Params: params, Tests: make([]bundle.TestCase, 0, len(p.Tests))}
+	r.Body, _ = json.Marshal(body)
+	for i, tc := range p.Tests {
+		var in any
+		if err := json.Unmarshal(tc.Input, &in); err != nil {
+			return Rendered{}, fmt.Errorf("test %d: %w", i, err)
+		}
+		if in, err = substitute(in, params); err != nil {
+			return Rendered{}, fmt.Errorf("test %d: %w", i, err)
+		}
+		tc.Input, _ = json.Marshal(in)
+		r.Tests = append(r.Tests, tc)
+	}
+	return r, nil
+}
+
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?:\[(\d+)\])?\}`)
+
+// substitute replaces ${name} and ${name[i]} in strings: a string that is only a placeholder takes the
+// parameter's value and type, others get it interpolated
+func substitute(v any, params map[string]any) (any, error) {
+	switch x := v.(type) {
+	case map[string]any:
+		for k, e := range x {
+			s, err := substitute(e, params)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = s
+		}
+		return x, nil
+	case []any:
+		for i, e := range x {
+			s, err := substitute(e, params)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = s
+		}
+		return x, nil
+	case string:
+		if m := placeholder.FindStringSubmatchIndex(x); m != nil && m[0] == 0 && m[1] == len(x) {
+			index := ""
+			if m[4] >= 0 {
+				index = x[m[4]:m[5]]
+			}
+			return lookup(x[m[2]:m[3]], index, params)
+		}
+		var err error
+		out := placeholder.ReplaceAllStringFunc(x, func(ph string) string {
+			sm := placeholder.FindStringSubmatch(ph)
+			val, e := lookup(sm[1], sm[2], params)
+			if e != nil {
+				err = e
+				return ph
+			}
+			if f, ok := val.(float64); ok {
+				return strconv.FormatFloat(f, 'f', -1, 64)
+			}
+			if l, ok := val.([]any); ok {
+				parts := make([]string, len(l))
+				for i, s := range l {
+					parts[i] = fmt.Sprint(s)
+				}
+				return strings.Join(parts, ", ")
+			}
+			return fmt.Sprint(val)
+		})
+		return out, err
+	}
+	return v, nil
+}
+
+func lookup(name, index string, params map[string]any) (any, error) {
+	v, ok := params[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown param ${%s}", name)
+	}
+	if index == "" {
+		return v, nil
+	}
+	list, _ := v.([]any)
+	i, _ := strconv.Atoi(index)
+	if i >= len(list) {
+		return nil, fmt.Errorf("${%s[%s]}: index out of range", name, index)
+	}
+	return list[i], nil
+}
@@ -0,0 +1,175 @@
+package packs
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+	"github.com/Armour007/aura-backend/internal/policy/bundle"
+)
+
+// runTests evaluates rendered tests the way installs do, returning the failures
+func runTests(t *testing.T, r Rendered) []string {
+	t.Helper()
+	e := &policy.AuraJSONEvaluator{}
+	cp, err := e.Compile(r.Body)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	var failed []string
+	for _, tc := range r.Tests {
+		dec, err := e.Evaluate(cp, tc.Input)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.Name, err)
+		}
+		status := "deny"
+		if dec.Allow {
+			status = "allow"
+		} else if dec.RequireApproval {
+			status = "needs_approval"
+		}
+		if status != tc.Expect {
+			failed = append(failed, tc.Name+": "+status)
+		}
+	}
+	return failed
+}
+
+func TestCatalog(t *testing.T) {
+	if len(All()) < 3 {
+		t.Fatalf("catalogue has %d packs", len(All()))
+	}
+	for _, p := range All() {
+		t.Run(p.ID, func(t *testing.T) {
+			if p.Engine != policy.EngineAuraJSON {
+				t.Fatalf("engine %s", p.Engine)
+			}
+			r, err := p.Render(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if failed := runTests(t, r); len(failed) > 0 {
+				t.Errorf("failing tests: %v", failed)
+			}
+		})
+	}
+}
+
+func TestRenderParams(t *testing.T) {
+	p, ok := Get("payments-approval-threshold")
+	if !ok {
+		t.Fatal("pack missing")
+	}
+	r, err := p.Render(map[string]any{"approval_threshold": 5000.0, "approver_group": "treasury"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(r.Body)
+	if !strings.Contains(body, `"gte":5000`) || !strings.Contains(body, `"approvers":["treasury"]`) || !strings.Contains(body, `"hint":"payments from 5000 need approval"`) {
+		t.Errorf("body %s", body)
+	}
+	if r.Params["max_amount"] != 10000.0 {
+		t.Errorf("default not applied: %v", r.Params)
+	}
+	if failed := runTests(t, r); len(failed) > 0 {
+		t.Errorf("failing tests: %v", failed)
+	}
+	// a threshold over the hard limit makes the pack's own tests fail, so the install is refused
+	r, err = p.Render(map[string]any{"approval_threshold": 20000.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := runTests(t, r); len(failed) == 0 {
+		t.Error("expected failing tests")
+	}
+
+	for name, values := range map[string]map[string]any{
+		"unknown param":  {"nope": 1.0},
+		"wrong type":     {"approval_threshold": "5000"},
+		"out of range":   {"approval_threshold": 0.0},
+		"string not num": {"max_amount": true},
+	} {
+		if _, err := p.Render(values); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	params := map[string]any{"n": 5.0, "regions": []any{"EU", "EEA"}, "group": "ops"}
+	in := map[string]any{
+		"n":      "${n}",
+		"list":   "${regions}",
+		"first":  "${regions[0]}",
+		"text":   "at least ${n} from ${regions} for ${group}",
+		"nested": []any{"${group}", "plain"},
+	}
+	out, err := substitute(in, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(out)
+	want := `{"first":"EU","list":["EU","EEA"],"n":5,"nested":["ops","plain"],"text":"at least 5 from EU, EEA for ops"}`
+	if string(got) != want {
+		t.Errorf("got %s", got)
+	}
+	for _, s := range []string{"${missing}", "${regions[2]}", "x ${missing} y"} {
+		if _, err := substitute(s, params); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	valid := Pack{ID: "x", Version: 1, Engine: "aurajson", Body: json.RawMessage(`{"rules":[]}`), Tests: []bundle.TestCase{{Name: "t", Input: json.RawMessage(`{}`), Expect: "deny"}}}
+	for name, mut := range map[string]func(*Pack){
+		"no version":  func(p *Pack) { p.Version = 0 },
+		"no tests":    func(p *Pack) { p.Tests = nil },
+		"bad default": func(p *Pack) { p.Params = []Param{{Name: "a", Type: ParamNumber, Default: "1"}} },
+		"dup param":   func(p *Pack) { p.Params = []Param{{Name: "a", Type: ParamString}, {Name: "a", Type: ParamString}} },
+	} {
+		p := valid
+		mut(&p)
+		raw, _ := json.Marshal(p)
+		if _, err := Parse(raw); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	raw, _ := json.Marshal(valid)
+	if _, err := Parse(raw); err != nil {
+		t.Error(err)
+	}
+	// a required param must be given
+	valid.Params = []Param{{Name: "a", Type: ParamString}}
+	if _, err := valid.Resolve(nil); err == nil {
+		t.Error("expected required param error")
+	}
+}
+
+func TestUpgradeAcrossParamRename(t *testing.T) {
+	p, ok := Get("payments-approval-threshold")
+	if !ok {
+		t.Fatal("pack missing")
+	}
+	// an install of an earlier version that called the threshold "threshold" and had a since dropped param
+	stored := map[string]any{"threshold": 5000.0, "max_amount": 20000.0, "currency": "EUR"}
+	if _, err := p.Render(stored); err == nil {
+		t.Fatal("stored params of the old version must not render as they are")
+	}
+	values, dropped := p.Carry(stored)
+	if len(dropped) != 2 || dropped[0] != "currency" || dropped[1] != "threshold" {
+		t.Errorf("dropped %v", dropped)
+	}
+	values["approval_threshold"] = 5000.0
+	r, err := p.Render(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Params["max_amount"] != 20000.0 || r.Params["approval_threshold"] != 5000.0 {
+		t.Errorf("params %v", r.Params)
+	}
+	if failed := runTests(t, r); len(failed) > 0 {
+		t.Errorf("failing tests: %v", failed)
+	}
+}
@@ -0,0 +1,161 @@
+package packs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// Install is a pack installed into an org: the policy it created and the pack version and parameters of
+// that policy's latest pack version. NotifiedVersion is the newest pack version the org has been told about.
+type Install struct {
+	ID              uuid.UUID       `db:"id" json:"id"`
+	OrgID           uuid.UUID       `db:"org_id" json:"org_id"`
+	PackID          string          `db:"pack_id" json:"pack_id"`
+	PackVersion     int             `db:"pack_version" json:"pack_version"`
+	PolicyID        uuid.UUID       `db:"policy_id" json:"policy_id"`
+	Version         int             `db:"version" json:"version"`
+	Params          json.RawMessage `db:"params" json:"params"`
+	NotifiedVersion int             `db:"notified_version" json:"-"`
+	InstalledBy     *uuid.UUID      `db:"installed_by_user_id" json:"installed_by_user_id,omitempty"`
+	InstalledAt     time.Time       `db:"installed_at" json:"installed_at"`
+	UpdatedAt       time.Time       `db:"updated_at" json:"updated_at"`
+}
+
+const installCols = `id, org_id, pack_id, pack_version, policy_id, version, params, notified_version, installed_by_user_id, installed_at, updated_at`
+
+// GetInstall returns the org's install of a pack, nil when it is not installed
+func GetInstall(ctx context.Context, orgID uuid.UUID, packID string) (*Install, error) {
+	var in Install
+	err := databasepkg.DB.GetContext(ctx, &in, `SELECT `+installCols+` FROM policy_pack_installs WHERE org_id=$1 AND pack_id=$2`, orgID, packID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &in, nil
+}
+
+// ErrInstallChanged is returned when another request installed or upgraded the pack since the caller read
+// the install; rendering again against the current install and retrying is safe
+var ErrInstallChanged = errors.New("the pack install changed concurrently; retry")
+
+// Apply is an install or upgrade of a pack, rendered and tested by the caller
+type Apply struct {
+	OrgID uuid.UUID
+	Pack  Pack
+	// Prev is the install the caller rendered against, nil for a first install
+	Prev *Install
+	// Name of the policy a first install creates
+	Name string
+	Body json.RawMessage
+	// Changed is false when Body is the installed version's; no version is added then
+	Changed  bool
+	Checksum string
+	Params   json.RawMessage
+	// ScopeType/ScopeID assign the policy when it is not assigned to that scope yet (empty: no assignment)
+	ScopeType, ScopeID string
+	By                 *uuid.UUID
+}
+
+// Applied is the result of ApplyInstall; Assigned is set when the assignment was added
+type Applied struct {
+	Install  Install
+	Assigned bool
+}
+
+// ApplyInstall writes an install or upgrade in one transaction: the policy of a first install, a draft
+// version recording its checksum and pack:<id>@<version> as change ticket, the assignment and the install
+// row. A failure leaves nothing behind, so a retry cannot create a second policy for the pack.
+func ApplyInstall(ctx context.Context, a Apply) (Applied, error) {
+	tx, err := databasepkg.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return Applied{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	// installs of a pack into an org are serialized
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "policy_pack:"+a.OrgID.String()+":"+a.Pack.ID); err != nil {
+		return Applied{}, err
+	}
+	var cur Install
+	err = tx.GetContext(ctx, &cur, `SELECT `+installCols+` FROM policy_pack_installs WHERE org_id=$1 AND pack_id=$2`, a.OrgID, a.Pack.ID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if a.Prev != nil {
+			return Applied{}, ErrInstallChanged
+		}
+	case err != nil:
+		return Applied{}, err
+	case a.Prev == nil || cur.PolicyID != a.Prev.PolicyID || cur.Version != a.Prev.Version:
+		return Applied{}, ErrInstallChanged
+	}
+
+	out := Install{OrgID: a.OrgID, PackID: a.Pack.ID, PackVersion: a.Pack.Version, Params: a.Params}
+	if a.Prev == nil {
+		if err := tx.GetContext(ctx, &out.PolicyID, `INSERT INTO policies (org_id,name,engine_type,created_by_user_id) VALUES ($1,$2,$3,$4) RETURNING id`,
+			a.OrgID, a.Name, a.Pack.Engine, a.By); err != nil {
+			return Applied{}, err
+		}
+	} else {
+		out.PolicyID, out.Version = a.Prev.PolicyID, a.Prev.Version
+	}
+	if a.Changed {
+		if err := tx.GetContext(ctx, &out.Version, `INSERT INTO policy_versions (policy_id,version,body,created_by_user_id,status,checksum,change_ticket)
+			SELECT $1, COALESCE(MAX(version),0)+1, $2, $3, 'draft', $4, $5 FROM policy_versions WHERE policy_id=$1 RETURNING version`,
+			out.PolicyID, a.Body, a.By, a.Checksum, fmt.Sprintf("pack:%s@%d", a.Pack.ID, a.Pack.Version)); err != nil {
+			return Applied{}, err
+		}
+	}
+	res := Applied{}
+	if a.ScopeType != "" {
+		r, err := tx.ExecContext(ctx, `INSERT INTO policy_assignments (policy_id, scope_type, scope_id) SELECT $1,$2,$3
+			WHERE NOT EXISTS (SELECT 1 FROM policy_assignments WHERE policy_id=$1 AND scope_type=$2 AND scope_id=$3)`, out.PolicyID, a.ScopeType, a.ScopeID)
+		if err != nil {
+			return Applied{}, err
+		}
+		n, _ := r.RowsAffected()
+		res.Assigned = n == 1
+	}
+	// the org has seen the version it installs
+	if err := tx.GetContext(ctx, &res.Install, `INSERT INTO policy_pack_installs (org_id, pack_id, pack_version, policy_id, version, params, notified_version, installed_by_user_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$3,$7)
+		ON CONFLICT (org_id, pack_id) DO UPDATE SET pack_version=EXCLUDED.pack_version, policy_id=EXCLUDED.policy_id, version=EXCLUDED.version,
+			params=EXCLUDED.params, notified_version=GREATEST(policy_pack_installs.notified_version, EXCLUDED.notified_version), updated_at=now()
+		RETURNING `+installCols, out.OrgID, out.PackID, out.PackVersion, out.PolicyID, out.Version, out.Params, a.By); err != nil {
+		return Applied{}, err
+	}
+	return res, tx.Commit()
+}
+
+// ListInstalls returns the org's installed packs
+func ListInstalls(ctx context.Context, orgID uuid.UUID) ([]Install, error) {
+	out := []Install{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+installCols+` FROM policy_pack_installs WHERE org_id=$1 ORDER BY pack_id`, orgID)
+	return out, err
+}
+
+// StaleInstalls returns the installs of a pack older than version that have not been told about it
+func StaleInstalls(ctx context.Context, packID string, version int) ([]Install, error) {
+	out := []Install{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+installCols+` FROM policy_pack_installs
+		WHERE pack_id=$1 AND pack_version < $2 AND notified_version < $2 ORDER BY installed_at`, packID, version)
+	return out, err
+}
+
+// MarkNotified records that an install was told about version; false when another process already did,
+// so each upgrade is announced once
+func MarkNotified(ctx context.Context, id uuid.UUID, version int) (bool, error) {
+	res, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_pack_installs SET notified_version=$2 WHERE id=$1 AND notified_version < $2`, id, version)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,431 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// OutcomeConditional marks a partial result that depends on input not yet known
+const OutcomeConditional = "conditional"
+
+// PartialEvaluator is implemented by engines that can evaluate a policy with part of its input unknown
+type PartialEvaluator interface {
+	Partial(compiled CompiledPolicy, known json.RawMessage, unknowns []string) (PartialResult, error)
+}
+
+// ResidualRule is a rule that matches, or may match once the unknown input is supplied
+type ResidualRule struct {
+	RuleID string `json:"rule_id,omitempty"`
+	Effect string `json:"effect"`
+	// Condition is the residual `when` clause (AuraJSON) or query (Rego); nil when the rule matches unconditionally
+	Condition any `json:"condition,omitempty"`
+}
+
+// PartialResult is the outcome of partially evaluating one policy
+type PartialResult struct {
+	Outcome string         `json:"outcome"`
+	Rules   []ResidualRule `json:"rules,omitempty"`
+	// Support holds auxiliary Rego rules referenced by residual queries
+	Support []string `json:"support,omitempty"`
+}
+
+// unknownMatcher reports whether a missing input path should be kept residual; no unknowns means every missing field
+func unknownMatcher(unknowns []string) func(string) bool {
+	if len(unknowns) == 0 {
+		return func(string) bool { return true }
+	}
+	return func(path string) bool {
+		for _, u := range unknowns {
+			if path == u || strings.HasPrefix(path, u+".") || strings.HasPrefix(path, u+"[") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type triState int
+
+const (
+	triFalse triState = iota
+	triTrue
+	triResidual
+)
+
+// Partial evaluates rules against the known input. Fields missing from known (restricted to unknowns when
+// given) are left symbolic: rules depending on them are returned with their residual `when` clause.
+// Schema validation is skipped since the input is incomplete by design.
+func (e *AuraJSONEvaluator) Partial(compiled CompiledPolicy, known json.RawMessage, unknowns []string) (PartialResult, error) {
+	cj, ok := compiled.(*compiledJSON)
+	if !ok {
+		return PartialResult{}, fmt.Errorf("bad compiled policy type")
+	}
+	var in map[string]any
+	_ = json.Unmarshal(known, &in)
+	if in == nil {
+		in = map[string]any{}
+	}
+	unknown := unknownMatcher(unknowns)
+	denyOverrides, firstMatch := true, false
+	if p, ok := cj.Body["precedence"].(map[string]any); ok {
+		if v, ok := p["deny_overrides"].(bool); ok {
+			denyOverrides = v
+		}
+		firstMatch, _ = p["first_match"].(bool)
+	}
+
+	res := PartialResult{Rules: []ResidualRule{}}
+	residual := false
+	definite := map[string]bool{}
+	rules, _ := cj.Body["rules"].([]any)
+	for _, r := range rules {
+		rm, ok := r.(map[string]any)
+		if !ok {
+			continue
+		}
+		effect := strings.ToLower(fmt.Sprintf("%v", rm["effect"]))
+		if effect == "needs_approval" {
+			effect = "require_approval"
+		}
+		state, cond := partialWhen(in, rm["when"], cj.Exprs, unknown)
+		if state == triFalse {
+			continue
+		}
+		rr := ResidualRule{RuleID: fmt.Sprintf("%v", rm["id"]), Effect: effect}
+		if state == triResidual {
+			rr.Condition = cond
+			residual = true
+		} else {
+			definite[effect] = true
+		}
+		res.Rules = append(res.Rules, rr)
+	}
+
+	switch {
+	case len(res.Rules) == 0:
+		res.Outcome = OutcomeNotApplicable
+	case !residual:
+		// every rule decided: replay Evaluate's ordering semantics exactly
+		allow, approval := false, false
+		for _, rr := range res.Rules {
+			switch rr.Effect {
+			case "deny":
+				allow = false
+			case "allow":
+				allow = true
+			case "require_approval":
+				approval = true
+			}
+			if rr.Effect == "deny" && denyOverrides {
+				break
+			}
+			if firstMatch && (rr.Effect == "allow" || rr.Effect == "deny") {
+				break
+			}
+		}
+		res.Outcome = OutcomeDeny
+		if allow {
+			res.Outcome = OutcomeAllow
+		} else if approval {
+			res.Outcome = OutcomeRequireApproval
+		}
+	case denyOverrides && !firstMatch && definite["deny"] && !mayEffect(res.Rules, "require_approval"):
+		res.Outcome = OutcomeDeny
+		res.Rules = definiteRules(res.Rules, "deny")
+	default:
+		res.Outcome = OutcomeConditional
+	}
+	return res, nil
+}
+
+func mayEffect(rules []ResidualRule, effect string) bool {
+	for _, r := range rules {
+		if r.Effect == effect {
+			return true
+		}
+	}
+	return false
+}
+
+func definiteRules(rules []ResidualRule, effect string) []ResidualRule {
+	out := []ResidualRule{}
+	for _, r := range rules {
+		if r.Effect == effect && r.Condition == nil {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// partialWhen mirrors evalExpr with three-valued logic; evaluation errors fold to false as in Evaluate
+func partialWhen(in map[string]any, when any, exprs map[string]*Expr, unknown func(string) bool) (triState, any) {
+	if when == nil {
+		return triTrue, nil
+	}
+	if s, ok := when.(string); ok {
+		x := exprs[s]
+		if x == nil {
+			var err error
+			if x, err = CompileExpr(s); err != nil {
+				return triFalse, nil
+			}
+		}
+		known, v, res, err := x.Partial(in, unknown)
+		switch {
+		case err != nil:
+			return triFalse, nil
+		case !known:
+			return triResidual, res
+		case v:
+			return triTrue, nil
+		}
+		return triFalse, nil
+	}
+	expr, _ := when.(map[string]any)
+	if expr == nil {
+		return triTrue, nil
+	}
+	// trust graph checks are only answered at evaluation time
+	if _, ok := expr["rel"]; ok && len(expr) == 1 {
+		return triResidual, expr
+	}
+	if v, ok := expr["and"].([]any); ok {
+		return partialAnd(in, v, exprs, unknown)
+	}
+	if v, ok := expr["or"].([]any); ok {
+		var residuals []any
+		for _, e := range v {
+			st, r := partialWhen(in, e, exprs, unknown)
+			if st == triTrue {
+				return triTrue, nil
+			}
+			if st == triResidual {
+				residuals = append(residuals, r)
+			}
+		}
+		switch len(residuals) {
+		case 0:
+			return triFalse, nil
+		case 1:
+			return triResidual, residuals[0]
+		}
+		return triResidual, map[string]any{"or": residuals}
+	}
+	if v, ok := expr["not"]; ok && v != nil {
+		st, r := partialWhen(in, v, exprs, unknown)
+		switch st {
+		case triTrue:
+			return triFalse, nil
+		case triResidual:
+			return triResidual, map[string]any{"not": r}
+		}
+		// a false inner clause may stem from an evaluation error, which never matches under not
+		if ok, err := evalExpr(context.Background(), in, v, exprs); err != nil || ok {
+			return triFalse, nil
+		}
+		return triTrue, nil
+	}
+	// implicit conjunction of an optional expr and every field operator map
+	keys := make([]string, 0, len(expr))
+	for k := range expr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]any, 0, len(keys))
+	for _, k := range keys {
+		if k == "expr" {
+			if s, ok := expr[k].(string); ok {
+				parts = append(parts, s)
+			}
+			continue
+		}
+		if k == "rel" {
+			parts = append(parts, map[string]any{k: expr[k]})
+			continue
+		}
+		ops, ok := expr[k].(map[string]any)
+		if !ok {
+			continue
+		}
+		parts = append(parts, map[string]any{k: ops})
+	}
+	return partialAnd(in, parts, exprs, unknown)
+}
+
+func partialAnd(in map[string]any, clauses []any, exprs map[string]*Expr, unknown func(string) bool) (triState, any) {
+	var residuals []any
+	for _, e := range clauses {
+		var st triState
+		var r any
+		if fm, ok := fieldClause(e); ok {
+			st, r = partialField(in, fm, exprs, unknown)
+		} else {
+			st, r = partialWhen(in, e, exprs, unknown)
+		}
+		if st == triFalse {
+			return triFalse, nil
+		}
+		if st == triResidual {
+			residuals = append(residuals, r)
+		}
+	}
+	switch len(residuals) {
+	case 0:
+		return triTrue, nil
+	case 1:
+		return triResidual, residuals[0]
+	}
+	return triResidual, map[string]any{"and": residuals}
+}
+
+// fieldClause recognises a single {"field": {ops}} map (not a logical operator)
+func fieldClause(e any) (map[string]any, bool) {
+	m, ok := e.(map[string]any)
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	for k, v := range m {
+		switch k {
+		case "and", "or", "not", "expr", "rel":
+			return nil, false
+		}
+		_, isOps := v.(map[string]any)
+		return m, isOps
+	}
+	return nil, false
+}
+
+func partialField(in map[string]any, fm map[string]any, exprs map[string]*Expr, unknown func(string) bool) (triState, any) {
+	for k := range fm {
+		if _, has := pluck(in, k); !has {
+			if unknown(k) {
+				return triResidual, fm
+			}
+			return triFalse, nil
+		}
+	}
+	if ok, _ := evalExpr(context.Background(), in, fm, exprs); ok {
+		return triTrue, nil
+	}
+	return triFalse, nil
+}
+
+// CombinePartial folds per-policy partial outcomes (in evaluation order) with a combining algorithm.
+// A conditional result that can never produce allow or require_approval collapses to deny.
+func CombinePartial(alg string, results []PartialResult) string {
+	if !ValidCombiningAlg(alg) {
+		alg = CombineDenyOverrides
+	}
+	has := map[string]bool{}
+	applicable := 0
+	for _, r := range results {
+		has[r.Outcome] = true
+		if r.Outcome != OutcomeNotApplicable && r.Outcome != OutcomeConditional {
+			applicable++
+		}
+	}
+	first := func(order ...string) string {
+		for _, o := range order {
+			if has[o] {
+				return o
+			}
+		}
+		return OutcomeNotApplicable
+	}
+	var out string
+	switch alg {
+	case CombineDenyOverrides:
+		out = first(OutcomeDeny, OutcomeConditional, OutcomeRequireApproval, OutcomeAllow)
+	case CombinePermitOverrides:
+		out = first(OutcomeAllow, OutcomeConditional, OutcomeRequireApproval, OutcomeDeny)
+	case CombineFirstApplicable:
+		out = OutcomeNotApplicable
+		for _, r := range results {
+			if r.Outcome != OutcomeNotApplicable {
+				out = r.Outcome
+				break
+			}
+		}
+	case CombineOnlyOneApplicable:
+		switch {
+		case applicable > 1:
+			out = OutcomeDeny
+		case has[OutcomeConditional]:
+			out = OutcomeConditional
+		default:
+			out = first(OutcomeDeny, OutcomeRequireApproval, OutcomeAllow)
+		}
+	}
+	if out == OutcomeConditional {
+		may := false
+		for _, r := range results {
+			if r.Outcome == OutcomeAllow || r.Outcome == OutcomeRequireApproval || mayEffect(r.Rules, "allow") || mayEffect(r.Rules, "require_approval") {
+				may = true
+				break
+			}
+		}
+		if !may {
+			out = OutcomeDeny
+		}
+	}
+	return out
+}
+
+// PolicyActions lists literal values an AuraJSON body compares its "action" field against (eq / in),
+// used as the default candidate set when a query does not name actions
+func PolicyActions(body json.RawMessage) []string {
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil
+	}
+	set := map[string]bool{}
+	rules, _ := m["rules"].([]any)
+	for _, r := range rules {
+		if rm, ok := r.(map[string]any); ok {
+			collectActions(rm["when"], set)
+		}
+	}
+	out := make([]string, 0, len(set))
+	for a := range set {
+		out = append(out, a)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func collectActions(when any, set map[string]bool) {
+	switch w := when.(type) {
+	case []any:
+		for _, x := range w {
+			collectActions(x, set)
+		}
+	case map[string]any:
+		for k, v := range w {
+			switch k {
+			case "and", "or", "not":
+				collectActions(v, set)
+			case "action":
+				ops, _ := v.(map[string]any)
+				for op, rhs := range ops {
+					switch strings.ToLower(op) {
+					case "eq":
+						if s, ok := rhs.(string); ok {
+							set[s] = true
+						}
+					case "in":
+						if l, ok := rhs.([]any); ok {
+							for _, x := range l {
+								if s, ok := x.(string); ok {
+									set[s] = true
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
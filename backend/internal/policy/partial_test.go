@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func partialEval(t *testing.T, body, known string, unknowns ...string) PartialResult {
+	t.Helper()
+	e := &AuraJSONEvaluator{}
+	cp, err := e.Compile(json.RawMessage(body))
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	res, err := e.Partial(cp, json.RawMessage(known), unknowns)
+	if err != nil {
+		t.Fatalf("partial: %v", err)
+	}
+	return res
+}
+
+func TestPartial_AuraJSONResiduals(t *testing.T) {
+	body := `{"rules":[
+		{"id":"deny_shell_prod","effect":"deny","when":{"action":{"eq":"shell"},"env":{"eq":"prod"}}},
+		{"id":"allow_query_small","effect":"allow","when":{"and":[{"action":{"eq":"db.query"}},"request.amount <= principal.limit && resource.startsWith('db/')"]}},
+		{"id":"allow_read","effect":"allow","when":{"action":{"in":["read","list"]}}}
+	]}`
+	known := func(action string) string {
+		return `{"action":"` + action + `","env":"prod","principal":{"limit":100}}`
+	}
+
+	if res := partialEval(t, body, known("read")); res.Outcome != OutcomeAllow || len(res.Rules) != 1 || res.Rules[0].Condition != nil {
+		t.Fatalf("read: expected unconditional allow, got %+v", res)
+	}
+	if res := partialEval(t, body, known("shell")); res.Outcome != OutcomeDeny {
+		t.Fatalf("shell: expected deny, got %+v", res)
+	}
+	if res := partialEval(t, body, known("delete")); res.Outcome != OutcomeNotApplicable {
+		t.Fatalf("delete: expected not applicable, got %+v", res)
+	}
+	res := partialEval(t, body, known("db.query"))
+	if res.Outcome != OutcomeConditional || len(res.Rules) != 1 {
+		t.Fatalf("db.query: expected conditional, got %+v", res)
+	}
+	if got := res.Rules[0].Condition; got != `(request.amount <= 100) && startsWith(resource, "db/")` {
+		t.Fatalf("unexpected residual %v", got)
+	}
+
+	// restricting unknowns makes other missing fields evaluate as absent
+	res = partialEval(t, body, known("db.query"), "resource")
+	if res.Outcome != OutcomeNotApplicable {
+		t.Fatalf("expected request.amount to be treated as missing, got %+v", res)
+	}
+}
+
+func TestPartial_LegacyFieldsAndNot(t *testing.T) {
+	body := `{"rules":[
+		{"id":"deny_pii","effect":"deny","when":{"not":{"resource":{"in":["public","docs"]}}}},
+		{"id":"allow_all","effect":"allow"}
+	]}`
+	res := partialEval(t, body, `{"action":"read"}`)
+	if res.Outcome != OutcomeConditional || len(res.Rules) != 2 {
+		t.Fatalf("expected conditional, got %+v", res)
+	}
+	want := map[string]any{"not": map[string]any{"resource": map[string]any{"in": []any{"public", "docs"}}}}
+	if !reflect.DeepEqual(res.Rules[0].Condition, want) {
+		t.Fatalf("unexpected residual %#v", res.Rules[0].Condition)
+	}
+	if res := partialEval(t, body, `{"action":"read","resource":"docs"}`); res.Outcome != OutcomeAllow {
+		t.Fatalf("expected allow once resource is known, got %+v", res)
+	}
+}
+
+func TestCombinePartial(t *testing.T) {
+	allow := PartialResult{Outcome: OutcomeAllow}
+	deny := PartialResult{Outcome: OutcomeDeny}
+	na := PartialResult{Outcome: OutcomeNotApplicable}
+	condDeny := PartialResult{Outcome: OutcomeConditional, Rules: []ResidualRule{{Effect: "deny", Condition: "x"}}}
+	condAllow := PartialResult{Outcome: OutcomeConditional, Rules: []ResidualRule{{Effect: "allow", Condition: "x"}}}
+
+	cases := []struct {
+		alg  string
+		in   []PartialResult
+		want string
+	}{
+		{CombineDenyOverrides, []PartialResult{allow, condDeny}, OutcomeConditional},
+		{CombineDenyOverrides, []PartialResult{allow, deny, condAllow}, OutcomeDeny},
+		{CombineDenyOverrides, []PartialResult{condDeny, na}, OutcomeDeny},
+		{CombinePermitOverrides, []PartialResult{condDeny, allow}, OutcomeAllow},
+		{CombineFirstApplicable, []PartialResult{na, condAllow, deny}, OutcomeConditional},
+		{CombineFirstApplicable, []PartialResult{na, allow, deny}, OutcomeAllow},
+		{CombineOnlyOneApplicable, []PartialResult{allow, deny}, OutcomeDeny},
+		{CombineOnlyOneApplicable, []PartialResult{na, allow}, OutcomeAllow},
+	}
+	for i, tc := range cases {
+		if got := CombinePartial(tc.alg, tc.in); got != tc.want {
+			t.Fatalf("case %d (%s): got %s want %s", i, tc.alg, got, tc.want)
+		}
+	}
+}
+
+func TestPolicyActions(t *testing.T) {
+	body := json.RawMessage(`{"rules":[{"when":{"action":{"eq":"shell"}}},{"when":{"or":[{"action":{"in":["read","list"]}},{"env":{"eq":"dev"}}]}}]}`)
+	if got := PolicyActions(body); !reflect.DeepEqual(got, []string{"list", "read", "shell"}) {
+		t.Fatalf("unexpected actions %v", got)
+	}
+}
@@ -0,0 +1,179 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrNoRelations is returned by relationship checks of policies evaluated without a graph (see WithRelations)
+var ErrNoRelations = errors.New("relationship checks are not available in this evaluation")
+
+// RelationChecker answers a relationship check made by a policy: whether subject has relation on object,
+// both written type:id (e.g. agent:a1 editor doc:d1). The trace it returns is recorded in the rule trace;
+// only the "allowed" permissionship satisfies the check.
+type RelationChecker func(ctx context.Context, subject, relation, object string) (GraphTrace, error)
+
+type relationsKey struct{}
+type relationsRecorderKey struct{}
+
+// relations memoizes the checks made through one context
+type relations struct {
+	check RelationChecker
+	mu    sync.Mutex
+	memo  map[string]*relationCell
+}
+
+type relationCell struct {
+	once sync.Once
+	gt   GraphTrace
+	err  error
+}
+
+// WithRelations lets policies evaluated with ctx call the trust graph through check. Checks are memoized
+// for the lifetime of ctx, so the policies combined into one decision share them.
+func WithRelations(ctx context.Context, check RelationChecker) context.Context {
+	return context.WithValue(ctx, relationsKey{}, &relations{check: check, memo: map[string]*relationCell{}})
+}
+
+// RelationsChecked reports how many distinct checks policies made through ctx; decisions depending on
+// the graph must not outlive the tuples they read
+func RelationsChecked(ctx context.Context) int {
+	r, _ := ctx.Value(relationsKey{}).(*relations)
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.memo)
+}
+
+type relationsRecorder struct {
+	mu     sync.Mutex
+	traces []GraphTrace
+}
+
+// RecordRelations returns a context whose checks are collected, and a function listing them in call order
+func RecordRelations(ctx context.Context) (context.Context, func() []GraphTrace) {
+	rec := &relationsRecorder{}
+	return context.WithValue(ctx, relationsRecorderKey{}, rec), func() []GraphTrace {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return rec.traces
+	}
+}
+
+// CheckRelation runs a relationship check for a policy through the checker of ctx, memoized, and records it
+// with the recorder of ctx. A failed check is recorded with its error and returned as false with the error.
+func CheckRelation(ctx context.Context, subject, relation, object string) (bool, error) {
+	r, _ := ctx.Value(relationsKey{}).(*relations)
+	if r == nil {
+		return false, ErrNoRelations
+	}
+	key := subject + " " + relation + " " + object
+	r.mu.Lock()
+	cell, ok := r.memo[key]
+	if !ok {
+		cell = &relationCell{}
+		r.memo[key] = cell
+	}
+	r.mu.Unlock()
+	cell.once.Do(func() {
+		cell.gt, cell.err = r.check(ctx, subject, relation, object)
+		cell.gt.Subject, cell.gt.Relation, cell.gt.Object = subject, relation, object
+		if cell.err != nil {
+			cell.gt.Error = cell.err.Error()
+		}
+	})
+	if rec, _ := ctx.Value(relationsRecorderKey{}).(*relationsRecorder); rec != nil {
+		rec.mu.Lock()
+		rec.traces = append(rec.traces, cell.gt)
+		rec.mu.Unlock()
+	}
+	return cell.err == nil && cell.gt.Permissionship == "allowed", cell.err
+}
+
+// relClause is an AuraJSON rule clause checking the trust graph:
+//
+//	{"rel": {"subject": "agent:{{principal.agent_id}}", "relation": "editor", "object": "{{resource}}"}}
+//
+// {{path}} placeholders are filled in from the input.
+type relClause struct {
+	Subject  string
+	Relation string
+	Object   string
+}
+
+var relPlaceholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// parseRelClause reads the value of a "rel" key
+func parseRelClause(v any) (relClause, error) {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return relClause{}, fmt.Errorf("rel expects an object with subject, relation and object")
+	}
+	var c relClause
+	for k, x := range m {
+		s, ok := x.(string)
+		if !ok || strings.TrimSpace(s) == "" {
+			return relClause{}, fmt.Errorf("rel: %s must be a non-empty string", k)
+		}
+		switch k {
+		case "subject":
+			c.Subject = s
+		case "relation":
+			c.Relation = s
+		case "object":
+			c.Object = s
+		default:
+			return relClause{}, fmt.Errorf("rel: unknown field %q", k)
+		}
+	}
+	if c.Subject == "" || c.Relation == "" || c.Object == "" {
+		return relClause{}, fmt.Errorf("rel expects an object with subject, relation and object")
+	}
+	for _, s := range []string{c.Subject, c.Object} {
+		for _, m := range relPlaceholder.FindAllStringSubmatch(s, -1) {
+			if m[1] == "" {
+				return relClause{}, fmt.Errorf("rel: empty placeholder in %q", s)
+			}
+		}
+	}
+	return c, nil
+}
+
+// fill replaces the {{path}} placeholders of s with input values
+func fill(input map[string]any, s string) (string, error) {
+	var missing string
+	out := relPlaceholder.ReplaceAllStringFunc(s, func(m string) string {
+		path := relPlaceholder.FindStringSubmatch(m)[1]
+		v, ok := pluck(input, path)
+		if !ok || v == nil {
+			if missing == "" {
+				missing = path
+			}
+			return ""
+		}
+		return fmt.Sprintf("%v", v)
+	})
+	if missing != "" {
+		return "", fmt.Errorf("rel: %s is not in the input", missing)
+	}
+	return out, nil
+}
+
+// eval checks the clause for input through the checker of ctx
+func (c relClause) eval(ctx context.Context, input map[string]any) (bool, error) {
+	subject, err := fill(input, c.Subject)
+	if err != nil {
+		return false, err
+	}
+	object, err := fill(input, c.Object)
+	if err != nil {
+		return false, err
+	}
+	return CheckRelation(ctx, subject, c.Relation, object)
+}
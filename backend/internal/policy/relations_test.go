@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// graphOf answers checks from a set of "subject relation object" triples, counting calls
+func graphOf(calls *atomic.Int64, tuples ...string) RelationChecker {
+	allowed := map[string]bool{}
+	for _, t := range tuples {
+		allowed[t] = true
+	}
+	return func(ctx context.Context, subject, relation, object string) (GraphTrace, error) {
+		calls.Add(1)
+		if strings.HasPrefix(object, "broken:") {
+			return GraphTrace{}, errors.New("graph unavailable")
+		}
+		perm := "denied"
+		if allowed[subject+" "+relation+" "+object] {
+			perm = "allowed"
+		}
+		return GraphTrace{Permissionship: perm, Source: "test"}, nil
+	}
+}
+
+func TestRelClauses(t *testing.T) {
+	body := `{"rules":[
+		{"id":"deny_suspended","effect":"deny","when":{"rel":{"subject":"agent:{{principal.agent_id}}","relation":"suspended","object":"org:{{principal.org_id}}"}}},
+		{"id":"allow_editor","effect":"allow","when":{"action":{"eq":"write"},"rel":{"subject":"agent:{{principal.agent_id}}","relation":"editor","object":"{{resource}}"}}},
+		{"id":"allow_viewer","effect":"allow","when":{"or":[
+			{"rel":{"subject":"agent:{{principal.agent_id}}","relation":"editor","object":"{{resource}}"}},
+			{"rel":{"subject":"agent:{{principal.agent_id}}","relation":"viewer","object":"{{resource}}"}}
+		]}}
+	]}`
+	e := &AuraJSONEvaluator{}
+	cp, err := e.Compile(json.RawMessage(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int64
+	ctx := WithRelations(context.Background(), graphOf(&calls, "agent:a1 editor doc:d1"))
+	in := `{"action":"write","resource":"doc:d1","principal":{"agent_id":"a1","org_id":"o1"}}`
+	d, err := e.EvaluateContext(ctx, cp, json.RawMessage(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allow {
+		t.Fatalf("expected allow, got %+v", d)
+	}
+	// the editor check of allow_viewer is memoized, and decides the or
+	if calls.Load() != 2 || RelationsChecked(ctx) != 2 {
+		t.Errorf("expected 2 graph calls, got %d", calls.Load())
+	}
+	rules := d.Trace.EvaluatedRules
+	if len(rules[1].Relations) != 1 || rules[1].Relations[0].Object != "doc:d1" || rules[1].Relations[0].Permissionship != "allowed" {
+		t.Errorf("allow_editor trace: %+v", rules[1])
+	}
+	if len(rules[2].Relations) != 1 || rules[2].Relations[0].Relation != "editor" {
+		t.Errorf("allow_viewer trace: %+v", rules[2])
+	}
+
+	// a missing placeholder, a failing graph and a missing graph never match, and say why
+	for _, in := range []string{`{"action":"write","principal":{"agent_id":"a1","org_id":"o1"}}`, `{"action":"write","resource":"broken:x","principal":{"agent_id":"a1","org_id":"o1"}}`} {
+		d, _ := e.EvaluateContext(ctx, cp, json.RawMessage(in))
+		if d.Allow || d.Trace.EvaluatedRules[1].Reason == "" {
+			t.Errorf("%s: expected a deny with a reason, got %+v", in, d.Trace.EvaluatedRules[1])
+		}
+	}
+	d, _ = e.Evaluate(cp, json.RawMessage(in))
+	if d.Allow || !strings.Contains(d.Trace.EvaluatedRules[1].Reason, ErrNoRelations.Error()) {
+		t.Errorf("without a graph: %+v", d.Trace.EvaluatedRules[1])
+	}
+}
+
+func TestRelClauseCompile(t *testing.T) {
+	for _, when := range []string{
+		`{"rel":"agent:a1 editor doc:d1"}`,
+		`{"rel":{"subject":"agent:a1","relation":"editor"}}`,
+		`{"rel":{"subject":"agent:a1","relation":"editor","object":"doc:d1","context":"x"}}`,
+		`{"rel":{"subject":"agent:{{}}","relation":"editor","object":"doc:d1"}}`,
+	} {
+		body := `{"rules":[{"id":"r","effect":"allow","when":` + when + `}]}`
+		if _, err := (&AuraJSONEvaluator{}).Compile(json.RawMessage(body)); err == nil {
+			t.Errorf("%s: expected a compile error", when)
+		}
+	}
+}
+
+func TestPartial_RelIsResidual(t *testing.T) {
+	body := `{"rules":[{"id":"allow_editor","effect":"allow","when":{"action":{"eq":"write"},"rel":{"subject":"agent:{{principal.agent_id}}","relation":"editor","object":"{{resource}}"}}}]}`
+	if res := partialEval(t, body, `{"action":"read"}`); res.Outcome != OutcomeNotApplicable {
+		t.Fatalf("read: expected not applicable, got %+v", res)
+	}
+	res := partialEval(t, body, `{"action":"write"}`)
+	if res.Outcome != OutcomeConditional || len(res.Rules) != 1 {
+		t.Fatalf("write: expected the rel clause as residual, got %+v", res)
+	}
+	if c, ok := res.Rules[0].Condition.(map[string]any); !ok || c["rel"] == nil {
+		t.Fatalf("unexpected residual %v", res.Rules[0].Condition)
+	}
+}
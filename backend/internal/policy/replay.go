@@ -0,0 +1,369 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// Replay job statuses
+const (
+	ReplayQueued    = "queued"
+	ReplayRunning   = "running"
+	ReplaySucceeded = "succeeded"
+	ReplayFailed    = "failed"
+	ReplayCanceled  = "canceled"
+)
+
+// Replay re-evaluates a candidate policy version over the decision traces an org stored in [From, To).
+// Each trace is evaluated with the policy versions that made it, the candidate standing in for its policy;
+// traces the policy took no part in are only evaluated with IncludeUnassigned, the candidate added.
+type Replay struct {
+	ID                uuid.UUID  `db:"id" json:"id"`
+	OrgID             uuid.UUID  `db:"org_id" json:"org_id"`
+	PolicyID          uuid.UUID  `db:"policy_id" json:"policy_id"`
+	Version           int        `db:"version" json:"version"`
+	From              time.Time  `db:"window_from" json:"from"`
+	To                time.Time  `db:"window_to" json:"to"`
+	IncludeUnassigned bool       `db:"include_unassigned" json:"include_unassigned"`
+	Status            string     `db:"status" json:"status"`
+	Worker            *string    `db:"worker" json:"-"`
+	HeartbeatAt       *time.Time `db:"heartbeat_at" json:"heartbeat_at,omitempty"`
+	CursorAt          *time.Time `db:"cursor_at" json:"-"`
+	CursorID          int64      `db:"cursor_id" json:"-"`
+	Read              int64      `db:"read" json:"read"`
+	Evaluated         int64      `db:"evaluated" json:"evaluated"`
+	Skipped           int64      `db:"skipped" json:"skipped"`
+	Flipped           int64      `db:"flipped" json:"flipped"`
+	Error             *string    `db:"error" json:"error,omitempty"`
+	CreatedBy         *uuid.UUID `db:"created_by_user_id" json:"created_by,omitempty"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	StartedAt         *time.Time `db:"started_at" json:"started_at,omitempty"`
+	FinishedAt        *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+// FlipRate is the share of evaluated traces whose outcome the candidate changes
+func (r Replay) FlipRate() float64 {
+	if r.Evaluated == 0 {
+		return 0
+	}
+	return float64(r.Flipped) / float64(r.Evaluated)
+}
+
+// ReplayFlip is a stored decision whose outcome the candidate changes
+type ReplayFlip struct {
+	ID            int64      `db:"id" json:"-"`
+	ReplayID      uuid.UUID  `db:"replay_id" json:"-"`
+	TraceID       string     `db:"trace_id" json:"trace_id"`
+	AgentID       *uuid.UUID `db:"agent_id" json:"agent_id,omitempty"`
+	Action        string     `db:"action" json:"action,omitempty"`
+	Original      string     `db:"original" json:"original"`
+	Replayed      string     `db:"replayed" json:"replayed"`
+	OriginalRules RuleIDs    `db:"original_rules" json:"original_rules"`
+	ReplayedRules RuleIDs    `db:"replayed_rules" json:"replayed_rules"`
+	Rules         RuleIDs    `db:"rules" json:"rules"`
+	Reason        *string    `db:"reason" json:"reason,omitempty"`
+	DecidedAt     time.Time  `db:"decided_at" json:"decided_at"`
+}
+
+// ReplayTrace is a stored decision read back for a replay
+type ReplayTrace struct {
+	ID            int64           `db:"id"`
+	TraceID       string          `db:"trace_id"`
+	AgentID       *uuid.UUID      `db:"agent_id"`
+	Allow         bool            `db:"allow"`
+	PolicyID      *uuid.UUID      `db:"policy_id"`
+	PolicyVersion *int            `db:"policy_version"`
+	Trace         json.RawMessage `db:"trace"`
+	CreatedAt     time.Time       `db:"created_at"`
+}
+
+// ReplayRef is a policy version that took part in a stored decision
+type ReplayRef struct {
+	PolicyID  uuid.UUID
+	Version   int
+	ScopeType string
+	ScopeID   string
+}
+
+// ReplayRefs returns the policy versions a stored decision was combined from, in evaluation order.
+// Traces recorded before multi-policy composition only name the policy in their columns.
+func ReplayRefs(tr *Trace, policyID *uuid.UUID, version *int) []ReplayRef {
+	if tr != nil && len(tr.Policies) > 0 {
+		out := make([]ReplayRef, 0, len(tr.Policies))
+		for _, p := range tr.Policies {
+			out = append(out, ReplayRef{PolicyID: p.PolicyID, Version: p.PolicyVersion, ScopeType: p.ScopeType, ScopeID: p.ScopeID})
+		}
+		return out
+	}
+	if policyID != nil && *policyID != uuid.Nil && version != nil {
+		return []ReplayRef{{PolicyID: *policyID, Version: *version}}
+	}
+	return nil
+}
+
+// OriginalOutcome is the outcome the policies gave a stored decision: a require_approval that was redeemed
+// into an allow counts as require_approval
+func OriginalOutcome(allow bool, tr *Trace) string {
+	if tr != nil && tr.ApprovalID != "" {
+		return OutcomeRequireApproval
+	}
+	if allow {
+		return OutcomeAllow
+	}
+	if tr != nil && tr.RequireApproval {
+		return OutcomeRequireApproval
+	}
+	return OutcomeDeny
+}
+
+// ReplayOutcome is the outcome of a combined decision as /v2/verify serves it (no applicable policy denies)
+func ReplayOutcome(d Decision) string {
+	if d.Allow {
+		return OutcomeAllow
+	}
+	if d.RequireApproval {
+		return OutcomeRequireApproval
+	}
+	return OutcomeDeny
+}
+
+// FlipRules picks the rules a flip is attributed to: the rules that matched in only one of the two
+// evaluations, or the rules that matched in both when the same did (an effect or condition changed)
+func FlipRules(original, replayed RuleIDs) RuleIDs {
+	in := func(ids RuleIDs, id string) bool {
+		for _, x := range ids {
+			if x == id {
+				return true
+			}
+		}
+		return false
+	}
+	out := RuleIDs{}
+	for _, id := range original {
+		if !in(replayed, id) {
+			out = append(out, id)
+		}
+	}
+	for _, id := range replayed {
+		if !in(original, id) {
+			out = append(out, id)
+		}
+	}
+	if len(out) > 0 {
+		return out
+	}
+	return append(out, replayed...)
+}
+
+const replayCols = `id, org_id, policy_id, version, window_from, window_to, include_unassigned, status, worker, heartbeat_at, cursor_at, cursor_id,
+	read, evaluated, skipped, flipped, error, created_by_user_id, created_at, started_at, finished_at`
+
+// CreateReplay queues a replay
+func CreateReplay(ctx context.Context, r Replay) (Replay, error) {
+	var out Replay
+	err := databasepkg.DB.QueryRowxContext(ctx, `INSERT INTO policy_replays (org_id, policy_id, version, window_from, window_to, include_unassigned, created_by_user_id)
+		VALUES ($1,$2,$3,$4,$5,$6,$7) RETURNING `+replayCols, r.OrgID, r.PolicyID, r.Version, r.From, r.To, r.IncludeUnassigned, r.CreatedBy).StructScan(&out)
+	return out, err
+}
+
+// GetReplay returns a replay of the org, or nil when there is none with that id
+func GetReplay(ctx context.Context, orgID, id uuid.UUID) (*Replay, error) {
+	var r Replay
+	err := databasepkg.DB.GetContext(ctx, &r, `SELECT `+replayCols+` FROM policy_replays WHERE id=$1 AND org_id=$2`, id, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ListReplays returns the replays of a policy, most recent first
+func ListReplays(ctx context.Context, orgID, policyID uuid.UUID) ([]Replay, error) {
+	out := []Replay{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+replayCols+` FROM policy_replays WHERE org_id=$1 AND policy_id=$2 ORDER BY created_at DESC LIMIT 100`, orgID, policyID)
+	return out, err
+}
+
+// LatestReplay returns the most recent succeeded replay of a policy version, or nil
+func LatestReplay(ctx context.Context, policyID uuid.UUID, version int) (*Replay, error) {
+	var r Replay
+	err := databasepkg.DB.GetContext(ctx, &r, `SELECT `+replayCols+` FROM policy_replays WHERE policy_id=$1 AND version=$2 AND status='succeeded' ORDER BY finished_at DESC LIMIT 1`, policyID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ClaimReplay hands worker the oldest queued replay, or a running one whose worker stopped heartbeating
+// for stale; nil when there is none
+func ClaimReplay(ctx context.Context, worker string, stale time.Duration) (*Replay, error) {
+	var r Replay
+	err := databasepkg.DB.GetContext(ctx, &r, `UPDATE policy_replays SET status='running', worker=$1, heartbeat_at=now(), started_at=COALESCE(started_at, now())
+		WHERE id=(SELECT id FROM policy_replays WHERE status='queued' OR (status='running' AND heartbeat_at < now() - make_interval(secs => $2))
+			ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING `+replayCols, worker, stale.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// ReplayPage reads the next traces of a replay's window after its cursor
+func ReplayPage(ctx context.Context, r Replay, limit int) ([]ReplayTrace, error) {
+	out := []ReplayTrace{}
+	after := r.From
+	if r.CursorAt != nil {
+		after = *r.CursorAt
+	}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT id, trace_id, agent_id, allow, policy_id, policy_version, trace, created_at FROM decision_traces
+		WHERE org_id=$1 AND created_at >= $2 AND created_at < $3 AND (created_at, id) > ($4, $5)
+		ORDER BY created_at, id LIMIT $6`, r.OrgID, r.From, r.To, after, r.CursorID, limit)
+	return out, err
+}
+
+// SaveReplayProgress stores the flips of a page and advances the replay's cursor and counters (r holds
+// the new totals). False when the worker no longer owns the replay: it was canceled or reclaimed.
+func SaveReplayProgress(ctx context.Context, r Replay, flips []ReplayFlip) (bool, error) {
+	tx, err := databasepkg.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, `UPDATE policy_replays SET cursor_at=$3, cursor_id=$4, read=$5, evaluated=$6, skipped=$7, flipped=$8, heartbeat_at=now()
+		WHERE id=$1 AND worker=$2 AND status='running'`, r.ID, r.Worker, r.CursorAt, r.CursorID, r.Read, r.Evaluated, r.Skipped, r.Flipped)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if len(flips) > 0 {
+		if _, err := tx.NamedExecContext(ctx, `INSERT INTO policy_replay_flips (replay_id, trace_id, agent_id, action, original, replayed, original_rules, replayed_rules, rules, reason, decided_at)
+			VALUES (:replay_id, :trace_id, :agent_id, :action, :original, :replayed, :original_rules, :replayed_rules, :rules, :reason, :decided_at)`, flips); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit()
+}
+
+// FinishReplay ends a replay the worker owns with status (succeeded or failed)
+func FinishReplay(ctx context.Context, id uuid.UUID, worker, status string, errMsg *string) error {
+	_, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_replays SET status=$3, error=$4, finished_at=now() WHERE id=$1 AND worker=$2 AND status='running'`, id, worker, status, errMsg)
+	return err
+}
+
+// CancelReplay cancels a queued or running replay; false when it had already finished
+func CancelReplay(ctx context.Context, orgID, id uuid.UUID) (bool, error) {
+	res, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_replays SET status='canceled', finished_at=now() WHERE id=$1 AND org_id=$2 AND status IN ('queued','running')`, id, orgID)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ReplayGroup counts the flips of a replay sharing an agent, action or rule, by transition (allow->deny)
+type ReplayGroup struct {
+	Key         string         `json:"key"`
+	Transitions map[string]int `json:"transitions"`
+	Total       int            `json:"total"`
+}
+
+// Dimensions flips are grouped by
+const (
+	ReplayByAgent  = "agent"
+	ReplayByAction = "action"
+	ReplayByRule   = "rule"
+)
+
+var replayGroupKeys = map[string]string{
+	ReplayByAgent:  `COALESCE(f.agent_id::text, '')`,
+	ReplayByAction: `f.action`,
+	ReplayByRule:   `r.rule_id`,
+}
+
+// ReplayGroups groups a replay's flips by agent, action or rule, the largest groups first (at most limit)
+func ReplayGroups(ctx context.Context, replayID uuid.UUID, by string, limit int) ([]ReplayGroup, error) {
+	key, ok := replayGroupKeys[by]
+	if !ok {
+		return nil, errors.New("group by agent, action or rule")
+	}
+	from := `policy_replay_flips f`
+	if by == ReplayByRule {
+		from += `, jsonb_array_elements_text(CASE WHEN jsonb_array_length(f.rules)=0 THEN '[""]'::jsonb ELSE f.rules END) AS r(rule_id)`
+	}
+	var rows []replayGroupRow
+	err := databasepkg.DB.SelectContext(ctx, &rows, `WITH g AS (SELECT `+key+` AS key, f.original || '->' || f.replayed AS transition, COUNT(*) AS count
+			FROM `+from+` WHERE f.replay_id=$1 GROUP BY 1, 2),
+		top AS (SELECT key FROM g GROUP BY key ORDER BY SUM(count) DESC, key LIMIT $2)
+		SELECT g.key, g.transition, g.count FROM g JOIN top USING (key)`, replayID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return groupTransitions(rows), nil
+}
+
+type replayGroupRow struct {
+	Key        string `db:"key"`
+	Transition string `db:"transition"`
+	Count      int    `db:"count"`
+}
+
+// groupTransitions folds (key, transition, count) rows into groups, the largest first
+func groupTransitions(rows []replayGroupRow) []ReplayGroup {
+	idx := map[string]int{}
+	out := []ReplayGroup{}
+	for _, r := range rows {
+		i, ok := idx[r.Key]
+		if !ok {
+			i = len(out)
+			idx[r.Key] = i
+			out = append(out, ReplayGroup{Key: r.Key, Transitions: map[string]int{}})
+		}
+		out[i].Transitions[r.Transition] += r.Count
+		out[i].Total += r.Count
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// StreamReplayFlips calls emit with every flip of a replay in trace order, a page at a time
+func StreamReplayFlips(ctx context.Context, replayID uuid.UUID, emit func(ReplayFlip) error) error {
+	var after int64
+	for {
+		page := []ReplayFlip{}
+		if err := databasepkg.DB.SelectContext(ctx, &page, `SELECT id, replay_id, trace_id, agent_id, action, original, replayed, original_rules, replayed_rules, rules, reason, decided_at
+			FROM policy_replay_flips WHERE replay_id=$1 AND id > $2 ORDER BY id LIMIT 1000`, replayID, after); err != nil {
+			return err
+		}
+		for _, f := range page {
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+		if len(page) < 1000 {
+			return nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestReplayRefs(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	tr := &Trace{Policies: []PolicyTrace{{PolicyID: a, PolicyVersion: 2, ScopeType: "agent", ScopeID: "x"}, {PolicyID: b, PolicyVersion: 1, ScopeType: "org"}}}
+	got := ReplayRefs(tr, &b, nil)
+	want := []ReplayRef{{PolicyID: a, Version: 2, ScopeType: "agent", ScopeID: "x"}, {PolicyID: b, Version: 1, ScopeType: "org"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("composed trace: %+v", got)
+	}
+	// traces from before composition name their policy in the columns; graph denials name none
+	v := 3
+	if got := ReplayRefs(&Trace{}, &a, &v); !reflect.DeepEqual(got, []ReplayRef{{PolicyID: a, Version: 3}}) {
+		t.Errorf("single policy trace: %+v", got)
+	}
+	if got := ReplayRefs(&Trace{Engine: "graph"}, nil, nil); got != nil {
+		t.Errorf("graph denial: %+v", got)
+	}
+}
+
+func TestReplayOutcomes(t *testing.T) {
+	cases := []struct {
+		allow bool
+		tr    *Trace
+		want  string
+	}{
+		{true, &Trace{}, OutcomeAllow},
+		{false, &Trace{}, OutcomeDeny},
+		{false, &Trace{RequireApproval: true}, OutcomeRequireApproval},
+		// an approval redeemed into an allow was a require_approval decision of the policies
+		{true, &Trace{ApprovalID: "ap1"}, OutcomeRequireApproval},
+		{false, nil, OutcomeDeny},
+	}
+	for i, c := range cases {
+		if got := OriginalOutcome(c.allow, c.tr); got != c.want {
+			t.Errorf("case %d: got %s, want %s", i, got, c.want)
+		}
+	}
+	if ReplayOutcome(Decision{}) != OutcomeDeny || ReplayOutcome(Decision{RequireApproval: true}) != OutcomeRequireApproval || ReplayOutcome(Decision{Allow: true}) != OutcomeAllow {
+		t.Error("replay outcomes")
+	}
+}
+
+func TestFlipRules(t *testing.T) {
+	if got := FlipRules(RuleIDs{"a", "b"}, RuleIDs{"b", "c"}); !reflect.DeepEqual(got, RuleIDs{"a", "c"}) {
+		t.Errorf("changed matches: %v", got)
+	}
+	// the same rules matched: an effect changed, and they are what flipped the decision
+	if got := FlipRules(RuleIDs{"a"}, RuleIDs{"a"}); !reflect.DeepEqual(got, RuleIDs{"a"}) {
+		t.Errorf("same matches: %v", got)
+	}
+	if got := FlipRules(nil, nil); got == nil || len(got) != 0 {
+		t.Errorf("no matches: %#v", got)
+	}
+}
+
+func TestGroupTransitions(t *testing.T) {
+	got := groupTransitions([]replayGroupRow{
+		{Key: "read", Transition: "allow->deny", Count: 2},
+		{Key: "write", Transition: "deny->allow", Count: 5},
+		{Key: "read", Transition: "deny->require_approval", Count: 1},
+		{Key: "delete", Transition: "allow->deny", Count: 3},
+	})
+	want := []ReplayGroup{
+		{Key: "write", Transitions: map[string]int{"deny->allow": 5}, Total: 5},
+		{Key: "delete", Transitions: map[string]int{"allow->deny": 3}, Total: 3},
+		{Key: "read", Transitions: map[string]int{"allow->deny": 2, "deny->require_approval": 1}, Total: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v", got)
+	}
+}
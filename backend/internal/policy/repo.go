@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 
 	databasepkg "github.com/Armour007/aura-backend/internal"
 	"github.com/google/uuid"
@@ -35,6 +36,14 @@ func AddVersion(ctx context.Context, policyID uuid.UUID, body json.RawMessage, c
 	return pv, nil
 }
 
+// SetVersionSource records where a version came from: the checksum of the body as submitted (before org
+// schema definitions are bundled into it) and the change ticket, e.g. a commit SHA; empty values are kept
+func SetVersionSource(ctx context.Context, policyID uuid.UUID, version int, checksum, changeTicket string) error {
+	_, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_versions SET checksum=COALESCE(NULLIF($3,''),checksum), change_ticket=COALESCE(NULLIF($4,''),change_ticket)
+		WHERE policy_id=$1 AND version=$2`, policyID, version, checksum, changeTicket)
+	return err
+}
+
 func Assign(ctx context.Context, policyID uuid.UUID, scopeType, scopeID string) error {
 	_, err := databasepkg.DB.ExecContext(ctx, `INSERT INTO policy_assignments (policy_id, scope_type, scope_id) VALUES ($1,$2,$3)`, policyID, scopeType, scopeID)
 	return err
@@ -107,6 +116,76 @@ func GetActiveAssignmentsForOrg(ctx context.Context, orgID uuid.UUID) ([]struct
 	return out, nil
 }
 
+// ApplicableAssignment is an active policy version reachable through one assignment scope
+type ApplicableAssignment struct {
+	Policy    databasepkg.Policy
+	Version   databasepkg.PolicyVersion
+	ScopeType string
+	ScopeID   string
+}
+
+// GetApplicableAssignments returns every active policy version assigned to the agent, to a team the agent
+// is a member of (team:<id>#member@agent:<id> tuples of the org) or to the org, most specific scope first.
+// A policy assigned at several scopes is returned once, at its most specific scope.
+func GetApplicableAssignments(ctx context.Context, orgID uuid.UUID, agentID string) ([]ApplicableAssignment, error) {
+	rows := []struct {
+		PolicyID  uuid.UUID `db:"policy_id"`
+		Version   int       `db:"version"`
+		ScopeType string    `db:"scope_type"`
+		ScopeID   string    `db:"scope_id"`
+	}{}
+	if err := databasepkg.DB.SelectContext(ctx, &rows, `
+		SELECT pa.policy_id, pv.version, pa.scope_type, pa.scope_id
+		FROM policy_assignments pa
+		JOIN policies p ON p.id=pa.policy_id
+		JOIN policy_versions pv ON pv.policy_id=pa.policy_id
+		WHERE p.org_id=$1 AND pv.status='active' AND (
+			(pa.scope_type='org' AND pa.scope_id=$2)
+			OR (pa.scope_type='agent' AND pa.scope_id=$3)
+			OR (pa.scope_type='team' AND pa.scope_id IN (
+				SELECT object_id FROM trust_tuples WHERE org_id=$1 AND object_type='team' AND relation='member' AND subject_type='agent' AND subject_id=$3))
+		)
+		ORDER BY CASE pa.scope_type WHEN 'agent' THEN 0 WHEN 'team' THEN 1 ELSE 2 END, pa.created_at, pv.version DESC
+	`, orgID, orgID.String(), agentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	out := make([]ApplicableAssignment, 0, len(rows))
+	seen := map[uuid.UUID]bool{}
+	for _, r := range rows {
+		if seen[r.PolicyID] {
+			continue
+		}
+		seen[r.PolicyID] = true
+		p, err := GetPolicy(ctx, r.PolicyID)
+		if err != nil {
+			return nil, err
+		}
+		v, err := GetVersion(ctx, r.PolicyID, r.Version)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, ApplicableAssignment{Policy: p, Version: v, ScopeType: r.ScopeType, ScopeID: r.ScopeID})
+	}
+	return out, nil
+}
+
+// GetCombiningAlg returns the org's policy combining algorithm, falling back to
+// AURA_POLICY_COMBINING_ALG and finally deny-overrides.
+func GetCombiningAlg(ctx context.Context, orgID uuid.UUID) string {
+	var alg sql.NullString
+	_ = databasepkg.DB.GetContext(ctx, &alg, `SELECT policy_combining_alg FROM organizations WHERE id=$1`, orgID)
+	if alg.Valid && ValidCombiningAlg(alg.String) {
+		return alg.String
+	}
+	if env := os.Getenv("AURA_POLICY_COMBINING_ALG"); ValidCombiningAlg(env) {
+		return env
+	}
+	return CombineDenyOverrides
+}
+
 // GetPolicy returns a policy by id
 func GetPolicy(ctx context.Context, id uuid.UUID) (databasepkg.Policy, error) {
 	var p databasepkg.Policy
@@ -144,12 +223,26 @@ func ApproveVersion(ctx context.Context, policyID uuid.UUID, version int, approv
 	return err
 }
 
-// RecordApproval stores an approval from a specific user for given policy version
-func RecordApproval(ctx context.Context, policyID uuid.UUID, version int, user *uuid.UUID) error {
+// ApprovalReview is what an approver was shown: the version the diff was computed against and its summary
+type ApprovalReview struct {
+	BaseVersion int
+	Summary     map[string]int
+}
+
+// RecordApproval stores an approval from a specific user for given policy version, with the diff they
+// reviewed when known; approving again refreshes the review
+func RecordApproval(ctx context.Context, policyID uuid.UUID, version int, user *uuid.UUID, review *ApprovalReview) error {
 	if user == nil {
 		return errors.New("user required for approval")
 	}
-	_, err := databasepkg.DB.ExecContext(ctx, `INSERT INTO policy_version_approvals(policy_id, version, user_id) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`, policyID, version, *user)
+	var base *int
+	var summary []byte
+	if review != nil {
+		base = &review.BaseVersion
+		summary, _ = json.Marshal(review.Summary)
+	}
+	_, err := databasepkg.DB.ExecContext(ctx, `INSERT INTO policy_version_approvals(policy_id, version, user_id, base_version, diff_summary) VALUES ($1,$2,$3,$4,$5)
+		ON CONFLICT (policy_id, version, user_id) DO UPDATE SET base_version=EXCLUDED.base_version, diff_summary=EXCLUDED.diff_summary`, policyID, version, *user, base, summary)
 	return err
 }
 
@@ -160,9 +253,106 @@ func CountApprovals(ctx context.Context, policyID uuid.UUID, version int) (int,
 	return n, err
 }
 
+// ActiveVersion returns the policy's active version number, 0 when none is active
+func ActiveVersion(ctx context.Context, policyID uuid.UUID) (int, error) {
+	var v int
+	err := databasepkg.DB.GetContext(ctx, &v, `SELECT COALESCE(MAX(version),0) FROM policy_versions WHERE policy_id=$1 AND status='active'`, policyID)
+	return v, err
+}
+
 // GetVersion returns a specific version for a policy
 func GetVersion(ctx context.Context, policyID uuid.UUID, version int) (databasepkg.PolicyVersion, error) {
 	var v databasepkg.PolicyVersion
 	err := databasepkg.DB.GetContext(ctx, &v, `SELECT id, policy_id, version, body, compiled_blob, checksum, status, created_by_user_id, created_at, approved_by_user_id, approved_at, activated_at FROM policy_versions WHERE policy_id=$1 AND version=$2`, policyID, version)
 	return v, err
 }
+
+// PutSchemaDef creates or replaces a shared org-level schema definition, bumping its version
+func PutSchemaDef(ctx context.Context, orgID uuid.UUID, name string, schema json.RawMessage, updatedBy *uuid.UUID) (databasepkg.PolicySchemaDef, error) {
+	var d databasepkg.PolicySchemaDef
+	err := databasepkg.DB.QueryRowxContext(ctx, `INSERT INTO policy_schema_defs (org_id,name,schema,updated_by_user_id) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (org_id,name) DO UPDATE SET schema=EXCLUDED.schema, version=policy_schema_defs.version+1, updated_by_user_id=EXCLUDED.updated_by_user_id, updated_at=now()
+		RETURNING org_id, name, schema, version, updated_by_user_id, updated_at`, orgID, name, schema, updatedBy).StructScan(&d)
+	return d, err
+}
+
+// GetSchemaDef returns one shared schema definition; sql.ErrNoRows when missing
+func GetSchemaDef(ctx context.Context, orgID uuid.UUID, name string) (databasepkg.PolicySchemaDef, error) {
+	var d databasepkg.PolicySchemaDef
+	err := databasepkg.DB.GetContext(ctx, &d, `SELECT org_id, name, schema, version, updated_by_user_id, updated_at FROM policy_schema_defs WHERE org_id=$1 AND name=$2`, orgID, name)
+	return d, err
+}
+
+// ListSchemaDefs returns the org's shared schema definitions ordered by name
+func ListSchemaDefs(ctx context.Context, orgID uuid.UUID) ([]databasepkg.PolicySchemaDef, error) {
+	out := []databasepkg.PolicySchemaDef{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT org_id, name, schema, version, updated_by_user_id, updated_at FROM policy_schema_defs WHERE org_id=$1 ORDER BY name`, orgID)
+	return out, err
+}
+
+// DeleteSchemaDef removes a shared schema definition; stored policy versions keep their bundled copy
+func DeleteSchemaDef(ctx context.Context, orgID uuid.UUID, name string) (bool, error) {
+	res, err := databasepkg.DB.ExecContext(ctx, `DELETE FROM policy_schema_defs WHERE org_id=$1 AND name=$2`, orgID, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// BundleOrgSchemaDefs embeds the org's shared definitions referenced by body (see BundleSchemaDefs)
+func BundleOrgSchemaDefs(ctx context.Context, orgID uuid.UUID, body json.RawMessage) (json.RawMessage, error) {
+	return BundleSchemaDefs(body, func(name string) (json.RawMessage, error) {
+		d, err := GetSchemaDef(ctx, orgID, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("not defined for this organization")
+		}
+		if err != nil {
+			return nil, err
+		}
+		return d.Schema, nil
+	})
+}
+
+// PutRegoData creates or replaces an org data document (mounted at data.<name> for Rego), bumping its revision
+func PutRegoData(ctx context.Context, orgID uuid.UUID, name string, data json.RawMessage, updatedBy *uuid.UUID) (databasepkg.RegoDataBundle, error) {
+	var d databasepkg.RegoDataBundle
+	err := databasepkg.DB.QueryRowxContext(ctx, `INSERT INTO rego_data_bundles (org_id,name,data,updated_by_user_id) VALUES ($1,$2,$3,$4)
+		ON CONFLICT (org_id,name) DO UPDATE SET data=EXCLUDED.data, revision=rego_data_bundles.revision+1, updated_by_user_id=EXCLUDED.updated_by_user_id, updated_at=now()
+		RETURNING org_id, name, data, revision, updated_by_user_id, updated_at`, orgID, name, data, updatedBy).StructScan(&d)
+	return d, err
+}
+
+// ListRegoData returns the org's data documents ordered by name
+func ListRegoData(ctx context.Context, orgID uuid.UUID) ([]databasepkg.RegoDataBundle, error) {
+	out := []databasepkg.RegoDataBundle{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT org_id, name, data, revision, updated_by_user_id, updated_at FROM rego_data_bundles WHERE org_id=$1 ORDER BY name`, orgID)
+	return out, err
+}
+
+// DeleteRegoData removes an org data document
+func DeleteRegoData(ctx context.Context, orgID uuid.UUID, name string) (bool, error) {
+	res, err := databasepkg.DB.ExecContext(ctx, `DELETE FROM rego_data_bundles WHERE org_id=$1 AND name=$2`, orgID, name)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetRegoDataDocument assembles the org's data documents into the Rego base document, keyed by name
+func GetRegoDataDocument(ctx context.Context, orgID uuid.UUID) (map[string]any, error) {
+	rows, err := ListRegoData(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]any, len(rows))
+	for _, r := range rows {
+		var v any
+		if err := json.Unmarshal(r.Data, &v); err != nil {
+			return nil, fmt.Errorf("data %s: %w", r.Name, err)
+		}
+		doc[r.Name] = v
+	}
+	return doc, nil
+}
@@ -1,72 +1,185 @@
 package policy
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
 )
 
-// validateAgainstSchema implements a very small subset of JSON Schema:
-// { "required": ["field"], "properties": {"field": {"type": "string"|"number"|"boolean"|"object"|"array"} } }
-// Returns a slice of validation error messages (empty if valid)
-func validateAgainstSchema(input json.RawMessage, schema map[string]any) []string {
+// SchemaRefPrefix identifies shared org-level schema definitions in `$ref`, e.g.
+// {"$ref": "urn:aura:schema:payment_request"}. They are embedded into the policy schema's
+// `$defs` (with a matching `$id`) by BundleSchemaDefs when a version is stored, so a
+// compiled policy never depends on definitions changing underneath it.
+const SchemaRefPrefix = "urn:aura:schema:"
+
+// InputSchema is a compiled JSON Schema (draft 2020-12) for policy input
+type InputSchema struct {
+	schema *jsonschema.Schema
+}
+
+// CompileInputSchema compiles a policy `schema` block; schemas without `$schema` are treated as draft 2020-12.
+// References are only resolved within the document: remote and file URLs are never fetched.
+func CompileInputSchema(raw any) (*InputSchema, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(noSchemaLoader{})
+	const loc = "urn:aura:policy-input"
+	if err := c.AddResource(loc, doc); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	s, err := c.Compile(loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &InputSchema{schema: s}, nil
+}
+
+type noSchemaLoader struct{}
+
+func (noSchemaLoader) Load(url string) (any, error) {
+	return nil, fmt.Errorf("unresolved schema reference %s", url)
+}
+
+// Validate returns one message per failing keyword, prefixed with the JSON pointer (as a URI
+// fragment) of the offending input location, e.g. "#/request/amount: minimum: got -5, want 0".
+// An empty slice means the input is valid.
+func (s *InputSchema) Validate(input json.RawMessage) []string {
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(input))
+	if err != nil {
+		return []string{"#: input is not valid JSON"}
+	}
+	err = s.schema.Validate(inst)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []string{"#: " + err.Error()}
+	}
 	var errs []string
-	var m map[string]any
-	if err := json.Unmarshal(input, &m); err != nil {
-		return []string{"input is not valid JSON object"}
-	}
-	// required fields
-	if req, ok := schema["required"].([]any); ok {
-		for _, r := range req {
-			name, _ := r.(string)
-			if name == "" {
-				continue
-			}
-			if _, ok := m[name]; !ok {
-				errs = append(errs, fmt.Sprintf("missing required field '%s'", name))
-			}
+	seen := map[string]bool{}
+	collectSchemaErrors(ve, &errs, seen)
+	sort.Strings(errs)
+	if len(errs) == 0 {
+		errs = append(errs, "#: "+ve.Error())
+	}
+	return errs
+}
+
+var schemaMsgPrinter = message.NewPrinter(language.English)
+
+// collectSchemaErrors flattens the error tree to its leaves, which carry the precise keyword failures
+func collectSchemaErrors(ve *jsonschema.ValidationError, errs *[]string, seen map[string]bool) {
+	if len(ve.Causes) > 0 {
+		for _, c := range ve.Causes {
+			collectSchemaErrors(c, errs, seen)
 		}
+		return
 	}
-	// properties types
-	if props, ok := schema["properties"].(map[string]any); ok {
-		for name, specRaw := range props {
-			spec, _ := specRaw.(map[string]any)
-			expected, _ := spec["type"].(string)
-			if expected == "" {
-				continue
-			}
-			v, ok := m[name]
-			if !ok {
+	msg := "#" + jsonPointer(ve.InstanceLocation) + ": " + ve.ErrorKind.LocalizedString(schemaMsgPrinter)
+	if !seen[msg] {
+		seen[msg] = true
+		*errs = append(*errs, msg)
+	}
+}
+
+func jsonPointer(tokens []string) string {
+	var sb strings.Builder
+	for _, t := range tokens {
+		sb.WriteByte('/')
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+// SchemaDefRefs returns the names of shared org-level definitions referenced anywhere in v
+func SchemaDefRefs(v any) []string {
+	set := map[string]bool{}
+	collectSchemaRefs(v, set)
+	out := make([]string, 0, len(set))
+	for n := range set {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func collectSchemaRefs(v any, set map[string]bool) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, x := range t {
+			if s, ok := x.(string); ok && k == "$ref" && strings.HasPrefix(s, SchemaRefPrefix) {
+				name := strings.TrimPrefix(s, SchemaRefPrefix)
+				if i := strings.Index(name, "#"); i >= 0 {
+					name = name[:i]
+				}
+				set[name] = true
 				continue
-			} // handled by required above if needed
-			if !typeMatches(v, expected) {
-				errs = append(errs, fmt.Sprintf("field '%s' expected type %s", name, expected))
 			}
+			collectSchemaRefs(x, set)
+		}
+	case []any:
+		for _, x := range t {
+			collectSchemaRefs(x, set)
 		}
 	}
-	return errs
 }
 
-func typeMatches(v any, t string) bool {
-	switch t {
-	case "string":
-		_, ok := v.(string)
-		return ok
-	case "number":
-		switch v.(type) {
-		case float64, int, int64, json.Number:
-			return true
+// BundleSchemaDefs embeds every shared definition referenced (transitively) from the policy body's
+// `schema` block into `schema.$defs`, keyed and identified by its URN. lookup returns the stored
+// definition for a name or an error when it does not exist. Bodies without a schema are returned as-is.
+func BundleSchemaDefs(body json.RawMessage, lookup func(name string) (json.RawMessage, error)) (json.RawMessage, error) {
+	var m map[string]any
+	if err := json.Unmarshal(body, &m); err != nil {
+		return body, nil // let the engine report malformed bodies
+	}
+	schema, ok := m["schema"].(map[string]any)
+	if !ok {
+		return body, nil
+	}
+	defs, _ := schema["$defs"].(map[string]any)
+	if defs == nil {
+		defs = map[string]any{}
+	}
+	pending := SchemaDefRefs(schema)
+	added := false
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		key := SchemaRefPrefix + name
+		if _, ok := defs[key]; ok {
+			continue
+		}
+		raw, err := lookup(name)
+		if err != nil {
+			return nil, fmt.Errorf("schema definition %q: %w", name, err)
 		}
-		return false
-	case "boolean":
-		_, ok := v.(bool)
-		return ok
-	case "object":
-		_, ok := v.(map[string]any)
-		return ok
-	case "array":
-		_, ok := v.([]any)
-		return ok
-	default:
-		return true
+		var def map[string]any
+		if err := json.Unmarshal(raw, &def); err != nil {
+			return nil, fmt.Errorf("schema definition %q: %w", name, err)
+		}
+		def["$id"] = key
+		defs[key] = def
+		added = true
+		pending = append(pending, SchemaDefRefs(def)...)
+	}
+	if !added {
+		return body, nil
 	}
+	schema["$defs"] = defs
+	return json.Marshal(m)
 }
@@ -0,0 +1,76 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSchema_ValidationPointers(t *testing.T) {
+	body := `{"schema":{"type":"object","required":["request","env"],"properties":{
+		"env":{"enum":["prod","dev"]},
+		"request":{"type":"object","required":["amount"],"properties":{
+			"amount":{"type":"number","minimum":0,"maximum":10000},
+			"currency":{"type":"string","pattern":"^[A-Z]{3}$"},
+			"lines":{"type":"array","items":{"type":"object","required":["sku"]}}}}}},
+		"rules":[{"id":"allow","effect":"allow"}]}`
+	d := evalFor(t, body, `{"env":"qa","request":{"amount":-5,"currency":"usd","lines":[{"sku":"a"},{}]}}`)
+	if d.Allow || d.Reason != "Schema validation failed" {
+		t.Fatalf("expected schema deny, got %+v", d)
+	}
+	want := []string{"#/env:", "#/request/amount: minimum", "#/request/currency:", "#/request/lines/1: missing property 'sku'"}
+	got := strings.Join(d.Trace.Validations, "\n")
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Fatalf("expected validation %q in:\n%s", w, got)
+		}
+	}
+	if d := evalFor(t, body, `{"env":"prod","request":{"amount":5}}`); !d.Allow {
+		t.Fatalf("expected valid input to be allowed, got %+v", d.Trace.Validations)
+	}
+}
+
+func TestSchema_CompileRejectsInvalidSchema(t *testing.T) {
+	e := &AuraJSONEvaluator{}
+	for _, b := range []string{
+		`{"schema":{"type":"bogus"},"rules":[]}`,
+		`{"schema":{"properties":{"a":{"minimum":"zero"}}},"rules":[]}`,
+		`{"schema":{"$ref":"urn:aura:schema:missing"},"rules":[]}`,
+		`{"schema":{"$ref":"file:///etc/passwd"},"rules":[]}`,
+	} {
+		if _, err := e.Compile(json.RawMessage(b)); err == nil {
+			t.Fatalf("expected compile error for %s", b)
+		}
+	}
+}
+
+func TestSchema_BundleOrgDefs(t *testing.T) {
+	defs := map[string]string{
+		"payment": `{"type":"object","required":["amount"],"properties":{"amount":{"type":"number"},"payee":{"$ref":"urn:aura:schema:party"}}}`,
+		"party":   `{"type":"object","required":["id"]}`,
+	}
+	lookup := func(name string) (json.RawMessage, error) {
+		if s, ok := defs[name]; ok {
+			return json.RawMessage(s), nil
+		}
+		return nil, errors.New("not defined")
+	}
+	body := json.RawMessage(`{"schema":{"properties":{"request":{"$ref":"urn:aura:schema:payment"}}},"rules":[{"id":"allow","effect":"allow"}]}`)
+	bundled, err := BundleSchemaDefs(body, lookup)
+	if err != nil {
+		t.Fatalf("bundle: %v", err)
+	}
+	d := evalFor(t, string(bundled), `{"request":{"amount":1,"payee":{}}}`)
+	if d.Allow || len(d.Trace.Validations) != 1 || !strings.HasPrefix(d.Trace.Validations[0], "#/request/payee: missing property 'id'") {
+		t.Fatalf("expected transitive ref validation, got %+v", d.Trace.Validations)
+	}
+	// already-bundled bodies are stable
+	again, _ := BundleSchemaDefs(bundled, lookup)
+	if string(again) != string(bundled) {
+		t.Fatalf("re-bundling changed the body")
+	}
+	if _, err := BundleSchemaDefs(json.RawMessage(`{"schema":{"$ref":"urn:aura:schema:nope"}}`), lookup); err == nil {
+		t.Fatalf("expected unknown definition error")
+	}
+}
@@ -0,0 +1,268 @@
+package policy
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// Kinds of disagreement between a shadowed candidate version and the live decision
+const (
+	ShadowDecision = "decision" // allow and deny swapped
+	ShadowApproval = "approval" // both deny, but only one requires approval
+	ShadowError    = "error"    // the candidate version failed to compile
+)
+
+// Shadow evaluates a candidate version of a policy next to the version serving /v2/verify, on a
+// percentage of live decisions, without affecting them. Evaluated and Diverged count the sampled decisions.
+type Shadow struct {
+	ID        uuid.UUID  `db:"id" json:"id"`
+	OrgID     uuid.UUID  `db:"org_id" json:"org_id"`
+	PolicyID  uuid.UUID  `db:"policy_id" json:"policy_id"`
+	Version   int        `db:"version" json:"version"`
+	Percent   int        `db:"percent" json:"percent"`
+	Active    bool       `db:"active" json:"active"`
+	Evaluated int64      `db:"evaluated" json:"evaluated"`
+	Diverged  int64      `db:"diverged" json:"diverged"`
+	CreatedBy *uuid.UUID `db:"created_by_user_id" json:"created_by,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	StoppedAt *time.Time `db:"stopped_at" json:"stopped_at,omitempty"`
+}
+
+// ShadowDivergence is one live decision the candidate disagreed with, keyed by the live trace id
+type ShadowDivergence struct {
+	ID                    int64      `db:"id" json:"id"`
+	ShadowID              uuid.UUID  `db:"shadow_id" json:"shadow_id"`
+	OrgID                 uuid.UUID  `db:"org_id" json:"org_id"`
+	TraceID               string     `db:"trace_id" json:"trace_id"`
+	AgentID               *uuid.UUID `db:"agent_id" json:"agent_id,omitempty"`
+	ActiveVersion         int        `db:"active_version" json:"active_version"`
+	Kind                  string     `db:"kind" json:"kind"`
+	ActiveAllow           bool       `db:"active_allow" json:"active_allow"`
+	ActiveRequireApproval bool       `db:"active_require_approval" json:"active_require_approval"`
+	ActiveReason          *string    `db:"active_reason" json:"active_reason,omitempty"`
+	ActiveRules           RuleIDs    `db:"active_rules" json:"active_rules"`
+	ShadowAllow           *bool      `db:"shadow_allow" json:"shadow_allow,omitempty"`
+	ShadowRequireApproval *bool      `db:"shadow_require_approval" json:"shadow_require_approval,omitempty"`
+	ShadowReason          *string    `db:"shadow_reason" json:"shadow_reason,omitempty"`
+	ShadowRules           RuleIDs    `db:"shadow_rules" json:"shadow_rules"`
+	CreatedAt             time.Time  `db:"created_at" json:"created_at"`
+}
+
+// RuleIDs lists rule ids, stored as a jsonb array
+type RuleIDs []string
+
+func (r RuleIDs) Value() (driver.Value, error) {
+	if r == nil {
+		r = RuleIDs{}
+	}
+	b, err := json.Marshal([]string(r))
+	return string(b), err
+}
+
+func (r *RuleIDs) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = RuleIDs{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(r))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(r))
+	}
+	return fmt.Errorf("rule ids: cannot scan %T", src)
+}
+
+// ShadowRuleStat counts the divergences in which a rule matched, on one side of the comparison.
+// An empty RuleID stands for divergences where no rule of the policy matched.
+type ShadowRuleStat struct {
+	Side   string         `json:"side"` // active|shadow
+	RuleID string         `json:"rule_id"`
+	Kinds  map[string]int `json:"kinds"`
+	Total  int            `json:"total"`
+}
+
+// CompareShadow returns the kind of disagreement between the live decision and the decision the
+// candidate would have made, or "" when they agree. Decisions are compared before approval redemption.
+func CompareShadow(live, shadow Decision) string {
+	if live.Allow != shadow.Allow {
+		return ShadowDecision
+	}
+	if !live.Allow && live.RequireApproval != shadow.RequireApproval {
+		return ShadowApproval
+	}
+	return ""
+}
+
+// MatchedRules returns the ids of the rules of policyID that matched in a combined decision
+func MatchedRules(d Decision, policyID uuid.UUID) RuleIDs {
+	out := RuleIDs{}
+	if d.Trace == nil {
+		return out
+	}
+	for _, p := range d.Trace.Policies {
+		if p.PolicyID != policyID {
+			continue
+		}
+		for _, r := range p.EvaluatedRules {
+			if r.Matched {
+				out = append(out, r.RuleID)
+			}
+		}
+	}
+	return out
+}
+
+const shadowCols = `id, org_id, policy_id, version, percent, active, evaluated, diverged, created_by_user_id, created_at, stopped_at`
+
+// StartShadow starts shadowing version of a policy, stopping the policy's running shadow
+func StartShadow(ctx context.Context, orgID, policyID uuid.UUID, version, percent int, createdBy *uuid.UUID) (Shadow, error) {
+	tx, err := databasepkg.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return Shadow{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `UPDATE policy_shadows SET active=false, stopped_at=now() WHERE policy_id=$1 AND active=true`, policyID); err != nil {
+		return Shadow{}, err
+	}
+	var s Shadow
+	if err := tx.QueryRowxContext(ctx, `INSERT INTO policy_shadows (org_id, policy_id, version, percent, created_by_user_id) VALUES ($1,$2,$3,$4,$5) RETURNING `+shadowCols,
+		orgID, policyID, version, percent, createdBy).StructScan(&s); err != nil {
+		return Shadow{}, err
+	}
+	return s, tx.Commit()
+}
+
+// StopShadow stops the running shadow of a policy (only when it shadows version, unless version is 0);
+// false when none was running
+func StopShadow(ctx context.Context, orgID, policyID uuid.UUID, version int) (bool, error) {
+	res, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_shadows SET active=false, stopped_at=now() WHERE org_id=$1 AND policy_id=$2 AND active=true AND ($3=0 OR version=$3)`, orgID, policyID, version)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ActiveShadows returns the org's running shadows
+func ActiveShadows(ctx context.Context, orgID uuid.UUID) ([]Shadow, error) {
+	out := []Shadow{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+shadowCols+` FROM policy_shadows WHERE org_id=$1 AND active=true`, orgID)
+	return out, err
+}
+
+// ListShadows returns the shadows of a policy, most recent first
+func ListShadows(ctx context.Context, orgID, policyID uuid.UUID) ([]Shadow, error) {
+	out := []Shadow{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+shadowCols+` FROM policy_shadows WHERE org_id=$1 AND policy_id=$2 ORDER BY created_at DESC`, orgID, policyID)
+	return out, err
+}
+
+// GetShadow returns a shadow of a policy, or nil when there is none with that id
+func GetShadow(ctx context.Context, orgID, policyID, id uuid.UUID) (*Shadow, error) {
+	var s Shadow
+	err := databasepkg.DB.GetContext(ctx, &s, `SELECT `+shadowCols+` FROM policy_shadows WHERE id=$1 AND org_id=$2 AND policy_id=$3`, id, orgID, policyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// AddShadowCounts adds sampled and diverged decisions to a shadow's counters
+func AddShadowCounts(ctx context.Context, id uuid.UUID, evaluated, diverged int64) error {
+	_, err := databasepkg.DB.ExecContext(ctx, `UPDATE policy_shadows SET evaluated=evaluated+$2, diverged=diverged+$3 WHERE id=$1`, id, evaluated, diverged)
+	return err
+}
+
+func RecordShadowDivergence(ctx context.Context, d ShadowDivergence) error {
+	_, err := databasepkg.DB.NamedExecContext(ctx, `INSERT INTO policy_shadow_divergences (shadow_id, org_id, trace_id, agent_id, active_version, kind,
+		active_allow, active_require_approval, active_reason, active_rules, shadow_allow, shadow_require_approval, shadow_reason, shadow_rules)
+		VALUES (:shadow_id, :org_id, :trace_id, :agent_id, :active_version, :kind,
+		:active_allow, :active_require_approval, :active_reason, :active_rules, :shadow_allow, :shadow_require_approval, :shadow_reason, :shadow_rules)`, d)
+	return err
+}
+
+// ListShadowDivergences returns a shadow's most recent divergences, optionally of one kind
+func ListShadowDivergences(ctx context.Context, shadowID uuid.UUID, kind string, limit int) ([]ShadowDivergence, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	out := []ShadowDivergence{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT id, shadow_id, org_id, trace_id, agent_id, active_version, kind, active_allow, active_require_approval, active_reason,
+		active_rules, shadow_allow, shadow_require_approval, shadow_reason, shadow_rules, created_at
+		FROM policy_shadow_divergences WHERE shadow_id=$1 AND ($2='' OR kind=$2) ORDER BY created_at DESC LIMIT $3`, shadowID, kind, limit)
+	return out, err
+}
+
+// ShadowDivergenceKinds counts a shadow's divergences by kind
+func ShadowDivergenceKinds(ctx context.Context, shadowID uuid.UUID) (map[string]int, error) {
+	var rows []struct {
+		Kind  string `db:"kind"`
+		Count int    `db:"count"`
+	}
+	if err := databasepkg.DB.SelectContext(ctx, &rows, `SELECT kind, COUNT(*) AS count FROM policy_shadow_divergences WHERE shadow_id=$1 GROUP BY kind`, shadowID); err != nil {
+		return nil, err
+	}
+	out := map[string]int{}
+	for _, r := range rows {
+		out[r.Kind] = r.Count
+	}
+	return out, nil
+}
+
+// ShadowRuleStats aggregates a shadow's divergences by the rules that matched on each side, the rules
+// involved in the most divergences first
+func ShadowRuleStats(ctx context.Context, shadowID uuid.UUID) ([]ShadowRuleStat, error) {
+	var rows []struct {
+		Side   string `db:"side"`
+		RuleID string `db:"rule_id"`
+		Kind   string `db:"kind"`
+		Count  int    `db:"count"`
+	}
+	err := databasepkg.DB.SelectContext(ctx, &rows, `SELECT 'shadow' AS side, r.rule_id, d.kind, COUNT(*) AS count
+		FROM policy_shadow_divergences d, jsonb_array_elements_text(CASE WHEN jsonb_array_length(d.shadow_rules)=0 THEN '[""]'::jsonb ELSE d.shadow_rules END) AS r(rule_id)
+		WHERE d.shadow_id=$1 AND d.kind<>'error' GROUP BY r.rule_id, d.kind
+		UNION ALL
+		SELECT 'active' AS side, r.rule_id, d.kind, COUNT(*) AS count
+		FROM policy_shadow_divergences d, jsonb_array_elements_text(CASE WHEN jsonb_array_length(d.active_rules)=0 THEN '[""]'::jsonb ELSE d.active_rules END) AS r(rule_id)
+		WHERE d.shadow_id=$1 GROUP BY r.rule_id, d.kind`, shadowID)
+	if err != nil {
+		return nil, err
+	}
+	byRule := map[[2]string]*ShadowRuleStat{}
+	out := []ShadowRuleStat{}
+	for _, r := range rows {
+		k := [2]string{r.Side, r.RuleID}
+		st := byRule[k]
+		if st == nil {
+			st = &ShadowRuleStat{Side: r.Side, RuleID: r.RuleID, Kinds: map[string]int{}}
+			byRule[k] = st
+		}
+		st.Kinds[r.Kind] += r.Count
+		st.Total += r.Count
+	}
+	for _, st := range byRule {
+		out = append(out, *st)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Total != out[j].Total {
+			return out[i].Total > out[j].Total
+		}
+		if out[i].Side != out[j].Side {
+			return out[i].Side > out[j].Side
+		}
+		return out[i].RuleID < out[j].RuleID
+	})
+	return out, nil
+}
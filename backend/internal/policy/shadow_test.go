@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCompareShadow(t *testing.T) {
+	allow := Decision{Allow: true}
+	deny := Decision{}
+	approval := Decision{RequireApproval: true}
+	cases := []struct {
+		live, shadow Decision
+		want         string
+	}{
+		{allow, allow, ""},
+		{deny, deny, ""},
+		{approval, approval, ""},
+		{allow, deny, ShadowDecision},
+		{approval, allow, ShadowDecision},
+		{deny, approval, ShadowApproval},
+		{approval, deny, ShadowApproval},
+		// an allow that carries a stale require_approval flag is still an allow
+		{allow, Decision{Allow: true, RequireApproval: true}, ""},
+	}
+	for i, c := range cases {
+		if got := CompareShadow(c.live, c.shadow); got != c.want {
+			t.Errorf("case %d: got %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestMatchedRules(t *testing.T) {
+	pid, other := uuid.New(), uuid.New()
+	d := Decision{Trace: &Trace{Policies: []PolicyTrace{
+		{PolicyID: other, EvaluatedRules: []RuleTrace{{RuleID: "x", Matched: true}}},
+		{PolicyID: pid, EvaluatedRules: []RuleTrace{{RuleID: "a", Matched: true}, {RuleID: "b"}, {RuleID: "c", Matched: true}}},
+	}}}
+	if got := MatchedRules(d, pid); !reflect.DeepEqual(got, RuleIDs{"a", "c"}) {
+		t.Errorf("got %v", got)
+	}
+	if got := MatchedRules(Decision{}, pid); got == nil || len(got) != 0 {
+		t.Errorf("no trace: got %#v", got)
+	}
+}
+
+func TestRuleIDsColumn(t *testing.T) {
+	v, err := RuleIDs(nil).Value()
+	if err != nil || v != "[]" {
+		t.Fatalf("nil value: %v %v", v, err)
+	}
+	v, _ = RuleIDs{"a", "b"}.Value()
+	var got RuleIDs
+	if err := got.Scan([]byte(v.(string))); err != nil || !reflect.DeepEqual(got, RuleIDs{"a", "b"}) {
+		t.Errorf("round trip: %v %v", got, err)
+	}
+	if err := got.Scan(42); err == nil {
+		t.Error("expected an error scanning an int")
+	}
+}
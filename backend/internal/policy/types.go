@@ -1,6 +1,7 @@
 package policy
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -21,6 +22,11 @@ type Decision struct {
 	Trace           *Trace   `json:"trace,omitempty"`
 	RequireApproval bool     `json:"require_approval,omitempty"`
 	Hints           []string `json:"hints,omitempty"`
+	// Approvers names the approver groups a require_approval decision is routed to (empty: org default)
+	Approvers []string `json:"approvers,omitempty"`
+	// Obligations must be enforced by the caller; Advice is informational
+	Obligations []Obligation `json:"obligations,omitempty"`
+	Advice      []Obligation `json:"advice,omitempty"`
 }
 
 // Trace captures explainability details
@@ -35,6 +41,37 @@ type Trace struct {
 	At             time.Time       `json:"at"`
 	Engine         string          `json:"engine"`
 	Validations    []string        `json:"validations,omitempty"`
+	Obligations    []Obligation    `json:"obligations,omitempty"`
+	Advice         []Obligation    `json:"advice,omitempty"`
+	// Combining and Policies describe multi-policy composition (algorithm and every contributing policy)
+	Combining string        `json:"combining,omitempty"`
+	Policies  []PolicyTrace `json:"policies,omitempty"`
+	// Explain holds engine-level evaluation trace lines (OPA explain output for Rego policies)
+	Explain []string `json:"explain,omitempty"`
+	// Graph records the relationship check the decision depended on, with its proof
+	Graph *GraphTrace `json:"graph,omitempty"`
+	// Cached is set when the decision was served from the verify decision cache
+	Cached bool `json:"cached,omitempty"`
+	// RequireApproval, Hints and Approvers are kept so an approval request can be opened from the stored
+	// trace; ApprovalID is set when an approved request turned the decision into an allow
+	RequireApproval bool     `json:"require_approval,omitempty"`
+	Hints           []string `json:"hints,omitempty"`
+	Approvers       []string `json:"approvers,omitempty"`
+	ApprovalID      string   `json:"approval_id,omitempty"`
+}
+
+// GraphTrace records a relationship check (subject relation object, e.g. agent:a1 can_act_for org:o1).
+// Explanation is the graph's proof: the tuples of the path that granted it, or the tuple sets a denial
+// read (the SpiceDB debug trace when SpiceDB answered).
+type GraphTrace struct {
+	Subject        string          `json:"subject"`
+	Relation       string          `json:"relation"`
+	Object         string          `json:"object"`
+	Permissionship string          `json:"permissionship"`
+	MissingContext []string        `json:"missing_context,omitempty"`
+	Source         string          `json:"source,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	Explanation    json.RawMessage `json:"explanation,omitempty"`
 }
 
 // PrincipalTrace captures caller identity included in traces
@@ -51,6 +88,9 @@ type RuleTrace struct {
 	Matched bool   `json:"matched"`
 	Effect  string `json:"effect"`
 	Reason  string `json:"reason,omitempty"`
+	// Relations records the trust graph checks the rule made, in call order (memoized checks included).
+	// Rego rules cannot be told apart, so each rule of a Rego decision lists every check of the evaluation.
+	Relations []GraphTrace `json:"relations,omitempty"`
 }
 
 // Evaluator is the pluggable policy evaluator interface
@@ -60,6 +100,26 @@ type Evaluator interface {
 	Name() string
 }
 
+// DataCompiler is implemented by engines that evaluate against external data documents (Rego `data`).
+// Callers that know the owning org compile with its data; Compile is equivalent to no data.
+type DataCompiler interface {
+	CompileWithData(policyBody json.RawMessage, data map[string]any) (CompiledPolicy, error)
+}
+
+// ContextEvaluator is implemented by engines whose policies can call the trust graph; the checker and
+// memo travel in ctx (see WithRelations). Evaluate is equivalent to evaluating without a graph.
+type ContextEvaluator interface {
+	EvaluateContext(ctx context.Context, compiled CompiledPolicy, input json.RawMessage) (Decision, error)
+}
+
+// EvaluateWithContext evaluates with ctx when the engine accepts it
+func EvaluateWithContext(ctx context.Context, e Evaluator, compiled CompiledPolicy, input json.RawMessage) (Decision, error) {
+	if ce, ok := e.(ContextEvaluator); ok {
+		return ce.EvaluateContext(ctx, compiled, input)
+	}
+	return e.Evaluate(compiled, input)
+}
+
 // CompiledPolicy is an opaque compiled artifact
 type CompiledPolicy interface{}
 
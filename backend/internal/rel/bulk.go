@@ -0,0 +1,462 @@
+package rel
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+)
+
+// Bulk formats of tuple import and export
+const (
+	// FormatNDJSON is one JSON tuple per line
+	FormatNDJSON = "ndjson"
+	// FormatCSV has a header row and the caveat as its name and JSON context
+	FormatCSV = "csv"
+	// FormatZed is SpiceDB's relationship syntax as read by zed: type:id#relation@type:id[#relation][caveat:{context}]
+	FormatZed = "zed"
+)
+
+// ErrUnknownFormat is returned for bulk formats other than ndjson, csv and zed
+var ErrUnknownFormat = errors.New("unknown tuple format")
+
+var csvHeader = []string{"object_type", "object_id", "relation", "subject_type", "subject_id", "subject_relation", "caveat_name", "caveat_context"}
+
+// TupleEncoder writes tuples in a bulk format; Flush writes what is buffered
+type TupleEncoder interface {
+	Encode(t Tuple) error
+	Flush() error
+}
+
+// TupleDecoder reads tuples in a bulk format until io.EOF. Line is the input line of the last tuple read,
+// so errors can point at it; a malformed line does not stop the decoder.
+type TupleDecoder interface {
+	Decode() (Tuple, error)
+	Line() int
+}
+
+// NewTupleEncoder returns an encoder of format writing to w
+func NewTupleEncoder(w io.Writer, format string) (TupleEncoder, error) {
+	switch format {
+	case FormatNDJSON:
+		bw := bufio.NewWriter(w)
+		return &lineEncoder{w: bw, line: func(t Tuple) (string, error) {
+			b, err := json.Marshal(t)
+			return string(b), err
+		}}, nil
+	case FormatZed:
+		return &lineEncoder{w: bufio.NewWriter(w), line: func(t Tuple) (string, error) { return FormatTuple(t), nil }}, nil
+	case FormatCSV:
+		return &csvEncoder{w: csv.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+}
+
+// NewTupleDecoder returns a decoder of format reading from r
+func NewTupleDecoder(r io.Reader, format string) (TupleDecoder, error) {
+	switch format {
+	case FormatNDJSON:
+		return newLineDecoder(r, func(s string) (Tuple, error) {
+			var t Tuple
+			if err := json.Unmarshal([]byte(s), &t); err != nil {
+				return Tuple{}, fmt.Errorf("%w: %v", ErrInvalidTuple, err)
+			}
+			return t, nil
+		}), nil
+	case FormatZed:
+		return newLineDecoder(r, ParseTuple), nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(csvHeader)
+		cr.ReuseRecord = true
+		return &csvDecoder{r: cr}, nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownFormat, format)
+}
+
+type lineEncoder struct {
+	w    *bufio.Writer
+	line func(Tuple) (string, error)
+}
+
+func (e *lineEncoder) Encode(t Tuple) error {
+	s, err := e.line(t)
+	if err != nil {
+		return err
+	}
+	if _, err := e.w.WriteString(s); err != nil {
+		return err
+	}
+	return e.w.WriteByte('\n')
+}
+
+func (e *lineEncoder) Flush() error { return e.w.Flush() }
+
+// maxLine bounds a line of ndjson or zed input
+const maxLine = 1 << 20
+
+type lineDecoder struct {
+	s     *bufio.Scanner
+	line  int
+	parse func(string) (Tuple, error)
+}
+
+func newLineDecoder(r io.Reader, parse func(string) (Tuple, error)) *lineDecoder {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), maxLine)
+	return &lineDecoder{s: s, parse: parse}
+}
+
+// Decode skips blank lines and // comments, as zed does
+func (d *lineDecoder) Decode() (Tuple, error) {
+	for d.s.Scan() {
+		d.line++
+		s := strings.TrimSpace(d.s.Text())
+		if s == "" || strings.HasPrefix(s, "//") {
+			continue
+		}
+		t, err := d.parse(s)
+		if err != nil {
+			return Tuple{}, err
+		}
+		return t, requireFields(t)
+	}
+	if err := d.s.Err(); err != nil {
+		return Tuple{}, err
+	}
+	return Tuple{}, io.EOF
+}
+
+func (d *lineDecoder) Line() int { return d.line }
+
+type csvEncoder struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvEncoder) Encode(t Tuple) error {
+	if !e.header {
+		e.header = true
+		if err := e.w.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+	var name, caveatCtx string
+	if t.Caveat != nil {
+		name = t.Caveat.Name
+		if len(t.Caveat.Context) > 0 {
+			b, err := json.Marshal(t.Caveat.Context)
+			if err != nil {
+				return err
+			}
+			caveatCtx = string(b)
+		}
+	}
+	return e.w.Write([]string{t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID, t.SubjectRelation, name, caveatCtx})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type csvDecoder struct {
+	r    *csv.Reader
+	line int
+}
+
+// Decode skips the header row, which may be omitted
+func (d *csvDecoder) Decode() (Tuple, error) {
+	rec, err := d.read()
+	if err == nil && d.line == 1 && strings.Join(rec, ",") == strings.Join(csvHeader, ",") {
+		rec, err = d.read()
+	}
+	if err != nil {
+		return Tuple{}, err
+	}
+	t := Tuple{ObjectType: rec[0], ObjectID: rec[1], Relation: rec[2], SubjectType: rec[3], SubjectID: rec[4], SubjectRelation: rec[5]}
+	if rec[6] != "" {
+		t.Caveat = &Caveat{Name: rec[6]}
+		if rec[7] != "" {
+			if err := json.Unmarshal([]byte(rec[7]), &t.Caveat.Context); err != nil {
+				return Tuple{}, fmt.Errorf("%w: caveat context: %v", ErrInvalidTuple, err)
+			}
+		}
+	} else if rec[7] != "" {
+		return Tuple{}, fmt.Errorf("%w: caveat context without a caveat name", ErrInvalidTuple)
+	}
+	return t, requireFields(t)
+}
+
+func (d *csvDecoder) read() ([]string, error) {
+	rec, err := d.r.Read()
+	var perr *csv.ParseError
+	switch {
+	case err == nil:
+		d.line, _ = d.r.FieldPos(0)
+	case errors.As(err, &perr):
+		d.line = perr.StartLine
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTuple, perr.Err)
+	}
+	return rec, err
+}
+
+func (d *csvDecoder) Line() int { return d.line }
+
+// requireFields rejects tuples missing a part the schema cannot check for
+func requireFields(t Tuple) error {
+	if t.ObjectType == "" || t.ObjectID == "" || t.Relation == "" || t.SubjectType == "" || t.SubjectID == "" {
+		return fmt.Errorf("%w: object, relation and subject are required", ErrInvalidTuple)
+	}
+	if t.Caveat != nil && t.Caveat.Name == "" {
+		return fmt.Errorf("%w: caveat without a name", ErrInvalidTuple)
+	}
+	return nil
+}
+
+// FormatTuple renders t in zed's relationship syntax
+func FormatTuple(t Tuple) string {
+	s := t.ObjectType + ":" + t.ObjectID + "#" + t.Relation + "@" + subjectString(t)
+	if t.Caveat != nil {
+		s += "[" + t.Caveat.Name
+		if len(t.Caveat.Context) > 0 {
+			b, _ := json.Marshal(t.Caveat.Context)
+			s += ":" + string(b)
+		}
+		s += "]"
+	}
+	return s
+}
+
+// ParseTuple reads a tuple in zed's relationship syntax
+func ParseTuple(s string) (Tuple, error) {
+	bad := func(why string) (Tuple, error) {
+		return Tuple{}, fmt.Errorf("%w: %q: %s", ErrInvalidTuple, s, why)
+	}
+	resource, subject, ok := strings.Cut(s, "@")
+	if !ok {
+		return bad("missing @subject")
+	}
+	var t Tuple
+	object, relation, ok := strings.Cut(resource, "#")
+	if !ok {
+		return bad("missing #relation")
+	}
+	t.Relation = relation
+	if t.ObjectType, t.ObjectID, ok = strings.Cut(object, ":"); !ok {
+		return bad("object is not type:id")
+	}
+	if i := strings.IndexByte(subject, '['); i >= 0 {
+		if !strings.HasSuffix(subject, "]") {
+			return bad("unterminated caveat")
+		}
+		name, ctxJSON, hasCtx := strings.Cut(subject[i+1:len(subject)-1], ":")
+		t.Caveat = &Caveat{Name: name}
+		if hasCtx {
+			if err := json.Unmarshal([]byte(ctxJSON), &t.Caveat.Context); err != nil {
+				return bad("caveat context: " + err.Error())
+			}
+		}
+		subject = subject[:i]
+	}
+	subjectObj, subjectRel, _ := strings.Cut(subject, "#")
+	t.SubjectRelation = subjectRel
+	if t.SubjectType, t.SubjectID, ok = strings.Cut(subjectObj, ":"); !ok {
+		return bad("subject is not type:id")
+	}
+	return t, nil
+}
+
+// exportPage is how many tuples ExportTuples reads per query
+const exportPage = 1000
+
+// ExportTuples streams the tuples of the org in ctx matching filter to emit, in insertion order. Tuples are
+// read in pages, so a long export does not hold one query open; tuples written meanwhile may or may not be seen.
+func ExportTuples(ctx context.Context, filter TupleFilter, emit func(Tuple) error) error {
+	org, err := orgOf(ctx)
+	if err != nil {
+		return err
+	}
+	where, args := filter.where(org)
+	q := `SELECT id, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json FROM trust_tuples
+		WHERE ` + where + fmt.Sprintf(` AND id > $%d ORDER BY id LIMIT %d`, len(args)+1, exportPage)
+	var after int64
+	for {
+		var rows []struct {
+			ID int64 `db:"id"`
+			Tuple
+		}
+		if err := databasepkg.DB.SelectContext(ctx, &rows, q, append(args, after)...); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			if err := emit(r.Tuple); err != nil {
+				return err
+			}
+		}
+		if len(rows) < exportPage {
+			return nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// TupleOrgs lists the orgs owning tuples in trust_tuples
+func TupleOrgs(ctx context.Context) ([]string, error) {
+	orgs := []string{}
+	err := databasepkg.DB.SelectContext(ctx, &orgs, `SELECT DISTINCT org_id::text FROM trust_tuples WHERE org_id IS NOT NULL ORDER BY 1`)
+	return orgs, err
+}
+
+// DefaultImportBatch is the number of tuples ImportTuples writes per transaction
+const DefaultImportBatch = 1000
+
+// maxImportErrors bounds the invalid tuples an import reports
+const maxImportErrors = 100
+
+// ImportOptions tunes ImportTuples. DryRun validates the input without writing it.
+type ImportOptions struct {
+	BatchSize int
+	DryRun    bool
+}
+
+// ImportError is a tuple an import rejected, by input line
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult reports an import. Imported counts the tuples of committed batches and Revision is the token
+// of the last one; both stay empty on a dry run.
+type ImportResult struct {
+	DryRun   bool          `json:"dry_run"`
+	Read     int           `json:"read"`
+	Imported int           `json:"imported"`
+	Batches  int           `json:"batches"`
+	Invalid  []ImportError `json:"invalid,omitempty"`
+	Revision string        `json:"revision,omitempty"`
+}
+
+// rejected reports errors that condemn a tuple rather than the import
+func rejected(err error) bool {
+	return errors.Is(err, ErrInvalidTuple) || errors.Is(err, ErrUnknownRelation) || errors.Is(err, ErrInvalidContext) || errors.Is(err, ErrCrossOrg)
+}
+
+// ImportTuples touches the tuples read from dec into g for the org in ctx, one transaction per batch. Every
+// tuple is first validated against the org schema and tenancy rules. A dry run reports all invalid tuples
+// (up to 100) and writes nothing; otherwise the import stops at the first invalid tuple, keeping the batches
+// committed before it, and returns an error wrapping ErrInvalidTuple.
+func ImportTuples(ctx context.Context, g GraphClient, dec TupleDecoder, opts ImportOptions) (ImportResult, error) {
+	res := ImportResult{DryRun: opts.DryRun}
+	org, err := orgOf(ctx)
+	if err != nil {
+		return res, err
+	}
+	size := opts.BatchSize
+	if size <= 0 {
+		size = DefaultImportBatch
+	}
+	batch := make([]Tuple, 0, size)
+	flush := func() error {
+		if len(batch) == 0 || opts.DryRun {
+			batch = batch[:0]
+			return nil
+		}
+		token, err := g.WriteRelationships(ctx, Touches(batch), nil)
+		if err != nil {
+			return err
+		}
+		res.Imported += len(batch)
+		res.Batches++
+		res.Revision = token
+		batch = batch[:0]
+		return nil
+	}
+	for {
+		t, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			res.Read++
+			err = validateImport(ctx, org, t)
+		}
+		if err != nil {
+			if !rejected(err) {
+				return res, err
+			}
+			if len(res.Invalid) < maxImportErrors {
+				res.Invalid = append(res.Invalid, ImportError{Line: dec.Line(), Error: err.Error()})
+			}
+			if !opts.DryRun {
+				return res, fmt.Errorf("line %d: %w", dec.Line(), err)
+			}
+			continue
+		}
+		if batch = append(batch, t); len(batch) == size {
+			if err := flush(); err != nil {
+				return res, err
+			}
+		}
+	}
+	return res, flush()
+}
+
+// validateImport checks one imported tuple like a write of it would
+func validateImport(ctx context.Context, org string, t Tuple) error {
+	if err := orgScope.check(ctx, org, []Tuple{t}); err != nil {
+		return err
+	}
+	return ValidateTuples(ctx, []Tuple{t})
+}
+
+var changeColumns = []string{"revision", "org_id", "operation", "object_type", "object_id", "relation", "subject_type", "subject_id", "subject_relation", "caveat_json"}
+
+func onlyTouches(updates []RelationshipUpdate) bool {
+	for _, u := range updates {
+		if u.Operation != OpTouch {
+			return false
+		}
+	}
+	return true
+}
+
+// copyTouches writes touches in bulk: it COPYs them into a temporary table and upserts from there. Like
+// single touches, the last touch of a tuple wins and unchanged tuples are neither rewritten nor logged.
+func copyTouches(ctx context.Context, tx *wtx, org string, updates []RelationshipUpdate) ([]Change, error) {
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE IF NOT EXISTS trust_tuples_import (
+		ord int NOT NULL, object_type text NOT NULL, object_id text NOT NULL, relation text NOT NULL,
+		subject_type text NOT NULL, subject_id text NOT NULL, subject_relation text NOT NULL, caveat_json jsonb
+	) ON COMMIT DROP`); err != nil {
+		return nil, err
+	}
+	rows := make([][]any, len(updates))
+	for i, u := range updates {
+		t := u.Tuple
+		rows[i] = []any{i, t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID, t.SubjectRelation, caveatJSON(t.Caveat)}
+	}
+	if err := tx.copyFrom(ctx, "trust_tuples_import", []string{"ord", "object_type", "object_id", "relation", "subject_type", "subject_id", "subject_relation", "caveat_json"}, rows); err != nil {
+		return nil, err
+	}
+	written := []Tuple{}
+	if err := tx.SelectContext(ctx, &written, `INSERT INTO trust_tuples (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json)
+		SELECT DISTINCT ON (object_type, object_id, relation, subject_type, subject_id, subject_relation)
+			$1::uuid, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json
+		FROM trust_tuples_import ORDER BY object_type, object_id, relation, subject_type, subject_id, subject_relation, ord DESC
+		ON CONFLICT (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation)
+		DO UPDATE SET caveat_json=EXCLUDED.caveat_json WHERE trust_tuples.caveat_json IS DISTINCT FROM EXCLUDED.caveat_json
+		RETURNING object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json`, org); err != nil {
+		return nil, err
+	}
+	changes := make([]Change, len(written))
+	for i, t := range written {
+		changes[i] = Change{Operation: OpTouch, Tuple: t}
+	}
+	return changes, nil
+}
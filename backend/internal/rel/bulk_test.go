@@ -0,0 +1,144 @@
+package rel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTupleFormatsRoundTrip(t *testing.T) {
+	tuples := []Tuple{
+		{ObjectType: "org", ObjectID: "o1", Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"},
+		{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "org", SubjectID: "o1", SubjectRelation: "member"},
+		{ObjectType: "doc", ObjectID: "d,1", Relation: "viewer", SubjectType: "user", SubjectID: "alice",
+			Caveat: &Caveat{Name: "valid_between", Context: map[string]any{"not_after": "2030-01-01T00:00:00Z"}}},
+		{ObjectType: "doc", ObjectID: "d2", Relation: "viewer", SubjectType: "user", SubjectID: "bob", Caveat: &Caveat{Name: "business_hours"}},
+	}
+	for _, format := range []string{FormatNDJSON, FormatCSV, FormatZed} {
+		var buf bytes.Buffer
+		enc, err := NewTupleEncoder(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tu := range tuples {
+			if err := enc.Encode(tu); err != nil {
+				t.Fatalf("%s: encode: %v", format, err)
+			}
+		}
+		if err := enc.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		dec, _ := NewTupleDecoder(&buf, format)
+		var got []Tuple
+		for {
+			tu, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: decode line %d: %v", format, dec.Line(), err)
+			}
+			got = append(got, tu)
+		}
+		if !reflect.DeepEqual(got, tuples) {
+			t.Errorf("%s: round trip\n got %+v\nwant %+v", format, got, tuples)
+		}
+	}
+	if _, err := NewTupleEncoder(io.Discard, "yaml"); !errors.Is(err, ErrUnknownFormat) {
+		t.Errorf("unknown format: got %v", err)
+	}
+}
+
+func TestParseTuple(t *testing.T) {
+	tu, err := ParseTuple(`doc:d1#viewer@team:devs#member[ip_allowlist:{"cidrs":["10.0.0.0/8"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "team", SubjectID: "devs", SubjectRelation: "member",
+		Caveat: &Caveat{Name: "ip_allowlist", Context: map[string]any{"cidrs": []any{"10.0.0.0/8"}}}}
+	if !reflect.DeepEqual(tu, want) {
+		t.Errorf("got %+v", tu)
+	}
+	for _, s := range []string{"doc:d1#viewer", "doc:d1@user:alice", "d1#viewer@user:alice", "doc:d1#viewer@alice", "doc:d1#viewer@user:alice[c:{", "doc:d1#viewer@user:alice[c:nope]"} {
+		if _, err := ParseTuple(s); !errors.Is(err, ErrInvalidTuple) {
+			t.Errorf("%q: got %v", s, err)
+		}
+	}
+}
+
+func TestDecoderLines(t *testing.T) {
+	in := "// exported\n\ndoc:d1#viewer@user:alice\ndoc:#viewer@user:bob\ndoc:d3#viewer@user:carol\n"
+	dec, _ := NewTupleDecoder(strings.NewReader(in), FormatZed)
+	if _, err := dec.Decode(); err != nil || dec.Line() != 3 {
+		t.Fatalf("first tuple: line %d, %v", dec.Line(), err)
+	}
+	if _, err := dec.Decode(); !errors.Is(err, ErrInvalidTuple) || dec.Line() != 4 {
+		t.Fatalf("empty object id: line %d, %v", dec.Line(), err)
+	}
+	if tu, err := dec.Decode(); err != nil || tu.SubjectID != "carol" {
+		t.Fatalf("the decoder must go on after a bad line: %+v %v", tu, err)
+	}
+
+	// the CSV header is optional, and a short row is reported by its line
+	dec, _ = NewTupleDecoder(strings.NewReader("doc,d1,viewer,user,alice,,,\ndoc,d2,viewer\n"), FormatCSV)
+	if tu, err := dec.Decode(); err != nil || tu.ObjectID != "d1" || dec.Line() != 1 {
+		t.Fatalf("headerless row: %+v line %d, %v", tu, dec.Line(), err)
+	}
+	if _, err := dec.Decode(); !errors.Is(err, ErrInvalidTuple) || dec.Line() != 2 {
+		t.Fatalf("short row: line %d, %v", dec.Line(), err)
+	}
+}
+
+func TestImportTuples(t *testing.T) {
+	const org, other = "00000000-0000-0000-0000-0000000000a1", "00000000-0000-0000-0000-0000000000a2"
+	saved := orgScope
+	defer func() { orgScope = saved; ForgetSchemas() }()
+	schemaCache.Lock()
+	schemaCache.m[org] = DefaultSchema()
+	schemaCache.Unlock()
+	orgScope = tenancy{
+		owner: func(ctx context.Context, namespace, id string) (string, error) {
+			if namespace == "org" {
+				return id, nil
+			}
+			return "", nil
+		},
+		contract: func(ctx context.Context, org, counterparty string) (bool, error) { return false, nil },
+	}
+	in := strings.Join([]string{
+		"team:devs#member@agent:a1",
+		"team:devs#member@agent:a2",
+		"org:" + other + "#can_act_for@agent:a1", // another org's object
+		"team:ops#member@agent:a3",
+		"not a tuple",
+		"team:ops#member@agent:a4",
+	}, "\n")
+	ctx := WithOrg(context.Background(), org)
+	g := &stubGraph{}
+
+	dec, _ := NewTupleDecoder(strings.NewReader(in), FormatZed)
+	res, err := ImportTuples(ctx, g, dec, ImportOptions{BatchSize: 2, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Read != 5 || res.Imported != 0 || len(res.Invalid) != 2 || res.Invalid[0].Line != 3 || res.Invalid[1].Line != 5 {
+		t.Errorf("dry run: %+v", res)
+	}
+
+	dec, _ = NewTupleDecoder(strings.NewReader(in), FormatZed)
+	res, err = ImportTuples(ctx, g, dec, ImportOptions{BatchSize: 2})
+	if !errors.Is(err, ErrCrossOrg) {
+		t.Fatalf("import: got %v", err)
+	}
+	if res.Imported != 2 || res.Batches != 1 || res.Revision != LocalToken(2) {
+		t.Errorf("the batch before the invalid tuple must be committed: %+v", res)
+	}
+
+	if _, err := ImportTuples(context.Background(), g, dec, ImportOptions{}); !errors.Is(err, ErrNoOrg) {
+		t.Errorf("import without an org: got %v", err)
+	}
+}
@@ -0,0 +1,385 @@
+package rel
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	cacheShards = 16
+	// DefaultCacheEntries bounds a CachedGraph created without an explicit size
+	DefaultCacheEntries = 10000
+	// flightTimeout bounds a check shared by concurrent callers; it runs detached from any one of them
+	flightTimeout = 10 * time.Second
+)
+
+// CachedGraph wraps a GraphClient with local TTL caching of check results. Results a caveat took part in
+// are not cached; the others do not depend on the caveat context, so they are cached and answer checks
+// with any context. Explained results are cached with their
+// explanation; checks asking for one are not answered from entries without it. Entries remember the latest local revision
+// the cache had observed when they were computed, so at_least_as_fresh checks can tell whether an entry
+// is recent enough; fully consistent checks always go to the inner client.
+//
+// The cache is safe for concurrent use. It is split into shards, each an LRU list bounded to its share of
+// the entries. Entries are indexed by the org/object#relation tuple sets the check read, so a write drops
+// only the checks of its org it can affect (see Invalidate); results without that information are dropped
+// on every write. Concurrent misses for the same check share one call to the inner client, which a caller
+// giving up does not cancel.
+type CachedGraph struct {
+	inner    GraphClient
+	ttl      time.Duration
+	negTtl   time.Duration
+	shards   [cacheShards]*cacheShard
+	revision atomic.Int64
+	// epoch advances on every invalidation; a result computed across one is not cached
+	epoch atomic.Uint64
+
+	flightMu sync.Mutex
+	flights  map[string]*flight
+}
+
+type cacheEntry struct {
+	key      string
+	result   CheckResult
+	expires  time.Time
+	revision int64
+	// deps are the tuple sets the result read, qualified by org (see ObjectKey); nil when unknown
+	deps []string
+}
+
+type cacheShard struct {
+	mu      sync.Mutex
+	max     int
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	// deps maps org/object#relation to the keys of entries that read it; unknown holds entries without deps
+	deps    map[string]map[string]struct{}
+	unknown map[string]struct{}
+}
+
+// flight is a check in progress that identical concurrent checks wait for
+type flight struct {
+	done chan struct{}
+	res  CheckResult
+	err  error
+}
+
+// NewCachedGraph creates a caching layer holding at most DefaultCacheEntries results
+func NewCachedGraph(inner GraphClient, ttl, negTtl time.Duration) *CachedGraph {
+	return NewCachedGraphSize(inner, ttl, negTtl, DefaultCacheEntries)
+}
+
+// NewCachedGraphSize creates a caching layer holding at most maxEntries results (DefaultCacheEntries if <= 0)
+func NewCachedGraphSize(inner GraphClient, ttl, negTtl time.Duration, maxEntries int) *CachedGraph {
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	c := &CachedGraph{inner: inner, ttl: ttl, negTtl: negTtl, flights: map[string]*flight{}}
+	per := (maxEntries + cacheShards - 1) / cacheShards
+	for i := range c.shards {
+		c.shards[i] = newCacheShard(per)
+	}
+	return c
+}
+
+func newCacheShard(max int) *cacheShard {
+	return &cacheShard{max: max, lru: list.New(), entries: map[string]*list.Element{}, deps: map[string]map[string]struct{}{}, unknown: map[string]struct{}{}}
+}
+
+func (c *CachedGraph) key(org string, sub RelationRef, rel string, obj RelationRef) string {
+	return org + "/" + sub.Namespace + ":" + sub.ObjectID + "#" + rel + "@" + obj.Namespace + ":" + obj.ObjectID
+}
+
+func (c *CachedGraph) shard(key string) *cacheShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return c.shards[h.Sum32()%cacheShards]
+}
+
+// ObjectKey names the tuple set of an org a tuple belongs to (org/object#relation), the unit of targeted
+// invalidation; tuples are partitioned by org, so a write only affects the checks of its org
+func ObjectKey(org string, t Tuple) string {
+	return org + "/" + t.ObjectType + ":" + t.ObjectID + "#" + t.Relation
+}
+
+// UpdatedObjects lists the tuple sets of org touched by updates
+func UpdatedObjects(org string, updates []RelationshipUpdate) []string {
+	seen := map[string]bool{}
+	for _, u := range updates {
+		seen[ObjectKey(org, u.Tuple)] = true
+	}
+	return sortedKeys(seen)
+}
+
+// FilterObjects lists the tuple sets of org a delete by filter can touch, or nil when the filter does not
+// name a single one and every cached result must go
+func FilterObjects(org string, f TupleFilter) []string {
+	if f.ObjectType == "" || f.ObjectID == "" || f.Relation == "" {
+		return nil
+	}
+	return []string{ObjectKey(org, Tuple{ObjectType: f.ObjectType, ObjectID: f.ObjectID, Relation: f.Relation})}
+}
+
+// orgDeps qualifies the object#relation tuple sets a check of org read, nil staying nil (unknown)
+func orgDeps(org string, deps []string) []string {
+	if deps == nil {
+		return nil
+	}
+	out := make([]string, len(deps))
+	for i, d := range deps {
+		out[i] = org + "/" + d
+	}
+	return out
+}
+
+func (c *CachedGraph) Upsert(ctx context.Context, t Tuple) error {
+	return c.UpsertBatch(ctx, []Tuple{t})
+}
+func (c *CachedGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error {
+	err := c.inner.UpsertBatch(ctx, tuples)
+	if err == nil {
+		c.Invalidate(UpdatedObjects(OrgFrom(ctx), Touches(tuples)))
+	}
+	return err
+}
+func (c *CachedGraph) WriteRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (string, error) {
+	token, err := c.inner.WriteRelationships(ctx, updates, preconditions)
+	if err == nil {
+		c.Observe(token)
+		c.Invalidate(UpdatedObjects(OrgFrom(ctx), updates))
+	}
+	return token, err
+}
+func (c *CachedGraph) Delete(ctx context.Context, filter TupleFilter, preconditions []Precondition) (string, error) {
+	token, err := c.inner.Delete(ctx, filter, preconditions)
+	if err == nil {
+		c.Observe(token)
+		if objects := FilterObjects(OrgFrom(ctx), filter); objects != nil {
+			c.Invalidate(objects)
+		} else {
+			c.Clear()
+		}
+	}
+	return token, err
+}
+
+// Observe records a write's revision token, from this instance or announced by another one
+func (c *CachedGraph) Observe(token string) {
+	rev, err := localRevision(token)
+	if err != nil {
+		return
+	}
+	for {
+		cur := c.revision.Load()
+		if rev <= cur || c.revision.CompareAndSwap(cur, rev) {
+			return
+		}
+	}
+}
+
+func (c *CachedGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef, caveatCtx map[string]any) (CheckResult, error) {
+	cons := ConsistencyFrom(ctx)
+	if cons.FullyConsistent {
+		return c.inner.Check(ctx, subject, relation, object, caveatCtx)
+	}
+	var need int64
+	if cons.AtLeastAsFresh != "" {
+		rev, err := localRevision(cons.AtLeastAsFresh)
+		if err != nil {
+			// SpiceDB tokens can only be judged by SpiceDB
+			return c.inner.Check(ctx, subject, relation, object, caveatCtx)
+		}
+		need = rev
+	}
+	explain := ExplainFrom(ctx)
+	org := OrgFrom(ctx)
+	k := c.key(org, subject, relation, object)
+	sh := c.shard(k)
+	if res, ok := sh.get(k, need); ok && (!explain || res.Explanation != nil) {
+		res.Source = "cache"
+		return res, nil
+	}
+	flightKey := k + "@" + strconv.FormatInt(need, 10)
+	if explain {
+		flightKey += "+explain"
+	}
+	if len(caveatCtx) > 0 {
+		// a caveated result depends on the context, so only checks with the same one share a call
+		b, _ := json.Marshal(caveatCtx)
+		h := fnv.New64a()
+		_, _ = h.Write(b)
+		flightKey += "+ctx" + strconv.FormatUint(h.Sum64(), 16)
+	}
+	return c.coalesce(ctx, flightKey, func() (CheckResult, error) {
+		// the call answers every waiting caller, so it keeps the values of ctx but not its cancellation
+		fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flightTimeout)
+		defer cancel()
+		// the result reflects at least the revision observed before reading, and the one the inner client ensured
+		seen := max(c.revision.Load(), need)
+		epoch := c.epoch.Load()
+		res, err := c.inner.Check(fctx, subject, relation, object, caveatCtx)
+		if err != nil || res.Caveated || c.epoch.Load() != epoch {
+			return res, err
+		}
+		ttl := c.ttl
+		if !res.Allowed() && c.negTtl > 0 {
+			ttl = c.negTtl
+		}
+		sh.put(&cacheEntry{key: k, result: res, expires: time.Now().Add(ttl), revision: seen, deps: orgDeps(org, res.deps)})
+		return res, nil
+	})
+}
+
+// coalesce runs fn once for concurrent callers with the same key; they all get its result. fn runs in the
+// background, so a caller whose ctx ends returns its error without failing the others.
+func (c *CachedGraph) coalesce(ctx context.Context, key string, fn func() (CheckResult, error)) (CheckResult, error) {
+	c.flightMu.Lock()
+	f, ok := c.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		c.flights[key] = f
+		go func() {
+			defer func() {
+				c.flightMu.Lock()
+				delete(c.flights, key)
+				c.flightMu.Unlock()
+				close(f.done)
+			}()
+			f.res, f.err = fn()
+		}()
+	}
+	c.flightMu.Unlock()
+	select {
+	case <-f.done:
+		return f.res, f.err
+	case <-ctx.Done():
+		return CheckResult{Permissionship: PermissionDenied, Source: "cache"}, ctx.Err()
+	}
+}
+
+func (c *CachedGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
+	return c.inner.Expand(ctx, relation, object, depth)
+}
+
+func (c *CachedGraph) LookupResources(ctx context.Context, subject RelationRef, relation, resourceType string, page Page) (LookupResult, error) {
+	return c.inner.LookupResources(ctx, subject, relation, resourceType, page)
+}
+
+func (c *CachedGraph) LookupSubjects(ctx context.Context, resource RelationRef, relation, subjectType string, page Page) (LookupResult, error) {
+	return c.inner.LookupSubjects(ctx, resource, relation, subjectType, page)
+}
+
+// Invalidate drops the cached checks that read any of the given org/object#relation tuple sets (see
+// ObjectKey), along with every result whose dependencies are unknown
+func (c *CachedGraph) Invalidate(objects []string) {
+	c.epoch.Add(1)
+	for _, sh := range c.shards {
+		sh.invalidate(objects)
+	}
+}
+
+// Clear resets all cache entries (used on schema changes or external invalidation without details)
+func (c *CachedGraph) Clear() {
+	c.epoch.Add(1)
+	for _, sh := range c.shards {
+		sh.clear()
+	}
+}
+
+// Len returns the number of cached results
+func (c *CachedGraph) Len() int {
+	n := 0
+	for _, sh := range c.shards {
+		sh.mu.Lock()
+		n += sh.lru.Len()
+		sh.mu.Unlock()
+	}
+	return n
+}
+
+func (s *cacheShard) get(key string, need int64) (CheckResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return CheckResult{}, false
+	}
+	ent := el.Value.(*cacheEntry)
+	if !time.Now().Before(ent.expires) {
+		s.removeLocked(el)
+		return CheckResult{}, false
+	}
+	if ent.revision < need {
+		return CheckResult{}, false
+	}
+	s.lru.MoveToFront(el)
+	return ent.result, true
+}
+
+func (s *cacheShard) put(ent *cacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[ent.key]; ok {
+		s.removeLocked(el)
+	}
+	s.entries[ent.key] = s.lru.PushFront(ent)
+	if ent.deps == nil {
+		s.unknown[ent.key] = struct{}{}
+	}
+	for _, d := range ent.deps {
+		if s.deps[d] == nil {
+			s.deps[d] = map[string]struct{}{}
+		}
+		s.deps[d][ent.key] = struct{}{}
+	}
+	for s.lru.Len() > s.max {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+func (s *cacheShard) removeLocked(el *list.Element) {
+	ent := s.lru.Remove(el).(*cacheEntry)
+	delete(s.entries, ent.key)
+	delete(s.unknown, ent.key)
+	for _, d := range ent.deps {
+		if keys := s.deps[d]; keys != nil {
+			delete(keys, ent.key)
+			if len(keys) == 0 {
+				delete(s.deps, d)
+			}
+		}
+	}
+}
+
+func (s *cacheShard) invalidate(objects []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	drop := func(key string) {
+		if el, ok := s.entries[key]; ok {
+			s.removeLocked(el)
+		}
+	}
+	for key := range s.unknown {
+		drop(key)
+	}
+	for _, o := range objects {
+		for key := range s.deps[o] {
+			drop(key)
+		}
+	}
+}
+
+func (s *cacheShard) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lru.Init()
+	s.entries = map[string]*list.Element{}
+	s.deps = map[string]map[string]struct{}{}
+	s.unknown = map[string]struct{}{}
+}
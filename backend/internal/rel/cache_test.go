@@ -2,20 +2,50 @@ package rel
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 type stubGraph struct {
-	calls int
-	allow bool
+	calls    atomic.Int64
+	allow    bool
+	caveated bool
+	// deps is reported as the tuple sets each check read; gate, when set, holds checks until it is closed
+	deps []string
+	gate chan struct{}
 }
 
 func (s *stubGraph) Upsert(ctx context.Context, t Tuple) error             { return nil }
 func (s *stubGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error { return nil }
-func (s *stubGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error) {
-	s.calls++
-	return s.allow, "stub", nil
+func (s *stubGraph) WriteRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (string, error) {
+	return LocalToken(int64(len(updates))), nil
+}
+func (s *stubGraph) Delete(ctx context.Context, filter TupleFilter, preconditions []Precondition) (string, error) {
+	return "", nil
+}
+func (s *stubGraph) LookupResources(ctx context.Context, subject RelationRef, relation, resourceType string, page Page) (LookupResult, error) {
+	return LookupResult{}, nil
+}
+func (s *stubGraph) LookupSubjects(ctx context.Context, resource RelationRef, relation, subjectType string, page Page) (LookupResult, error) {
+	return LookupResult{}, nil
+}
+func (s *stubGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef, caveatCtx map[string]any) (CheckResult, error) {
+	s.calls.Add(1)
+	if s.gate != nil {
+		<-s.gate
+	}
+	perm := PermissionDenied
+	if s.allow {
+		perm = PermissionAllowed
+	}
+	res := CheckResult{Permissionship: perm, Caveated: s.caveated, Source: "stub", deps: s.deps}
+	if ExplainFrom(ctx) {
+		res.Explanation = &Explanation{Visited: s.deps}
+	}
+	return res, nil
 }
 func (s *stubGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
 	return GraphExpansion{}, nil
@@ -28,42 +58,277 @@ func TestCachedGraph_PositiveAndNegativeTTL(t *testing.T) {
 	obj := RelationRef{"resource", "r1"}
 
 	// first call hits inner
-	if ok, src, _ := cg.Check(context.Background(), sub, "viewer", obj); !ok || src == "cache" {
-		t.Fatalf("expected allow via inner, got ok=%v src=%s", ok, src)
+	if res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil); !res.Allowed() || res.Source == "cache" {
+		t.Fatalf("expected allow via inner, got %+v", res)
 	}
-	if inner.calls != 1 {
-		t.Fatalf("expected 1 call, got %d", inner.calls)
+	if inner.calls.Load() != 1 {
+		t.Fatalf("expected 1 call, got %d", inner.calls.Load())
 	}
 	// second call should be cache
-	if ok, src, _ := cg.Check(context.Background(), sub, "viewer", obj); !ok || src != "cache" {
-		t.Fatalf("expected cache allow, got ok=%v src=%s", ok, src)
+	if res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil); !res.Allowed() || res.Source != "cache" {
+		t.Fatalf("expected cache allow, got %+v", res)
 	}
-	if inner.calls != 1 {
-		t.Fatalf("expected still 1 call, got %d", inner.calls)
+	if inner.calls.Load() != 1 {
+		t.Fatalf("expected still 1 call, got %d", inner.calls.Load())
 	}
 	// expire positive TTL
 	time.Sleep(120 * time.Millisecond)
-	if _, _, _ = cg.Check(context.Background(), sub, "viewer", obj); inner.calls != 2 {
+	if _, _ = cg.Check(context.Background(), sub, "viewer", obj, nil); inner.calls.Load() != 2 {
 		t.Fatalf("expected inner call after TTL expiry")
 	}
 
 	// switch to negative (wait for positive TTL to expire to avoid cached allow)
 	inner.allow = false
-	inner.calls = 0
+	inner.calls.Store(0)
 	time.Sleep(110 * time.Millisecond)
-	if ok, _, _ := cg.Check(context.Background(), sub, "viewer", obj); ok {
+	if res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil); res.Allowed() {
 		t.Fatalf("expected deny")
 	}
-	if inner.calls != 1 {
-		t.Fatalf("expected 1 call, got %d", inner.calls)
+	if inner.calls.Load() != 1 {
+		t.Fatalf("expected 1 call, got %d", inner.calls.Load())
 	}
 	// cache deny
-	if ok, src, _ := cg.Check(context.Background(), sub, "viewer", obj); ok || src != "cache" {
-		t.Fatalf("expected cached deny, got ok=%v src=%s", ok, src)
+	if res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil); res.Allowed() || res.Source != "cache" {
+		t.Fatalf("expected cached deny, got %+v", res)
 	}
 	// wait for negative TTL expire and ensure another inner call
 	time.Sleep(60 * time.Millisecond)
-	if _, _, _ = cg.Check(context.Background(), sub, "viewer", obj); inner.calls < 2 {
+	if _, _ = cg.Check(context.Background(), sub, "viewer", obj, nil); inner.calls.Load() < 2 {
 		t.Fatalf("expected another inner call after neg TTL expiry")
 	}
 }
+
+func TestCachedGraph_SkipsCaveatedResults(t *testing.T) {
+	inner := &stubGraph{allow: true, caveated: true}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"resource", "r1"}
+	for i := 0; i < 2; i++ {
+		if res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil); res.Source != "stub" {
+			t.Fatalf("caveated result must not be served from cache, got %+v", res)
+		}
+	}
+	// a result no caveat took part in does not depend on the context, so it answers checks with any context
+	inner.caveated = false
+	_, _ = cg.Check(context.Background(), sub, "viewer", obj, map[string]any{"env": "prod"})
+	if res, _ := cg.Check(context.Background(), sub, "viewer", obj, map[string]any{"env": "dev"}); res.Source != "cache" {
+		t.Fatalf("uncaveated result with context must be cached, got %+v", res)
+	}
+	if inner.calls.Load() != 3 {
+		t.Fatalf("expected 3 inner calls, got %d", inner.calls.Load())
+	}
+}
+
+func TestCachedGraph_ExplainedChecks(t *testing.T) {
+	inner := &stubGraph{deps: []string{"org:o1#can_act_for"}}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	sub, obj := RelationRef{"agent", "a1"}, RelationRef{"org", "o1"}
+	if res, _ := cg.Check(context.Background(), sub, "can_act_for", obj, nil); res.Explanation != nil {
+		t.Fatal("unexpected explanation")
+	}
+	explain := WithExplain(context.Background())
+	// an entry without an explanation does not answer an explained check
+	if res, _ := cg.Check(explain, sub, "can_act_for", obj, nil); res.Explanation == nil || res.Source == "cache" || inner.calls.Load() != 2 {
+		t.Fatalf("expected an explained result from inner, got %+v", res)
+	}
+	// the explained result is cached for both kinds of checks
+	for _, ctx := range []context.Context{explain, context.Background()} {
+		if res, _ := cg.Check(ctx, sub, "can_act_for", obj, nil); res.Explanation == nil || res.Source != "cache" {
+			t.Fatalf("expected the cached explanation, got %+v", res)
+		}
+	}
+	if inner.calls.Load() != 2 {
+		t.Fatalf("expected 2 inner calls, got %d", inner.calls.Load())
+	}
+}
+
+func TestCachedGraph_TargetedInvalidation(t *testing.T) {
+	inner := &stubGraph{allow: true, deps: []string{"doc:d1#viewer", "group:g1#member"}}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	ctx := context.Background()
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"doc", "d1"}
+	_, _ = cg.Check(ctx, sub, "viewer", obj, nil)
+
+	cg.Invalidate([]string{"/doc:d2#viewer"})
+	if res, _ := cg.Check(ctx, sub, "viewer", obj, nil); res.Source != "cache" {
+		t.Fatalf("unrelated write must keep the entry, got %+v", res)
+	}
+	cg.Invalidate([]string{"/group:g1#member"})
+	if res, _ := cg.Check(ctx, sub, "viewer", obj, nil); res.Source != "stub" {
+		t.Fatalf("write to a dependency must drop the entry, got %+v", res)
+	}
+
+	// results without dependency information go on any write
+	inner.deps = nil
+	cg.Clear()
+	_, _ = cg.Check(ctx, sub, "viewer", obj, nil)
+	cg.Invalidate([]string{"/doc:d2#viewer"})
+	if res, _ := cg.Check(ctx, sub, "viewer", obj, nil); res.Source != "stub" {
+		t.Fatalf("entry without deps must be dropped, got %+v", res)
+	}
+}
+
+func TestCachedGraph_InvalidationIsPerOrg(t *testing.T) {
+	inner := &stubGraph{allow: true, deps: []string{"doc:d1#viewer"}}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	o1, o2 := WithOrg(context.Background(), "o1"), WithOrg(context.Background(), "o2")
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"doc", "d1"}
+	_, _ = cg.Check(o1, sub, "viewer", obj, nil)
+	_, _ = cg.Check(o2, sub, "viewer", obj, nil)
+	// o2 writing the same tuple set leaves o1's checks cached
+	_, _ = cg.WriteRelationships(o2, Touches([]Tuple{{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "bob"}}), nil)
+	if res, _ := cg.Check(o1, sub, "viewer", obj, nil); res.Source != "cache" {
+		t.Fatalf("another org's write must keep the entry, got %+v", res)
+	}
+	if res, _ := cg.Check(o2, sub, "viewer", obj, nil); res.Source != "stub" {
+		t.Fatalf("the org's own write must drop the entry, got %+v", res)
+	}
+	cg.Invalidate(FilterObjects("o1", TupleFilter{ObjectType: "doc", ObjectID: "d1", Relation: "viewer"}))
+	if res, _ := cg.Check(o1, sub, "viewer", obj, nil); res.Source != "stub" {
+		t.Fatalf("expected the entry dropped, got %+v", res)
+	}
+}
+
+func TestCachedGraph_WritesInvalidateWrittenObjects(t *testing.T) {
+	inner := &stubGraph{allow: true, deps: []string{"doc:d1#viewer"}}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	ctx := context.Background()
+	sub := RelationRef{"user", "alice"}
+	_, _ = cg.Check(ctx, sub, "viewer", RelationRef{"doc", "d1"}, nil)
+	_, _ = cg.WriteRelationships(ctx, Touches([]Tuple{{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "bob"}}), nil)
+	if res, _ := cg.Check(ctx, sub, "viewer", RelationRef{"doc", "d1"}, nil); res.Source != "stub" {
+		t.Fatalf("write must drop the entry, got %+v", res)
+	}
+	if FilterObjects("", TupleFilter{ObjectType: "doc"}) != nil {
+		t.Fatalf("a filter without object id and relation must invalidate everything")
+	}
+}
+
+func TestCachedGraph_LRUBound(t *testing.T) {
+	inner := &stubGraph{allow: true, deps: []string{}}
+	cg := NewCachedGraphSize(inner, time.Minute, time.Minute, 32)
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		_, _ = cg.Check(ctx, RelationRef{"user", fmt.Sprint(i)}, "viewer", RelationRef{"doc", "d1"}, nil)
+	}
+	if n := cg.Len(); n > 32 {
+		t.Fatalf("cache holds %d entries, want at most 32", n)
+	}
+	// the most recent check is still cached
+	if res, _ := cg.Check(ctx, RelationRef{"user", "999"}, "viewer", RelationRef{"doc", "d1"}, nil); res.Source != "cache" {
+		t.Fatalf("expected recent entry to survive eviction, got %+v", res)
+	}
+}
+
+func TestCachedGraph_CoalescesConcurrentChecks(t *testing.T) {
+	inner := &stubGraph{allow: true, gate: make(chan struct{})}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"doc", "d1"}
+	var wg sync.WaitGroup
+	results := make([]CheckResult, 20)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = cg.Check(context.Background(), sub, "viewer", obj, nil)
+		}(i)
+	}
+	// let the first check reach the inner client and the others queue behind it
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(inner.gate)
+	wg.Wait()
+	if n := inner.calls.Load(); n != 1 {
+		t.Fatalf("expected one inner call for identical concurrent checks, got %d", n)
+	}
+	for _, res := range results {
+		if !res.Allowed() {
+			t.Fatalf("every caller should see the shared result, got %+v", res)
+		}
+	}
+}
+
+func TestCachedGraph_CancelledCallerDoesNotFailOthers(t *testing.T) {
+	inner := &stubGraph{allow: true, gate: make(chan struct{})}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"doc", "d1"}
+	first, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := cg.Check(first, sub, "viewer", obj, nil)
+		errs <- err
+	}()
+	for inner.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan CheckResult, 1)
+	go func() {
+		res, _ := cg.Check(context.Background(), sub, "viewer", obj, nil)
+		done <- res
+	}()
+	// the caller that started the shared call gives up; the call goes on for the one still waiting
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("expected the cancelled caller to get its error, got %v", err)
+	}
+	close(inner.gate)
+	if res := <-done; !res.Allowed() {
+		t.Fatalf("the waiting caller should get the shared result, got %+v", res)
+	}
+	if n := inner.calls.Load(); n != 1 {
+		t.Fatalf("expected one inner call, got %d", n)
+	}
+}
+
+// run with -race: checks, writes and clears from many goroutines
+func TestCachedGraph_ConcurrentAccess(t *testing.T) {
+	inner := &stubGraph{allow: true, deps: []string{"doc:d1#viewer"}}
+	cg := NewCachedGraphSize(inner, time.Minute, time.Minute, 64)
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				sub := RelationRef{"user", fmt.Sprint((g*200 + i) % 100)}
+				if _, err := cg.Check(ctx, sub, "viewer", RelationRef{"doc", "d1"}, nil); err != nil {
+					t.Error(err)
+					return
+				}
+				switch i % 50 {
+				case 10:
+					cg.Invalidate([]string{"/doc:d1#viewer"})
+				case 20:
+					_, _ = cg.WriteRelationships(ctx, Touches([]Tuple{{ObjectType: "doc", ObjectID: "d2", Relation: "viewer", SubjectType: "user", SubjectID: "x"}}), nil)
+				case 30:
+					cg.Clear()
+				case 40:
+					cg.Observe(LocalToken(int64(i)))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := cg.Len(); n > 64 {
+		t.Fatalf("cache holds %d entries, want at most 64", n)
+	}
+}
+
+func TestCheckerRecordsDeps(t *testing.T) {
+	read := memTuples(
+		Tuple{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "user", SubjectID: "alice"},
+		Tuple{ObjectType: "resource", ObjectID: "R1", Relation: "editor", SubjectType: "team", SubjectID: "devs", SubjectRelation: "member"},
+	)
+	c := newChecker(DefaultSchema(), read)
+	c.deps = map[string]bool{}
+	if _, err := c.check(context.Background(), RelationRef{"resource", "R1"}, "viewer", RelationRef{"user", "alice"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"resource:R1#editor", "team:devs#member"} {
+		if !c.deps[want] {
+			t.Fatalf("expected %s among deps %v", want, sortedKeys(c.deps))
+		}
+	}
+}
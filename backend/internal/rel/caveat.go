@@ -0,0 +1,196 @@
+package rel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Armour007/aura-backend/internal/policy"
+)
+
+// ErrInvalidContext is returned when check context does not fit a caveat's parameter types
+var ErrInvalidContext = errors.New("invalid caveat context")
+
+// Caveat makes a tuple conditional: the named caveat of the schema must hold, evaluated with Context
+// (written with the tuple, taking precedence) over the check's context. Stored in trust_tuples.caveat_json.
+type Caveat struct {
+	Name    string         `json:"name"`
+	Context map[string]any `json:"context,omitempty"`
+}
+
+// Scan implements sql.Scanner for the caveat_json column
+func (c *Caveat) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	}
+	return fmt.Errorf("cannot scan %T into Caveat", src)
+}
+
+// caveatJSON is the caveat_json column value of a tuple
+func caveatJSON(c *Caveat) any {
+	if c == nil {
+		return nil
+	}
+	b, _ := json.Marshal(c)
+	return b
+}
+
+// CaveatDef is a named condition over typed parameters, written in the AuraJSON expression language:
+//
+//	"valid_between": {"parameters": {"now": "timestamp", "not_before": "timestamp", "not_after": "timestamp"},
+//	                  "expression": "now >= not_before && now < not_after"}
+//
+// Parameter types follow SpiceDB: string, int, uint, double, bool, timestamp, duration, list<T>, map<T>, any.
+type CaveatDef struct {
+	Parameters map[string]string `json:"parameters"`
+	Expression string            `json:"expression"`
+
+	expr *policy.Expr
+}
+
+func (d *CaveatDef) compile() error {
+	if len(d.Parameters) == 0 {
+		return fmt.Errorf("caveat has no parameters")
+	}
+	for name, typ := range d.Parameters {
+		if !schemaName.MatchString(name) {
+			return fmt.Errorf("parameter %q: names must match %s", name, schemaName)
+		}
+		if caveatBaseType(typ) == "" {
+			return fmt.Errorf("parameter %q: unknown type %q", name, typ)
+		}
+	}
+	expr, err := policy.CompileExpr(d.Expression)
+	if err != nil {
+		return err
+	}
+	// with every parameter unknown, only references to undeclared names can fail
+	if _, _, _, err := expr.Partial(map[string]any{}, func(path string) bool {
+		_, ok := d.Parameters[paramOf(path)]
+		return ok
+	}); err != nil {
+		return fmt.Errorf("expression %q: %w", d.Expression, err)
+	}
+	d.expr = expr
+	return nil
+}
+
+func caveatBaseType(typ string) string {
+	switch {
+	case strings.HasPrefix(typ, "list<") && strings.HasSuffix(typ, ">"):
+		return "list"
+	case strings.HasPrefix(typ, "map<") && strings.HasSuffix(typ, ">"):
+		return "map"
+	}
+	switch typ {
+	case "string", "int", "uint", "double", "bool", "timestamp", "duration", "any":
+		return typ
+	}
+	return ""
+}
+
+func paramOf(path string) string {
+	if i := strings.IndexAny(path, ".["); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// eval evaluates the caveat with the check's context and the tuple's own context
+func (d *CaveatDef) eval(name string, tupleCtx, checkCtx map[string]any) (outcome, error) {
+	input := map[string]any{}
+	for p, typ := range d.Parameters {
+		v, ok := tupleCtx[p]
+		if !ok {
+			v, ok = checkCtx[p]
+		}
+		if !ok {
+			continue
+		}
+		cv, err := convertParam(typ, v)
+		if err != nil {
+			return outcome{}, fmt.Errorf("%w: caveat %s parameter %s: %v", ErrInvalidContext, name, p, err)
+		}
+		input[p] = cv
+	}
+	known, value, _, err := d.expr.Partial(input, func(path string) bool {
+		p := paramOf(path)
+		_, declared := d.Parameters[p]
+		_, present := input[p]
+		return declared && !present
+	})
+	if err != nil {
+		return outcome{}, fmt.Errorf("caveat %s: %w", name, err)
+	}
+	if known {
+		if value {
+			return outcome{perm: PermissionAllowed}, nil
+		}
+		return outcome{perm: PermissionDenied}, nil
+	}
+	var missing []string
+	for p := range d.Parameters {
+		if _, ok := input[p]; !ok {
+			missing = append(missing, p)
+		}
+	}
+	sort.Strings(missing)
+	return outcome{perm: PermissionConditional, missing: missing}, nil
+}
+
+// convertParam maps a context value onto the evaluator's value for a parameter type
+func convertParam(typ string, v any) (any, error) {
+	switch caveatBaseType(typ) {
+	case "int", "uint", "double":
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case json.Number:
+			return n.Float64()
+		}
+		return nil, fmt.Errorf("expected a number")
+	case "bool":
+		if _, ok := v.(bool); !ok {
+			return nil, fmt.Errorf("expected a bool")
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return nil, fmt.Errorf("expected a string")
+		}
+	case "timestamp":
+		switch t := v.(type) {
+		case time.Time:
+			return t, nil
+		case string:
+			return time.Parse(time.RFC3339Nano, t)
+		}
+		return nil, fmt.Errorf("expected an RFC 3339 timestamp")
+	case "duration":
+		switch d := v.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			return time.ParseDuration(d)
+		}
+		return nil, fmt.Errorf("expected a duration such as \"90m\"")
+	case "list":
+		if _, ok := v.([]any); !ok {
+			return nil, fmt.Errorf("expected a list")
+		}
+	case "map":
+		if _, ok := v.(map[string]any); !ok {
+			return nil, fmt.Errorf("expected a map")
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,106 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const caveatSchema = `{
+	"caveats": {
+		"valid_between": {"parameters": {"now": "timestamp", "not_before": "timestamp", "not_after": "timestamp"},
+			"expression": "now >= not_before && now < not_after"},
+		"low_risk": {"parameters": {"env": "string", "risk": "map<any>"},
+			"expression": "env == \"prod\" && risk.score < 50"}
+	},
+	"namespaces": {
+		"user": {},
+		"doc": {"relations": {
+			"viewer": {"types": ["user", "user with valid_between", "user with low_risk"]},
+			"blocked": {"types": ["user with low_risk"]},
+			"reader": {"rewrite": "viewer - blocked"}
+		}}
+	}
+}`
+
+func caveatCheck(t *testing.T, s *Schema, read tupleReader, user, relation string, cctx map[string]any) outcome {
+	t.Helper()
+	c := newChecker(s, read)
+	c.caveatCtx = cctx
+	o, err := c.check(context.Background(), RelationRef{"doc", "d1"}, relation, RelationRef{"user", user}, 0)
+	if err != nil {
+		t.Fatalf("check %s %s: %v", user, relation, err)
+	}
+	if !c.caveated {
+		t.Fatalf("check %s %s: expected a caveated check", user, relation)
+	}
+	return o
+}
+
+func TestCaveatedTuples(t *testing.T) {
+	s, err := ParseSchema([]byte(caveatSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	window := &Caveat{Name: "valid_between", Context: map[string]any{"not_before": "2025-01-01T00:00:00Z", "not_after": "2025-02-01T00:00:00Z"}}
+	read := memTuples(
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "alice", Caveat: window},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "bob", Caveat: &Caveat{Name: "low_risk", Context: map[string]any{"env": "prod"}}},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "carol"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "blocked", SubjectType: "user", SubjectID: "carol", Caveat: &Caveat{Name: "low_risk"}},
+	)
+	if o := caveatCheck(t, s, read, "alice", "viewer", map[string]any{"now": "2025-01-15T00:00:00Z"}); o.perm != PermissionAllowed {
+		t.Errorf("alice within window: got %v", o.perm)
+	}
+	if o := caveatCheck(t, s, read, "alice", "viewer", map[string]any{"now": "2025-03-01T00:00:00Z"}); o.perm != PermissionDenied {
+		t.Errorf("alice after window: got %v", o.perm)
+	}
+	// the tuple's env wins over the caller's
+	if o := caveatCheck(t, s, read, "bob", "viewer", map[string]any{"env": "dev", "risk": map[string]any{"score": 10.0}}); o.perm != PermissionAllowed {
+		t.Errorf("bob with low risk: got %v", o.perm)
+	}
+	o := caveatCheck(t, s, read, "bob", "viewer", map[string]any{})
+	if o.perm != PermissionConditional || !reflect.DeepEqual(o.missing, []string{"risk"}) {
+		t.Errorf("bob without risk: got %v missing %v", o.perm, o.missing)
+	}
+	// an unresolved exclusion leaves the result conditional
+	o = caveatCheck(t, s, read, "carol", "reader", map[string]any{})
+	if o.perm != PermissionConditional || !reflect.DeepEqual(o.missing, []string{"env", "risk"}) {
+		t.Errorf("carol reader without context: got %v missing %v", o.perm, o.missing)
+	}
+	if o := caveatCheck(t, s, read, "carol", "reader", map[string]any{"env": "prod", "risk": map[string]any{"score": 80.0}}); o.perm != PermissionAllowed {
+		t.Errorf("carol reader with high risk: got %v", o.perm)
+	}
+	c := newChecker(s, read)
+	c.caveatCtx = map[string]any{"now": 42.0}
+	if _, err := c.check(context.Background(), RelationRef{"doc", "d1"}, "viewer", RelationRef{"user", "alice"}, 0); !errors.Is(err, ErrInvalidContext) {
+		t.Errorf("expected ErrInvalidContext for a numeric timestamp, got %v", err)
+	}
+}
+
+func TestCaveatSchemaErrors(t *testing.T) {
+	bad := []string{
+		`{"caveats": {"c": {"parameters": {"a": "int"}, "expression": "a > b"}}, "namespaces": {}}`,
+		`{"caveats": {"c": {"parameters": {"a": "float"}, "expression": "a > 1"}}, "namespaces": {}}`,
+		`{"namespaces": {"user": {}, "doc": {"relations": {"viewer": {"types": ["user with missing"]}}}}}`,
+	}
+	for _, src := range bad {
+		if _, err := ParseSchema([]byte(src)); err == nil {
+			t.Errorf("expected an error for %s", src)
+		}
+	}
+	s, _ := ParseSchema([]byte(caveatSchema))
+	tu := Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "a", Caveat: &Caveat{Name: "low_risk"}}
+	if err := s.ValidateTuple(tu); err != nil {
+		t.Errorf("caveated tuple: %v", err)
+	}
+	tu.Relation = "blocked"
+	tu.Caveat = nil
+	if err := s.ValidateTuple(tu); !errors.Is(err, ErrInvalidTuple) {
+		t.Errorf("blocked requires the caveat, got %v", err)
+	}
+	if err := DefaultSchema().ValidateTuple(Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "a", Caveat: &Caveat{Name: "low_risk"}}); !errors.Is(err, ErrInvalidTuple) {
+		t.Errorf("default schema defines no caveats, got %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package rel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidToken is returned for revision tokens that are malformed or come from another backend
+	ErrInvalidToken = errors.New("invalid revision token")
+	// ErrRevisionUnavailable is returned when the store has not yet caught up with a requested revision
+	ErrRevisionUnavailable = errors.New("requested revision is not available yet")
+)
+
+// Revision tokens ("zookies") are opaque to callers: the backend and its revision, base64url encoded.
+// The local backend's revision is the changelog revision; SpiceDB's is its ZedToken.
+const (
+	tokenLocal   = "local"
+	tokenSpiceDB = "spicedb"
+)
+
+func encodeToken(backend, rev string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(backend + ":" + rev))
+}
+
+func decodeToken(token string) (backend, rev string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	backend, rev, ok := strings.Cut(string(b), ":")
+	if !ok || rev == "" {
+		return "", "", ErrInvalidToken
+	}
+	return backend, rev, nil
+}
+
+// LocalToken is the revision token of a changelog revision
+func LocalToken(rev int64) string { return encodeToken(tokenLocal, strconv.FormatInt(rev, 10)) }
+
+// localRevision decodes a token of the local backend
+func localRevision(token string) (int64, error) {
+	backend, rev, err := decodeToken(token)
+	if err != nil {
+		return 0, err
+	}
+	if backend != tokenLocal {
+		return 0, fmt.Errorf("%w: issued by %s", ErrInvalidToken, backend)
+	}
+	n, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil || n < 0 {
+		return 0, ErrInvalidToken
+	}
+	return n, nil
+}
+
+// Consistency says how fresh the data a check or expand reads must be. The zero value lets the server
+// answer from caches (minimize latency).
+type Consistency struct {
+	// AtLeastAsFresh is a revision token returned by a write; the answer reflects that write or later ones
+	AtLeastAsFresh string `json:"at_least_as_fresh,omitempty"`
+	// FullyConsistent reads the latest data, bypassing caches
+	FullyConsistent bool `json:"fully_consistent,omitempty"`
+}
+
+// Validate rejects combined requirements and malformed tokens
+func (c Consistency) Validate() error {
+	if c.FullyConsistent && c.AtLeastAsFresh != "" {
+		return fmt.Errorf("%w: at_least_as_fresh and fully_consistent are exclusive", ErrInvalidToken)
+	}
+	if c.AtLeastAsFresh != "" {
+		if _, _, err := decodeToken(c.AtLeastAsFresh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type consistencyKey struct{}
+
+// WithConsistency sets the consistency requirement of the graph reads made with ctx
+func WithConsistency(ctx context.Context, c Consistency) context.Context {
+	return context.WithValue(ctx, consistencyKey{}, c)
+}
+
+// ConsistencyFrom returns the requirement set by WithConsistency, or the zero value
+func ConsistencyFrom(ctx context.Context) Consistency {
+	c, _ := ctx.Value(consistencyKey{}).(Consistency)
+	return c
+}
+
+// ensureLocalRevision fails unless the changelog has reached the revision ctx requires
+func ensureLocalRevision(ctx context.Context) error {
+	token := ConsistencyFrom(ctx).AtLeastAsFresh
+	if token == "" {
+		return nil
+	}
+	want, err := localRevision(token)
+	if err != nil {
+		return err
+	}
+	head, err := HeadRevision(ctx)
+	if err != nil {
+		return err
+	}
+	if head < want {
+		return fmt.Errorf("%w: at %d, want %d", ErrRevisionUnavailable, head, want)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevisionTokens(t *testing.T) {
+	tok := LocalToken(42)
+	if rev, err := localRevision(tok); err != nil || rev != 42 {
+		t.Fatalf("round trip: %d %v", rev, err)
+	}
+	if _, err := localRevision(encodeToken(tokenSpiceDB, "GhUKEzE2")); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("a SpiceDB token is not a local revision, got %v", err)
+	}
+	for _, bad := range []string{"not base64!", encodeToken(tokenLocal, "x"), encodeToken(tokenLocal, "-1")} {
+		if _, err := localRevision(bad); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%q: got %v", bad, err)
+		}
+	}
+	if err := (Consistency{AtLeastAsFresh: tok, FullyConsistent: true}).Validate(); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("exclusive requirements: got %v", err)
+	}
+	if err := (Consistency{AtLeastAsFresh: tok}).Validate(); err != nil {
+		t.Errorf("valid token: %v", err)
+	}
+}
+
+func TestCachedGraph_Consistency(t *testing.T) {
+	inner := &stubGraph{allow: true}
+	cg := NewCachedGraph(inner, time.Minute, time.Minute)
+	sub, obj := RelationRef{"user", "alice"}, RelationRef{"resource", "r1"}
+	check := func(c Consistency) string {
+		res, err := cg.Check(WithConsistency(context.Background(), c), sub, "viewer", obj, nil)
+		if err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		return res.Source
+	}
+	if src := check(Consistency{}); src != "stub" {
+		t.Fatalf("first check: %s", src)
+	}
+	if src := check(Consistency{}); src != "cache" {
+		t.Fatalf("minimize latency: %s", src)
+	}
+	if src := check(Consistency{FullyConsistent: true}); src != "stub" {
+		t.Fatalf("fully consistent must bypass the cache: %s", src)
+	}
+	// the entry predates revision 5, so the check is recomputed and then served at that revision
+	if src := check(Consistency{AtLeastAsFresh: LocalToken(5)}); src != "stub" {
+		t.Fatalf("newer token: %s", src)
+	}
+	if src := check(Consistency{AtLeastAsFresh: LocalToken(5)}); src != "cache" {
+		t.Fatalf("same token: %s", src)
+	}
+	if src := check(Consistency{AtLeastAsFresh: LocalToken(6)}); src != "stub" {
+		t.Fatalf("token past the entry: %s", src)
+	}
+	// entries computed after a write was observed satisfy its token
+	token, _ := cg.WriteRelationships(context.Background(), make([]RelationshipUpdate, 9), nil)
+	cg.Clear()
+	check(Consistency{})
+	if src := check(Consistency{AtLeastAsFresh: token}); src != "cache" {
+		t.Fatalf("entry computed after the observed write: %s", src)
+	}
+}
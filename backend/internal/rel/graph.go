@@ -2,6 +2,7 @@ package rel
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 )
 
@@ -11,69 +12,88 @@ type RelationRef struct {
 	ObjectID  string `json:"object_id"`
 }
 
-// GraphExpansion is a simplified expansion tree for debugging
+// GraphExpansion is a simplified expansion tree for debugging. Children of a node are a union unless
+// Operation is "intersection" or "exclusion" (first child minus the rest). Caveat is set on nodes
+// reached through a caveated tuple.
 type GraphExpansion struct {
-	Relation string           `json:"relation"`
-	Object   RelationRef      `json:"object"`
-	Children []GraphExpansion `json:"children,omitempty"`
+	Relation  string           `json:"relation"`
+	Object    RelationRef      `json:"object"`
+	Operation string           `json:"operation,omitempty"`
+	Caveat    *Caveat          `json:"caveat,omitempty"`
+	Children  []GraphExpansion `json:"children,omitempty"`
 }
 
-// GraphClient abstracts SpiceDB or local implementations
-type GraphClient interface {
-	Upsert(ctx context.Context, t Tuple) error
-	UpsertBatch(ctx context.Context, tuples []Tuple) error
-	Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error)
-	Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error)
-}
+// Permissionship is the outcome of a check
+type Permissionship string
 
-// CachedGraph wraps a GraphClient with local TTL caching of check results
-type CachedGraph struct {
-	inner  GraphClient
-	ttl    time.Duration
-	negTtl time.Duration
-	cache  map[string]cacheEntry
-}
+const (
+	PermissionDenied  Permissionship = "denied"
+	PermissionAllowed Permissionship = "allowed"
+	// PermissionConditional means a caveat on the path needs context the check did not carry
+	PermissionConditional Permissionship = "conditional"
+)
 
-type cacheEntry struct {
-	allow   bool
-	source  string
-	expires time.Time
+// CheckResult is the outcome of a check. MissingContext lists the caveat parameters a conditional
+// result is waiting for; Caveated is set when a caveat took part, so the result may change with context.
+type CheckResult struct {
+	Permissionship Permissionship `json:"permissionship"`
+	MissingContext []string       `json:"missing_context,omitempty"`
+	Caveated       bool           `json:"caveated,omitempty"`
+	Source         string         `json:"source"`
+	// Explanation is set for checks made with WithExplain
+	Explanation *Explanation `json:"explanation,omitempty"`
+	// deps are the object#relation tuple sets the local checker read; nil when unknown (e.g. SpiceDB)
+	deps []string
 }
 
-// NewCachedGraph creates a caching layer
-func NewCachedGraph(inner GraphClient, ttl, negTtl time.Duration) *CachedGraph {
-	return &CachedGraph{inner: inner, ttl: ttl, negTtl: negTtl, cache: make(map[string]cacheEntry)}
-}
+// Allowed reports an unconditional allow
+func (r CheckResult) Allowed() bool { return r.Permissionship == PermissionAllowed }
 
-func (c *CachedGraph) key(sub RelationRef, rel string, obj RelationRef) string {
-	return sub.Namespace + ":" + sub.ObjectID + "#" + rel + "@" + obj.Namespace + ":" + obj.ObjectID
+// Explanation proves a check result. Path lists the tuples of one path granting an allowed or conditional
+// result, from the object's tuple to the subject's; the tuples of every operand of an intersection are
+// included. A denial has no path: Visited lists the object#relation tuple sets the check read without
+// finding one. SpiceDB answers with its debug trace instead.
+type Explanation struct {
+	Path    []Tuple         `json:"path,omitempty"`
+	Visited []string        `json:"visited,omitempty"`
+	Trace   json.RawMessage `json:"trace,omitempty"`
 }
 
-func (c *CachedGraph) Upsert(ctx context.Context, t Tuple) error { return c.inner.Upsert(ctx, t) }
-func (c *CachedGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error {
-	return c.inner.UpsertBatch(ctx, tuples)
+type explainKey struct{}
+
+// WithExplain asks the checks made with ctx to explain their result (see CheckResult.Explanation)
+func WithExplain(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explainKey{}, true)
 }
 
-func (c *CachedGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error) {
-	k := c.key(subject, relation, object)
-	if ent, ok := c.cache[k]; ok && time.Now().Before(ent.expires) {
-		return ent.allow, "cache", nil
-	}
-	allow, source, err := c.inner.Check(ctx, subject, relation, object)
-	if err != nil {
-		return false, source, err
-	}
-	ttl := c.ttl
-	if !allow && c.negTtl > 0 {
-		ttl = c.negTtl
-	}
-	c.cache[k] = cacheEntry{allow: allow, source: source, expires: time.Now().Add(ttl)}
-	return allow, source, nil
+// ExplainFrom reports whether ctx asks for explanations (see WithExplain)
+func ExplainFrom(ctx context.Context) bool {
+	b, _ := ctx.Value(explainKey{}).(bool)
+	return b
 }
 
-func (c *CachedGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
-	return c.inner.Expand(ctx, relation, object, depth)
+// GraphClient abstracts SpiceDB or local implementations. Check evaluates caveats with caveatCtx
+// (which may be nil); "now" is always the server's current time, whatever the caller set.
+// Upsert and UpsertBatch touch tuples. WriteRelationships and Delete return the revision token of the
+// write, which reads can require through WithConsistency.
+type GraphClient interface {
+	Upsert(ctx context.Context, t Tuple) error
+	UpsertBatch(ctx context.Context, tuples []Tuple) error
+	WriteRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (string, error)
+	Delete(ctx context.Context, filter TupleFilter, preconditions []Precondition) (string, error)
+	Check(ctx context.Context, subject RelationRef, relation string, object RelationRef, caveatCtx map[string]any) (CheckResult, error)
+	Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error)
+	LookupResources(ctx context.Context, subject RelationRef, relation, resourceType string, page Page) (LookupResult, error)
+	LookupSubjects(ctx context.Context, resource RelationRef, relation, subjectType string, page Page) (LookupResult, error)
 }
 
-// Clear resets all cache entries (used on write or external invalidation)
-func (c *CachedGraph) Clear() { c.cache = make(map[string]cacheEntry) }
+// checkContext returns the caveat context of a check with "now" set to the server's time, so time-bound
+// caveats cannot be satisfied by a caller-supplied clock
+func checkContext(caveatCtx map[string]any) map[string]any {
+	out := make(map[string]any, len(caveatCtx)+1)
+	for k, v := range caveatCtx {
+		out[k] = v
+	}
+	out["now"] = time.Now().UTC().Format(time.RFC3339Nano)
+	return out
+}
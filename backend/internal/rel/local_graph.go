@@ -2,136 +2,457 @@ package rel
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 
 	databasepkg "github.com/Armour007/aura-backend/internal"
 )
 
-// LocalGraph implements GraphClient using the trust_tuples SQL table with simple DFS
-type LocalGraph struct{}
+// maxCheckDepth bounds how many rewrites and usersets a single check may follow
+const maxCheckDepth = 25
 
-func NewLocalGraph() *LocalGraph { return &LocalGraph{} }
+// MaxDepth is the deepest expansion a graph client answers
+const MaxDepth = maxCheckDepth
+
+// ErrMaxDepth is returned when a check or expand goes deeper than the graph allows
+var ErrMaxDepth = errors.New("relationship graph is nested too deeply")
+
+// tupleReader lists the tuples of object#relation
+type tupleReader func(ctx context.Context, object RelationRef, relation string) ([]Tuple, error)
+
+// LocalGraph implements GraphClient on the trust_tuples SQL table, reading the tuples and evaluating the
+// schema of the org in the request context (see WithOrg)
+type LocalGraph struct {
+	read  tupleReader
+	edges edgeReader
+}
+
+func NewLocalGraph() *LocalGraph { return &LocalGraph{read: readTuples, edges: readEdges} }
+
+// readTuples reads from the tuples of the org in ctx
+func readTuples(ctx context.Context, object RelationRef, relation string) ([]Tuple, error) {
+	out := []Tuple{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json FROM trust_tuples
+		WHERE org_id=$1 AND object_type=$2 AND object_id=$3 AND relation=$4`, OrgFrom(ctx), object.Namespace, object.ObjectID, relation)
+	return out, err
+}
+
+// readable checks that ctx names the org to read and that the changelog has reached the revision ctx requires
+func readable(ctx context.Context) error {
+	if _, err := orgOf(ctx); err != nil {
+		return err
+	}
+	return ensureLocalRevision(ctx)
+}
 
 func (l *LocalGraph) Upsert(ctx context.Context, t Tuple) error {
-	_, err := databasepkg.DB.ExecContext(ctx, `INSERT INTO trust_tuples (object_type, object_id, relation, subject_type, subject_id, caveat_json) VALUES ($1,$2,$3,$4,$5,NULL)`, t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID)
-	return err
+	return l.UpsertBatch(ctx, []Tuple{t})
 }
 
 func (l *LocalGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error {
-	for _, t := range tuples {
-		if err := l.Upsert(ctx, t); err != nil {
-			return err
-		}
+	_, err := writeRelationships(ctx, Touches(tuples), nil)
+	return err
+}
+
+// WriteRelationships applies updates atomically once the preconditions hold, logging them in the changelog
+func (l *LocalGraph) WriteRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (string, error) {
+	rev, err := writeRelationships(ctx, updates, preconditions)
+	if err != nil {
+		return "", err
 	}
-	return nil
+	return LocalToken(rev), nil
 }
 
-// Check supports direct edge and a limited 3-hop transitive traversal via member/editor/viewer/owner can_act_for
-func (l *LocalGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error) {
-	// direct relation (including relation implication)
-	if ok, err := l.hasDirectOrImplied(ctx, subject, relation, object); err != nil {
-		return false, "local", err
-	} else if ok {
-		return true, "local", nil
+// Delete removes every tuple matching filter once the preconditions hold
+func (l *LocalGraph) Delete(ctx context.Context, filter TupleFilter, preconditions []Precondition) (string, error) {
+	rev, err := deleteRelationships(ctx, filter, preconditions)
+	if err != nil {
+		return "", err
 	}
+	return LocalToken(rev), nil
+}
 
-	// BFS up to depth 3 following membership/delegation edges to intermediate nodes
-	type node struct {
-		ns, id string
-		depth  int
+// Check evaluates relation on object for subject under the org's schema, with caveats evaluated over caveatCtx.
+// Tuples are read from the database, so only at_least_as_fresh tokens need checking.
+func (l *LocalGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef, caveatCtx map[string]any) (CheckResult, error) {
+	if err := readable(ctx); err != nil {
+		return CheckResult{Permissionship: PermissionDenied, Source: "local"}, err
 	}
-	q := []node{{ns: subject.Namespace, id: subject.ObjectID, depth: 0}}
-	seen := map[string]bool{subject.Namespace + ":" + subject.ObjectID: true}
-	maxDepth := 3
-	for len(q) > 0 {
-		cur := q[0]
-		q = q[1:]
-		if cur.depth >= maxDepth {
-			continue
-		}
-		rows, err := databasepkg.DB.QueryxContext(ctx, `SELECT object_type, object_id, relation FROM trust_tuples WHERE subject_type=$1 AND subject_id=$2`, cur.ns, cur.id)
-		if err != nil {
-			return false, "local", err
-		}
-		for rows.Next() {
-			var ot, oid, rel string
-			if err := rows.Scan(&ot, &oid, &rel); err != nil {
-				_ = rows.Close()
-				return false, "local", err
-			}
-			// final hop: does this edge grant or imply the desired relation on the target object?
-			if ot == object.Namespace && oid == object.ObjectID && implies(rel, relation) {
-				_ = rows.Close()
-				return true, "local", nil
-			}
-			// intermediate hop: traverse only through membership/delegation
-			if rel == "member" || rel == "can_act_for" {
-				key := ot + ":" + oid
-				if !seen[key] {
-					seen[key] = true
-					q = append(q, node{ns: ot, id: oid, depth: cur.depth + 1})
-				}
-			}
+	s, err := SchemaFor(ctx, OrgFrom(ctx))
+	if err != nil {
+		return CheckResult{Permissionship: PermissionDenied, Source: "local"}, err
+	}
+	c := newChecker(s, l.read)
+	c.caveatCtx = checkContext(caveatCtx)
+	c.deps = map[string]bool{}
+	o, err := c.check(ctx, object, relation, subject, 0)
+	if err != nil {
+		return CheckResult{Permissionship: PermissionDenied, Source: "local"}, err
+	}
+	res := CheckResult{Permissionship: o.perm, MissingContext: o.missing, Caveated: c.caveated, Source: "local", deps: sortedKeys(c.deps)}
+	if ExplainFrom(ctx) {
+		res.Explanation = &Explanation{Path: o.path}
+		if o.perm == PermissionDenied {
+			res.Explanation.Visited = res.deps
 		}
-		_ = rows.Close()
 	}
-	return false, "local", nil
+	return res, nil
 }
 
-func (l *LocalGraph) hasDirectOrImplied(ctx context.Context, subject RelationRef, desired string, object RelationRef) (bool, error) {
-	// check for any tuple that satisfies desired via implication at target
-	// e.g., owner implies editor implies viewer
-	query := `SELECT relation FROM trust_tuples WHERE object_type=$1 AND object_id=$2 AND subject_type=$3 AND subject_id=$4`
-	rows, err := databasepkg.DB.QueryxContext(ctx, query, object.Namespace, object.ObjectID, subject.Namespace, subject.ObjectID)
+// Expand returns the userset tree of relation on object, following rewrites, usersets and arrows up to
+// depth levels deep (at most maxCheckDepth)
+func (l *LocalGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
+	if err := readable(ctx); err != nil {
+		return GraphExpansion{}, err
+	}
+	s, err := SchemaFor(ctx, OrgFrom(ctx))
 	if err != nil {
-		return false, err
+		return GraphExpansion{}, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var rel string
-		if err := rows.Scan(&rel); err != nil {
-			return false, err
-		}
-		if implies(rel, desired) {
-			return true, nil
+	return newChecker(s, l.read).expand(ctx, relation, object, min(depth, maxCheckDepth))
+}
+
+func (l *LocalGraph) lookup(ctx context.Context) (*lookup, error) {
+	if err := readable(ctx); err != nil {
+		return nil, err
+	}
+	s, err := SchemaFor(ctx, OrgFrom(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return &lookup{schema: s, read: l.read, edges: l.edges, caveatCtx: checkContext(nil)}, nil
+}
+
+// LookupResources lists the objects of resourceType on which subject has relation
+func (l *LocalGraph) LookupResources(ctx context.Context, subject RelationRef, relation, resourceType string, page Page) (LookupResult, error) {
+	lk, err := l.lookup(ctx)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return lk.resources(ctx, subject, relation, resourceType, page)
+}
+
+// LookupSubjects lists the subjects of subjectType that have relation on resource
+func (l *LocalGraph) LookupSubjects(ctx context.Context, resource RelationRef, relation, subjectType string, page Page) (LookupResult, error) {
+	lk, err := l.lookup(ctx)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return lk.subjects(ctx, resource, relation, subjectType, page)
+}
+
+// checker evaluates one check or expand; allowed results are memoized for its lifetime. When deps is
+// set, the tuple sets a check reads are recorded in it.
+type checker struct {
+	schema    *Schema
+	read      tupleReader
+	caveatCtx map[string]any
+	caveated  bool
+	memo      map[string]outcome
+	active    map[string]bool
+	deps      map[string]bool
+}
+
+func newChecker(s *Schema, read tupleReader) *checker {
+	return &checker{schema: s, read: read, memo: map[string]outcome{}, active: map[string]bool{}}
+}
+
+// outcome is the result of a check or a part of one; missing lists the context a conditional result needs
+// and path the tuples leading from the object to the subject when it is not denied
+type outcome struct {
+	perm    Permissionship
+	missing []string
+	path    []Tuple
+}
+
+var (
+	allowed = outcome{perm: PermissionAllowed}
+	denied  = outcome{perm: PermissionDenied}
+)
+
+// and combines outcomes that must all hold; the paths of both are needed
+func (o outcome) and(p outcome) outcome {
+	switch {
+	case o.perm == PermissionDenied || p.perm == PermissionDenied:
+		return denied
+	case o.perm == PermissionAllowed && p.perm == PermissionAllowed:
+		return outcome{perm: PermissionAllowed, path: concatPath(o.path, p.path)}
+	}
+	return outcome{perm: PermissionConditional, missing: mergeMissing(o.missing, p.missing), path: concatPath(o.path, p.path)}
+}
+
+// or combines alternatives, keeping the path of the one that holds
+func (o outcome) or(p outcome) outcome {
+	switch {
+	case o.perm == PermissionAllowed:
+		return o
+	case p.perm == PermissionAllowed:
+		return p
+	case o.perm == PermissionDenied:
+		return p
+	case p.perm == PermissionDenied:
+		return o
+	}
+	return outcome{perm: PermissionConditional, missing: mergeMissing(o.missing, p.missing), path: o.path}
+}
+
+// not negates an outcome; a conditional stays conditional. Nothing proves an absence, so the path is dropped.
+func (o outcome) not() outcome {
+	switch o.perm {
+	case PermissionAllowed:
+		return denied
+	case PermissionDenied:
+		return allowed
+	}
+	return outcome{perm: o.perm, missing: o.missing}
+}
+
+// via prefixes the path of o with the tuple that led to it
+func (o outcome) via(t Tuple) outcome {
+	if o.perm != PermissionDenied {
+		o.path = concatPath([]Tuple{t}, o.path)
+	}
+	return o
+}
+
+func concatPath(a, b []Tuple) []Tuple {
+	if len(a)+len(b) == 0 {
+		return nil
+	}
+	return append(append(make([]Tuple, 0, len(a)+len(b)), a...), b...)
+}
+
+func mergeMissing(a, b []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, m := range append(append([]string{}, a...), b...) {
+		if !seen[m] {
+			seen[m] = true
+			out = append(out, m)
 		}
 	}
-	return false, nil
+	sort.Strings(out)
+	return out
 }
 
-func implies(have, want string) bool {
-	if have == want {
-		return true
+// tuples reads object#relation, recording it as a dependency of the check
+func (c *checker) tuples(ctx context.Context, object RelationRef, relation string) ([]Tuple, error) {
+	if c.deps != nil {
+		c.deps[object.Namespace+":"+object.ObjectID+"#"+relation] = true
 	}
-	// owner -> editor -> viewer
-	if have == "owner" && (want == "editor" || want == "viewer") {
-		return true
+	return c.read(ctx, object, relation)
+}
+
+func (c *checker) check(ctx context.Context, object RelationRef, relation string, subject RelationRef, depth int) (outcome, error) {
+	if depth > maxCheckDepth {
+		return denied, ErrMaxDepth
 	}
-	if have == "editor" && want == "viewer" {
-		return true
+	key := object.Namespace + ":" + object.ObjectID + "#" + relation
+	if o, ok := c.memo[key]; ok {
+		return o, nil
 	}
-	return false
+	if c.active[key] {
+		// a cycle in the data cannot add members
+		return denied, nil
+	}
+	e, err := c.schema.rewriteFor(object.Namespace, relation)
+	if err != nil {
+		return denied, err
+	}
+	c.active[key] = true
+	defer delete(c.active, key)
+	o, err := c.eval(ctx, e, object, relation, subject, depth)
+	if err == nil && o.perm == PermissionAllowed {
+		c.memo[key] = o
+	}
+	return o, err
 }
 
-func (l *LocalGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
-	if depth <= 0 {
-		return GraphExpansion{Relation: relation, Object: object}, nil
+// caveat evaluates a tuple's caveat; tuples without one hold unconditionally
+func (c *checker) caveat(t Tuple) (outcome, error) {
+	if t.Caveat == nil {
+		return allowed, nil
+	}
+	c.caveated = true
+	def := c.schema.Caveats[t.Caveat.Name]
+	if def == nil {
+		// the schema no longer defines the caveat: the tuple cannot grant anything
+		return denied, nil
+	}
+	return def.eval(t.Caveat.Name, t.Caveat.Context, c.caveatCtx)
+}
+
+// viaTuples combines, over the tuples that match, each tuple's caveat with the outcome next gives for it,
+// recording the tuple on the path
+func (c *checker) viaTuples(tuples []Tuple, match func(t Tuple) bool, next func(t Tuple) (outcome, error)) (outcome, error) {
+	res := denied
+	for _, t := range tuples {
+		if !match(t) {
+			continue
+		}
+		cav, err := c.caveat(t)
+		if err != nil {
+			return denied, err
+		}
+		if cav.perm == PermissionDenied {
+			continue
+		}
+		o, err := next(t)
+		if err != nil {
+			return denied, err
+		}
+		if res = res.or(cav.and(o).via(t)); res.perm == PermissionAllowed {
+			return res, nil
+		}
+	}
+	return res, nil
+}
+
+func (c *checker) eval(ctx context.Context, e *rewrite, object RelationRef, relation string, subject RelationRef, depth int) (outcome, error) {
+	switch e.op {
+	case opThis:
+		tuples, err := c.tuples(ctx, object, relation)
+		if err != nil {
+			return denied, err
+		}
+		// direct subjects first, then usersets
+		direct, err := c.viaTuples(tuples, func(t Tuple) bool {
+			return t.SubjectRelation == "" && t.SubjectType == subject.Namespace && t.SubjectID == subject.ObjectID
+		}, func(Tuple) (outcome, error) { return allowed, nil })
+		if err != nil || direct.perm == PermissionAllowed {
+			return direct, err
+		}
+		usersets, err := c.viaTuples(tuples, func(t Tuple) bool { return t.SubjectRelation != "" }, func(t Tuple) (outcome, error) {
+			return c.check(ctx, RelationRef{Namespace: t.SubjectType, ObjectID: t.SubjectID}, t.SubjectRelation, subject, depth+1)
+		})
+		return direct.or(usersets), err
+	case opComputed:
+		return c.check(ctx, object, e.rel, subject, depth+1)
+	case opArrow:
+		tuples, err := c.tuples(ctx, object, e.rel)
+		if err != nil {
+			return denied, err
+		}
+		return c.viaTuples(tuples, func(t Tuple) bool { return c.schema.resolves(t.SubjectType, e.via) }, func(t Tuple) (outcome, error) {
+			return c.check(ctx, RelationRef{Namespace: t.SubjectType, ObjectID: t.SubjectID}, e.via, subject, depth+1)
+		})
+	case opUnion:
+		res := denied
+		for _, a := range e.args {
+			o, err := c.eval(ctx, a, object, relation, subject, depth)
+			if err != nil {
+				return denied, err
+			}
+			if res = res.or(o); res.perm == PermissionAllowed {
+				return res, nil
+			}
+		}
+		return res, nil
+	case opIntersection:
+		res := allowed
+		for _, a := range e.args {
+			o, err := c.eval(ctx, a, object, relation, subject, depth)
+			if err != nil {
+				return denied, err
+			}
+			if res = res.and(o); res.perm == PermissionDenied {
+				return res, nil
+			}
+		}
+		return res, nil
+	case opExclusion:
+		base, err := c.eval(ctx, e.args[0], object, relation, subject, depth)
+		if err != nil || base.perm == PermissionDenied {
+			return denied, err
+		}
+		excluded, err := c.eval(ctx, e.args[1], object, relation, subject, depth)
+		if err != nil {
+			return denied, err
+		}
+		return base.and(excluded.not()), nil
+	}
+	return denied, fmt.Errorf("unknown rewrite %q", e.op)
+}
+
+func (c *checker) expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
+	exp := GraphExpansion{Relation: relation, Object: object}
+	key := object.Namespace + ":" + object.ObjectID + "#" + relation
+	if depth <= 0 || c.active[key] {
+		// a node already open on this path is left unexpanded
+		return exp, nil
 	}
-	// list direct subjects
-	rows, err := databasepkg.DB.QueryxContext(ctx, `SELECT subject_type, subject_id FROM trust_tuples WHERE object_type=$1 AND object_id=$2 AND relation=$3`, object.Namespace, object.ObjectID, relation)
+	e, err := c.schema.rewriteFor(object.Namespace, relation)
 	if err != nil {
 		return GraphExpansion{}, err
 	}
-	defer rows.Close()
-	exp := GraphExpansion{Relation: relation, Object: object}
-	for rows.Next() {
-		var st, sid string
-		if err := rows.Scan(&st, &sid); err != nil {
-			return GraphExpansion{}, err
+	c.active[key] = true
+	defer delete(c.active, key)
+	exp.Children, err = c.expandRewrite(ctx, e, relation, object, depth)
+	return exp, err
+}
+
+// expandRewrite lists the children a rewrite contributes: subjects, and userset nodes expanded up to depth.
+// Intersections and exclusions become one node per operand under a node carrying the operation.
+func (c *checker) expandRewrite(ctx context.Context, e *rewrite, relation string, object RelationRef, depth int) ([]GraphExpansion, error) {
+	node := func(rel string, obj RelationRef) (GraphExpansion, error) {
+		if rel == "" {
+			return GraphExpansion{Relation: "subject", Object: obj}, nil
+		}
+		return c.expand(ctx, rel, obj, depth-1)
+	}
+	var out []GraphExpansion
+	switch e.op {
+	case opThis, opArrow:
+		tuplesOf := relation
+		if e.op == opArrow {
+			tuplesOf = e.rel
+		}
+		tuples, err := c.read(ctx, object, tuplesOf)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tuples {
+			rel := t.SubjectRelation
+			if e.op == opArrow {
+				if !c.schema.resolves(t.SubjectType, e.via) {
+					continue
+				}
+				rel = e.via
+			}
+			n, err := node(rel, RelationRef{Namespace: t.SubjectType, ObjectID: t.SubjectID})
+			if err != nil {
+				return nil, err
+			}
+			n.Caveat = t.Caveat
+			out = append(out, n)
+		}
+	case opComputed:
+		n, err := node(e.rel, object)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	case opUnion:
+		for _, a := range e.args {
+			children, err := c.expandRewrite(ctx, a, relation, object, depth)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, children...)
+		}
+	case opIntersection, opExclusion:
+		op := GraphExpansion{Relation: relation, Object: object, Operation: e.op}
+		for _, a := range e.args {
+			children, err := c.expandRewrite(ctx, a, relation, object, depth)
+			if err != nil {
+				return nil, err
+			}
+			op.Children = append(op.Children, GraphExpansion{Relation: relation, Object: object, Children: children})
 		}
-		child := GraphExpansion{Relation: "subject", Object: RelationRef{Namespace: st, ObjectID: sid}}
-		exp.Children = append(exp.Children, child)
+		out = append(out, op)
 	}
-	return exp, nil
+	return out, nil
 }
 
 var _ GraphClient = (*LocalGraph)(nil)
@@ -0,0 +1,168 @@
+package rel
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+)
+
+// ErrLookupTooBroad is returned when a lookup would visit more of the graph than a request may
+var ErrLookupTooBroad = errors.New("lookup visits too much of the relationship graph")
+
+const (
+	// maxLookupNodes bounds the objects a lookup may visit while collecting candidates
+	maxLookupNodes = 10000
+	// DefaultLookupLimit and MaxLookupLimit bound a lookup page
+	DefaultLookupLimit = 100
+	MaxLookupLimit     = 1000
+)
+
+// Page selects a page of lookup results: Cursor is the NextCursor of the previous page ("" for the first)
+type Page struct {
+	Cursor string `json:"cursor,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+func (p Page) limit() int {
+	switch {
+	case p.Limit <= 0:
+		return DefaultLookupLimit
+	case p.Limit > MaxLookupLimit:
+		return MaxLookupLimit
+	}
+	return p.Limit
+}
+
+// LookupItem is one resource or subject found by a lookup
+type LookupItem struct {
+	ObjectID       string         `json:"object_id"`
+	Permissionship Permissionship `json:"permissionship"`
+	MissingContext []string       `json:"missing_context,omitempty"`
+}
+
+// LookupResult is a page of lookup results, ordered by object id. NextCursor is empty on the last page.
+type LookupResult struct {
+	Results    []LookupItem `json:"results"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// edgeReader lists the tuples of any relation on node, or with reverse the tuples naming node as subject
+type edgeReader func(ctx context.Context, node RelationRef, reverse bool) ([]Tuple, error)
+
+// readEdges reads from the tuples of the org in ctx
+func readEdges(ctx context.Context, node RelationRef, reverse bool) ([]Tuple, error) {
+	col := "object"
+	if reverse {
+		col = "subject"
+	}
+	out := []Tuple{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json FROM trust_tuples
+		WHERE org_id=$1 AND `+col+`_type=$2 AND `+col+`_id=$3`, OrgFrom(ctx), node.Namespace, node.ObjectID)
+	return out, err
+}
+
+// lookup answers reverse queries with the rules of Check: it collects the objects of the wanted type that
+// tuples connect to the starting object, in either direction, and keeps those a check allows
+type lookup struct {
+	schema    *Schema
+	read      tupleReader
+	edges     edgeReader
+	caveatCtx map[string]any
+}
+
+// candidates walks tuples from start and returns the ids of reachable objects of namespace ns, sorted
+func (l *lookup) candidates(ctx context.Context, start RelationRef, ns string, reverse bool) ([]string, error) {
+	seen := map[RelationRef]bool{start: true}
+	found := map[string]bool{}
+	frontier := []RelationRef{start}
+	for depth := 0; len(frontier) > 0 && depth <= maxCheckDepth; depth++ {
+		var next []RelationRef
+		for _, node := range frontier {
+			tuples, err := l.edges(ctx, node, reverse)
+			if err != nil {
+				return nil, err
+			}
+			for _, t := range tuples {
+				other := RelationRef{Namespace: t.SubjectType, ObjectID: t.SubjectID}
+				if reverse {
+					other = RelationRef{Namespace: t.ObjectType, ObjectID: t.ObjectID}
+				}
+				if other.Namespace == ns {
+					found[other.ObjectID] = true
+				}
+				if seen[other] {
+					continue
+				}
+				if len(seen) >= maxLookupNodes {
+					return nil, ErrLookupTooBroad
+				}
+				seen[other] = true
+				next = append(next, other)
+			}
+		}
+		frontier = next
+	}
+	return sortedKeys(found), nil
+}
+
+// page checks candidates after the cursor in order until the page is full
+func (l *lookup) page(ctx context.Context, ids []string, p Page, check func(c *checker, id string) (outcome, error)) (LookupResult, error) {
+	after := ""
+	if p.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+		if err != nil {
+			return LookupResult{}, ErrInvalidToken
+		}
+		after = string(b)
+	}
+	i := sort.SearchStrings(ids, after)
+	if i < len(ids) && ids[i] == after {
+		i++
+	}
+	res := LookupResult{Results: []LookupItem{}}
+	for ; i < len(ids); i++ {
+		if len(res.Results) == p.limit() {
+			res.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(ids[i-1]))
+			break
+		}
+		c := newChecker(l.schema, l.read)
+		c.caveatCtx = l.caveatCtx
+		o, err := check(c, ids[i])
+		if err != nil {
+			return LookupResult{}, err
+		}
+		if o.perm != PermissionDenied {
+			res.Results = append(res.Results, LookupItem{ObjectID: ids[i], Permissionship: o.perm, MissingContext: o.missing})
+		}
+	}
+	return res, nil
+}
+
+func (l *lookup) resources(ctx context.Context, subject RelationRef, relation, resourceType string, p Page) (LookupResult, error) {
+	if _, err := l.schema.rewriteFor(resourceType, relation); err != nil {
+		return LookupResult{}, err
+	}
+	ids, err := l.candidates(ctx, subject, resourceType, true)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return l.page(ctx, ids, p, func(c *checker, id string) (outcome, error) {
+		return c.check(ctx, RelationRef{Namespace: resourceType, ObjectID: id}, relation, subject, 0)
+	})
+}
+
+func (l *lookup) subjects(ctx context.Context, resource RelationRef, relation, subjectType string, p Page) (LookupResult, error) {
+	if _, err := l.schema.rewriteFor(resource.Namespace, relation); err != nil {
+		return LookupResult{}, err
+	}
+	ids, err := l.candidates(ctx, resource, subjectType, false)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return l.page(ctx, ids, p, func(c *checker, id string) (outcome, error) {
+		return c.check(ctx, resource, relation, RelationRef{Namespace: subjectType, ObjectID: id}, 0)
+	})
+}
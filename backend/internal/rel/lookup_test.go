@@ -0,0 +1,122 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// memLookup serves a lookup from a slice of tuples
+func memLookup(s *Schema, edges ...Tuple) *lookup {
+	return &lookup{schema: s, read: memTuples(edges...), edges: func(ctx context.Context, node RelationRef, reverse bool) ([]Tuple, error) {
+		out := []Tuple{}
+		for _, e := range edges {
+			if (!reverse && e.ObjectType == node.Namespace && e.ObjectID == node.ObjectID) ||
+				(reverse && e.SubjectType == node.Namespace && e.SubjectID == node.ObjectID) {
+				out = append(out, e)
+			}
+		}
+		return out, nil
+	}}
+}
+
+func ids(res LookupResult) []string {
+	out := []string{}
+	for _, it := range res.Results {
+		out = append(out, it.ObjectID)
+	}
+	return out
+}
+
+func TestLookupDelegations(t *testing.T) {
+	// agents a1 and a2 act for org o1 through team ops; a1 also acts for o2 directly
+	l := memLookup(DefaultSchema(),
+		Tuple{ObjectType: "team", ObjectID: "ops", Relation: "member", SubjectType: "agent", SubjectID: "a1"},
+		Tuple{ObjectType: "team", ObjectID: "ops", Relation: "member", SubjectType: "agent", SubjectID: "a2"},
+		Tuple{ObjectType: "org", ObjectID: "o1", Relation: "can_act_for", SubjectType: "team", SubjectID: "ops"},
+		Tuple{ObjectType: "org", ObjectID: "o2", Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"},
+		Tuple{ObjectType: "org", ObjectID: "o3", Relation: "viewer", SubjectType: "agent", SubjectID: "a1"},
+	)
+	ctx := context.Background()
+	res, err := l.resources(ctx, RelationRef{"agent", "a1"}, "can_act_for", "org", Page{})
+	if err != nil {
+		t.Fatalf("lookup resources: %v", err)
+	}
+	if got := ids(res); !reflect.DeepEqual(got, []string{"o1", "o2"}) || res.NextCursor != "" {
+		t.Errorf("orgs a1 can act for: %v (cursor %q)", got, res.NextCursor)
+	}
+	res, err = l.subjects(ctx, RelationRef{"org", "o1"}, "can_act_for", "agent", Page{})
+	if err != nil {
+		t.Fatalf("lookup subjects: %v", err)
+	}
+	if got := ids(res); !reflect.DeepEqual(got, []string{"a1", "a2"}) {
+		t.Errorf("agents acting for o1: %v", got)
+	}
+	// one per page
+	first, _ := l.subjects(ctx, RelationRef{"org", "o1"}, "can_act_for", "agent", Page{Limit: 1})
+	if got := ids(first); !reflect.DeepEqual(got, []string{"a1"}) || first.NextCursor == "" {
+		t.Fatalf("first page: %v (cursor %q)", got, first.NextCursor)
+	}
+	second, _ := l.subjects(ctx, RelationRef{"org", "o1"}, "can_act_for", "agent", Page{Limit: 1, Cursor: first.NextCursor})
+	if got := ids(second); !reflect.DeepEqual(got, []string{"a2"}) {
+		t.Errorf("second page: %v", got)
+	}
+	if _, err := l.subjects(ctx, RelationRef{"org", "o1"}, "can_act_for", "agent", Page{Cursor: "%%"}); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("bad cursor: %v", err)
+	}
+}
+
+func TestLookupFollowsRewrites(t *testing.T) {
+	s, err := ParseSchema([]byte(docSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	l := memLookup(s,
+		Tuple{ObjectType: "team", ObjectID: "core", Relation: "member", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "folder", ObjectID: "f1", Relation: "viewer", SubjectType: "team", SubjectID: "core", SubjectRelation: "member"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "parent", SubjectType: "folder", SubjectID: "f1"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "user", SubjectID: "carol"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "banned", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "doc", ObjectID: "d2", Relation: "owner", SubjectType: "user", SubjectID: "bob"},
+	)
+	ctx := context.Background()
+	res, _ := l.resources(ctx, RelationRef{"user", "bob"}, "viewer", "doc", Page{})
+	if got := ids(res); !reflect.DeepEqual(got, []string{"d1", "d2"}) {
+		t.Errorf("docs bob views: %v", got)
+	}
+	// can_share = (editor & parent->viewer) - banned: carol is no folder viewer and bob is banned
+	res, _ = l.subjects(ctx, RelationRef{"doc", "d1"}, "can_share", "user", Page{})
+	if got := ids(res); len(got) != 0 {
+		t.Errorf("users who can share d1: %v", got)
+	}
+	res, _ = l.subjects(ctx, RelationRef{"doc", "d1"}, "viewer", "user", Page{})
+	if got := ids(res); !reflect.DeepEqual(got, []string{"bob", "carol"}) {
+		t.Errorf("users who view d1: %v", got)
+	}
+	if _, err := l.resources(ctx, RelationRef{"user", "bob"}, "admin", "doc", Page{}); !errors.Is(err, ErrUnknownRelation) {
+		t.Errorf("unknown relation: %v", err)
+	}
+}
+
+func TestExpandStopsAtCycles(t *testing.T) {
+	read := memTuples(
+		Tuple{ObjectType: "team", ObjectID: "a", Relation: "member", SubjectType: "team", SubjectID: "b", SubjectRelation: "member"},
+		Tuple{ObjectType: "team", ObjectID: "b", Relation: "member", SubjectType: "team", SubjectID: "a", SubjectRelation: "member"},
+		Tuple{ObjectType: "team", ObjectID: "b", Relation: "member", SubjectType: "user", SubjectID: "alice"},
+	)
+	s, err := ParseSchema([]byte(docSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	exp, err := newChecker(s, read).expand(context.Background(), "member", RelationRef{"team", "a"}, MaxDepth)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	// team:a -> team:b#member -> {team:a#member (left open), alice}
+	b := exp.Children[0]
+	if len(b.Children) != 2 || len(b.Children[0].Children) != 0 || b.Children[1].Object.ObjectID != "alice" {
+		t.Errorf("unexpected tree: %+v", exp)
+	}
+}
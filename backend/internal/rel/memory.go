@@ -2,35 +2,67 @@ package rel
 
 import "sync"
 
-// Tuple represents (object, relation, subject)
+// Tuple represents (object, relation, subject). A subject relation makes the subject a userset,
+// e.g. team:devs#member.
 type Tuple struct {
-	ObjectType  string `json:"object_type"`
-	ObjectID    string `json:"object_id"`
-	Relation    string `json:"relation"`
-	SubjectType string `json:"subject_type"`
-	SubjectID   string `json:"subject_id"`
+	ObjectType      string  `json:"object_type" db:"object_type"`
+	ObjectID        string  `json:"object_id" db:"object_id"`
+	Relation        string  `json:"relation" db:"relation"`
+	SubjectType     string  `json:"subject_type" db:"subject_type"`
+	SubjectID       string  `json:"subject_id" db:"subject_id"`
+	SubjectRelation string  `json:"subject_relation,omitempty" db:"subject_relation"`
+	Caveat          *Caveat `json:"caveat,omitempty" db:"caveat_json"`
 }
 
-// Store is an in-memory tuple store for prototype
+// Store is an in-memory tuple store for prototype, partitioned by org like trust_tuples
 type Store struct {
 	mu     sync.RWMutex
-	tuples []Tuple
+	tuples map[string][]Tuple
 }
 
-func NewStore() *Store { return &Store{tuples: []Tuple{}} }
+func NewStore() *Store { return &Store{tuples: map[string][]Tuple{}} }
 
-func (s *Store) Upsert(ts []Tuple) {
+// key identifies a tuple regardless of its caveat
+func (t Tuple) key() TupleFilter {
+	return TupleFilter{ObjectType: t.ObjectType, ObjectID: t.ObjectID, Relation: t.Relation, SubjectType: t.SubjectType, SubjectID: t.SubjectID, SubjectRelation: t.SubjectRelation}
+}
+
+// Upsert touches the tuples of org: an existing tuple takes the new caveat
+func (s *Store) Upsert(org string, ts []Tuple) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tuples := s.tuples[org]
+next:
+	for _, t := range ts {
+		for i := range tuples {
+			if tuples[i].key() == t.key() {
+				tuples[i] = t
+				continue next
+			}
+		}
+		tuples = append(tuples, t)
+	}
+	s.tuples[org] = tuples
+}
+
+// Delete removes the tuples of org matching filter
+func (s *Store) Delete(org string, filter TupleFilter) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	// simplistic: append; no de-dup
-	s.tuples = append(s.tuples, ts...)
+	kept := s.tuples[org][:0]
+	for _, t := range s.tuples[org] {
+		if !filter.matches(t) {
+			kept = append(kept, t)
+		}
+	}
+	s.tuples[org] = kept
 }
 
-func (s *Store) Check(subjectType, subjectID, relation, objectType, objectID string) bool {
+func (s *Store) Check(org, subjectType, subjectID, relation, objectType, objectID string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for _, t := range s.tuples {
-		if t.ObjectType == objectType && t.ObjectID == objectID && t.Relation == relation && t.SubjectType == subjectType && t.SubjectID == subjectID {
+	for _, t := range s.tuples[org] {
+		if t.ObjectType == objectType && t.ObjectID == objectID && t.Relation == relation && t.SubjectType == subjectType && t.SubjectID == subjectID && t.Caveat == nil {
 			return true
 		}
 	}
@@ -8,22 +8,28 @@ import (
 
 type TupleDB struct{}
 
+// Upsert validates the tuples against the org schema in ctx and touches them
 func (TupleDB) Upsert(ctx context.Context, tuples []Tuple) error {
 	if len(tuples) == 0 {
 		return nil
 	}
-	// naive: insert all
-	for _, t := range tuples {
-		_, err := databasepkg.DB.ExecContext(ctx, `INSERT INTO trust_tuples (object_type, object_id, relation, subject_type, subject_id, caveat_json) VALUES ($1,$2,$3,$4,$5,NULL)`, t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+	_, err := writeRelationships(ctx, Touches(tuples), nil)
+	return err
+}
+
+// Delete removes the tuples of the org in ctx matching filter
+func (TupleDB) Delete(ctx context.Context, filter TupleFilter) error {
+	_, err := deleteRelationships(ctx, filter, nil)
+	return err
 }
 
+// Check looks for a direct, uncaveated tuple among the tuples of the org in ctx
 func (TupleDB) Check(ctx context.Context, subjectType, subjectID, relation, objectType, objectID string) (bool, error) {
+	org, err := orgOf(ctx)
+	if err != nil {
+		return false, err
+	}
 	var n int
-	err := databasepkg.DB.GetContext(ctx, &n, `SELECT COUNT(1) FROM trust_tuples WHERE object_type=$1 AND object_id=$2 AND relation=$3 AND subject_type=$4 AND subject_id=$5`, objectType, objectID, relation, subjectType, subjectID)
+	err = databasepkg.DB.GetContext(ctx, &n, `SELECT COUNT(1) FROM trust_tuples WHERE org_id=$1 AND object_type=$2 AND object_id=$3 AND relation=$4 AND subject_type=$5 AND subject_id=$6 AND subject_relation='' AND caveat_json IS NULL`, org, objectType, objectID, relation, subjectType, subjectID)
 	return n > 0, err
 }
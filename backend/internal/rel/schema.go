@@ -0,0 +1,453 @@
+package rel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrInvalidTuple is returned when a tuple write does not fit the org's schema
+	ErrInvalidTuple = errors.New("tuple does not match schema")
+	// ErrUnknownRelation is returned when a check or expand names a relation the schema does not define
+	ErrUnknownRelation = errors.New("relation not defined in schema")
+)
+
+var schemaName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// Schema is an org's namespace configuration for the trust graph: object types, their relations and
+// the userset rewrites that derive one relation from others.
+//
+//	{"namespaces": {
+//	  "team":     {"relations": {"member": {"types": ["user", "agent", "team#member"]}}},
+//	  "folder":   {"relations": {"viewer": {"types": ["user"]}}},
+//	  "resource": {"relations": {
+//	    "parent": {"types": ["folder"]},
+//	    "owner":  {"types": ["user"]},
+//	    "editor": {"types": ["user", "team#member"], "rewrite": "editor + owner"},
+//	    "viewer": {"types": ["user", "team#member"], "rewrite": "viewer + editor + parent->viewer"}}}}}
+//
+// A rewrite combines relations of the same object with + (union), & (intersection) and - (exclusion);
+// parent->viewer is a tuple-to-userset (viewer on every object related through parent) and a relation
+// naming itself stands for its own tuples. Without a rewrite a relation is just its tuples.
+// A type such as "user with valid_between" admits tuples carrying that caveat (see CaveatDef).
+type Schema struct {
+	Namespaces map[string]Namespace  `json:"namespaces"`
+	Caveats    map[string]*CaveatDef `json:"caveats,omitempty"`
+
+	// open marks the built-in default: undefined relations follow the legacy rules and writes are not checked
+	open     bool
+	rewrites map[string]*rewrite
+}
+
+// Namespace is one object type
+type Namespace struct {
+	Relations map[string]Relation `json:"relations,omitempty"`
+}
+
+// Relation lists the subjects a tuple may carry (object types or usersets such as team#member) and an
+// optional rewrite expression
+type Relation struct {
+	Types   []string `json:"types,omitempty"`
+	Rewrite string   `json:"rewrite,omitempty"`
+}
+
+// Rewrite operations
+const (
+	opThis         = "this"
+	opComputed     = "computed"
+	opArrow        = "arrow"
+	opUnion        = "union"
+	opIntersection = "intersection"
+	opExclusion    = "exclusion"
+)
+
+type rewrite struct {
+	op   string
+	rel  string // computed relation, or the tupleset relation of an arrow
+	via  string // arrow: relation evaluated on the objects reached through rel
+	args []*rewrite
+}
+
+var defaultSchema = &Schema{Namespaces: map[string]Namespace{}, open: true, rewrites: map[string]*rewrite{}}
+
+// DefaultSchema applies to orgs without a schema of their own. It keeps the original graph semantics:
+// owner implies editor implies viewer, and any relation reaches through the members and delegates
+// (member, can_act_for) of the subjects it names.
+func DefaultSchema() *Schema { return defaultSchema }
+
+// Default reports whether s is the built-in default schema
+func (s *Schema) Default() bool { return s.open }
+
+// ParseSchema decodes and validates a schema definition
+func ParseSchema(raw []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var s Schema
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if len(s.Namespaces) == 0 {
+		return nil, fmt.Errorf("schema defines no namespaces")
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (s *Schema) compile() error {
+	s.rewrites = map[string]*rewrite{}
+	for _, name := range sortedKeys(s.Caveats) {
+		if !schemaName.MatchString(name) {
+			return fmt.Errorf("caveat %q: names must match %s", name, schemaName)
+		}
+		if s.Caveats[name] == nil {
+			return fmt.Errorf("caveat %s: definition required", name)
+		}
+		if err := s.Caveats[name].compile(); err != nil {
+			return fmt.Errorf("caveat %s: %w", name, err)
+		}
+	}
+	for _, ns := range sortedKeys(s.Namespaces) {
+		if !schemaName.MatchString(ns) {
+			return fmt.Errorf("namespace %q: names must match %s", ns, schemaName)
+		}
+		for _, name := range sortedKeys(s.Namespaces[ns].Relations) {
+			r := s.Namespaces[ns].Relations[name]
+			where := ns + "#" + name
+			if !schemaName.MatchString(name) {
+				return fmt.Errorf("%s: names must match %s", where, schemaName)
+			}
+			for _, t := range r.Types {
+				t, caveat, _ := strings.Cut(t, " with ")
+				if caveat != "" && s.Caveats[caveat] == nil {
+					return fmt.Errorf("%s: subject type %q names an undefined caveat", where, t+" with "+caveat)
+				}
+				tns, trel, _ := strings.Cut(t, "#")
+				if !s.defines(tns, "") {
+					return fmt.Errorf("%s: subject type %q is not a defined namespace", where, tns)
+				}
+				if trel != "" && !s.defines(tns, trel) {
+					return fmt.Errorf("%s: subject type %q names an undefined relation", where, t)
+				}
+			}
+			expr := &rewrite{op: opThis}
+			if r.Rewrite != "" {
+				var err error
+				if expr, err = parseRewrite(r.Rewrite, name); err != nil {
+					return fmt.Errorf("%s: %w", where, err)
+				}
+			}
+			if err := s.checkRewrite(ns, name, r, expr); err != nil {
+				return fmt.Errorf("%s: %w", where, err)
+			}
+			s.rewrites[where] = expr
+		}
+	}
+	return s.checkCycles()
+}
+
+// checkRewrite verifies the relations a rewrite refers to
+func (s *Schema) checkRewrite(ns, name string, r Relation, expr *rewrite) error {
+	usesThis := false
+	var walk func(e *rewrite) error
+	walk = func(e *rewrite) error {
+		switch e.op {
+		case opThis:
+			usesThis = true
+		case opComputed:
+			if !s.defines(ns, e.rel) {
+				return fmt.Errorf("rewrite refers to undefined relation %q", e.rel)
+			}
+		case opArrow:
+			ts, ok := s.Namespaces[ns].Relations[e.rel]
+			if !ok {
+				return fmt.Errorf("rewrite refers to undefined relation %q", e.rel)
+			}
+			if len(ts.Types) == 0 {
+				return fmt.Errorf("%s->%s: %q has no subject types to follow", e.rel, e.via, e.rel)
+			}
+			found := false
+			for _, t := range ts.Types {
+				t, _, _ := strings.Cut(t, " with ")
+				tns, _, _ := strings.Cut(t, "#")
+				if s.defines(tns, e.via) {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("%s->%s: no subject type of %q defines %q", e.rel, e.via, e.rel, e.via)
+			}
+		}
+		for _, a := range e.args {
+			if err := walk(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(expr); err != nil {
+		return err
+	}
+	if usesThis && len(r.Types) == 0 {
+		return fmt.Errorf("relation has no subject types")
+	}
+	if !usesThis && len(r.Types) > 0 {
+		return fmt.Errorf("types are unreachable: the rewrite must include %q to use its own tuples", name)
+	}
+	return nil
+}
+
+// checkCycles rejects relations that derive from themselves without going through tuples
+func (s *Schema) checkCycles() error {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[string]int{}
+	var visit func(key string, path []string) error
+	visit = func(key string, path []string) error {
+		switch state[key] {
+		case visiting:
+			return fmt.Errorf("rewrite cycle: %s", strings.Join(append(path, key), " -> "))
+		case done:
+			return nil
+		}
+		state[key] = visiting
+		ns, _, _ := strings.Cut(key, "#")
+		for _, ref := range computedRefs(s.rewrites[key]) {
+			if err := visit(ns+"#"+ref, append(path, key)); err != nil {
+				return err
+			}
+		}
+		state[key] = done
+		return nil
+	}
+	for _, key := range sortedKeys(s.rewrites) {
+		if err := visit(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func computedRefs(e *rewrite) []string {
+	if e == nil {
+		return nil
+	}
+	var out []string
+	if e.op == opComputed {
+		out = append(out, e.rel)
+	}
+	for _, a := range e.args {
+		out = append(out, computedRefs(a)...)
+	}
+	return out
+}
+
+// defines reports whether the schema has namespace ns (relation "") or relation ns#rel
+func (s *Schema) defines(ns, rel string) bool {
+	n, ok := s.Namespaces[ns]
+	if !ok || rel == "" {
+		return ok
+	}
+	_, ok = n.Relations[rel]
+	return ok
+}
+
+// resolves reports whether a check of ns#rel can be evaluated
+func (s *Schema) resolves(ns, rel string) bool { return s.open || s.defines(ns, rel) }
+
+// rewriteFor returns the rewrite evaluated for ns#rel
+func (s *Schema) rewriteFor(ns, rel string) (*rewrite, error) {
+	if e, ok := s.rewrites[ns+"#"+rel]; ok {
+		return e, nil
+	}
+	if s.open {
+		return legacyRewrite(rel), nil
+	}
+	return nil, fmt.Errorf("%w: %s#%s", ErrUnknownRelation, ns, rel)
+}
+
+// legacyRewrite is the default schema's rule for any relation: its own tuples, the relation it is implied
+// by (owner > editor > viewer), and the members and delegates of every subject it names
+func legacyRewrite(rel string) *rewrite {
+	args := []*rewrite{
+		{op: opThis},
+		{op: opArrow, rel: rel, via: "member"},
+		{op: opArrow, rel: rel, via: "can_act_for"},
+	}
+	switch rel {
+	case "editor":
+		args = append(args, &rewrite{op: opComputed, rel: "owner"})
+	case "viewer":
+		args = append(args, &rewrite{op: opComputed, rel: "editor"})
+	}
+	return &rewrite{op: opUnion, args: args}
+}
+
+// ValidateTuple checks a tuple write against the schema. The default schema only requires every field.
+func (s *Schema) ValidateTuple(t Tuple) error {
+	if t.ObjectType == "" || t.ObjectID == "" || t.Relation == "" || t.SubjectType == "" || t.SubjectID == "" {
+		return fmt.Errorf("%w: object_type, object_id, relation, subject_type and subject_id are required", ErrInvalidTuple)
+	}
+	if t.Caveat != nil && t.Caveat.Name == "" {
+		return fmt.Errorf("%w: caveat name is required", ErrInvalidTuple)
+	}
+	if s.open {
+		if t.Caveat != nil {
+			return fmt.Errorf("%w: caveats need an org schema that defines them", ErrInvalidTuple)
+		}
+		return nil
+	}
+	where := t.ObjectType + "#" + t.Relation
+	r, ok := s.Namespaces[t.ObjectType].Relations[t.Relation]
+	if !ok {
+		return fmt.Errorf("%w: %s is not defined", ErrInvalidTuple, where)
+	}
+	subject := t.SubjectType
+	if t.SubjectRelation != "" {
+		subject += "#" + t.SubjectRelation
+	}
+	if t.Caveat != nil {
+		subject += " with " + t.Caveat.Name
+	}
+	for _, allowed := range r.Types {
+		if allowed == subject {
+			return nil
+		}
+	}
+	if len(r.Types) == 0 {
+		return fmt.Errorf("%w: %s is computed and cannot be written", ErrInvalidTuple, where)
+	}
+	return fmt.Errorf("%w: %s does not allow subjects of type %s (allowed: %s)", ErrInvalidTuple, where, subject, strings.Join(r.Types, ", "))
+}
+
+// parseRewrite parses a rewrite expression. Union binds loosest, then intersection, then exclusion;
+// parentheses group. self is the relation being defined, whose name stands for its own tuples.
+func parseRewrite(src, self string) (*rewrite, error) {
+	p := &rewriteParser{src: src, self: self}
+	p.next()
+	e, err := p.union()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("rewrite %q: unexpected %q", src, p.tok)
+	}
+	return e, nil
+}
+
+type rewriteParser struct {
+	src, self string
+	pos       int
+	tok       string
+}
+
+func (p *rewriteParser) next() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t' || p.src[p.pos] == '\n') {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = ""
+		return
+	}
+	start := p.pos
+	c := p.src[p.pos]
+	switch {
+	case c == '-' && strings.HasPrefix(p.src[p.pos:], "->"):
+		p.pos += 2
+	case strings.IndexByte("+&-()", c) >= 0:
+		p.pos++
+	default:
+		for p.pos < len(p.src) {
+			c := p.src[p.pos]
+			if c != '_' && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+				break
+			}
+			p.pos++
+		}
+		if p.pos == start {
+			p.pos++
+		}
+	}
+	p.tok = p.src[start:p.pos]
+}
+
+func (p *rewriteParser) binary(op, sym string, operand func() (*rewrite, error)) (*rewrite, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == sym {
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if left.op == op && op != opExclusion {
+			left.args = append(left.args, right)
+		} else {
+			left = &rewrite{op: op, args: []*rewrite{left, right}}
+		}
+	}
+	return left, nil
+}
+
+func (p *rewriteParser) union() (*rewrite, error) { return p.binary(opUnion, "+", p.intersection) }
+
+func (p *rewriteParser) intersection() (*rewrite, error) {
+	return p.binary(opIntersection, "&", p.exclusion)
+}
+
+func (p *rewriteParser) exclusion() (*rewrite, error) { return p.binary(opExclusion, "-", p.term) }
+
+func (p *rewriteParser) term() (*rewrite, error) {
+	if p.tok == "(" {
+		p.next()
+		e, err := p.union()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("rewrite %q: missing )", p.src)
+		}
+		p.next()
+		return e, nil
+	}
+	name := p.tok
+	if !schemaName.MatchString(name) {
+		if name == "" {
+			return nil, fmt.Errorf("rewrite %q: unexpected end", p.src)
+		}
+		return nil, fmt.Errorf("rewrite %q: unexpected %q", p.src, name)
+	}
+	p.next()
+	if p.tok == "->" {
+		p.next()
+		via := p.tok
+		if !schemaName.MatchString(via) {
+			return nil, fmt.Errorf("rewrite %q: %s-> must be followed by a relation", p.src, name)
+		}
+		p.next()
+		return &rewrite{op: opArrow, rel: name, via: via}, nil
+	}
+	if name == p.self {
+		return &rewrite{op: opThis}, nil
+	}
+	return &rewrite{op: opComputed, rel: name}, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
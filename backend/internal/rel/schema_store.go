@@ -0,0 +1,128 @@
+package rel
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is one stored revision of an org's schema; the highest version is in force
+type SchemaVersion struct {
+	OrgID           uuid.UUID       `db:"org_id" json:"org_id"`
+	Version         int             `db:"version" json:"version"`
+	Definition      json.RawMessage `db:"definition" json:"definition"`
+	CreatedByUserID *uuid.UUID      `db:"created_by_user_id" json:"created_by_user_id,omitempty"`
+	CreatedAt       time.Time       `db:"created_at" json:"created_at"`
+}
+
+const schemaVersionCols = `org_id, version, definition, created_by_user_id, created_at`
+
+// SaveSchema stores def as the org's next schema version. Callers validate it with ParseSchema first.
+func SaveSchema(ctx context.Context, orgID uuid.UUID, def json.RawMessage, createdBy *uuid.UUID) (SchemaVersion, error) {
+	var sv SchemaVersion
+	err := databasepkg.DB.QueryRowxContext(ctx, `INSERT INTO trust_graph_schemas (org_id, version, definition, created_by_user_id)
+		SELECT $1, COALESCE(MAX(version),0)+1, $2, $3 FROM trust_graph_schemas WHERE org_id=$1
+		RETURNING `+schemaVersionCols, orgID, def, createdBy).StructScan(&sv)
+	if err == nil {
+		ForgetSchemas()
+	}
+	return sv, err
+}
+
+// GetSchemaVersion returns one version (0 for the latest), or nil when there is none
+func GetSchemaVersion(ctx context.Context, orgID uuid.UUID, version int) (*SchemaVersion, error) {
+	var sv SchemaVersion
+	var err error
+	if version == 0 {
+		err = databasepkg.DB.GetContext(ctx, &sv, `SELECT `+schemaVersionCols+` FROM trust_graph_schemas WHERE org_id=$1 ORDER BY version DESC LIMIT 1`, orgID)
+	} else {
+		err = databasepkg.DB.GetContext(ctx, &sv, `SELECT `+schemaVersionCols+` FROM trust_graph_schemas WHERE org_id=$1 AND version=$2`, orgID, version)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sv, nil
+}
+
+// ListSchemaVersions returns the org's schema history, newest first
+func ListSchemaVersions(ctx context.Context, orgID uuid.UUID) ([]SchemaVersion, error) {
+	out := []SchemaVersion{}
+	err := databasepkg.DB.SelectContext(ctx, &out, `SELECT `+schemaVersionCols+` FROM trust_graph_schemas WHERE org_id=$1 ORDER BY version DESC`, orgID)
+	return out, err
+}
+
+var schemaCache = struct {
+	sync.RWMutex
+	m map[string]*Schema
+}{m: map[string]*Schema{}}
+
+// SchemaFor returns the schema in force for an org, or the default schema when it has none
+func SchemaFor(ctx context.Context, orgID string) (*Schema, error) {
+	id, err := uuid.Parse(orgID)
+	if err != nil {
+		return DefaultSchema(), nil
+	}
+	schemaCache.RLock()
+	s, ok := schemaCache.m[orgID]
+	schemaCache.RUnlock()
+	if ok {
+		return s, nil
+	}
+	sv, err := GetSchemaVersion(ctx, id, 0)
+	if err != nil {
+		return nil, err
+	}
+	s = DefaultSchema()
+	if sv != nil {
+		if s, err = ParseSchema(sv.Definition); err != nil {
+			return nil, err
+		}
+	}
+	schemaCache.Lock()
+	schemaCache.m[orgID] = s
+	schemaCache.Unlock()
+	return s, nil
+}
+
+// ForgetSchemas drops cached schemas so the next check reloads them
+func ForgetSchemas() {
+	schemaCache.Lock()
+	schemaCache.m = map[string]*Schema{}
+	schemaCache.Unlock()
+}
+
+// ValidateTuples checks tuple writes against the schema of the org in ctx
+func ValidateTuples(ctx context.Context, tuples []Tuple) error {
+	s, err := SchemaFor(ctx, OrgFrom(ctx))
+	if err != nil {
+		return err
+	}
+	for _, t := range tuples {
+		if err := s.ValidateTuple(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type orgKey struct{}
+
+// WithOrg scopes graph reads and writes on ctx to an org's schema
+func WithOrg(ctx context.Context, orgID string) context.Context {
+	return context.WithValue(ctx, orgKey{}, orgID)
+}
+
+// OrgFrom returns the org set by WithOrg, or ""
+func OrgFrom(ctx context.Context) string {
+	s, _ := ctx.Value(orgKey{}).(string)
+	return s
+}
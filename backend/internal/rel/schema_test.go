@@ -0,0 +1,147 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const docSchema = `{"namespaces": {
+	"user": {},
+	"team": {"relations": {"member": {"types": ["user", "team#member"]}}},
+	"folder": {"relations": {"viewer": {"types": ["user", "team#member"]}}},
+	"doc": {"relations": {
+		"parent": {"types": ["folder"]},
+		"owner": {"types": ["user"]},
+		"banned": {"types": ["user"]},
+		"editor": {"types": ["user", "team#member"], "rewrite": "editor + owner"},
+		"viewer": {"types": ["user", "team#member"], "rewrite": "viewer + editor + parent->viewer"},
+		"can_share": {"rewrite": "(editor & parent->viewer) - banned"}
+	}}
+}}`
+
+func TestSchemaRewrites(t *testing.T) {
+	s, err := ParseSchema([]byte(docSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	read := memTuples(
+		Tuple{ObjectType: "team", ObjectID: "core", Relation: "member", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "team", ObjectID: "all", Relation: "member", SubjectType: "team", SubjectID: "core", SubjectRelation: "member"},
+		Tuple{ObjectType: "folder", ObjectID: "f1", Relation: "viewer", SubjectType: "team", SubjectID: "all", SubjectRelation: "member"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "parent", SubjectType: "folder", SubjectID: "f1"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "owner", SubjectType: "user", SubjectID: "alice"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "banned", SubjectType: "user", SubjectID: "bob"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "team", SubjectID: "core", SubjectRelation: "member"},
+		Tuple{ObjectType: "team", ObjectID: "core", Relation: "member", SubjectType: "user", SubjectID: "carol"},
+		Tuple{ObjectType: "team", ObjectID: "all", Relation: "member", SubjectType: "user", SubjectID: "erin"},
+	)
+	d1 := RelationRef{"doc", "d1"}
+	cases := []struct {
+		user, relation string
+		want           bool
+	}{
+		{"alice", "editor", true}, // owner
+		{"alice", "viewer", true}, // owner via editor
+		{"bob", "viewer", true},   // direct editor
+		{"erin", "viewer", true},  // parent->viewer through team:all
+		{"erin", "editor", false},
+		{"carol", "viewer", true},    // editor through team#member
+		{"carol", "can_share", true}, // editor and, through core in all, a folder viewer
+		{"bob", "can_share", false},  // banned
+		{"dave", "viewer", false},
+	}
+	for _, tc := range cases {
+		if got := checkWith(t, s, read, RelationRef{"user", tc.user}, tc.relation, d1); got != tc.want {
+			t.Errorf("%s %s: got %v, want %v", tc.user, tc.relation, got, tc.want)
+		}
+	}
+	// a relation-only tuple for team:core is not a member of anything: only the userset counts
+	if checkWith(t, s, read, RelationRef{"team", "core"}, "editor", d1) {
+		t.Error("team:core itself is not an editor, only its members are")
+	}
+	if _, err := newChecker(s, read).check(context.Background(), d1, "admin", RelationRef{"user", "alice"}, 0); !errors.Is(err, ErrUnknownRelation) {
+		t.Errorf("expected ErrUnknownRelation, got %v", err)
+	}
+}
+
+func TestSchemaValidateTuple(t *testing.T) {
+	s, err := ParseSchema([]byte(docSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	ok := []Tuple{
+		{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "team", SubjectID: "t", SubjectRelation: "member"},
+		{ObjectType: "doc", ObjectID: "d1", Relation: "parent", SubjectType: "folder", SubjectID: "f"},
+	}
+	for _, tu := range ok {
+		if err := s.ValidateTuple(tu); err != nil {
+			t.Errorf("%+v: %v", tu, err)
+		}
+	}
+	bad := []Tuple{
+		{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "team", SubjectID: "t"},
+		{ObjectType: "doc", ObjectID: "d1", Relation: "can_share", SubjectType: "user", SubjectID: "u"},
+		{ObjectType: "doc", ObjectID: "d1", Relation: "admin", SubjectType: "user", SubjectID: "u"},
+		{ObjectType: "repo", ObjectID: "r", Relation: "owner", SubjectType: "user", SubjectID: "u"},
+		{ObjectType: "doc", ObjectID: "", Relation: "owner", SubjectType: "user", SubjectID: "u"},
+	}
+	for _, tu := range bad {
+		if err := s.ValidateTuple(tu); !errors.Is(err, ErrInvalidTuple) {
+			t.Errorf("%+v: expected ErrInvalidTuple, got %v", tu, err)
+		}
+	}
+	if err := DefaultSchema().ValidateTuple(Tuple{ObjectType: "x", ObjectID: "1", Relation: "anything", SubjectType: "y", SubjectID: "2"}); err != nil {
+		t.Errorf("default schema accepts any complete tuple: %v", err)
+	}
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	cases := map[string]string{
+		`{"namespaces": {}}`: "no namespaces",
+		`{"namespaces": {"doc": {"relations": {"viewer": {"types": ["user"]}}}}}`:                                                               "not a defined namespace",
+		`{"namespaces": {"user": {}, "doc": {"relations": {"viewer": {"types": ["user#member"]}}}}}`:                                            "undefined relation",
+		`{"namespaces": {"doc": {"relations": {"viewer": {"rewrite": "editor"}}}}}`:                                                             "undefined relation",
+		`{"namespaces": {"doc": {"relations": {"a": {"rewrite": "b"}, "b": {"rewrite": "a"}}}}}`:                                                "cycle",
+		`{"namespaces": {"user": {}, "doc": {"relations": {"viewer": {"types": ["user"], "rewrite": "owner"}, "owner": {"types": ["user"]}}}}}`: "unreachable",
+		`{"namespaces": {"user": {}, "doc": {"relations": {"p": {"types": ["user"]}, "v": {"rewrite": "p->viewer"}}}}}`:                         "no subject type",
+		`{"namespaces": {"user": {}, "doc": {"relations": {"v": {"types": ["user"], "rewrite": "v + "}}}}}`:                                     "unexpected end",
+		`{"namespaces": {"Doc": {}}}`:               "names must match",
+		`{"namespaces": {"doc": {"relation": {}}}}`: "unknown field",
+	}
+	for src, want := range cases {
+		_, err := ParseSchema([]byte(src))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", src, want, err)
+		}
+	}
+}
+
+func TestExpandSchema(t *testing.T) {
+	s, err := ParseSchema([]byte(docSchema))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	read := memTuples(
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "owner", SubjectType: "user", SubjectID: "alice"},
+		Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "editor", SubjectType: "team", SubjectID: "core", SubjectRelation: "member"},
+		Tuple{ObjectType: "team", ObjectID: "core", Relation: "member", SubjectType: "user", SubjectID: "bob"},
+	)
+	exp, err := newChecker(s, read).expand(context.Background(), "editor", RelationRef{"doc", "d1"}, 3)
+	if err != nil {
+		t.Fatalf("expand: %v", err)
+	}
+	// editor = team:core#member (expanded to bob) + owner (expanded to alice)
+	if len(exp.Children) != 2 {
+		t.Fatalf("expected 2 children, got %+v", exp)
+	}
+	team, owner := exp.Children[0], exp.Children[1]
+	if team.Relation != "member" || len(team.Children) != 1 || team.Children[0].Object.ObjectID != "bob" {
+		t.Errorf("unexpected userset node %+v", team)
+	}
+	if owner.Relation != "owner" || len(owner.Children) != 1 || owner.Children[0].Object.ObjectID != "alice" {
+		t.Errorf("unexpected computed node %+v", owner)
+	}
+}
@@ -4,78 +4,423 @@ package rel
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
 
 	authzedv1 "github.com/authzed/authzed-go/proto/authzed/api/v1"
 	authzed "github.com/authzed/authzed-go/v1"
+	"github.com/authzed/grpcutil"
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
-// SpiceDBGraph implements GraphClient using authzed-go. Build with -tags spicedb
+// SpiceDBGraph implements GraphClient using authzed-go. Build with -tags spicedb. SpiceDB holds the
+// relationships of every org, so calls touching objects bound to another org are refused (see tenancy.go).
 type SpiceDBGraph struct {
-	client authzed.Client
+	client *authzed.Client
 }
 
 func NewSpiceDBGraph(endpoint, token string) (*SpiceDBGraph, error) {
-	conn, err := grpc.Dial(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	c, err := authzed.NewClient(endpoint, grpc.WithTransportCredentials(insecure.NewCredentials()), grpcutil.WithInsecureBearerToken(token))
 	if err != nil {
 		return nil, err
 	}
-	c := authzed.NewClientWithConn(conn, authzed.WithToken(token))
 	return &SpiceDBGraph{client: c}, nil
 }
 
 func (s *SpiceDBGraph) withToken(ctx context.Context) context.Context { return ctx }
 
+// guard fails unless ctx names an org and object is not bound to another one
+func guard(ctx context.Context, object RelationRef) error {
+	org, err := orgOf(ctx)
+	if err != nil {
+		return err
+	}
+	return orgScope.guard(ctx, org, object)
+}
+
+// guardFilter guards the object a filter selects; filters must name the object in bound namespaces
+func guardFilter(ctx context.Context, f TupleFilter) error {
+	if f.ObjectID == "" && boundNamespaces[f.ObjectType] {
+		return fmt.Errorf("%w: filters on %s need an object_id", ErrCrossOrg, f.ObjectType)
+	}
+	return guard(ctx, RelationRef{Namespace: f.ObjectType, ObjectID: f.ObjectID})
+}
+
+// relationship maps a tuple, including its caveat, onto a SpiceDB relationship
+func relationship(t Tuple) (*authzedv1.Relationship, error) {
+	r := &authzedv1.Relationship{
+		Resource: &authzedv1.ObjectReference{ObjectType: t.ObjectType, ObjectId: t.ObjectID},
+		Relation: t.Relation,
+		Subject:  &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: t.SubjectType, ObjectId: t.SubjectID}, OptionalRelation: t.SubjectRelation},
+	}
+	if t.Caveat != nil {
+		cctx, err := structpb.NewStruct(t.Caveat.Context)
+		if err != nil {
+			return nil, err
+		}
+		r.OptionalCaveat = &authzedv1.ContextualizedCaveat{CaveatName: t.Caveat.Name, Context: cctx}
+	}
+	return r, nil
+}
+
 func (s *SpiceDBGraph) Upsert(ctx context.Context, t Tuple) error {
-	_, err := s.client.WriteRelationships(ctx, &authzedv1.WriteRelationshipsRequest{
-		Updates: []*authzedv1.RelationshipUpdate{{
-			Operation: authzedv1.RelationshipUpdate_OPERATION_CREATE,
-			Relationship: &authzedv1.Relationship{
-				Resource: &authzedv1.ObjectReference{ObjectType: t.ObjectType, ObjectId: t.ObjectID},
-				Relation: t.Relation,
-				Subject:  &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: t.SubjectType, ObjectId: t.SubjectID}},
-			},
-		}},
-	})
-	return err
+	return s.UpsertBatch(ctx, []Tuple{t})
 }
 
 func (s *SpiceDBGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error {
-	ups := make([]*authzedv1.RelationshipUpdate, 0, len(tuples))
-	for _, t := range tuples {
-		ups = append(ups, &authzedv1.RelationshipUpdate{
-			Operation: authzedv1.RelationshipUpdate_OPERATION_CREATE,
-			Relationship: &authzedv1.Relationship{
-				Resource: &authzedv1.ObjectReference{ObjectType: t.ObjectType, ObjectId: t.ObjectID},
-				Relation: t.Relation,
-				Subject:  &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: t.SubjectType, ObjectId: t.SubjectID}},
-			},
-		})
-	}
-	_, err := s.client.WriteRelationships(ctx, &authzedv1.WriteRelationshipsRequest{Updates: ups})
+	_, err := s.WriteRelationships(ctx, Touches(tuples), nil)
 	return err
 }
 
-func (s *SpiceDBGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error) {
+var spiceOps = map[UpdateOp]authzedv1.RelationshipUpdate_Operation{
+	OpTouch:  authzedv1.RelationshipUpdate_OPERATION_TOUCH,
+	OpCreate: authzedv1.RelationshipUpdate_OPERATION_CREATE,
+	OpDelete: authzedv1.RelationshipUpdate_OPERATION_DELETE,
+}
+
+func relationshipFilter(f TupleFilter) (*authzedv1.RelationshipFilter, error) {
+	if f.ObjectType == "" {
+		return nil, fmt.Errorf("%w: SpiceDB filters require object_type", ErrInvalidTuple)
+	}
+	rf := &authzedv1.RelationshipFilter{ResourceType: f.ObjectType, OptionalResourceId: f.ObjectID, OptionalRelation: f.Relation}
+	if f.SubjectType != "" {
+		rf.OptionalSubjectFilter = &authzedv1.SubjectFilter{SubjectType: f.SubjectType, OptionalSubjectId: f.SubjectID}
+		if f.SubjectRelation != "" {
+			rf.OptionalSubjectFilter.OptionalRelation = &authzedv1.SubjectFilter_RelationFilter{Relation: f.SubjectRelation}
+		}
+	}
+	return rf, nil
+}
+
+func spicePreconditions(ctx context.Context, preconditions []Precondition) ([]*authzedv1.Precondition, error) {
+	if err := checkPreconditions(preconditions); err != nil {
+		return nil, err
+	}
+	out := make([]*authzedv1.Precondition, 0, len(preconditions))
+	for _, p := range preconditions {
+		if err := guardFilter(ctx, p.Filter); err != nil {
+			return nil, err
+		}
+		rf, err := relationshipFilter(p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		op := authzedv1.Precondition_OPERATION_MUST_MATCH
+		if p.Operation == PreconditionMustNotMatch {
+			op = authzedv1.Precondition_OPERATION_MUST_NOT_MATCH
+		}
+		out = append(out, &authzedv1.Precondition{Operation: op, Filter: rf})
+	}
+	return out, nil
+}
+
+// spiceErr maps SpiceDB's failed preconditions and existing relationships onto the package errors
+func spiceErr(err error) error {
+	switch status.Code(err) {
+	case grpccodes.FailedPrecondition:
+		return fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	case grpccodes.AlreadyExists:
+		return fmt.Errorf("%w: %v", ErrTupleExists, err)
+	}
+	return err
+}
+
+// WriteRelationships applies updates in SpiceDB and returns its ZedToken as the revision token
+func (s *SpiceDBGraph) WriteRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (string, error) {
+	if err := validateUpdates(ctx, updates); err != nil {
+		return "", err
+	}
+	pre, err := spicePreconditions(ctx, preconditions)
+	if err != nil {
+		return "", err
+	}
+	ups := make([]*authzedv1.RelationshipUpdate, 0, len(updates))
+	for _, u := range updates {
+		if u.Operation == OpDelete {
+			if err := guard(ctx, RelationRef{Namespace: u.Tuple.ObjectType, ObjectID: u.Tuple.ObjectID}); err != nil {
+				return "", err
+			}
+		}
+		r, err := relationship(u.Tuple)
+		if err != nil {
+			return "", err
+		}
+		ups = append(ups, &authzedv1.RelationshipUpdate{Operation: spiceOps[u.Operation], Relationship: r})
+	}
+	resp, err := s.client.WriteRelationships(ctx, &authzedv1.WriteRelationshipsRequest{Updates: ups, OptionalPreconditions: pre})
+	if err != nil {
+		return "", spiceErr(err)
+	}
+	return encodeToken(tokenSpiceDB, resp.GetWrittenAt().GetToken()), nil
+}
+
+// Delete removes the relationships matching filter, which must name an object type, and an object
+// when the type is bound to orgs
+func (s *SpiceDBGraph) Delete(ctx context.Context, filter TupleFilter, preconditions []Precondition) (string, error) {
+	rf, err := relationshipFilter(filter)
+	if err != nil {
+		return "", err
+	}
+	if err := guardFilter(ctx, filter); err != nil {
+		return "", err
+	}
+	pre, err := spicePreconditions(ctx, preconditions)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.client.DeleteRelationships(ctx, &authzedv1.DeleteRelationshipsRequest{RelationshipFilter: rf, OptionalPreconditions: pre})
+	if err != nil {
+		return "", spiceErr(err)
+	}
+	return encodeToken(tokenSpiceDB, resp.GetDeletedAt().GetToken()), nil
+}
+
+// consistency maps the requirement in ctx onto SpiceDB's; tokens of the local backend are rejected
+func consistency(ctx context.Context) (*authzedv1.Consistency, error) {
+	c := ConsistencyFrom(ctx)
+	switch {
+	case c.FullyConsistent:
+		return &authzedv1.Consistency{Requirement: &authzedv1.Consistency_FullyConsistent{FullyConsistent: true}}, nil
+	case c.AtLeastAsFresh != "":
+		backend, zed, err := decodeToken(c.AtLeastAsFresh)
+		if err != nil {
+			return nil, err
+		}
+		if backend != tokenSpiceDB {
+			return nil, fmt.Errorf("%w: issued by %s", ErrInvalidToken, backend)
+		}
+		return &authzedv1.Consistency{Requirement: &authzedv1.Consistency_AtLeastAsFresh{AtLeastAsFresh: &authzedv1.ZedToken{Token: zed}}}, nil
+	}
+	return &authzedv1.Consistency{Requirement: &authzedv1.Consistency_MinimizeLatency{MinimizeLatency: true}}, nil
+}
+
+func (s *SpiceDBGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef, caveatCtx map[string]any) (CheckResult, error) {
+	// SpiceDB does not say whether a caveat was evaluated, so a check with context counts as caveated
+	res := CheckResult{Permissionship: PermissionDenied, Caveated: len(caveatCtx) > 0, Source: "spicedb"}
+	if err := guard(ctx, object); err != nil {
+		return res, err
+	}
+	cctx, err := structpb.NewStruct(checkContext(caveatCtx))
+	if err != nil {
+		return res, err
+	}
+	cons, err := consistency(ctx)
+	if err != nil {
+		return res, err
+	}
 	resp, err := s.client.CheckPermission(ctx, &authzedv1.CheckPermissionRequest{
-		Resource:   &authzedv1.ObjectReference{ObjectType: object.Namespace, ObjectId: object.ObjectID},
-		Permission: relation,
-		Subject:    &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: subject.Namespace, ObjectId: subject.ObjectID}},
+		Consistency: cons,
+		Resource:    &authzedv1.ObjectReference{ObjectType: object.Namespace, ObjectId: object.ObjectID},
+		Permission:  relation,
+		Subject:     &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: subject.Namespace, ObjectId: subject.ObjectID}},
+		Context:     cctx,
+		WithTracing: ExplainFrom(ctx),
 	})
 	if err != nil {
-		return false, "spicedb", err
+		return res, err
 	}
-	return resp.GetPermissionship() == authzedv1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION, "spicedb", nil
+	if ExplainFrom(ctx) {
+		trace, err := protojson.Marshal(resp.GetDebugTrace().GetCheck())
+		if err != nil {
+			return res, err
+		}
+		res.Explanation = &Explanation{Trace: trace}
+	}
+	switch resp.GetPermissionship() {
+	case authzedv1.CheckPermissionResponse_PERMISSIONSHIP_HAS_PERMISSION:
+		res.Permissionship = PermissionAllowed
+	case authzedv1.CheckPermissionResponse_PERMISSIONSHIP_CONDITIONAL_PERMISSION:
+		res.Permissionship = PermissionConditional
+		res.MissingContext = resp.GetPartialCaveatInfo().GetMissingRequiredContext()
+		res.Caveated = true
+	}
+	return res, nil
 }
 
+// Expand maps SpiceDB's expand tree, expanding userset leaves again until depth is used up
 func (s *SpiceDBGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
-	// Simplified: we don't transform full expand tree; return root
-	_, _ = s.client.ExpandPermissionTree(ctx, &authzedv1.ExpandPermissionTreeRequest{
-		Resource:   &authzedv1.ObjectReference{ObjectType: object.Namespace, ObjectId: object.ObjectID},
-		Permission: relation,
+	root := GraphExpansion{Relation: relation, Object: object}
+	if err := guard(ctx, object); err != nil {
+		return GraphExpansion{}, err
+	}
+	if depth = min(depth, maxCheckDepth); depth <= 0 {
+		return root, nil
+	}
+	cons, err := consistency(ctx)
+	if err != nil {
+		return GraphExpansion{}, err
+	}
+	resp, err := s.client.ExpandPermissionTree(ctx, &authzedv1.ExpandPermissionTreeRequest{
+		Consistency: cons,
+		Resource:    &authzedv1.ObjectReference{ObjectType: object.Namespace, ObjectId: object.ObjectID},
+		Permission:  relation,
 	})
-	return GraphExpansion{Relation: relation, Object: object}, nil
+	if err != nil {
+		return GraphExpansion{}, err
+	}
+	return s.expandTree(ctx, resp.GetTreeRoot(), depth)
+}
+
+func (s *SpiceDBGraph) expandTree(ctx context.Context, t *authzedv1.PermissionRelationshipTree, depth int) (GraphExpansion, error) {
+	exp := GraphExpansion{Relation: t.GetExpandedRelation(), Object: RelationRef{Namespace: t.GetExpandedObject().GetObjectType(), ObjectID: t.GetExpandedObject().GetObjectId()}}
+	switch n := t.GetTreeType().(type) {
+	case *authzedv1.PermissionRelationshipTree_Intermediate:
+		switch n.Intermediate.GetOperation() {
+		case authzedv1.AlgebraicSubjectSet_OPERATION_INTERSECTION:
+			exp.Operation = opIntersection
+		case authzedv1.AlgebraicSubjectSet_OPERATION_EXCLUSION:
+			exp.Operation = opExclusion
+		}
+		for _, c := range n.Intermediate.GetChildren() {
+			child, err := s.expandTree(ctx, c, depth)
+			if err != nil {
+				return GraphExpansion{}, err
+			}
+			exp.Children = append(exp.Children, child)
+		}
+	case *authzedv1.PermissionRelationshipTree_Leaf:
+		for _, sub := range n.Leaf.GetSubjects() {
+			obj := RelationRef{Namespace: sub.GetObject().GetObjectType(), ObjectID: sub.GetObject().GetObjectId()}
+			if sub.GetOptionalRelation() == "" {
+				exp.Children = append(exp.Children, GraphExpansion{Relation: "subject", Object: obj})
+				continue
+			}
+			child, err := s.Expand(ctx, sub.GetOptionalRelation(), obj, depth-1)
+			if err != nil {
+				return GraphExpansion{}, err
+			}
+			exp.Children = append(exp.Children, child)
+		}
+	}
+	return exp, nil
+}
+
+func lookupItem(id string, perm authzedv1.LookupPermissionship, partial *authzedv1.PartialCaveatInfo) LookupItem {
+	it := LookupItem{ObjectID: id, Permissionship: PermissionAllowed}
+	if perm == authzedv1.LookupPermissionship_LOOKUP_PERMISSIONSHIP_CONDITIONAL_PERMISSION {
+		it.Permissionship = PermissionConditional
+		it.MissingContext = partial.GetMissingRequiredContext()
+	}
+	return it
+}
+
+// LookupResources pages through SpiceDB's lookup; the cursor is SpiceDB's. Resources bound to another
+// org are left out, so a page may hold fewer results than its limit.
+func (s *SpiceDBGraph) LookupResources(ctx context.Context, subject RelationRef, relation, resourceType string, page Page) (LookupResult, error) {
+	org, err := orgOf(ctx)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	cons, err := consistency(ctx)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	cctx, err := structpb.NewStruct(checkContext(nil))
+	if err != nil {
+		return LookupResult{}, err
+	}
+	req := &authzedv1.LookupResourcesRequest{
+		Consistency:        cons,
+		ResourceObjectType: resourceType,
+		Permission:         relation,
+		Subject:            &authzedv1.SubjectReference{Object: &authzedv1.ObjectReference{ObjectType: subject.Namespace, ObjectId: subject.ObjectID}},
+		Context:            cctx,
+		OptionalLimit:      uint32(page.limit()),
+	}
+	if page.Cursor != "" {
+		req.OptionalCursor = &authzedv1.Cursor{Token: page.Cursor}
+	}
+	stream, err := s.client.LookupResources(ctx, req)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	res := LookupResult{Results: []LookupItem{}}
+	n := 0
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return LookupResult{}, err
+		}
+		n++
+		res.NextCursor = r.GetAfterResultCursor().GetToken()
+		if err := orgScope.guard(ctx, org, RelationRef{Namespace: resourceType, ObjectID: r.GetResourceObjectId()}); errors.Is(err, ErrCrossOrg) {
+			continue
+		} else if err != nil {
+			return LookupResult{}, err
+		}
+		res.Results = append(res.Results, lookupItem(r.GetResourceObjectId(), r.GetPermissionship(), r.GetPartialCaveatInfo()))
+	}
+	if n < page.limit() {
+		res.NextCursor = ""
+	}
+	return res, nil
+}
+
+// LookupSubjects reads every subject from SpiceDB and pages through them by object id, as SpiceDB does
+// not limit subject lookups
+func (s *SpiceDBGraph) LookupSubjects(ctx context.Context, resource RelationRef, relation, subjectType string, page Page) (LookupResult, error) {
+	if err := guard(ctx, resource); err != nil {
+		return LookupResult{}, err
+	}
+	cons, err := consistency(ctx)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	cctx, err := structpb.NewStruct(checkContext(nil))
+	if err != nil {
+		return LookupResult{}, err
+	}
+	after := ""
+	if page.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil {
+			return LookupResult{}, ErrInvalidToken
+		}
+		after = string(b)
+	}
+	stream, err := s.client.LookupSubjects(ctx, &authzedv1.LookupSubjectsRequest{
+		Consistency:       cons,
+		Resource:          &authzedv1.ObjectReference{ObjectType: resource.Namespace, ObjectId: resource.ObjectID},
+		Permission:        relation,
+		SubjectObjectType: subjectType,
+		Context:           cctx,
+	})
+	if err != nil {
+		return LookupResult{}, err
+	}
+	var all []LookupItem
+	for {
+		r, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return LookupResult{}, err
+		}
+		sub := r.GetSubject()
+		if sub.GetSubjectObjectId() > after {
+			all = append(all, lookupItem(sub.GetSubjectObjectId(), sub.GetPermissionship(), sub.GetPartialCaveatInfo()))
+		}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].ObjectID < all[j].ObjectID })
+	res := LookupResult{Results: []LookupItem{}}
+	if len(all) > page.limit() {
+		all = all[:page.limit()]
+		res.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(all[len(all)-1].ObjectID))
+	}
+	res.Results = append(res.Results, all...)
+	return res, nil
 }
 
 var _ GraphClient = (*SpiceDBGraph)(nil)
@@ -1,3 +1,5 @@
+//go:build !spicedb
+
 package rel
 
 import "fmt"
@@ -0,0 +1,109 @@
+package rel
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrNoOrg is returned for tuple reads and writes made without an org (see WithOrg)
+	ErrNoOrg = errors.New("tuple operations need an org")
+	// ErrCrossOrg is returned for tuples reaching into another org without a federation contract
+	ErrCrossOrg = errors.New("tuple crosses an org boundary")
+)
+
+// Tuples are partitioned by org: every tuple is owned by the org it was written for, and checks, expands
+// and lookups read only the tuples of the org in their context. Objects of the org and agent namespaces
+// are bound to an org, so only that org may own tuples about them; a subject bound to another org needs
+// an active federation contract with it. SpiceDB has no partitions: there the bound objects are guarded
+// on every call, and objects of other namespaces are shared by all orgs.
+
+// orgOf returns the org in ctx, which must be an org id
+func orgOf(ctx context.Context) (string, error) {
+	org := OrgFrom(ctx)
+	if _, err := uuid.Parse(org); err != nil {
+		return "", ErrNoOrg
+	}
+	return org, nil
+}
+
+// tenancy decides which tuples an org may own
+type tenancy struct {
+	// owner returns the org an object is bound to, or "" for objects that only exist within a partition
+	owner func(ctx context.Context, namespace, id string) (string, error)
+	// contract reports an active federation contract of org with counterparty
+	contract func(ctx context.Context, org, counterparty string) (bool, error)
+}
+
+var orgScope = tenancy{owner: objectOrg, contract: hasFederationContract}
+
+// boundNamespaces are the namespaces whose objects are bound to an org
+var boundNamespaces = map[string]bool{"org": true, "agent": true}
+
+// objectOrg binds org objects to themselves and agents to the org that registered them
+func objectOrg(ctx context.Context, namespace, id string) (string, error) {
+	switch namespace {
+	case "org":
+		return id, nil
+	case "agent":
+		var org string
+		err := databasepkg.DB.GetContext(ctx, &org, `SELECT organization_id::text FROM agents WHERE id::text=$1`, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return org, err
+	}
+	return "", nil
+}
+
+func hasFederationContract(ctx context.Context, org, counterparty string) (bool, error) {
+	var ok bool
+	err := databasepkg.DB.GetContext(ctx, &ok, `SELECT EXISTS (SELECT 1 FROM federation_contracts WHERE org_id::text=$1 AND counterparty_org_id::text=$2 AND active=true)`, org, counterparty)
+	return ok, err
+}
+
+// check lets org own tuples whose object is its own, and whose subject is its own or belongs to an org
+// it has an active federation contract with
+func (tn tenancy) check(ctx context.Context, org string, tuples []Tuple) error {
+	contracts := map[string]bool{}
+	for _, t := range tuples {
+		if err := tn.guard(ctx, org, RelationRef{Namespace: t.ObjectType, ObjectID: t.ObjectID}); err != nil {
+			return err
+		}
+		other, err := tn.owner(ctx, t.SubjectType, t.SubjectID)
+		if err != nil {
+			return err
+		}
+		if other == "" || other == org {
+			continue
+		}
+		ok, seen := contracts[other]
+		if !seen {
+			if ok, err = tn.contract(ctx, org, other); err != nil {
+				return err
+			}
+			contracts[other] = ok
+		}
+		if !ok {
+			return fmt.Errorf("%w: subject %s belongs to org %s, which has no active federation contract with this org", ErrCrossOrg, subjectString(t), other)
+		}
+	}
+	return nil
+}
+
+// guard fails with ErrCrossOrg when object is bound to an org other than org
+func (tn tenancy) guard(ctx context.Context, org string, object RelationRef) error {
+	owner, err := tn.owner(ctx, object.Namespace, object.ObjectID)
+	if err != nil {
+		return err
+	}
+	if owner != "" && owner != org {
+		return fmt.Errorf("%w: %s:%s belongs to another org", ErrCrossOrg, object.Namespace, object.ObjectID)
+	}
+	return nil
+}
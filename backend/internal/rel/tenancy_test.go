@@ -0,0 +1,62 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTenancy(t *testing.T) {
+	const org, partner, other = "o1", "o2", "o3"
+	agents := map[string]string{"a1": org, "a2": partner, "a3": other}
+	tn := tenancy{
+		owner: func(ctx context.Context, namespace, id string) (string, error) {
+			switch namespace {
+			case "org":
+				return id, nil
+			case "agent":
+				return agents[id], nil
+			}
+			return "", nil
+		},
+		contract: func(ctx context.Context, o, counterparty string) (bool, error) {
+			return o == org && counterparty == partner, nil
+		},
+	}
+	ctx := context.Background()
+	cases := []struct {
+		name  string
+		tuple Tuple
+		ok    bool
+	}{
+		{"own org", Tuple{ObjectType: "org", ObjectID: org, Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"}, true},
+		{"unbound object", Tuple{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "agent", SubjectID: "a1"}, true},
+		{"unknown agent", Tuple{ObjectType: "org", ObjectID: org, Relation: "can_act_for", SubjectType: "agent", SubjectID: "remote"}, true},
+		{"federated agent", Tuple{ObjectType: "org", ObjectID: org, Relation: "can_act_for", SubjectType: "agent", SubjectID: "a2"}, true},
+		{"other org object", Tuple{ObjectType: "org", ObjectID: other, Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"}, false},
+		{"other org agent object", Tuple{ObjectType: "agent", ObjectID: "a3", Relation: "owner", SubjectType: "agent", SubjectID: "a1"}, false},
+		{"agent without contract", Tuple{ObjectType: "org", ObjectID: org, Relation: "can_act_for", SubjectType: "agent", SubjectID: "a3"}, false},
+		{"org userset without contract", Tuple{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "org", SubjectID: other, SubjectRelation: "member"}, false},
+	}
+	for _, c := range cases {
+		err := tn.check(ctx, org, []Tuple{c.tuple})
+		if c.ok && err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrCrossOrg) {
+			t.Errorf("%s: expected ErrCrossOrg, got %v", c.name, err)
+		}
+	}
+}
+
+func TestOrgRequired(t *testing.T) {
+	if _, err := orgOf(context.Background()); !errors.Is(err, ErrNoOrg) {
+		t.Errorf("no org: got %v", err)
+	}
+	if _, err := orgOf(WithOrg(context.Background(), "not-a-uuid")); !errors.Is(err, ErrNoOrg) {
+		t.Errorf("malformed org: got %v", err)
+	}
+	if _, err := NewLocalGraph().Check(context.Background(), RelationRef{Namespace: "agent", ObjectID: "a1"}, "can_act_for", RelationRef{Namespace: "org", ObjectID: "o1"}, nil); !errors.Is(err, ErrNoOrg) {
+		t.Errorf("check without an org: got %v", err)
+	}
+}
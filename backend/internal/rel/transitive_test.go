@@ -5,66 +5,89 @@ import (
 	"testing"
 )
 
-type memGraph struct{ edges []Tuple }
-
-func (m *memGraph) Upsert(ctx context.Context, t Tuple) error {
-	m.edges = append(m.edges, t)
-	return nil
-}
-func (m *memGraph) UpsertBatch(ctx context.Context, tuples []Tuple) error {
-	m.edges = append(m.edges, tuples...)
-	return nil
-}
-func (m *memGraph) Check(ctx context.Context, subject RelationRef, relation string, object RelationRef) (bool, string, error) {
-	// simple BFS in-memory for tests: follow member/can_act_for, and allow final owner/editor/viewer implications
-	type node struct {
-		ns, id string
-		depth  int
-	}
-	seen := map[string]bool{subject.Namespace + ":" + subject.ObjectID: true}
-	q := []node{{subject.Namespace, subject.ObjectID, 0}}
-	for len(q) > 0 {
-		cur := q[0]
-		q = q[1:]
-		for _, e := range m.edges {
-			if e.SubjectType == cur.ns && e.SubjectID == cur.id {
-				if e.ObjectType == object.Namespace && e.ObjectID == object.ObjectID && implies(e.Relation, relation) {
-					return true, "mem", nil
-				}
-				if e.Relation == "member" || e.Relation == "can_act_for" {
-					k := e.ObjectType + ":" + e.ObjectID
-					if !seen[k] {
-						seen[k] = true
-						q = append(q, node{e.ObjectType, e.ObjectID, cur.depth + 1})
-					}
-				}
+// memTuples serves tuples from a slice in place of trust_tuples
+func memTuples(edges ...Tuple) tupleReader {
+	return func(ctx context.Context, object RelationRef, relation string) ([]Tuple, error) {
+		out := []Tuple{}
+		for _, e := range edges {
+			if e.ObjectType == object.Namespace && e.ObjectID == object.ObjectID && e.Relation == relation {
+				out = append(out, e)
 			}
 		}
+		return out, nil
 	}
-	return false, "mem", nil
 }
-func (m *memGraph) Expand(ctx context.Context, relation string, object RelationRef, depth int) (GraphExpansion, error) {
-	return GraphExpansion{}, nil
+
+func checkWith(t *testing.T, s *Schema, read tupleReader, subject RelationRef, relation string, object RelationRef) bool {
+	t.Helper()
+	o, err := newChecker(s, read).check(context.Background(), object, relation, subject, 0)
+	if err != nil {
+		t.Fatalf("check %s#%s: %v", object.ObjectID, relation, err)
+	}
+	return o.perm == PermissionAllowed
 }
 
 func TestTransitiveImplications(t *testing.T) {
-	mg := &memGraph{}
-	// team devs member alice
-	mg.Upsert(context.Background(), Tuple{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "user", SubjectID: "alice"})
-	// resource R1 editor team devs
-	mg.Upsert(context.Background(), Tuple{ObjectType: "resource", ObjectID: "R1", Relation: "editor", SubjectType: "team", SubjectID: "devs"})
+	s := DefaultSchema()
+	// team devs member alice; resource R1 editor team devs
+	read := memTuples(
+		Tuple{ObjectType: "team", ObjectID: "devs", Relation: "member", SubjectType: "user", SubjectID: "alice"},
+		Tuple{ObjectType: "resource", ObjectID: "R1", Relation: "editor", SubjectType: "team", SubjectID: "devs"},
+	)
 	// alice should be viewer via editor implication
-	ok, _, _ := mg.Check(context.Background(), RelationRef{"user", "alice"}, "viewer", RelationRef{"resource", "R1"})
-	if !ok {
+	if !checkWith(t, s, read, RelationRef{"user", "alice"}, "viewer", RelationRef{"resource", "R1"}) {
 		t.Fatal("expected viewer via editor->viewer implication and team membership")
 	}
+	if checkWith(t, s, read, RelationRef{"user", "alice"}, "owner", RelationRef{"resource", "R1"}) {
+		t.Fatal("editor must not imply owner")
+	}
 	// owner implies editor and viewer
-	mg = &memGraph{}
-	mg.Upsert(context.Background(), Tuple{ObjectType: "resource", ObjectID: "R2", Relation: "owner", SubjectType: "user", SubjectID: "alice"})
-	if ok, _, _ := mg.Check(context.Background(), RelationRef{"user", "alice"}, "editor", RelationRef{"resource", "R2"}); !ok {
+	read = memTuples(Tuple{ObjectType: "resource", ObjectID: "R2", Relation: "owner", SubjectType: "user", SubjectID: "alice"})
+	if !checkWith(t, s, read, RelationRef{"user", "alice"}, "editor", RelationRef{"resource", "R2"}) {
 		t.Fatal("expected editor via owner implication")
 	}
-	if ok, _, _ := mg.Check(context.Background(), RelationRef{"user", "alice"}, "viewer", RelationRef{"resource", "R2"}); !ok {
+	if !checkWith(t, s, read, RelationRef{"user", "alice"}, "viewer", RelationRef{"resource", "R2"}) {
 		t.Fatal("expected viewer via owner implication")
 	}
 }
+
+func TestDefaultSchemaDelegationChain(t *testing.T) {
+	// agent a1 acts for agent a2, a2 is a member of team ops, ops acts for org o1; the chain loops back
+	read := memTuples(
+		Tuple{ObjectType: "agent", ObjectID: "a2", Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"},
+		Tuple{ObjectType: "team", ObjectID: "ops", Relation: "member", SubjectType: "agent", SubjectID: "a2"},
+		Tuple{ObjectType: "org", ObjectID: "o1", Relation: "can_act_for", SubjectType: "team", SubjectID: "ops"},
+		Tuple{ObjectType: "agent", ObjectID: "a1", Relation: "can_act_for", SubjectType: "org", SubjectID: "o1"},
+	)
+	if !checkWith(t, DefaultSchema(), read, RelationRef{"agent", "a1"}, "can_act_for", RelationRef{"org", "o1"}) {
+		t.Fatal("expected delegation through agent and team")
+	}
+	if checkWith(t, DefaultSchema(), read, RelationRef{"agent", "a3"}, "can_act_for", RelationRef{"org", "o1"}) {
+		t.Fatal("unrelated agent must not act for the org")
+	}
+}
+
+func TestCheckPathProof(t *testing.T) {
+	chain := []Tuple{
+		{ObjectType: "org", ObjectID: "o1", Relation: "can_act_for", SubjectType: "team", SubjectID: "ops"},
+		{ObjectType: "team", ObjectID: "ops", Relation: "member", SubjectType: "agent", SubjectID: "a2"},
+		{ObjectType: "agent", ObjectID: "a2", Relation: "can_act_for", SubjectType: "agent", SubjectID: "a1"},
+	}
+	read := memTuples(append([]Tuple{{ObjectType: "org", ObjectID: "o1", Relation: "can_act_for", SubjectType: "agent", SubjectID: "a9"}}, chain...)...)
+	o, err := newChecker(DefaultSchema(), read).check(context.Background(), RelationRef{"org", "o1"}, "can_act_for", RelationRef{"agent", "a1"}, 0)
+	if err != nil || o.perm != PermissionAllowed {
+		t.Fatalf("expected allow, got %+v %v", o, err)
+	}
+	if len(o.path) != len(chain) {
+		t.Fatalf("path %+v, want %+v", o.path, chain)
+	}
+	for i := range chain {
+		if o.path[i].key() != chain[i].key() {
+			t.Fatalf("path %+v, want %+v", o.path, chain)
+		}
+	}
+	o, _ = newChecker(DefaultSchema(), read).check(context.Background(), RelationRef{"org", "o1"}, "can_act_for", RelationRef{"agent", "a3"}, 0)
+	if o.perm != PermissionDenied || o.path != nil {
+		t.Fatalf("a denial has no path: %+v", o)
+	}
+}
@@ -0,0 +1,100 @@
+package rel
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+)
+
+// Change is one entry of the tuple changelog (trust_tuple_changes). Changes of one write share a revision.
+// OrgID is the org owning the tuple; it is empty for changes logged before tuples were partitioned by org.
+type Change struct {
+	Revision  int64     `json:"revision"`
+	OrgID     string    `json:"org_id,omitempty"`
+	Operation UpdateOp  `json:"operation"`
+	Tuple     Tuple     `json:"tuple"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Changes returns up to limit changes after revision, oldest first. Whole revisions are returned, so
+// the result may exceed limit by the rest of the last revision.
+func Changes(ctx context.Context, afterRevision int64, limit int) ([]Change, error) {
+	var rows []struct {
+		Revision  int64     `db:"revision"`
+		OrgID     string    `db:"org_id"`
+		Operation UpdateOp  `db:"operation"`
+		CreatedAt time.Time `db:"created_at"`
+		Tuple
+	}
+	if err := databasepkg.DB.SelectContext(ctx, &rows, `SELECT revision, COALESCE(org_id::text,'') AS org_id, operation, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json, created_at
+		FROM trust_tuple_changes
+		WHERE revision > $1 AND revision <= COALESCE((SELECT MAX(revision) FROM (SELECT revision FROM trust_tuple_changes WHERE revision > $1 ORDER BY revision LIMIT $2) r), $1)
+		ORDER BY revision, id`, afterRevision, limit); err != nil {
+		return nil, err
+	}
+	out := make([]Change, len(rows))
+	for i, r := range rows {
+		out[i] = Change{Revision: r.Revision, OrgID: r.OrgID, Operation: r.Operation, Tuple: r.Tuple, CreatedAt: r.CreatedAt}
+	}
+	return out, nil
+}
+
+// HeadRevision returns the latest changelog revision (0 before the first write)
+func HeadRevision(ctx context.Context) (int64, error) {
+	var rev int64
+	err := databasepkg.DB.GetContext(ctx, &rev, `SELECT COALESCE(MAX(revision),0) FROM trust_tuple_changes`)
+	return rev, err
+}
+
+// watchPoll bounds how long a watcher waits to see writes made by other instances
+const watchPoll = time.Second
+
+var changeSignal = struct {
+	sync.Mutex
+	ch chan struct{}
+}{ch: make(chan struct{})}
+
+// notifyChanges wakes the watchers of this instance
+func notifyChanges() {
+	changeSignal.Lock()
+	close(changeSignal.ch)
+	changeSignal.ch = make(chan struct{})
+	changeSignal.Unlock()
+}
+
+// NotifyChanges wakes watchers after another instance announced a write (e.g. over the mesh bus)
+func NotifyChanges() { notifyChanges() }
+
+func changesSignal() <-chan struct{} {
+	changeSignal.Lock()
+	defer changeSignal.Unlock()
+	return changeSignal.ch
+}
+
+// Watch streams changes after revision to emit, in order, until ctx ends or emit fails
+func Watch(ctx context.Context, afterRevision int64, emit func(Change) error) error {
+	for {
+		wake := changesSignal()
+		changes, err := Changes(ctx, afterRevision, 500)
+		if err != nil {
+			return err
+		}
+		for _, ch := range changes {
+			if err := emit(ch); err != nil {
+				return err
+			}
+			afterRevision = ch.Revision
+		}
+		if len(changes) > 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		case <-time.After(watchPoll):
+		}
+	}
+}
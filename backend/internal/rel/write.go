@@ -0,0 +1,298 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	databasepkg "github.com/Armour007/aura-backend/internal"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrPreconditionFailed is returned when a write's preconditions do not hold; nothing is written
+	ErrPreconditionFailed = errors.New("relationship precondition failed")
+	// ErrTupleExists is returned when a create targets a tuple that is already stored
+	ErrTupleExists = errors.New("relationship already exists")
+)
+
+// UpdateOp is how a RelationshipUpdate applies its tuple
+type UpdateOp string
+
+const (
+	// OpTouch writes the tuple, replacing the caveat of an existing one
+	OpTouch UpdateOp = "touch"
+	// OpCreate writes the tuple and fails with ErrTupleExists when it is already stored
+	OpCreate UpdateOp = "create"
+	// OpDelete removes the tuple; deleting a missing tuple is not an error
+	OpDelete UpdateOp = "delete"
+)
+
+// RelationshipUpdate is one change of a WriteRelationships call
+type RelationshipUpdate struct {
+	Operation UpdateOp `json:"operation"`
+	Tuple     Tuple    `json:"tuple"`
+}
+
+// TupleFilter selects tuples; empty fields match anything
+type TupleFilter struct {
+	ObjectType      string `json:"object_type,omitempty"`
+	ObjectID        string `json:"object_id,omitempty"`
+	Relation        string `json:"relation,omitempty"`
+	SubjectType     string `json:"subject_type,omitempty"`
+	SubjectID       string `json:"subject_id,omitempty"`
+	SubjectRelation string `json:"subject_relation,omitempty"`
+}
+
+// Empty reports a filter that matches every tuple
+func (f TupleFilter) Empty() bool { return f == TupleFilter{} }
+
+func (f TupleFilter) matches(t Tuple) bool {
+	eq := func(want, got string) bool { return want == "" || want == got }
+	return eq(f.ObjectType, t.ObjectType) && eq(f.ObjectID, t.ObjectID) && eq(f.Relation, t.Relation) &&
+		eq(f.SubjectType, t.SubjectType) && eq(f.SubjectID, t.SubjectID) && eq(f.SubjectRelation, t.SubjectRelation)
+}
+
+// where renders the filter as SQL conditions on the trust_tuples of org
+func (f TupleFilter) where(org string) (string, []any) {
+	q, args := "org_id=$1", []any{org}
+	add := func(col, val string) {
+		if val != "" {
+			args = append(args, val)
+			q += fmt.Sprintf(" AND %s=$%d", col, len(args))
+		}
+	}
+	add("object_type", f.ObjectType)
+	add("object_id", f.ObjectID)
+	add("relation", f.Relation)
+	add("subject_type", f.SubjectType)
+	add("subject_id", f.SubjectID)
+	add("subject_relation", f.SubjectRelation)
+	return q, args
+}
+
+// Precondition requires tuples matching Filter to exist ("must_match") or not ("must_not_match")
+type Precondition struct {
+	Operation string      `json:"operation"`
+	Filter    TupleFilter `json:"filter"`
+}
+
+const (
+	PreconditionMustMatch    = "must_match"
+	PreconditionMustNotMatch = "must_not_match"
+)
+
+func checkPreconditions(preconditions []Precondition) error {
+	for _, p := range preconditions {
+		if p.Operation != PreconditionMustMatch && p.Operation != PreconditionMustNotMatch {
+			return fmt.Errorf("%w: unknown precondition operation %q", ErrInvalidTuple, p.Operation)
+		}
+	}
+	return nil
+}
+
+// Touches turns tuples into touch updates
+func Touches(tuples []Tuple) []RelationshipUpdate {
+	out := make([]RelationshipUpdate, len(tuples))
+	for i, t := range tuples {
+		out[i] = RelationshipUpdate{Operation: OpTouch, Tuple: t}
+	}
+	return out
+}
+
+// validateUpdates checks operations, and the tuples that are written against the org schema and the
+// tenancy rules of the org in ctx. Deletes are not validated so tuples the schema no longer admits can
+// be removed.
+func validateUpdates(ctx context.Context, updates []RelationshipUpdate) error {
+	var written []Tuple
+	for _, u := range updates {
+		switch u.Operation {
+		case OpTouch, OpCreate:
+			written = append(written, u.Tuple)
+		case OpDelete:
+		default:
+			return fmt.Errorf("%w: unknown operation %q", ErrInvalidTuple, u.Operation)
+		}
+	}
+	org, err := orgOf(ctx)
+	if err != nil {
+		return err
+	}
+	if err := orgScope.check(ctx, org, written); err != nil {
+		return err
+	}
+	return ValidateTuples(ctx, written)
+}
+
+// writeLockKey serializes tuple writes so changelog revisions commit in order
+const writeLockKey = 0x61757261_7472 // "auratr"
+
+// copyThreshold is the number of rows from which a write COPYs them instead of inserting one by one
+const copyThreshold = 64
+
+// wtx is a write transaction on a dedicated connection, so large writes can COPY into it
+type wtx struct {
+	*sqlx.Tx
+	conn *sqlx.Conn
+}
+
+// copyFrom COPYs rows into the columns of table within the transaction
+func (tx *wtx) copyFrom(ctx context.Context, table string, columns []string, rows [][]any) error {
+	return tx.conn.Raw(func(dc any) error {
+		c, ok := dc.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("rel: COPY needs the pgx driver, got %T", dc)
+		}
+		_, err := c.Conn().CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+		return err
+	})
+}
+
+// writeTx applies a write to the tuples of org in one transaction under the write lock: it checks the
+// preconditions, lets apply record its changes, and appends them to trust_tuple_changes under the next
+// revision. Writes that change nothing do not take a revision; the returned revision is then the current one.
+func writeTx(ctx context.Context, org string, preconditions []Precondition, apply func(tx *wtx) ([]Change, error)) (int64, error) {
+	conn, err := databasepkg.DB.Connx(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	sqltx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	tx := &wtx{Tx: sqltx, conn: conn}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, writeLockKey); err != nil {
+		return 0, err
+	}
+	for _, p := range preconditions {
+		where, args := p.Filter.where(org)
+		var found bool
+		if err := tx.GetContext(ctx, &found, `SELECT EXISTS (SELECT 1 FROM trust_tuples WHERE `+where+`)`, args...); err != nil {
+			return 0, err
+		}
+		if found != (p.Operation == PreconditionMustMatch) {
+			return 0, fmt.Errorf("%w: %s %+v", ErrPreconditionFailed, p.Operation, p.Filter)
+		}
+	}
+	changes, err := apply(tx)
+	if err != nil {
+		return 0, err
+	}
+	var rev int64
+	if err := tx.GetContext(ctx, &rev, `SELECT COALESCE(MAX(revision),0) FROM trust_tuple_changes`); err != nil {
+		return 0, err
+	}
+	switch {
+	case len(changes) >= copyThreshold:
+		rev++
+		rows := make([][]any, len(changes))
+		for i, ch := range changes {
+			t := ch.Tuple
+			rows[i] = []any{rev, org, string(ch.Operation), t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID, t.SubjectRelation, caveatJSON(t.Caveat)}
+		}
+		if err := tx.copyFrom(ctx, "trust_tuple_changes", changeColumns, rows); err != nil {
+			return 0, err
+		}
+	case len(changes) > 0:
+		rev++
+		for _, ch := range changes {
+			t := ch.Tuple
+			if _, err := tx.ExecContext(ctx, `INSERT INTO trust_tuple_changes (revision, org_id, operation, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json)
+				VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`, rev, org, ch.Operation, t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID, t.SubjectRelation, caveatJSON(t.Caveat)); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if len(changes) > 0 {
+		notifyChanges()
+	}
+	return rev, nil
+}
+
+// writeRelationships applies updates to the trust_tuples of the org in ctx and returns the changelog revision
+func writeRelationships(ctx context.Context, updates []RelationshipUpdate, preconditions []Precondition) (int64, error) {
+	if err := validateUpdates(ctx, updates); err != nil {
+		return 0, err
+	}
+	if err := checkPreconditions(preconditions); err != nil {
+		return 0, err
+	}
+	org := OrgFrom(ctx)
+	if len(updates) >= copyThreshold && onlyTouches(updates) {
+		return writeTx(ctx, org, preconditions, func(tx *wtx) ([]Change, error) { return copyTouches(ctx, tx, org, updates) })
+	}
+	return writeTx(ctx, org, preconditions, func(tx *wtx) ([]Change, error) {
+		var changes []Change
+		for _, u := range updates {
+			t := u.Tuple
+			var q string
+			switch u.Operation {
+			case OpTouch:
+				// an unchanged tuple is not rewritten, so it is not logged either
+				q = `INSERT INTO trust_tuples (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+					ON CONFLICT (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation)
+					DO UPDATE SET caveat_json=EXCLUDED.caveat_json WHERE trust_tuples.caveat_json IS DISTINCT FROM EXCLUDED.caveat_json`
+			case OpCreate:
+				q = `INSERT INTO trust_tuples (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+					ON CONFLICT (org_id, object_type, object_id, relation, subject_type, subject_id, subject_relation) DO NOTHING`
+			case OpDelete:
+				q = `DELETE FROM trust_tuples WHERE org_id=$1 AND object_type=$2 AND object_id=$3 AND relation=$4 AND subject_type=$5 AND subject_id=$6 AND subject_relation=$7`
+			}
+			args := []any{org, t.ObjectType, t.ObjectID, t.Relation, t.SubjectType, t.SubjectID, t.SubjectRelation}
+			if u.Operation != OpDelete {
+				args = append(args, caveatJSON(t.Caveat))
+			}
+			res, err := tx.ExecContext(ctx, q, args...)
+			if err != nil {
+				return nil, err
+			}
+			n, _ := res.RowsAffected()
+			if n == 0 && u.Operation == OpCreate {
+				return nil, fmt.Errorf("%w: %s:%s#%s@%s", ErrTupleExists, t.ObjectType, t.ObjectID, t.Relation, subjectString(t))
+			}
+			if n > 0 {
+				changes = append(changes, Change{Operation: u.Operation, Tuple: t})
+			}
+		}
+		return changes, nil
+	})
+}
+
+// deleteRelationships removes every tuple of the org in ctx matching filter and returns the changelog revision
+func deleteRelationships(ctx context.Context, filter TupleFilter, preconditions []Precondition) (int64, error) {
+	if err := checkPreconditions(preconditions); err != nil {
+		return 0, err
+	}
+	org, err := orgOf(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return writeTx(ctx, org, preconditions, func(tx *wtx) ([]Change, error) {
+		where, args := filter.where(org)
+		deleted := []Tuple{}
+		if err := tx.SelectContext(ctx, &deleted, `DELETE FROM trust_tuples WHERE `+where+`
+			RETURNING object_type, object_id, relation, subject_type, subject_id, subject_relation, caveat_json`, args...); err != nil {
+			return nil, err
+		}
+		changes := make([]Change, len(deleted))
+		for i, t := range deleted {
+			changes[i] = Change{Operation: OpDelete, Tuple: t}
+		}
+		return changes, nil
+	})
+}
+
+func subjectString(t Tuple) string {
+	s := t.SubjectType + ":" + t.SubjectID
+	if t.SubjectRelation != "" {
+		s += "#" + t.SubjectRelation
+	}
+	return s
+}
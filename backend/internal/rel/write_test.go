@@ -0,0 +1,61 @@
+package rel
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTupleFilter(t *testing.T) {
+	f := TupleFilter{ObjectType: "doc", Relation: "viewer", SubjectRelation: "member"}
+	where, args := f.where("o1")
+	if where != "org_id=$1 AND object_type=$2 AND relation=$3 AND subject_relation=$4" || len(args) != 4 || args[0] != "o1" {
+		t.Fatalf("where: %q %v", where, args)
+	}
+	if !f.matches(Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "team", SubjectID: "t", SubjectRelation: "member"}) {
+		t.Error("expected a match")
+	}
+	if f.matches(Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "u"}) {
+		t.Error("a direct subject must not match a subject relation filter")
+	}
+	if !(TupleFilter{}).Empty() || f.Empty() {
+		t.Error("Empty")
+	}
+}
+
+func TestStoreTouchAndDelete(t *testing.T) {
+	s := NewStore()
+	tu := Tuple{ObjectType: "doc", ObjectID: "d1", Relation: "viewer", SubjectType: "user", SubjectID: "alice"}
+	s.Upsert("o1", []Tuple{tu, tu})
+	caveated := tu
+	caveated.Caveat = &Caveat{Name: "valid_between"}
+	s.Upsert("o1", []Tuple{caveated})
+	if len(s.tuples["o1"]) != 1 {
+		t.Fatalf("expected one tuple after repeated touches, got %d", len(s.tuples["o1"]))
+	}
+	if s.Check("o1", "user", "alice", "viewer", "doc", "d1") {
+		t.Error("the touch must have replaced the caveat")
+	}
+	s.Upsert("o1", []Tuple{tu, {ObjectType: "doc", ObjectID: "d2", Relation: "viewer", SubjectType: "user", SubjectID: "alice"}})
+	s.Upsert("o2", []Tuple{tu})
+	s.Delete("o1", TupleFilter{ObjectType: "doc", ObjectID: "d1"})
+	if s.Check("o1", "user", "alice", "viewer", "doc", "d1") || !s.Check("o1", "user", "alice", "viewer", "doc", "d2") {
+		t.Error("delete removed the wrong tuples")
+	}
+	if !s.Check("o2", "user", "alice", "viewer", "doc", "d1") || s.Check("o2", "user", "alice", "viewer", "doc", "d2") {
+		t.Error("orgs must not see each other's tuples")
+	}
+}
+
+func TestWriteValidation(t *testing.T) {
+	ctx := context.Background()
+	if _, err := writeRelationships(ctx, []RelationshipUpdate{{Operation: "upsert"}}, nil); !errors.Is(err, ErrInvalidTuple) {
+		t.Errorf("unknown operation: got %v", err)
+	}
+	if _, err := deleteRelationships(ctx, TupleFilter{ObjectType: "doc"}, []Precondition{{Operation: "exists"}}); !errors.Is(err, ErrInvalidTuple) {
+		t.Errorf("unknown precondition: got %v", err)
+	}
+	if _, err := deleteRelationships(ctx, TupleFilter{ObjectType: "doc"}, nil); !errors.Is(err, ErrNoOrg) {
+		t.Errorf("delete without an org: got %v", err)
+	}
+}